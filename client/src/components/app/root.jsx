@@ -9,6 +9,7 @@ import ProjectsPage from '../projects/projects.page';
 import ProjectPage from '../projects/project/project.page';
 import UsersPage from '../users/users.page';
 import UserPage from '../users/user/user.page';
+import ExportPage from '../export/export.page';
 
 import { requireAuthorization } from '../auth/auth.isAuthorized';
 import { AUTH_ADMIN_ROLE, AUTH_RI_ROLE } from '../../modules/auth/auth.constants';
@@ -31,6 +32,7 @@ const RootComponent = ({
           <IndexRoute component={requireAuthorization(UsersPage)} />
           <Route path=':id' component={requireAuthorization(UserPage)} />
         </Route>
+        <Route path='exports/:id' component={requireAuthorization(ExportPage)} />
       </Route>
     </Router>
   </Provider>
@@ -0,0 +1,58 @@
+// React
+import React from 'react';
+import PropTypes from 'prop-types';
+import { connect } from 'react-redux';
+import { Container, Message, Segment } from 'semantic-ui-react';
+import DocumentTitle from 'react-document-title';
+
+// API Fetching
+import ExportThunks from '../../modules/export/export.thunk';
+
+// ExportPage downloads the file of an export requested in background, from the link sent by email
+class ExportPage extends React.Component {
+
+  componentWillMount = () => {
+    this.props.downloadJob(this.props.params.id);
+  }
+
+  render = () => {
+    const { isFetching, errorMessage } = this.props;
+    return (
+      <DocumentTitle title='D.A.D - Export'>
+        <Container>
+          <Segment loading={isFetching}>
+            {errorMessage
+              ? <Message error header='Impossible to download the export' content={errorMessage} />
+              : <p>Your export is being downloaded.</p>}
+          </Segment>
+        </Container>
+      </DocumentTitle>
+    );
+  }
+}
+
+ExportPage.propTypes = {
+  params: PropTypes.object.isRequired,
+  isFetching: PropTypes.bool.isRequired,
+  errorMessage: PropTypes.string,
+  downloadJob: PropTypes.func.isRequired
+};
+
+// Function to map state to container props
+const mapStateToProps = (state) => {
+  const { isFetching, errorMessage } = state.export;
+  return { isFetching, errorMessage };
+};
+
+// Function to map dispatch to container props
+const mapDispatchToProps = (dispatch) => {
+  return {
+    downloadJob: (id) => dispatch(ExportThunks.downloadJob(id))
+  };
+};
+
+// Redux container to Export page
+export default connect(
+  mapStateToProps,
+  mapDispatchToProps
+)(ExportPage);
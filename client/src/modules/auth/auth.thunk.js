@@ -5,6 +5,25 @@ import { checkHttpStatus, handleError, parseJSON } from '../utils/promises';
 // Auth Actions
 import AuthActions from './auth.actions';
 
+let refreshTimeout = null;
+
+// Stores the tokens in the localstorage for authentication purpose
+// and refreshes them one minute before the access token expires
+const saveTokens = (tokens, dispatch) => {
+  localStorage.setItem('id_token', tokens.id_token);
+  localStorage.setItem('refresh_token', tokens.refresh_token);
+  clearTimeout(refreshTimeout);
+  if (tokens.expires_in) {
+    refreshTimeout = setTimeout(() => dispatch(refresh()), Math.max(tokens.expires_in - 60, 10) * 1000);
+  }
+};
+
+const removeTokens = () => {
+  clearTimeout(refreshTimeout);
+  localStorage.removeItem('id_token');
+  localStorage.removeItem('refresh_token');
+};
+
 // Calls the API to get a token and
 // dispatches actions along the way
 const loginUser = (auth) => {
@@ -23,8 +42,8 @@ const loginUser = (auth) => {
       .then (checkHttpStatus)
       .then(parseJSON)
       .then((user) =>  {
-          // When uer is authorized, add the JWT token in the localstorage for authentication purpose
-        localStorage.setItem('id_token', user.id_token);
+        // When user is authorized, add the JWT tokens in the localstorage for authentication purpose
+        saveTokens(user, dispatch);
         dispatch(AuthActions.receiveLogin(user));
       }).catch((error) => {
         // When error happens.
@@ -39,11 +58,35 @@ const loginUser = (auth) => {
   };
 };
 
-// Logs the user out
+// Gets new tokens with the refresh token. The user is logged out when the session expired
+const refresh = () => {
+  let config = {
+    method: 'POST',
+    headers: { 'Content-Type': 'application/x-www-form-urlencoded' },
+    body: `refresh_token=${encodeURIComponent(localStorage.getItem('refresh_token') || '')}`
+  };
+
+  return (dispatch) => {
+    return fetch('/auth/refresh', config)
+      .then(checkHttpStatus)
+      .then(parseJSON)
+      .then((tokens) => saveTokens(tokens, dispatch))
+      .catch(() => dispatch(logoutUser()));
+  };
+};
+
+// Logs the user out, revoking its tokens on server side
 const logoutUser = () => {
+  let config = withAuth({
+    method: 'POST',
+    headers: { 'Content-Type': 'application/x-www-form-urlencoded' },
+    body: `refresh_token=${encodeURIComponent(localStorage.getItem('refresh_token') || '')}`
+  });
+
   return (dispatch) => {
     dispatch(AuthActions.requestLogout());
-    localStorage.removeItem('id_token');
+    fetch('/auth/logout', config).catch(() => {});
+    removeTokens();
     dispatch(AuthActions.receiveLogout());
   };
 };
@@ -67,6 +110,7 @@ const profile = () => {
 
 export default {
   loginUser,
+  refresh,
   logoutUser,
   profile
 };
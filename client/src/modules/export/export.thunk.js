@@ -29,6 +29,30 @@ const exportAll = (language) => {
   };
 };
 
+// Calls the API to download the file of an export generated in background
+const downloadJob = (id) => {
+
+  return (dispatch) => {
+    dispatch(ExportActions.requestExportAll());
+
+    return fetch(`/api/export/jobs/${id}/download`, withAuth({ method: 'GET' }))
+      .then(checkHttpStatus)
+      .then((response) => {
+        const disposition = response.headers.get('Content-Disposition') || '';
+        const match = disposition.match(/filename="(.+)"/);
+        const filename = match ? match[1] : `DAD-Export-${id}`;
+        response.blob().then((blob) => {
+          download(blob, filename);
+          dispatch(ExportActions.receiveExportAll());
+        });
+      })
+      .catch((error) => {
+        handleError(error, ExportActions.invalidRequestExportAll, dispatch);
+      });
+  };
+};
+
 export default {
-  exportAll
+  exportAll,
+  downloadJob
 };
@@ -54,7 +54,8 @@ const store = createStore(
 
 const authToken = localStorage.getItem('id_token');
 if (authToken) {
-  store.dispatch(AuthThunks.profile());
+  // The access token may have expired since the last visit
+  store.dispatch(AuthThunks.refresh()).then(() => store.dispatch(AuthThunks.profile()));
 }
 
 const language = localStorage.getItem('language') || LanguagesConstants.DEFAULT_LANGUAGE;
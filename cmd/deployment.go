@@ -0,0 +1,120 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/soprasteria/dad/server/jobs"
+	"github.com/soprasteria/dad/server/mongo"
+	"github.com/soprasteria/dad/server/types"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// deploymentCmd represents the deployment-indicators command
+var deploymentCmd = &cobra.Command{
+	Use:   "deployment-indicators",
+	Short: "Run the analytics of deployment status on projects",
+	Long: `Run the analytics of deployment status on projects, updating their matrix with the services deployed on Docktor.
+With --dry-run, the changes are printed as JSON without saving the projects. The changes to approve can then be saved with --apply.`,
+	PreRun: func(cmd *cobra.Command, args []string) {
+		// Flags are bound when the command is executed, as they share their configuration keys with the serve command
+		_ = viper.BindPFlag("server.mongo.addr", cmd.Flags().Lookup("mongo-addr"))
+		_ = viper.BindPFlag("server.mongo.username", cmd.Flags().Lookup("mongo-username"))
+		_ = viper.BindPFlag("server.mongo.password", cmd.Flags().Lookup("mongo-password"))
+		_ = viper.BindPFlag("docktor.addr", cmd.Flags().Lookup("docktor-addr"))
+		_ = viper.BindPFlag("docktor.user", cmd.Flags().Lookup("docktor-user"))
+		_ = viper.BindPFlag("docktor.password", cmd.Flags().Lookup("docktor-password"))
+		_ = viper.BindPFlag("tasks.recurrence.updateProgress", cmd.Flags().Lookup("tasks-recurrence-updateProgress"))
+		_ = viper.BindPFlag("tasks.recurrence.workers", cmd.Flags().Lookup("tasks-recurrence-workers"))
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		applyFile, _ := cmd.Flags().GetString("apply")
+		if dryRun && applyFile != "" {
+			log.Fatal("--dry-run and --apply can't be used together")
+		}
+
+		mongo.Connect()
+		var err error
+		switch {
+		case dryRun:
+			err = previewDeployment()
+		case applyFile != "":
+			err = applyDeployment(applyFile)
+		default:
+			err = runDeployment()
+		}
+		if err != nil {
+			log.WithError(err).Fatal("Deployment analytics failed")
+		}
+	},
+}
+
+// currentUsername is the user recorded as the author of the changes made from the command line
+func currentUsername() string {
+	current, err := user.Current()
+	if err != nil {
+		return ""
+	}
+	return current.Username
+}
+
+func printJSON(value interface{}) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+func previewDeployment() error {
+	run, err := jobs.RunDryRun(context.Background(), jobs.DeploymentJob, types.ManualTrigger, currentUsername())
+	if err != nil {
+		return err
+	}
+	return printJSON(run.Diffs)
+}
+
+func applyDeployment(file string) error {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	var diffs []types.DeploymentDiff
+	if err := json.Unmarshal(content, &diffs); err != nil {
+		return fmt.Errorf("Deployment changes are not valid: %v", err)
+	}
+
+	results, err := jobs.ApplyDeploymentChanges(diffs, currentUsername())
+	if err != nil {
+		return err
+	}
+	return printJSON(results)
+}
+
+func runDeployment() error {
+	run, err := jobs.Run(context.Background(), jobs.DeploymentJob, types.ManualTrigger, currentUsername())
+	if err != nil {
+		return err
+	}
+	fmt.Println(run.Summary)
+	return nil
+}
+
+func init() {
+	deploymentCmd.Flags().Bool("dry-run", false, "Print the changes of the projects as JSON, without saving them")
+	deploymentCmd.Flags().String("apply", "", "JSON file of the changes to save, among the ones printed with --dry-run")
+	deploymentCmd.Flags().StringP("mongo-addr", "m", "localhost:27017", "URL to access MongoDB")
+	deploymentCmd.Flags().StringP("mongo-username", "", "", "A user which has access to MongoDB")
+	deploymentCmd.Flags().StringP("mongo-password", "", "", "Password of the mongo user")
+	deploymentCmd.Flags().String("docktor-addr", "http://localhost:3000", "Docktor HTTP address. Format http://host:port")
+	deploymentCmd.Flags().String("docktor-user", "user", "Docktor user to connect with")
+	deploymentCmd.Flags().String("docktor-password", "password", "Docktor password to connect with")
+	deploymentCmd.Flags().BoolP("tasks-recurrence-updateProgress", "", false, "Update the progress during the recurrence tasks.")
+	deploymentCmd.Flags().IntP("tasks-recurrence-workers", "", 4, "Number of projects analyzed at the same time by the deployment indicators task.")
+	RootCmd.AddCommand(deploymentCmd)
+}
@@ -15,6 +15,8 @@
 package cmd
 
 import (
+	"time"
+
 	log "github.com/Sirupsen/logrus"
 	"github.com/soprasteria/dad/server"
 	"github.com/soprasteria/dad/server/email"
@@ -44,10 +46,18 @@ func init() {
 	serveCmd.Flags().StringP("mongo-username", "", "", "A user which has access to MongoDB")
 	serveCmd.Flags().StringP("mongo-password", "", "", "Password of the mongo user")
 	serveCmd.Flags().StringP("jwt-secret", "j", "dev-dad-secret", "Secret key used for JWT token authentication. Change it in your instance")
+	serveCmd.Flags().Duration("access-token-validity", 15*time.Minute, "Validity of access tokens. Expired access tokens are renewed with refresh tokens")
+	serveCmd.Flags().Duration("refresh-token-validity", 7*24*time.Hour, "Validity of refresh tokens, after which users have to login again")
 	serveCmd.Flags().StringP("reset-pwd-secret", "", "dev-dad-reset-pwd-to-change", "Secret key used when resetting the password. Change it in your instance")
 	serveCmd.Flags().StringP("bcrypt-pepper", "p", "dev-dad-bcrypt", "Pepper used in password generation. Change it in your instance")
+	serveCmd.Flags().String("server-url", "http://localhost:8080", "Public URL of DAD, used in links sent by email")
 	serveCmd.Flags().BoolP("ldap-enable", "", true, "Enable LDAP")
 	serveCmd.Flags().String("ldap-address", "", "LDAP full address like : ldap.server:389. Optional")
+	serveCmd.Flags().StringSlice("ldap-failover", []string{}, "LDAP full addresses of failover servers, tried in order when the previous servers are unreachable. Optional")
+	serveCmd.Flags().String("ldap-tls", "", "TLS mode of LDAP connections: empty for plain connections, ldaps or starttls")
+	serveCmd.Flags().String("ldap-caFile", "", "PEM bundle of the CAs verifying the certificate of LDAP servers. System CAs are used otherwise")
+	serveCmd.Flags().Int("ldap-poolSize", 5, "Maximum number of connections to LDAP servers")
+	serveCmd.Flags().Duration("ldap-timeout", 10*time.Second, "Timeout of LDAP connections and requests")
 	serveCmd.Flags().String("ldap-baseDN", "", "BaseDN. Optional")
 	serveCmd.Flags().String("ldap-domain", "", "Domain of the user. Optional")
 	serveCmd.Flags().String("ldap-bindDN", "", "DN of system account. Optional")
@@ -58,6 +68,16 @@ func init() {
 	serveCmd.Flags().String("ldap-attr-lastname", "sn", "LDAP attribute for lastname of users.")
 	serveCmd.Flags().String("ldap-attr-realname", "cn", "LDAP attribute for firstname of users.")
 	serveCmd.Flags().String("ldap-attr-email", "mail", "LDAP attribute for lastname of users.")
+	serveCmd.Flags().String("ldap-attr-groups", "memberOf", "LDAP attribute listing the groups of users. Optional")
+	serveCmd.Flags().String("ldap-groupBaseDN", "", "BaseDN of the groups search, when groups are not listed in an attribute of users. Optional")
+	serveCmd.Flags().String("ldap-groupSearchFilter", "(member=%s)", "LDAP request to find the groups of a user, %s being replaced by the DN of the user.")
+	serveCmd.Flags().String("oidc-issuer", "", "Issuer URL of the OpenID Connect identity provider. Optional, enables OpenID Connect authentication")
+	serveCmd.Flags().String("oidc-client-id", "", "Client ID of DAD in the OpenID Connect identity provider")
+	serveCmd.Flags().String("oidc-client-secret", "", "Client secret of DAD in the OpenID Connect identity provider")
+	serveCmd.Flags().String("oidc-redirect-url", "", "URL where the OpenID Connect identity provider redirects users after login")
+	serveCmd.Flags().StringSlice("oidc-scopes", []string{"openid", "profile", "email"}, "Scopes requested to the OpenID Connect identity provider")
+	serveCmd.Flags().String("oidc-username-claim", "preferred_username", "Claim of the OpenID Connect ID token containing the username")
+	serveCmd.Flags().String("oidc-state-secret", "dev-dad-oidc-state-to-change", "Secret key used to sign the OpenID Connect state. Change it in your instance")
 	serveCmd.Flags().String("smtp-server", "", "SMTP server with its port.")
 	serveCmd.Flags().String("smtp-user", "", "SMTP user for authentication.")
 	serveCmd.Flags().String("smtp-password", "", "SMTP password for authentication.")
@@ -69,18 +89,43 @@ func init() {
 	serveCmd.Flags().String("docktor-addr", "http://localhost:3000", "Docktor HTTP address. Format http://host:port")
 	serveCmd.Flags().String("docktor-user", "user", "Docktor user to connect with")
 	serveCmd.Flags().String("docktor-password", "password", "Docktor password to connect with")
+	serveCmd.Flags().Duration("docktor-timeout", 15*time.Second, "Timeout of requests to Docktor")
+	serveCmd.Flags().Int("docktor-retries", 3, "Number of retries of requests to Docktor failing with a transient error")
+	serveCmd.Flags().Duration("docktor-retryDelay", 500*time.Millisecond, "Delay before retrying a request to Docktor, doubled at each retry")
+	serveCmd.Flags().Float64("docktor-rateLimit", 10, "Maximum number of requests per second to Docktor, 0 for unlimited")
+	serveCmd.Flags().Int("docktor-breakerThreshold", 5, "Number of consecutive failed requests to Docktor suspending the requests")
+	serveCmd.Flags().Duration("docktor-breakerCooldown", time.Minute, "Delay during which requests to Docktor are suspended after too many failures")
 	serveCmd.Flags().StringP("tasks-recurrence", "", "0 0 23 * * *", "Recurrence of back-end update tasks, like updating the deployment indicator (see https://godoc.org/github.com/robfig/cron)")
 	serveCmd.Flags().BoolP("tasks-recurrence-updateProgress", "", false, "Update the progress during the recurrence tasks.")
+	serveCmd.Flags().IntP("tasks-recurrence-workers", "", 4, "Number of projects analyzed at the same time by the deployment indicators task.")
+	serveCmd.Flags().StringP("tasks-snapshot-recurrence", "", "0 0 22 * * 0", "Recurrence of the snapshot of projects maturity, used to compute trends (see https://godoc.org/github.com/robfig/cron)")
+	serveCmd.Flags().StringP("tasks-purge-recurrence", "", "0 0 3 * * *", "Recurrence of the purge of archived projects (see https://godoc.org/github.com/robfig/cron)")
+	serveCmd.Flags().IntP("tasks-purge-retention", "", 90, "Number of days an archived project can be restored before being permanently deleted. 0 disables the purge")
+	serveCmd.Flags().StringP("tasks-ldap-sync-recurrence", "", "0 0 2 * * *", "Recurrence of the synchronization of users with LDAP (see https://godoc.org/github.com/robfig/cron)")
+	serveCmd.Flags().StringP("tasks-exports-recurrence", "", "0 0 * * * *", "Recurrence of the deletion of expired export files (see https://godoc.org/github.com/robfig/cron)")
+	serveCmd.Flags().StringP("tasks-docktor-groups-recurrence", "", "0 0 1 * * *", "Recurrence of the refresh of Docktor group names of projects (see https://godoc.org/github.com/robfig/cron)")
+	serveCmd.Flags().StringP("tasks-indicators-cleanup-recurrence", "", "0 0 4 * * *", "Recurrence of the deletion of stale usage indicators (see https://godoc.org/github.com/robfig/cron)")
+	serveCmd.Flags().IntP("tasks-indicators-cleanup-retention", "", 90, "Number of days a usage indicator is kept without being updated. 0 disables the cleanup")
+	serveCmd.Flags().StringP("tasks-reminders-recurrence", "", "0 0 8 * * 1", "Recurrence of the reminders of overdue matrix lines sent to project managers (see https://godoc.org/github.com/robfig/cron)")
+	serveCmd.Flags().IntP("exports-retention", "", 24, "Number of hours an asynchronous export file can be downloaded before being deleted")
 
 	// Bind env variables.
 	_ = viper.BindPFlag("server.mongo.addr", serveCmd.Flags().Lookup("mongo-addr"))
 	_ = viper.BindPFlag("server.mongo.username", serveCmd.Flags().Lookup("mongo-username"))
 	_ = viper.BindPFlag("server.mongo.password", serveCmd.Flags().Lookup("mongo-password"))
 	_ = viper.BindPFlag("auth.jwt-secret", serveCmd.Flags().Lookup("jwt-secret"))
+	_ = viper.BindPFlag("auth.access-token-validity", serveCmd.Flags().Lookup("access-token-validity"))
+	_ = viper.BindPFlag("auth.refresh-token-validity", serveCmd.Flags().Lookup("refresh-token-validity"))
 	_ = viper.BindPFlag("auth.reset-pwd-secret", serveCmd.Flags().Lookup("reset-pwd-secret"))
 	_ = viper.BindPFlag("auth.bcrypt-pepper", serveCmd.Flags().Lookup("bcrypt-pepper"))
+	_ = viper.BindPFlag("server.url", serveCmd.Flags().Lookup("server-url"))
 	_ = viper.BindPFlag("ldap.enable", serveCmd.Flags().Lookup("ldap-enable"))
 	_ = viper.BindPFlag("ldap.address", serveCmd.Flags().Lookup("ldap-address"))
+	_ = viper.BindPFlag("ldap.failover", serveCmd.Flags().Lookup("ldap-failover"))
+	_ = viper.BindPFlag("ldap.tls", serveCmd.Flags().Lookup("ldap-tls"))
+	_ = viper.BindPFlag("ldap.caFile", serveCmd.Flags().Lookup("ldap-caFile"))
+	_ = viper.BindPFlag("ldap.poolSize", serveCmd.Flags().Lookup("ldap-poolSize"))
+	_ = viper.BindPFlag("ldap.timeout", serveCmd.Flags().Lookup("ldap-timeout"))
 	_ = viper.BindPFlag("ldap.baseDN", serveCmd.Flags().Lookup("ldap-baseDN"))
 	_ = viper.BindPFlag("ldap.domain", serveCmd.Flags().Lookup("ldap-domain"))
 	_ = viper.BindPFlag("ldap.bindDN", serveCmd.Flags().Lookup("ldap-bindDN"))
@@ -91,6 +136,16 @@ func init() {
 	_ = viper.BindPFlag("ldap.attr.lastname", serveCmd.Flags().Lookup("ldap-attr-lastname"))
 	_ = viper.BindPFlag("ldap.attr.realname", serveCmd.Flags().Lookup("ldap-attr-realname"))
 	_ = viper.BindPFlag("ldap.attr.email", serveCmd.Flags().Lookup("ldap-attr-email"))
+	_ = viper.BindPFlag("ldap.attr.groups", serveCmd.Flags().Lookup("ldap-attr-groups"))
+	_ = viper.BindPFlag("ldap.groupBaseDN", serveCmd.Flags().Lookup("ldap-groupBaseDN"))
+	_ = viper.BindPFlag("ldap.groupSearchFilter", serveCmd.Flags().Lookup("ldap-groupSearchFilter"))
+	_ = viper.BindPFlag("oidc.issuer", serveCmd.Flags().Lookup("oidc-issuer"))
+	_ = viper.BindPFlag("oidc.client-id", serveCmd.Flags().Lookup("oidc-client-id"))
+	_ = viper.BindPFlag("oidc.client-secret", serveCmd.Flags().Lookup("oidc-client-secret"))
+	_ = viper.BindPFlag("oidc.redirect-url", serveCmd.Flags().Lookup("oidc-redirect-url"))
+	_ = viper.BindPFlag("oidc.scopes", serveCmd.Flags().Lookup("oidc-scopes"))
+	_ = viper.BindPFlag("oidc.username-claim", serveCmd.Flags().Lookup("oidc-username-claim"))
+	_ = viper.BindPFlag("oidc.state-secret", serveCmd.Flags().Lookup("oidc-state-secret"))
 	_ = viper.BindPFlag("smtp.server", serveCmd.Flags().Lookup("smtp-server"))
 	_ = viper.BindPFlag("smtp.user", serveCmd.Flags().Lookup("smtp-user"))
 	_ = viper.BindPFlag("smtp.password", serveCmd.Flags().Lookup("smtp-password"))
@@ -102,8 +157,25 @@ func init() {
 	_ = viper.BindPFlag("docktor.addr", serveCmd.Flags().Lookup("docktor-addr"))
 	_ = viper.BindPFlag("docktor.user", serveCmd.Flags().Lookup("docktor-user"))
 	_ = viper.BindPFlag("docktor.password", serveCmd.Flags().Lookup("docktor-password"))
+	_ = viper.BindPFlag("docktor.timeout", serveCmd.Flags().Lookup("docktor-timeout"))
+	_ = viper.BindPFlag("docktor.retries", serveCmd.Flags().Lookup("docktor-retries"))
+	_ = viper.BindPFlag("docktor.retryDelay", serveCmd.Flags().Lookup("docktor-retryDelay"))
+	_ = viper.BindPFlag("docktor.rateLimit", serveCmd.Flags().Lookup("docktor-rateLimit"))
+	_ = viper.BindPFlag("docktor.breakerThreshold", serveCmd.Flags().Lookup("docktor-breakerThreshold"))
+	_ = viper.BindPFlag("docktor.breakerCooldown", serveCmd.Flags().Lookup("docktor-breakerCooldown"))
 	_ = viper.BindPFlag("tasks.recurrence", serveCmd.Flags().Lookup("tasks-recurrence"))
 	_ = viper.BindPFlag("tasks.recurrence.updateProgress", serveCmd.Flags().Lookup("tasks-recurrence-updateProgress"))
+	_ = viper.BindPFlag("tasks.recurrence.workers", serveCmd.Flags().Lookup("tasks-recurrence-workers"))
+	_ = viper.BindPFlag("tasks.snapshot.recurrence", serveCmd.Flags().Lookup("tasks-snapshot-recurrence"))
+	_ = viper.BindPFlag("tasks.purge.recurrence", serveCmd.Flags().Lookup("tasks-purge-recurrence"))
+	_ = viper.BindPFlag("tasks.purge.retention", serveCmd.Flags().Lookup("tasks-purge-retention"))
+	_ = viper.BindPFlag("tasks.ldap-sync.recurrence", serveCmd.Flags().Lookup("tasks-ldap-sync-recurrence"))
+	_ = viper.BindPFlag("tasks.exports.recurrence", serveCmd.Flags().Lookup("tasks-exports-recurrence"))
+	_ = viper.BindPFlag("tasks.docktor-groups.recurrence", serveCmd.Flags().Lookup("tasks-docktor-groups-recurrence"))
+	_ = viper.BindPFlag("tasks.indicators-cleanup.recurrence", serveCmd.Flags().Lookup("tasks-indicators-cleanup-recurrence"))
+	_ = viper.BindPFlag("tasks.indicators-cleanup.retention", serveCmd.Flags().Lookup("tasks-indicators-cleanup-retention"))
+	_ = viper.BindPFlag("tasks.reminders.recurrence", serveCmd.Flags().Lookup("tasks-reminders-recurrence"))
+	_ = viper.BindPFlag("exports.retention", serveCmd.Flags().Lookup("exports-retention"))
 	RootCmd.AddCommand(serveCmd)
 
 }
@@ -4,25 +4,36 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/spf13/viper"
 	"gopkg.in/ldap.v2"
 )
 
 // LDAP is an LDAP entry point allowing authentication with an LDAP server
+// It is safe for concurrent use, connections being taken from a pool
 type LDAP struct {
 	server *LDAPConf
-	conn   *ldap.Conn
+	pool   *ldapPool
 }
 
 // LDAPConf contains data used to connect to an LDAP directory service
 type LDAPConf struct {
-	LdapServer   string
-	BaseDN       string
-	BindDN       string
-	BindPassword string
-	SearchFilter string
-	Attr         Attributes
+	LdapServer        string
+	Failover          []string      // Addresses of the failover servers, tried in order when the previous servers are unreachable
+	TLS               string        // Empty for plain connections, LDAPS or StartTLS otherwise
+	CAFile            string        // PEM bundle of the CAs verifying the certificate of the servers. System CAs are used otherwise
+	PoolSize          int           // Maximum number of connections to the servers
+	Timeout           time.Duration // Timeout of the connections and requests, and of the wait for a free connection
+	BaseDN            string
+	BindDN            string
+	BindPassword      string
+	SearchFilter      string
+	GroupBaseDN       string // Base DN of the group search. Optional, groups are read from the Groups attribute otherwise
+	GroupSearchFilter string // Filter of the group search, where %s is replaced by the DN of the user
+	Attr              Attributes
+	Mappings          GroupMappings // Roles and entities given to the members of groups
 }
 
 // Attributes list all LDAP attributes names in the LDAP
@@ -32,6 +43,7 @@ type Attributes struct {
 	Lastname  string
 	Realname  string
 	Email     string
+	Groups    string // Attribute listing the DN of the groups of the user, like memberOf
 }
 
 //LDAPUserInfo contains LDAP attributes values for a user
@@ -42,31 +54,72 @@ type LDAPUserInfo struct {
 	LastName  string
 	RealName  string
 	Email     string
+	Groups    []string // DN of the groups of the user
+}
+
+// NewLDAPConf reads the LDAP configuration
+// Group mappings can only be given in the configuration file, as a list of [[ldap.mappings]] tables
+func NewLDAPConf() *LDAPConf {
+	mappings := GroupMappings{}
+	if err := viper.UnmarshalKey("ldap.mappings", &mappings); err != nil {
+		log.WithError(err).Error("Invalid LDAP group mappings, they are ignored")
+		mappings = GroupMappings{}
+	}
+	return &LDAPConf{
+		LdapServer:        viper.GetString("ldap.address"),
+		Failover:          viper.GetStringSlice("ldap.failover"),
+		TLS:               viper.GetString("ldap.tls"),
+		CAFile:            viper.GetString("ldap.caFile"),
+		PoolSize:          viper.GetInt("ldap.poolSize"),
+		Timeout:           viper.GetDuration("ldap.timeout"),
+		BaseDN:            viper.GetString("ldap.baseDN"),
+		BindDN:            viper.GetString("ldap.bindDN"),
+		BindPassword:      viper.GetString("ldap.bindPassword"),
+		SearchFilter:      viper.GetString("ldap.searchFilter"),
+		GroupBaseDN:       viper.GetString("ldap.groupBaseDN"),
+		GroupSearchFilter: viper.GetString("ldap.groupSearchFilter"),
+		Attr: Attributes{
+			Username:  viper.GetString("ldap.attr.username"),
+			Firstname: viper.GetString("ldap.attr.firstname"),
+			Lastname:  viper.GetString("ldap.attr.lastname"),
+			Realname:  viper.GetString("ldap.attr.realname"),
+			Email:     viper.GetString("ldap.attr.email"),
+			Groups:    viper.GetString("ldap.attr.groups"),
+		},
+		Mappings: mappings,
+	}
 }
 
 //NewLDAP create a LDAP entrypoint
 func NewLDAP(server *LDAPConf) *LDAP {
-	return &LDAP{server: server}
+	a := &LDAP{server: server}
+	a.pool = newLDAPPool(server, a.initialBind)
+	return a
+}
+
+// Close closes the idle connections to the LDAP servers
+func (a *LDAP) Close() {
+	a.pool.close()
+}
+
+// Mappings returns the roles and entities given to the members of LDAP groups
+func (a *LDAP) Mappings() GroupMappings {
+	return a.server.Mappings
 }
 
 // Search search existence of username in LDAP
 // Returns the info about the user if found, error otherwize
 func (a *LDAP) Search(username string) (*LDAPUserInfo, error) {
-	// Reach the ldap server
-	if err := a.dial(); err != nil {
-		log.WithError(err).Error("LDAP dialing failed")
-		return nil, err
-	}
-	defer a.conn.Close()
-
-	// perform initial authentication
-	if err := a.initialBind(); err != nil {
-		log.WithError(err).Error("LDAP binding failed")
+	// Reach the ldap server, with initial authentication
+	conn, err := a.pool.get()
+	if err != nil {
+		log.WithError(err).Error("LDAP connection failed")
 		return nil, err
 	}
 
 	// find user entry & attributes
-	ldapUser, err := a.searchForUser(username)
+	ldapUser, err := a.searchForUser(conn, username)
+	a.pool.put(conn, err)
 	if err != nil {
 		log.WithError(err).WithField("username", username).Error("Error looking for user in AD")
 		return nil, err
@@ -77,60 +130,47 @@ func (a *LDAP) Search(username string) (*LDAPUserInfo, error) {
 
 //Login log the user in
 func (a *LDAP) Login(query *LoginUserQuery) (*LDAPUserInfo, error) {
-	// Reach the ldap server
-	if err := a.dial(); err != nil {
-		log.WithError(err).Error("LDAP dialing failed")
-		return nil, err
-	}
-	defer a.conn.Close()
-
-	// perform initial authentication
-	if err := a.initialBind(); err != nil {
-		log.WithError(err).Error("LDAP binding failed")
+	// Reach the ldap server, with initial authentication
+	conn, err := a.pool.get()
+	if err != nil {
+		log.WithError(err).Error("LDAP connection failed")
 		return nil, err
 	}
 
 	// find user entry & attributes
-	ldapUser, err := a.searchForUser(query.Username)
+	ldapUser, err := a.searchForUser(conn, query.Username)
 	if err != nil {
+		a.pool.put(conn, err)
 		log.WithError(err).WithField("username", query.Username).Error("Error looking for user in AD")
 		return nil, err
 	}
 
 	// Authenticate user with password
-	return ldapUser, a.secondBind(ldapUser, query.Password)
+	err = a.secondBind(conn, ldapUser, query.Password)
+	a.pool.put(conn, err)
+	return ldapUser, err
 
 }
 
-// Dial dials the LDAP server
-func (a *LDAP) dial() (err error) {
-	a.conn, err = ldap.Dial("tcp", a.server.LdapServer)
-	return err
-}
-
-// initialBind creates the first connexion with readonly user
-func (a *LDAP) initialBind() error {
+// initialBind authenticates the connection with readonly user
+func (a *LDAP) initialBind(conn *ldap.Conn) error {
 
 	if a.server.BindPassword == "" || a.server.BindDN == "" {
 		return fmt.Errorf("Bindpassword or bindDN (%s) is empty", a.server.BindDN)
 	}
 
 	// LDAP Bind
-	if err := a.conn.Bind(a.server.BindDN, a.server.BindPassword); err != nil {
-		if ldapErr, ok := err.(*ldap.Error); ok {
-			if ldapErr.ResultCode == ldap.LDAPResultInvalidCredentials {
-				return ErrInvalidCredentials
-			}
-		}
-		return err
+	// The credentials of the readonly user are configured: their failure is not the failure of the user logging in
+	if err := conn.Bind(a.server.BindDN, a.server.BindPassword); err != nil {
+		return fmt.Errorf("LDAP bind with %s failed: %v", a.server.BindDN, err)
 	}
 
 	return nil
 }
 
 // secondBind authenticate the user
-func (a *LDAP) secondBind(ldapUser *LDAPUserInfo, userPassword string) error {
-	if err := a.conn.Bind(ldapUser.DN, userPassword); err != nil {
+func (a *LDAP) secondBind(conn *ldap.Conn, ldapUser *LDAPUserInfo, userPassword string) error {
+	if err := conn.Bind(ldapUser.DN, userPassword); err != nil {
 		log.WithError(err).WithField("ldapUser", *ldapUser).Error("Failed LDAP secondBind")
 		if ldapErr, ok := err.(*ldap.Error); ok {
 			if ldapErr.ResultCode == ldap.LDAPResultInvalidCredentials {
@@ -144,26 +184,31 @@ func (a *LDAP) secondBind(ldapUser *LDAPUserInfo, userPassword string) error {
 }
 
 // searchForUser search the user in LDAP
-func (a *LDAP) searchForUser(username string) (*LDAPUserInfo, error) {
+func (a *LDAP) searchForUser(conn *ldap.Conn, username string) (*LDAPUserInfo, error) {
 	var searchResult *ldap.SearchResult
 	var err error
 
+	attributes := []string{
+		a.server.Attr.Username,
+		a.server.Attr.Firstname,
+		a.server.Attr.Lastname,
+		a.server.Attr.Realname,
+		a.server.Attr.Email,
+		"dn",
+	}
+	if a.server.Attr.Groups != "" {
+		attributes = append(attributes, a.server.Attr.Groups)
+	}
+
 	searchReq := ldap.SearchRequest{
 		BaseDN:       a.server.BaseDN,
 		Scope:        ldap.ScopeWholeSubtree,
 		DerefAliases: ldap.NeverDerefAliases,
-		Attributes: []string{
-			a.server.Attr.Username,
-			a.server.Attr.Firstname,
-			a.server.Attr.Lastname,
-			a.server.Attr.Realname,
-			a.server.Attr.Email,
-			"dn",
-		},
-		Filter: strings.Replace(a.server.SearchFilter, "%s", ldap.EscapeFilter(username), -1),
+		Attributes:   attributes,
+		Filter:       strings.Replace(a.server.SearchFilter, "%s", ldap.EscapeFilter(username), -1),
 	}
 
-	searchResult, err = a.conn.Search(&searchReq)
+	searchResult, err = conn.Search(&searchReq)
 	if err != nil {
 		return nil, err
 	}
@@ -176,14 +221,73 @@ func (a *LDAP) searchForUser(username string) (*LDAPUserInfo, error) {
 		return nil, errors.New("Ldap search matched more than one entry, please review your filter setting")
 	}
 
-	return &LDAPUserInfo{
+	ldapUser := &LDAPUserInfo{
 		DN:        searchResult.Entries[0].DN,
 		Username:  getLdapAttr(a.server.Attr.Username, searchResult),
 		FirstName: getLdapAttr(a.server.Attr.Firstname, searchResult),
 		LastName:  getLdapAttr(a.server.Attr.Lastname, searchResult),
 		RealName:  getLdapAttr(a.server.Attr.Realname, searchResult),
 		Email:     getLdapAttr(a.server.Attr.Email, searchResult),
-	}, nil
+	}
+	if a.server.Attr.Groups != "" {
+		ldapUser.Groups = searchResult.Entries[0].GetAttributeValues(a.server.Attr.Groups)
+	}
+	if a.server.GroupBaseDN != "" {
+		groups, err := a.searchForGroups(conn, ldapUser.DN)
+		if err != nil {
+			return nil, err
+		}
+		ldapUser.Groups = append(ldapUser.Groups, groups...)
+	}
+
+	return ldapUser, nil
+}
+
+// searchForGroups search the DN of the groups whose the user is member
+func (a *LDAP) searchForGroups(conn *ldap.Conn, userDN string) ([]string, error) {
+	searchReq := ldap.SearchRequest{
+		BaseDN:       a.server.GroupBaseDN,
+		Scope:        ldap.ScopeWholeSubtree,
+		DerefAliases: ldap.NeverDerefAliases,
+		Attributes:   []string{"dn"},
+		Filter:       strings.Replace(a.server.GroupSearchFilter, "%s", ldap.EscapeFilter(userDN), -1),
+	}
+
+	searchResult, err := conn.Search(&searchReq)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := []string{}
+	for _, entry := range searchResult.Entries {
+		groups = append(groups, entry.DN)
+	}
+	return groups, nil
+}
+
+// SearchAll searches the users in LDAP, with a single connection
+// Users who are not in the directory anymore are missing from the result. Any other error stops the search
+func (a *LDAP) SearchAll(usernames []string) (map[string]*LDAPUserInfo, error) {
+	// Reach the ldap server, with initial authentication
+	conn, err := a.pool.get()
+	if err != nil {
+		log.WithError(err).Error("LDAP connection failed")
+		return nil, err
+	}
+
+	ldapUsers := map[string]*LDAPUserInfo{}
+	for _, username := range usernames {
+		ldapUser, err := a.searchForUser(conn, username)
+		if err == ErrInvalidCredentials {
+			continue
+		} else if err != nil {
+			a.pool.put(conn, err)
+			return nil, err
+		}
+		ldapUsers[username] = ldapUser
+	}
+	a.pool.put(conn, nil)
+	return ldapUsers, nil
 }
 
 func getLdapAttrN(name string, result *ldap.SearchResult, n int) string {
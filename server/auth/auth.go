@@ -7,14 +7,9 @@ import (
 	log "github.com/Sirupsen/logrus"
 	"github.com/dgrijalva/jwt-go"
 	"github.com/soprasteria/dad/server/types"
-	"github.com/spf13/viper"
 	"gopkg.in/mgo.v2/bson"
 )
 
-const (
-	authenticationTokenValidity = time.Hour * 24 * 7
-)
-
 var (
 	// ErrInvalidCredentials is an error message when credentials are invalid
 	ErrInvalidCredentials = errors.New("Invalid Username or Password")
@@ -22,12 +17,49 @@ var (
 	ErrUsernameAlreadyTaken = errors.New("Username already taken")
 	// ErrUsernameAlreadyTakenOnLDAP is an error message when the username is already used by someone else on LDAP
 	ErrUsernameAlreadyTakenOnLDAP = errors.New("Username already taken in the configured LDAP server. Try login instead")
+	// ErrUserDisabled is an error message when a disabled user tries to log in
+	ErrUserDisabled = errors.New("Your account is disabled")
+	// ErrAuthenticationUnavailable is an error message when the credentials can't be checked, like when the LDAP server is unreachable
+	ErrAuthenticationUnavailable = errors.New("Authentication is unavailable, try again later")
 )
 
+// loginError returns the error of a failed login to return to the user
+// Only the rejection of the credentials is an invalid credentials error: failures of the provider itself are errors of DAD, and the user can try again later
+func loginError(err error) error {
+	if err == ErrInvalidCredentials || err == ErrLDAPPoolExhausted {
+		return err
+	}
+	return ErrAuthenticationUnavailable
+}
+
+// UserStore is the storage of users needed by the authentication, implemented by types.UserRepo
+type UserStore interface {
+	FindByUsername(username string) (types.User, error)
+	Save(user types.User) (types.User, error)
+}
+
+// RefreshTokenStore is the storage of refresh tokens, implemented by types.RefreshTokenRepo
+type RefreshTokenStore interface {
+	FindByHash(hash string) (types.RefreshToken, error)
+	Save(token types.RefreshToken) (types.RefreshToken, error)
+	Use(id bson.ObjectId) error
+	DeleteFamily(family bson.ObjectId) error
+	DeleteByUsername(username string) error
+}
+
+// RevokedTokenStore is the revocation list of access tokens, implemented by types.RevokedTokenRepo
+type RevokedTokenStore interface {
+	RevokeToken(tokenID string, expiresAt time.Time) error
+	RevokeUser(username string, expiresAt time.Time) error
+	IsRevoked(tokenID, username string, issuedAt time.Time) (bool, error)
+}
+
 // Authentication contains all APIs entrypoints needed for authentication
 type Authentication struct {
-	Users types.UserRepo
-	LDAP  *LDAP
+	Users         UserStore
+	RefreshTokens RefreshTokenStore
+	RevokedTokens RevokedTokenStore
+	Providers     []Provider // Providers authenticating users with a password, by order of priority for unknown users
 }
 
 // LoginUserQuery represents connection data
@@ -42,13 +74,6 @@ type MyCustomClaims struct {
 	jwt.StandardClaims
 }
 
-// CreateLoginToken generates a signed JWT Token from user to get the token when logged in.
-func (a *Authentication) CreateLoginToken(username string) (string, error) {
-	oneWeek := time.Now().Add(authenticationTokenValidity)
-	authSecret := viper.GetString("auth.jwt-secret")
-	return createToken(username, authSecret, oneWeek)
-}
-
 // createToken generates a JWT token from a username, a secret key and an expiration date to securise it
 func createToken(username, secret string, expiresAt time.Time) (string, error) {
 	claims := MyCustomClaims{
@@ -69,8 +94,10 @@ func createToken(username, secret string, expiresAt time.Time) (string, error) {
 	return signedToken, nil
 }
 
-// AuthenticateUser authenticates a user
-func (a *Authentication) AuthenticateUser(query *LoginUserQuery) error {
+// AuthenticateUser authenticates a user with the provider owning it
+// Unknown users are authenticated by the first provider able to register them, and created in DAD
+// The returned user is the one saved in DAD, whose username may differ in case from the typed one
+func (a *Authentication) AuthenticateUser(query *LoginUserQuery) (types.User, error) {
 	log.WithField("username", query.Username).Debug("Trying to fetch user from database for authentication")
 	user, err := a.Users.FindByUsername(query.Username)
 	if err != nil || user.ID.Hex() == "" {
@@ -81,62 +108,110 @@ func (a *Authentication) AuthenticateUser(query *LoginUserQuery) error {
 	return a.authenticateWhenUserFound(user, query)
 }
 
-func (a *Authentication) authenticateWhenUserFound(user types.User, query *LoginUserQuery) error {
+// provider returns the configured provider with given name, nil when it is not configured
+func (a *Authentication) provider(name string) Provider {
+	for _, provider := range a.Providers {
+		if provider.Name() == name {
+			return provider
+		}
+	}
+	return nil
+}
+
+func (a *Authentication) authenticateWhenUserFound(user types.User, query *LoginUserQuery) (types.User, error) {
 	log.WithFields(log.Fields{
 		"username": query.Username,
+		"provider": user.AuthProvider(),
 	}).Debug("Authentication")
-	// User is from LDAP
-	if a.LDAP != nil {
-		ldapUser, err := a.LDAP.Login(query)
-		if err != nil {
-			log.WithError(err).WithField("username", user.Username).Error("LDAP authentication failed")
-			return ErrInvalidCredentials
-		}
+	if user.Disabled {
+		log.WithField("username", user.Username).Warn("Disabled user tried to log in")
+		return types.User{}, ErrUserDisabled
+	}
+	provider := a.provider(user.AuthProvider())
+	if provider == nil {
+		log.WithField("username", user.Username).Errorf("Authentication provider %q is not configured", user.AuthProvider())
+		return types.User{}, ErrAuthenticationUnavailable
+	}
 
-		user.Updated = time.Now()
-		user.FirstName = ldapUser.FirstName
-		user.LastName = ldapUser.LastName
-		user.DisplayName = ldapUser.FirstName + " " + ldapUser.LastName
-		user.Username = ldapUser.Username
-		user.Email = ldapUser.Email
-		if user.ID.Hex() == "" {
-			user.ID = bson.NewObjectId()
-		}
-		_, err = a.Users.Save(user)
-		if err != nil {
-			log.WithError(err).WithField("username", user).Error("Failed to save LDAP user in DB")
-			return err
-		}
-		return nil
+	info, err := provider.Login(query)
+	if err != nil {
+		log.WithError(err).WithField("username", user.Username).Errorf("%s authentication failed", provider.Name())
+		return types.User{}, loginError(err)
+	}
+	user, err = a.saveUser(user, info, provider.Name())
+	if err != nil {
+		log.WithError(err).WithField("username", user.Username).Error("Failed to save user in DB")
 	}
-	return ErrInvalidCredentials
+	return user, err
 }
 
-func (a *Authentication) authenticateWhenUserNotFound(query *LoginUserQuery) error {
-	if a.LDAP != nil {
-		// Authenticating with LDAP
-		ldapUser, err := a.LDAP.Login(query)
+func (a *Authentication) authenticateWhenUserNotFound(query *LoginUserQuery) (types.User, error) {
+	// The user may be known by a provider which failed, so the login is not refused when a provider fails
+	loginErr := ErrInvalidCredentials
+	for _, provider := range a.Providers {
+		if !provider.CanRegister() {
+			continue
+		}
+		info, err := provider.Login(query)
 		if err != nil {
-			return ErrInvalidCredentials
+			log.WithError(err).WithField("username", query.Username).Debugf("%s authentication failed", provider.Name())
+			if err != ErrInvalidCredentials {
+				loginErr = loginError(err)
+			}
+			continue
 		}
-
 		user := types.User{
-			FirstName:   ldapUser.FirstName,
-			LastName:    ldapUser.LastName,
-			DisplayName: ldapUser.FirstName + " " + ldapUser.LastName,
-			Username:    ldapUser.Username,
-			Email:       ldapUser.Email,
-			Role:        types.DefaultRole(),
-			Created:     time.Now(),
-			Updated:     time.Now(),
-		}
-		if user.ID.Hex() == "" {
-			user.ID = bson.NewObjectId()
+			Role:    types.DefaultRole(),
+			Created: time.Now(),
 		}
-		_, err = a.Users.Save(user)
-		return err
+		return a.saveUser(user, info, provider.Name())
 	}
 
 	// When user is not found, there is no way to authenticate in application
-	return ErrInvalidCredentials
+	return types.User{}, loginErr
+}
+
+// AuthenticateExternalUser finds or creates the user authenticated by an external identity provider, like OpenID Connect
+// The username can't be used when it is already owned by another provider
+func (a *Authentication) AuthenticateExternalUser(provider string, info *UserInfo) (types.User, error) {
+	user, err := a.Users.FindByUsername(info.Username)
+	if err != nil || user.ID.Hex() == "" {
+		log.WithField("username", info.Username).Infof("Creating user authenticated by %s", provider)
+		user = types.User{
+			Role:    types.DefaultRole(),
+			Created: time.Now(),
+		}
+	} else if user.AuthProvider() != provider {
+		log.WithField("username", info.Username).Warnf("Username is already owned by provider %s", user.AuthProvider())
+		return types.User{}, ErrUsernameAlreadyTaken
+	} else if user.Disabled {
+		return types.User{}, ErrUserDisabled
+	}
+	return a.saveUser(user, info, provider)
+}
+
+// saveUser updates the identity of the user, as given by its provider, and saves it
+func (a *Authentication) saveUser(user types.User, info *UserInfo, provider string) (types.User, error) {
+	UpdateUserInfo(&user, info)
+	user.Provider = provider
+	if user.ID.Hex() == "" {
+		user.ID = bson.NewObjectId()
+	}
+	return a.Users.Save(user)
+}
+
+// UpdateUserInfo updates the identity of the user, and its role and entities when they are managed by its provider
+func UpdateUserInfo(user *types.User, info *UserInfo) {
+	user.Updated = time.Now()
+	user.FirstName = info.FirstName
+	user.LastName = info.LastName
+	user.DisplayName = info.FirstName + " " + info.LastName
+	user.Username = info.Username
+	user.Email = info.Email
+	if info.Role != "" {
+		user.Role = info.Role
+	}
+	if info.Entities != nil {
+		user.Entities = info.Entities
+	}
 }
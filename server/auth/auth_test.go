@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/soprasteria/dad/server/types"
+)
+
+// failingProvider is a provider whose logins all fail with the same error
+type failingProvider struct {
+	name string
+	err  error
+}
+
+func (p failingProvider) Name() string {
+	return p.name
+}
+
+func (p failingProvider) Login(query *LoginUserQuery) (*UserInfo, error) {
+	return nil, p.err
+}
+
+func (p failingProvider) CanRegister() bool {
+	return true
+}
+
+// loginErr returns the error of an authentication
+func loginErr(_ types.User, err error) error {
+	return err
+}
+
+func TestAuthenticationErrors(t *testing.T) {
+
+	query := &LoginUserQuery{Username: "jdoe", Password: "password"}
+	user := types.User{Username: "jdoe", Provider: types.LDAPProvider}
+	unreachable := errors.New("LDAP Result Code 200 \"Network Error\"")
+
+	Convey("Given a user whose credentials are rejected by its provider", t, func() {
+		login := Authentication{Providers: []Provider{failingProvider{name: types.LDAPProvider, err: ErrInvalidCredentials}}}
+		Convey("Then the credentials are invalid", func() {
+			So(loginErr(login.authenticateWhenUserFound(user, query)), ShouldEqual, ErrInvalidCredentials)
+			So(loginErr(login.authenticateWhenUserNotFound(query)), ShouldEqual, ErrInvalidCredentials)
+		})
+	})
+
+	Convey("Given a provider failing to check the credentials", t, func() {
+		login := Authentication{Providers: []Provider{failingProvider{name: types.LDAPProvider, err: unreachable}}}
+		Convey("Then the authentication is unavailable", func() {
+			So(loginErr(login.authenticateWhenUserFound(user, query)), ShouldEqual, ErrAuthenticationUnavailable)
+			So(loginErr(login.authenticateWhenUserNotFound(query)), ShouldEqual, ErrAuthenticationUnavailable)
+		})
+	})
+
+	Convey("Given a provider without free connection", t, func() {
+		login := Authentication{Providers: []Provider{failingProvider{name: types.LDAPProvider, err: ErrLDAPPoolExhausted}}}
+		Convey("Then the user is told to try again later", func() {
+			So(loginErr(login.authenticateWhenUserFound(user, query)), ShouldEqual, ErrLDAPPoolExhausted)
+		})
+	})
+
+	Convey("Given a user whose provider is not configured", t, func() {
+		login := Authentication{}
+		Convey("Then the authentication is unavailable", func() {
+			So(loginErr(login.authenticateWhenUserFound(user, query)), ShouldEqual, ErrAuthenticationUnavailable)
+		})
+	})
+}
@@ -31,3 +31,36 @@ func SendWelcomeEmail(user types.User) {
 		log.WithError(err).WithField("username", user.Username).Error("Failed to send welcome email")
 	}
 }
+
+// SendResetPasswordEmail sends the link allowing a local user to choose a new password
+func SendResetPasswordEmail(user types.User, link string) {
+	err := email.Send(email.SendOptions{
+		To: []mail.Address{
+			{Name: user.DisplayName, Address: user.Email},
+		},
+
+		Subject: "Choose your D.A.D password",
+
+		Body: hermes.Email{
+			Body: hermes.Body{
+				Name: user.DisplayName,
+				Intros: []string{
+					"You have received this email because a password reset was requested for your D.A.D account " + user.Username + ".",
+				},
+				Actions: []hermes.Action{
+					{
+						Instructions: "Click the button below to choose your password. This link is valid for one hour:",
+						Button: hermes.Button{
+							Color: "#DC4D2F",
+							Text:  "Choose your password",
+							Link:  link,
+						},
+					},
+				},
+			},
+		}})
+
+	if err != nil {
+		log.WithError(err).WithField("username", user.Username).Error("Failed to send reset password email")
+	}
+}
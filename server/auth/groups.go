@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/soprasteria/dad/server/types"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// GroupMapping gives a role and entities to the members of a LDAP group
+type GroupMapping struct {
+	Group    string     `mapstructure:"group"` // DN of the group
+	Role     types.Role `mapstructure:"role"`
+	Entities []string   `mapstructure:"entities"` // IDs of the entities
+}
+
+// GroupMappings are all the mappings of LDAP groups
+// Roles are managed by LDAP as soon as a mapping gives a role, and entities as soon as a mapping gives entities
+type GroupMappings []GroupMapping
+
+// rolesPriority orders the roles, from the one with the most rights
+var rolesPriority = []types.Role{types.AdminRole, types.RIRole, types.PMRole, types.DeputyRole}
+
+func (m GroupMappings) managesRoles() bool {
+	for _, mapping := range m {
+		if mapping.Role != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func (m GroupMappings) managesEntities() bool {
+	for _, mapping := range m {
+		if len(mapping.Entities) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply returns the role and the entities given to the member of the groups
+// The role is the one with the most rights among the groups, the default role when no group gives a role
+// The role is empty when roles are not managed by LDAP, and entities are nil when entities are not managed by LDAP
+func (m GroupMappings) Apply(groups []string) (types.Role, []bson.ObjectId) {
+	var role types.Role
+	var entities []bson.ObjectId
+	if m.managesEntities() {
+		entities = []bson.ObjectId{}
+	}
+
+	for _, mapping := range m {
+		if !isMember(groups, mapping.Group) {
+			continue
+		}
+		if mapping.Role != "" && rolePriority(mapping.Role) < rolePriority(role) {
+			role = mapping.Role
+		}
+		for _, entity := range mapping.Entities {
+			if !bson.IsObjectIdHex(entity) {
+				log.WithField("group", mapping.Group).Warnf("Entity %q of the LDAP group mapping is not a valid ID", entity)
+				continue
+			}
+			if id := bson.ObjectIdHex(entity); !containsID(entities, id) {
+				entities = append(entities, id)
+			}
+		}
+	}
+	// The default role is only given without mapped role, as it has more rights than the deputy role
+	if role == "" && m.managesRoles() {
+		role = types.DefaultRole()
+	}
+	return role, entities
+}
+
+func rolePriority(role types.Role) int {
+	for i, r := range rolesPriority {
+		if r == role {
+			return i
+		}
+	}
+	return len(rolesPriority)
+}
+
+// isMember checks the group is one of the groups, DNs being case insensitive
+func isMember(groups []string, group string) bool {
+	for _, g := range groups {
+		if strings.EqualFold(g, group) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsID(ids []bson.ObjectId, id bson.ObjectId) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/soprasteria/dad/server/types"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestGroupMappings(t *testing.T) {
+
+	banking := bson.NewObjectId()
+	paris := bson.NewObjectId()
+
+	Convey("Given mappings of roles and entities", t, func() {
+		mappings := GroupMappings{
+			{Group: "cn=dad-admins,ou=groups,dc=dad,dc=io", Role: types.AdminRole},
+			{Group: "cn=dad-ri,ou=groups,dc=dad,dc=io", Role: types.RIRole, Entities: []string{banking.Hex()}},
+			{Group: "cn=paris,ou=groups,dc=dad,dc=io", Entities: []string{paris.Hex(), banking.Hex()}},
+			{Group: "cn=dad-deputies,ou=groups,dc=dad,dc=io", Role: types.DeputyRole},
+		}
+
+		Convey("When the user is member of several groups", func() {
+			role, entities := mappings.Apply([]string{"CN=DAD-RI,ou=groups,dc=dad,dc=io", "cn=paris,ou=groups,dc=dad,dc=io", "cn=other"})
+			Convey("Then he gets the highest role and all the entities", func() {
+				So(role, ShouldEqual, types.RIRole)
+				So(entities, ShouldResemble, []bson.ObjectId{banking, paris})
+			})
+		})
+
+		Convey("When the user is member of an admin group", func() {
+			role, _ := mappings.Apply([]string{"cn=dad-ri,ou=groups,dc=dad,dc=io", "cn=dad-admins,ou=groups,dc=dad,dc=io"})
+			Convey("Then he is an admin", func() {
+				So(role, ShouldEqual, types.AdminRole)
+			})
+		})
+
+		Convey("When the user is member of a deputy group", func() {
+			role, entities := mappings.Apply([]string{"cn=dad-deputies,ou=groups,dc=dad,dc=io", "cn=paris,ou=groups,dc=dad,dc=io"})
+			Convey("Then he is a deputy, and not given the default role", func() {
+				So(role, ShouldEqual, types.DeputyRole)
+				So(entities, ShouldResemble, []bson.ObjectId{paris, banking})
+			})
+		})
+
+		Convey("When the user is member of a deputy group and of a RI group", func() {
+			role, _ := mappings.Apply([]string{"cn=dad-deputies,ou=groups,dc=dad,dc=io", "cn=dad-ri,ou=groups,dc=dad,dc=io"})
+			Convey("Then he gets the highest role", func() {
+				So(role, ShouldEqual, types.RIRole)
+			})
+		})
+
+		Convey("When the user is not member of any mapped group", func() {
+			role, entities := mappings.Apply([]string{"cn=other"})
+			Convey("Then he gets the default role and no entity", func() {
+				So(role, ShouldEqual, types.DefaultRole())
+				So(entities, ShouldBeEmpty)
+				So(entities, ShouldNotBeNil)
+			})
+		})
+	})
+
+	Convey("Given mappings of roles only", t, func() {
+		mappings := GroupMappings{{Group: "cn=dad-admins", Role: types.AdminRole}}
+		Convey("When applying them", func() {
+			_, entities := mappings.Apply([]string{"cn=dad-admins"})
+			Convey("Then entities are not managed by LDAP", func() {
+				So(entities, ShouldBeNil)
+			})
+		})
+	})
+
+	Convey("Given no mapping", t, func() {
+		role, entities := GroupMappings{}.Apply([]string{"cn=dad-admins"})
+		Convey("Then roles and entities are not managed by LDAP", func() {
+			So(role, ShouldBeEmpty)
+			So(entities, ShouldBeNil)
+		})
+	})
+}
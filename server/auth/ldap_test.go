@@ -0,0 +1,385 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/asn1-ber.v1"
+)
+
+// fakeLDAP is an in-process LDAP server, answering the bind, search, unbind and StartTLS operations
+type fakeLDAP struct {
+	listener  net.Listener
+	tlsConfig *tls.Config
+	ldaps     bool
+	entries   map[string]map[string]string // Attributes of the entries, by uid
+	passwords map[string]string            // Passwords, by DN
+
+	mu       sync.Mutex
+	accepted int
+	conns    []net.Conn
+}
+
+func newFakeLDAP(t *testing.T, tlsConfig *tls.Config, ldaps bool) *fakeLDAP {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeLDAP{
+		listener:  listener,
+		tlsConfig: tlsConfig,
+		ldaps:     ldaps,
+		entries: map[string]map[string]string{
+			"jdoe": {"dn": "uid=jdoe,ou=people,dc=dad,dc=io", "uid": "jdoe", "givenName": "John", "sn": "Doe", "cn": "John Doe", "mail": "john.doe@dad.io"},
+		},
+		passwords: map[string]string{
+			"cn=readonly,dc=dad,dc=io":        "readonly",
+			"uid=jdoe,ou=people,dc=dad,dc=io": "secret",
+		},
+	}
+	go f.serve()
+	return f
+}
+
+func (f *fakeLDAP) address() string {
+	return f.listener.Addr().String()
+}
+
+func (f *fakeLDAP) acceptedConns() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.accepted
+}
+
+// dropConns closes the opened connections on the server side, as a restarted server would do
+func (f *fakeLDAP) dropConns() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, conn := range f.conns {
+		//nolint:errcheck
+		conn.Close()
+	}
+	f.conns = nil
+}
+
+func (f *fakeLDAP) close() {
+	//nolint:errcheck
+	f.listener.Close()
+	f.dropConns()
+}
+
+func (f *fakeLDAP) serve() {
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			return
+		}
+		if f.ldaps {
+			conn = tls.Server(conn, f.tlsConfig)
+		}
+		f.mu.Lock()
+		f.accepted++
+		f.conns = append(f.conns, conn)
+		f.mu.Unlock()
+		go f.handle(conn)
+	}
+}
+
+func (f *fakeLDAP) handle(conn net.Conn) {
+	//nolint:errcheck
+	defer conn.Close()
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		messageID := packet.Children[0].Value.(int64)
+		request := packet.Children[1]
+
+		switch request.Tag {
+		case 0: // Bind
+			dn := request.Children[1].Data.String()
+			password := request.Children[2].Data.String()
+			code := 49
+			if expected, ok := f.passwords[dn]; ok && expected == password {
+				code = 0
+			}
+			f.write(conn, ldapResponse(messageID, 1, code))
+		case 3: // Search, with an equality filter on the username
+			filter := request.Children[6]
+			if entry, ok := f.entries[filter.Children[1].Data.String()]; ok {
+				f.write(conn, ldapEntry(messageID, entry))
+			}
+			f.write(conn, ldapResponse(messageID, 5, 0))
+		case 23: // StartTLS
+			f.write(conn, ldapResponse(messageID, 24, 0))
+			tlsConn := tls.Server(conn, f.tlsConfig)
+			if tlsConn.Handshake() != nil {
+				return
+			}
+			conn = tlsConn
+			f.mu.Lock()
+			f.conns = append(f.conns, conn)
+			f.mu.Unlock()
+		default: // Unbind
+			return
+		}
+	}
+}
+
+func (f *fakeLDAP) write(conn net.Conn, packet *ber.Packet) {
+	//nolint:errcheck
+	conn.Write(packet.Bytes())
+}
+
+func ldapMessage(messageID int64, operation *ber.Packet) *ber.Packet {
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, "MessageID"))
+	packet.AppendChild(operation)
+	return packet
+}
+
+func ldapResponse(messageID int64, tag int, code int) *ber.Packet {
+	response := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ber.Tag(tag), nil, "Response")
+	response.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, "Result Code"))
+	response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Matched DN"))
+	response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Diagnostic Message"))
+	return ldapMessage(messageID, response)
+}
+
+func ldapEntry(messageID int64, entry map[string]string) *ber.Packet {
+	response := ber.Encode(ber.ClassApplication, ber.TypeConstructed, 4, nil, "Search Result Entry")
+	response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, entry["dn"], "Object Name"))
+	attributes := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attributes")
+	for name, value := range entry {
+		attribute := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attribute")
+		attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "Type"))
+		values := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+		values.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, "Value"))
+		attribute.AppendChild(values)
+		attributes.AppendChild(attribute)
+	}
+	response.AppendChild(attributes)
+	return ldapMessage(messageID, response)
+}
+
+// newTestCertificate generates a self-signed certificate for 127.0.0.1, and writes it in a CA bundle file
+func newTestCertificate(t *testing.T) (*tls.Config, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caFile, err := ioutil.TempFile("", "dad-ldap-ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer caFile.Close()
+	if err = pem.Encode(caFile, &pem.Block{Type: "CERTIFICATE", Bytes: der}); err != nil {
+		t.Fatal(err)
+	}
+
+	config := &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	return config, caFile.Name()
+}
+
+func newTestLDAPConf(address string) *LDAPConf {
+	return &LDAPConf{
+		LdapServer:   address,
+		BaseDN:       "dc=dad,dc=io",
+		BindDN:       "cn=readonly,dc=dad,dc=io",
+		BindPassword: "readonly",
+		SearchFilter: "(uid=%s)",
+		Timeout:      2 * time.Second,
+		Attr: Attributes{
+			Username:  "uid",
+			Firstname: "givenName",
+			Lastname:  "sn",
+			Realname:  "cn",
+			Email:     "mail",
+		},
+	}
+}
+
+// closedAddress returns the address of a port where nothing listens
+func closedAddress(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	//nolint:errcheck
+	listener.Close()
+	return address
+}
+
+func TestLDAP(t *testing.T) {
+
+	tlsConfig, caFile := newTestCertificate(t)
+	defer os.Remove(caFile)
+
+	Convey("Given a plain LDAP server", t, func() {
+		server := newFakeLDAP(t, tlsConfig, false)
+		defer server.close()
+		ldap := NewLDAP(newTestLDAPConf(server.address()))
+		defer ldap.Close()
+
+		Convey("When searching users several times", func() {
+			user, err := ldap.Search("jdoe")
+			So(err, ShouldBeNil)
+			_, err = ldap.Search("jdoe")
+			So(err, ShouldBeNil)
+			Convey("Then users are found with a single connection", func() {
+				So(user.DN, ShouldEqual, "uid=jdoe,ou=people,dc=dad,dc=io")
+				So(user.Email, ShouldEqual, "john.doe@dad.io")
+				So(server.acceptedConns(), ShouldEqual, 1)
+			})
+		})
+
+		Convey("When the idle connection is broken", func() {
+			_, err := ldap.Search("jdoe")
+			So(err, ShouldBeNil)
+			server.dropConns()
+			user, err := ldap.Search("jdoe")
+			Convey("Then it is replaced by a new connection", func() {
+				So(err, ShouldBeNil)
+				So(user.Username, ShouldEqual, "jdoe")
+				So(server.acceptedConns(), ShouldEqual, 2)
+			})
+		})
+
+		Convey("When logging in", func() {
+			_, errWrongPassword := ldap.Login(&LoginUserQuery{Username: "jdoe", Password: "wrong"})
+			user, err := ldap.Login(&LoginUserQuery{Username: "jdoe", Password: "secret"})
+			_, errUnknownUser := ldap.Login(&LoginUserQuery{Username: "unknown", Password: "secret"})
+			Convey("Then only the right password is accepted", func() {
+				So(errWrongPassword, ShouldEqual, ErrInvalidCredentials)
+				So(err, ShouldBeNil)
+				So(user.Username, ShouldEqual, "jdoe")
+				So(errUnknownUser, ShouldEqual, ErrInvalidCredentials)
+				So(server.acceptedConns(), ShouldEqual, 1)
+			})
+		})
+	})
+
+	Convey("Given an unreachable LDAP server and a failover server", t, func() {
+		server := newFakeLDAP(t, tlsConfig, false)
+		defer server.close()
+		conf := newTestLDAPConf(closedAddress(t))
+		conf.Failover = []string{closedAddress(t), server.address()}
+		ldap := NewLDAP(conf)
+		defer ldap.Close()
+
+		Convey("When searching a user", func() {
+			user, err := ldap.Search("jdoe")
+			Convey("Then the failover server is used", func() {
+				So(err, ShouldBeNil)
+				So(user.Username, ShouldEqual, "jdoe")
+				So(server.acceptedConns(), ShouldEqual, 1)
+			})
+		})
+	})
+
+	Convey("Given only unreachable LDAP servers", t, func() {
+		conf := newTestLDAPConf(closedAddress(t))
+		conf.Failover = []string{closedAddress(t)}
+		ldap := NewLDAP(conf)
+		defer ldap.Close()
+
+		Convey("When searching a user", func() {
+			_, err := ldap.Search("jdoe")
+			Convey("Then an error is returned", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+
+	Convey("Given a LDAPS server", t, func() {
+		server := newFakeLDAP(t, tlsConfig, true)
+		defer server.close()
+		conf := newTestLDAPConf(server.address())
+		conf.TLS = LDAPS
+
+		Convey("When its certificate is verified with the CA bundle", func() {
+			conf.CAFile = caFile
+			ldap := NewLDAP(conf)
+			defer ldap.Close()
+			user, err := ldap.Search("jdoe")
+			Convey("Then the user is found", func() {
+				So(err, ShouldBeNil)
+				So(user.Username, ShouldEqual, "jdoe")
+			})
+		})
+
+		Convey("When its certificate is verified with the system CAs", func() {
+			ldap := NewLDAP(conf)
+			defer ldap.Close()
+			_, err := ldap.Search("jdoe")
+			Convey("Then the connection is refused", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+
+	Convey("Given a LDAP server supporting StartTLS", t, func() {
+		server := newFakeLDAP(t, tlsConfig, false)
+		defer server.close()
+		conf := newTestLDAPConf(server.address())
+		conf.TLS = StartTLS
+		conf.CAFile = caFile
+		ldap := NewLDAP(conf)
+		defer ldap.Close()
+
+		Convey("When searching a user", func() {
+			user, err := ldap.Search("jdoe")
+			Convey("Then the connection is upgraded to TLS", func() {
+				So(err, ShouldBeNil)
+				So(user.Username, ShouldEqual, "jdoe")
+			})
+		})
+	})
+
+	Convey("Given an unknown TLS mode", t, func() {
+		server := newFakeLDAP(t, tlsConfig, false)
+		defer server.close()
+		conf := newTestLDAPConf(server.address())
+		conf.TLS = "ssl"
+		ldap := NewLDAP(conf)
+		defer ldap.Close()
+
+		Convey("When searching a user", func() {
+			_, err := ldap.Search("jdoe")
+			Convey("Then an error is returned", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
@@ -0,0 +1,209 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"gopkg.in/ldap.v2"
+)
+
+const (
+	// LDAPS connects to the LDAP servers with TLS
+	LDAPS = "ldaps"
+	// StartTLS connects to the LDAP servers in plain TCP, then upgrades the connection to TLS
+	StartTLS = "starttls"
+
+	defaultLDAPPoolSize = 5
+	defaultLDAPTimeout  = 10 * time.Second
+	// Idle connections are closed after this delay, as servers and firewalls drop them anyway
+	ldapMaxIdleTime = 5 * time.Minute
+)
+
+// ErrLDAPPoolExhausted is an error message when no LDAP connection is available in time
+var ErrLDAPPoolExhausted = errors.New("All LDAP connections are busy, try again later")
+
+// ldapPool is a bounded pool of connections to the LDAP servers
+// Connections given by the pool are healthy and authenticated with the readonly user
+type ldapPool struct {
+	conf    *LDAPConf
+	rootCAs *x509.CertPool
+	caErr   error
+	slots   chan struct{}          // One slot by connection in use
+	idle    chan *ldapConn         // Connections available for reuse
+	bind    func(*ldap.Conn) error // Authenticates connections with the readonly user
+}
+
+type ldapConn struct {
+	*ldap.Conn
+	lastUsed time.Time
+}
+
+func newLDAPPool(conf *LDAPConf, bind func(*ldap.Conn) error) *ldapPool {
+	if conf.PoolSize <= 0 {
+		conf.PoolSize = defaultLDAPPoolSize
+	}
+	if conf.Timeout <= 0 {
+		conf.Timeout = defaultLDAPTimeout
+	}
+	pool := &ldapPool{
+		conf:  conf,
+		slots: make(chan struct{}, conf.PoolSize),
+		idle:  make(chan *ldapConn, conf.PoolSize),
+		bind:  bind,
+	}
+	if conf.CAFile != "" {
+		pool.rootCAs, pool.caErr = loadCAFile(conf.CAFile)
+		if pool.caErr != nil {
+			log.WithError(pool.caErr).WithField("file", conf.CAFile).Error("Unable to load the LDAP CA bundle")
+		}
+	}
+	return pool
+}
+
+func loadCAFile(file string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("No certificate found in %s", file)
+	}
+	return pool, nil
+}
+
+// get returns a connection authenticated with the readonly user. It has to be given back with put
+// Idle connections are checked by binding them again, and replaced when they are broken
+func (p *ldapPool) get() (*ldap.Conn, error) {
+	select {
+	case p.slots <- struct{}{}:
+	case <-time.After(p.conf.Timeout):
+		return nil, ErrLDAPPoolExhausted
+	}
+
+	for {
+		select {
+		case conn := <-p.idle:
+			if time.Since(conn.lastUsed) > ldapMaxIdleTime {
+				conn.Close()
+				continue
+			}
+			if err := p.bind(conn.Conn); err != nil {
+				log.WithError(err).Debug("Idle LDAP connection is broken, closing it")
+				conn.Close()
+				continue
+			}
+			return conn.Conn, nil
+		default:
+			conn, err := p.dial()
+			if err == nil {
+				err = p.bind(conn)
+				if err != nil {
+					conn.Close()
+				}
+			}
+			if err != nil {
+				<-p.slots
+				return nil, err
+			}
+			return conn, nil
+		}
+	}
+}
+
+// put gives back a connection, with the error of its last operation
+// Connections with network errors are closed instead of being reused
+func (p *ldapPool) put(conn *ldap.Conn, err error) {
+	if ldap.IsErrorWithCode(err, ldap.ErrorNetwork) {
+		conn.Close()
+	} else {
+		select {
+		case p.idle <- &ldapConn{Conn: conn, lastUsed: time.Now()}:
+		default:
+			conn.Close()
+		}
+	}
+	<-p.slots
+}
+
+// close closes all idle connections
+func (p *ldapPool) close() {
+	for {
+		select {
+		case conn := <-p.idle:
+			conn.Close()
+		default:
+			return
+		}
+	}
+}
+
+// dial connects to the first reachable server, among the main server and the failover servers
+func (p *ldapPool) dial() (*ldap.Conn, error) {
+	if p.caErr != nil {
+		return nil, p.caErr
+	}
+	var err error
+	for _, address := range append([]string{p.conf.LdapServer}, p.conf.Failover...) {
+		var conn *ldap.Conn
+		conn, err = p.dialServer(address)
+		if err == nil {
+			return conn, nil
+		}
+		log.WithError(err).WithField("address", address).Warn("LDAP server is unreachable")
+	}
+	return nil, err
+}
+
+func (p *ldapPool) dialServer(address string) (*ldap.Conn, error) {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{ServerName: host, RootCAs: p.rootCAs}
+
+	netConn, err := net.DialTimeout("tcp", address, p.conf.Timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	var conn *ldap.Conn
+	switch p.conf.TLS {
+	case LDAPS:
+		tlsConn := tls.Client(netConn, tlsConfig)
+		//nolint:errcheck
+		tlsConn.SetDeadline(time.Now().Add(p.conf.Timeout))
+		if err = tlsConn.Handshake(); err != nil {
+			//nolint:errcheck
+			netConn.Close()
+			return nil, err
+		}
+		//nolint:errcheck
+		tlsConn.SetDeadline(time.Time{})
+		conn = ldap.NewConn(tlsConn, true)
+		conn.Start()
+	case StartTLS:
+		conn = ldap.NewConn(netConn, false)
+		conn.Start()
+		conn.SetTimeout(p.conf.Timeout)
+		if err = conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	case "":
+		conn = ldap.NewConn(netConn, false)
+		conn.Start()
+	default:
+		//nolint:errcheck
+		netConn.Close()
+		return nil, fmt.Errorf("Unknown LDAP TLS mode %q, it should be empty, %s or %s", p.conf.TLS, LDAPS, StartTLS)
+	}
+	conn.SetTimeout(p.conf.Timeout)
+	return conn, nil
+}
@@ -0,0 +1,122 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/soprasteria/dad/server/types"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	resetPasswordTokenValidity = time.Hour
+	minPasswordLength          = 8
+)
+
+var (
+	// ErrInvalidResetToken is an error message when a password reset token is invalid or expired
+	ErrInvalidResetToken = errors.New("Password reset link is invalid or has expired")
+	// ErrPasswordTooShort is an error message when a new password is too short
+	ErrPasswordTooShort = fmt.Errorf("Password should contain at least %d characters", minPasswordLength)
+)
+
+// LocalProvider authenticates users whose password is hashed with bcrypt and stored in DAD
+// Local users are created by administrators, they are never registered at login
+type LocalProvider struct {
+	Users       types.UserRepo
+	Pepper      string // Secret added to passwords before hashing them
+	ResetSecret string // Secret used to sign password reset tokens
+}
+
+// Name of the local provider
+func (p LocalProvider) Name() string {
+	return types.LocalProvider
+}
+
+// CanRegister is false, as local users are created by administrators
+func (p LocalProvider) CanRegister() bool {
+	return false
+}
+
+// Login checks the password of a local user
+func (p LocalProvider) Login(query *LoginUserQuery) (*UserInfo, error) {
+	user, err := p.Users.FindByUsername(query.Username)
+	if err != nil || user.AuthProvider() != types.LocalProvider || user.Password == "" {
+		return nil, ErrInvalidCredentials
+	}
+	if !p.checkPassword(user.Password, query.Password) {
+		return nil, ErrInvalidCredentials
+	}
+	return &UserInfo{
+		Username:  user.Username,
+		FirstName: user.FirstName,
+		LastName:  user.LastName,
+		Email:     user.Email,
+	}, nil
+}
+
+// HashPassword hashes a password with bcrypt, after adding the pepper to it
+func (p LocalProvider) HashPassword(password string) (string, error) {
+	if len(password) < minPasswordLength {
+		return "", ErrPasswordTooShort
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password+p.Pepper), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+func (p LocalProvider) checkPassword(hash, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password+p.Pepper)) == nil
+}
+
+// CreateResetToken generates a token allowing a local user to choose a new password
+// The token is signed with the current password hash, so it can only be used once
+func (p LocalProvider) CreateResetToken(user types.User) (string, error) {
+	if user.AuthProvider() != types.LocalProvider {
+		return "", fmt.Errorf("User %s is not a local user, its password can't be reset", user.Username)
+	}
+	return createToken(user.Username, p.ResetSecret+user.Password, time.Now().Add(resetPasswordTokenValidity))
+}
+
+// parseResetToken checks the reset token was generated for the user and its current password
+func (p LocalProvider) parseResetToken(token string, user types.User) error {
+	_, err := jwt.ParseWithClaims(token, &MyCustomClaims{}, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, ErrInvalidResetToken
+		}
+		if claims, ok := t.Claims.(*MyCustomClaims); !ok || claims.Username != user.Username {
+			return nil, ErrInvalidResetToken
+		}
+		return []byte(p.ResetSecret + user.Password), nil
+	})
+	if err != nil {
+		return ErrInvalidResetToken
+	}
+	return nil
+}
+
+// ResetPassword sets the new password of a local user, given a valid reset token
+func (p LocalProvider) ResetPassword(token, password string) (types.User, error) {
+	// The username is read before checking the signature, because the signing key depends on the user
+	claims := MyCustomClaims{}
+	if _, _, err := new(jwt.Parser).ParseUnverified(token, &claims); err != nil {
+		return types.User{}, ErrInvalidResetToken
+	}
+	user, err := p.Users.FindByUsername(claims.Username)
+	if err != nil || user.AuthProvider() != types.LocalProvider {
+		return types.User{}, ErrInvalidResetToken
+	}
+	if err = p.parseResetToken(token, user); err != nil {
+		return types.User{}, err
+	}
+	if user.Disabled {
+		return types.User{}, ErrUserDisabled
+	}
+
+	user.Password, err = p.HashPassword(password)
+	if err != nil {
+		return types.User{}, err
+	}
+	return p.Users.Save(user)
+}
@@ -0,0 +1,53 @@
+package auth
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/soprasteria/dad/server/types"
+)
+
+func TestLocalProvider(t *testing.T) {
+
+	provider := LocalProvider{Pepper: "pepper", ResetSecret: "reset-secret"}
+
+	Convey("Given a hashed password", t, func() {
+		hash, err := provider.HashPassword("my-password")
+		So(err, ShouldBeNil)
+		Convey("Then only the right password matches it", func() {
+			So(provider.checkPassword(hash, "my-password"), ShouldBeTrue)
+			So(provider.checkPassword(hash, "other-password"), ShouldBeFalse)
+			So(LocalProvider{Pepper: "other"}.checkPassword(hash, "my-password"), ShouldBeFalse)
+		})
+	})
+
+	Convey("Given a too short password", t, func() {
+		_, err := provider.HashPassword("short")
+		Convey("Then it can't be hashed", func() {
+			So(err, ShouldEqual, ErrPasswordTooShort)
+		})
+	})
+
+	Convey("Given a reset token of a local user", t, func() {
+		user := types.User{Username: "jdoe", Provider: types.LocalProvider, Password: "hash"}
+		token, err := provider.CreateResetToken(user)
+		So(err, ShouldBeNil)
+		Convey("Then it is valid for this user and password", func() {
+			So(provider.parseResetToken(token, user), ShouldBeNil)
+		})
+		Convey("Then it is invalid once the password changed", func() {
+			user.Password = "new-hash"
+			So(provider.parseResetToken(token, user), ShouldEqual, ErrInvalidResetToken)
+		})
+		Convey("Then it is invalid for another user", func() {
+			So(provider.parseResetToken(token, types.User{Username: "other", Password: "hash"}), ShouldEqual, ErrInvalidResetToken)
+		})
+	})
+
+	Convey("Given a LDAP user", t, func() {
+		_, err := provider.CreateResetToken(types.User{Username: "jdoe"})
+		Convey("Then its password can't be reset", func() {
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
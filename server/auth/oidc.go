@@ -0,0 +1,326 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/soprasteria/dad/server/types"
+)
+
+const (
+	// OIDCStateValidity is the delay for the user to log in with the identity provider
+	OIDCStateValidity = 10 * time.Minute
+	oidcStateIssuer   = "dad-oidc"
+	oidcTimeout       = 10 * time.Second
+)
+
+// ErrInvalidOIDCState is an error message when the state returned by the identity provider is invalid or expired
+var ErrInvalidOIDCState = errors.New("Invalid or expired OpenID Connect state. Try login again")
+
+// OIDCConf contains data used to authenticate users with an OpenID Connect identity provider
+type OIDCConf struct {
+	Issuer        string
+	ClientID      string
+	ClientSecret  string
+	RedirectURL   string
+	Scopes        []string
+	UsernameClaim string // Claim of the ID token containing the username, preferred_username by default
+	StateSecret   string // Secret used to sign the state sent to the identity provider, distinct from the secret of DAD tokens
+}
+
+// oidcStateClaims are the claims of the state sent to the identity provider
+// The nonce binds the state to the browser which started the login, it is also kept in a cookie of this browser
+type oidcStateClaims struct {
+	Nonce string `json:"nonce"`
+	jwt.StandardClaims
+}
+
+// OIDC authenticates users with the authorization code flow of an OpenID Connect identity provider
+// The configuration of the provider is discovered at first use
+type OIDC struct {
+	conf   *OIDCConf
+	client *http.Client
+
+	mutex     sync.Mutex
+	discovery *oidcDiscovery
+	keys      map[string]*rsa.PublicKey
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type oidcJWKS struct {
+	Keys []struct {
+		Kid string `json:"kid"`
+		Kty string `json:"kty"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+	} `json:"keys"`
+}
+
+type oidcTokenResponse struct {
+	IDToken string `json:"id_token"`
+}
+
+// NewOIDC creates an OpenID Connect client from configuration
+func NewOIDC(conf *OIDCConf) *OIDC {
+	if conf.UsernameClaim == "" {
+		conf.UsernameClaim = "preferred_username"
+	}
+	if len(conf.Scopes) == 0 {
+		conf.Scopes = []string{"openid", "profile", "email"}
+	}
+	return &OIDC{
+		conf:   conf,
+		client: &http.Client{Timeout: oidcTimeout},
+	}
+}
+
+// Name of the OpenID Connect provider
+func (o *OIDC) Name() string {
+	return types.OIDCProvider
+}
+
+// AuthCodeURL returns the URL of the identity provider where users are redirected to log in
+// The nonce has to be kept by the browser of the user, and given back to Exchange with the authorization code and the state
+func (o *OIDC) AuthCodeURL() (loginURL, nonce string, err error) {
+	discovery, err := o.discover()
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err = newNonce()
+	if err != nil {
+		return "", "", err
+	}
+	state, err := jwt.NewWithClaims(jwt.SigningMethodHS256, oidcStateClaims{
+		nonce,
+		jwt.StandardClaims{
+			ExpiresAt: time.Now().Add(OIDCStateValidity).Unix(),
+			Issuer:    oidcStateIssuer,
+		},
+	}).SignedString([]byte(o.conf.StateSecret))
+	if err != nil {
+		return "", "", err
+	}
+	params := url.Values{
+		"response_type": {"code"},
+		"client_id":     {o.conf.ClientID},
+		"redirect_uri":  {o.conf.RedirectURL},
+		"scope":         {strings.Join(o.conf.Scopes, " ")},
+		"state":         {state},
+		"nonce":         {nonce},
+	}
+	separator := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return discovery.AuthorizationEndpoint + separator + params.Encode(), nonce, nil
+}
+
+// newNonce generates a random value, which can't be guessed by an attacker
+func newNonce() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// checkState checks the state was generated by DAD for the browser holding the nonce, and has not expired
+func (o *OIDC) checkState(state, nonce string) error {
+	claims := &oidcStateClaims{}
+	_, err := jwt.ParseWithClaims(state, claims, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, ErrInvalidOIDCState
+		}
+		return []byte(o.conf.StateSecret), nil
+	})
+	if err != nil || !claims.VerifyIssuer(oidcStateIssuer, true) || !sameNonce(claims.Nonce, nonce) {
+		return ErrInvalidOIDCState
+	}
+	return nil
+}
+
+// sameNonce compares nonces in constant time. An empty nonce never matches
+func sameNonce(expected, actual string) bool {
+	return expected != "" && subtle.ConstantTimeCompare([]byte(expected), []byte(actual)) == 1
+}
+
+// Exchange trades the authorization code against an ID token, and returns the identity of the user
+// The nonce is the one returned by AuthCodeURL when the login started, kept by the browser of the user
+func (o *OIDC) Exchange(code, state, nonce string) (*UserInfo, error) {
+	if err := o.checkState(state, nonce); err != nil {
+		return nil, err
+	}
+	discovery, err := o.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {o.conf.RedirectURL},
+	}
+	req, err := http.NewRequest(http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(o.conf.ClientID), url.QueryEscape(o.conf.ClientSecret))
+
+	tokens := oidcTokenResponse{}
+	if err = o.getJSON(req, &tokens); err != nil {
+		return nil, fmt.Errorf("Can't exchange the authorization code: %v", err)
+	}
+	if tokens.IDToken == "" {
+		return nil, errors.New("The identity provider did not return an ID token")
+	}
+
+	claims, err := o.verifyIDToken(tokens.IDToken, discovery.Issuer, nonce)
+	if err != nil {
+		return nil, err
+	}
+	username, _ := claims[o.conf.UsernameClaim].(string)
+	if username == "" {
+		return nil, fmt.Errorf("The ID token does not contain the %s claim", o.conf.UsernameClaim)
+	}
+	info := &UserInfo{Username: username}
+	info.FirstName, _ = claims["given_name"].(string)
+	info.LastName, _ = claims["family_name"].(string)
+	info.Email, _ = claims["email"].(string)
+	return info, nil
+}
+
+// verifyIDToken checks the signature, the issuer, the audience, the nonce and the expiration of the ID token
+func (o *OIDC) verifyIDToken(idToken, issuer, nonce string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(idToken, claims, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, fmt.Errorf("Unexpected signing method %v", t.Header["alg"])
+		}
+		kid, _ := t.Header["kid"].(string)
+		return o.key(kid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Invalid ID token: %v", err)
+	}
+	if _, ok := claims["exp"]; !ok {
+		return nil, errors.New("Invalid ID token: no expiration")
+	}
+	if !claims.VerifyIssuer(issuer, true) {
+		return nil, errors.New("Invalid ID token: unexpected issuer")
+	}
+	if !hasAudience(claims["aud"], o.conf.ClientID) {
+		return nil, errors.New("Invalid ID token: unexpected audience")
+	}
+	if tokenNonce, _ := claims["nonce"].(string); !sameNonce(tokenNonce, nonce) {
+		return nil, errors.New("Invalid ID token: unexpected nonce")
+	}
+	return claims, nil
+}
+
+// hasAudience checks the audience claim, which is either a string or an array of strings
+func hasAudience(aud interface{}, clientID string) bool {
+	switch a := aud.(type) {
+	case string:
+		return a == clientID
+	case []interface{}:
+		for _, v := range a {
+			if s, ok := v.(string); ok && s == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// discover fetches the configuration of the identity provider, only once
+func (o *OIDC) discover() (*oidcDiscovery, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if o.discovery != nil {
+		return o.discovery, nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(o.conf.Issuer, "/")+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	discovery := &oidcDiscovery{}
+	if err = o.getJSON(req, discovery); err != nil {
+		return nil, fmt.Errorf("Can't discover the OpenID Connect configuration of %s: %v", o.conf.Issuer, err)
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return nil, fmt.Errorf("Incomplete OpenID Connect configuration for %s", o.conf.Issuer)
+	}
+	o.discovery = discovery
+	return discovery, nil
+}
+
+// key returns the public key used to sign ID tokens
+// Keys are fetched again when the key id is unknown, as identity providers rotate their keys
+func (o *OIDC) key(kid string) (*rsa.PublicKey, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if key, ok := o.keys[kid]; ok {
+		return key, nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, o.discovery.JWKSURI, nil)
+	if err != nil {
+		return nil, err
+	}
+	jwks := oidcJWKS{}
+	if err = o.getJSON(req, &jwks); err != nil {
+		return nil, fmt.Errorf("Can't retrieve the signing keys: %v", err)
+	}
+	o.keys = map[string]*rsa.PublicKey{}
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(k.N)
+		e, errE := base64.RawURLEncoding.DecodeString(k.E)
+		if errN != nil || errE != nil {
+			continue
+		}
+		o.keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	if key, ok := o.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("Unknown signing key %q", kid)
+}
+
+func (o *OIDC) getJSON(req *http.Request, v interface{}) error {
+	req.Header.Set("Accept", "application/json")
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Unexpected status %s from %s", resp.Status, req.URL)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
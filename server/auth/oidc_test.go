@@ -0,0 +1,177 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// mockIdP is an OpenID Connect identity provider signing ID tokens with the claims it is given
+type mockIdP struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	claims jwt.MapClaims
+}
+
+func newMockIdP() *mockIdP {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		panic(err)
+	}
+	idp := &mockIdP{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.server.URL,
+			"authorization_endpoint": idp.server.URL + "/authorize",
+			"token_endpoint":         idp.server.URL + "/token",
+			"jwks_uri":               idp.server.URL + "/keys",
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kid": "test",
+				"kty": "RSA",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if id, secret, ok := r.BasicAuth(); !ok || id != "dad" || secret != "secret" || r.FormValue("code") != "code" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, idp.claims)
+		token.Header["kid"] = "test"
+		idToken, _ := token.SignedString(idp.key)
+		_ = json.NewEncoder(w).Encode(map[string]string{"id_token": idToken})
+	})
+	idp.server = httptest.NewServer(mux)
+	return idp
+}
+
+func TestOIDC(t *testing.T) {
+
+	idp := newMockIdP()
+	defer idp.server.Close()
+
+	oidc := NewOIDC(&OIDCConf{
+		Issuer:       idp.server.URL,
+		ClientID:     "dad",
+		ClientSecret: "secret",
+		RedirectURL:  "http://dad/oidc/callback",
+		StateSecret:  "state-secret",
+	})
+	validClaims := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss":                idp.server.URL,
+			"aud":                []string{"dad", "other"},
+			"exp":                time.Now().Add(time.Minute).Unix(),
+			"preferred_username": "jdoe",
+			"given_name":         "John",
+			"family_name":        "Doe",
+			"email":              "john.doe@dad.io",
+		}
+	}
+
+	Convey("Given an OpenID Connect identity provider", t, func() {
+		Convey("When getting the login URL", func() {
+			loginURL, nonce, err := oidc.AuthCodeURL()
+			So(err, ShouldBeNil)
+			u, _ := url.Parse(loginURL)
+			Convey("Then it points to the authorization endpoint with a valid state", func() {
+				So(u.Path, ShouldEqual, "/authorize")
+				So(u.Query().Get("client_id"), ShouldEqual, "dad")
+				So(u.Query().Get("scope"), ShouldEqual, "openid profile email")
+				So(u.Query().Get("nonce"), ShouldEqual, nonce)
+				So(oidc.checkState(u.Query().Get("state"), nonce), ShouldBeNil)
+			})
+
+			state := u.Query().Get("state")
+			validClaims := func() jwt.MapClaims {
+				claims := validClaims()
+				claims["nonce"] = nonce
+				return claims
+			}
+			Convey("Then a valid ID token gives the identity of the user", func() {
+				idp.claims = validClaims()
+				info, err := oidc.Exchange("code", state, nonce)
+				So(err, ShouldBeNil)
+				So(*info, ShouldResemble, UserInfo{Username: "jdoe", FirstName: "John", LastName: "Doe", Email: "john.doe@dad.io"})
+			})
+
+			Convey("Then the state is rejected from a browser without the nonce", func() {
+				idp.claims = validClaims()
+				_, err := oidc.Exchange("code", state, "")
+				So(err, ShouldEqual, ErrInvalidOIDCState)
+				_, err = oidc.Exchange("code", state, "other-nonce")
+				So(err, ShouldEqual, ErrInvalidOIDCState)
+			})
+
+			Convey("Then an ID token issued for another login is rejected", func() {
+				idp.claims = validClaims()
+				idp.claims["nonce"] = "other-nonce"
+				_, err := oidc.Exchange("code", state, nonce)
+				So(err, ShouldNotBeNil)
+			})
+
+			Convey("Then an ID token for another client is rejected", func() {
+				idp.claims = validClaims()
+				idp.claims["aud"] = "other"
+				_, err := oidc.Exchange("code", state, nonce)
+				So(err, ShouldNotBeNil)
+			})
+
+			Convey("Then an expired ID token is rejected", func() {
+				idp.claims = validClaims()
+				idp.claims["exp"] = time.Now().Add(-time.Minute).Unix()
+				_, err := oidc.Exchange("code", state, nonce)
+				So(err, ShouldNotBeNil)
+			})
+
+			Convey("Then an ID token from another issuer is rejected", func() {
+				idp.claims = validClaims()
+				idp.claims["iss"] = "https://evil.io"
+				_, err := oidc.Exchange("code", state, nonce)
+				So(err, ShouldNotBeNil)
+			})
+
+			Convey("Then an invalid authorization code is rejected", func() {
+				idp.claims = validClaims()
+				_, err := oidc.Exchange("wrong", state, nonce)
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When exchanging a code with a forged state", func() {
+			forged, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, oidcStateClaims{
+				"nonce",
+				jwt.StandardClaims{ExpiresAt: time.Now().Add(time.Minute).Unix(), Issuer: oidcStateIssuer},
+			}).SignedString([]byte("other-secret"))
+			_, err := oidc.Exchange("code", forged, "nonce")
+			Convey("Then it is rejected", func() {
+				So(err, ShouldEqual, ErrInvalidOIDCState)
+			})
+		})
+
+		Convey("When exchanging a code with a DAD token signed with the same secret as the state", func() {
+			token, _ := createToken("jdoe", "state-secret", time.Now().Add(time.Minute))
+			_, err := oidc.Exchange("code", token, "")
+			Convey("Then it is rejected, as it was not issued as a state", func() {
+				So(err, ShouldEqual, ErrInvalidOIDCState)
+			})
+		})
+	})
+}
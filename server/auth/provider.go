@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"github.com/soprasteria/dad/server/types"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// UserInfo contains the identity of a user, as given by an authentication provider
+type UserInfo struct {
+	Username  string
+	FirstName string
+	LastName  string
+	Email     string
+	Role      types.Role      // Role given by the provider, empty when the provider does not manage roles
+	Entities  []bson.ObjectId // Entities given by the provider, nil when the provider does not manage entities
+}
+
+// Provider authenticates users with their username and password
+type Provider interface {
+	// Name of the provider, stored in the users it owns
+	Name() string
+	// Login checks the credentials of the user and returns its identity
+	Login(query *LoginUserQuery) (*UserInfo, error)
+	// CanRegister tells whether unknown users authenticated by the provider are created in DAD at their first login
+	CanRegister() bool
+}
+
+// ldapProvider authenticates users against the LDAP server. Unknown users are registered at their first login
+// Their role and entities are given by the mappings of their groups
+type ldapProvider struct {
+	ldap *LDAP
+}
+
+// NewLDAPProvider creates a provider authenticating users against the LDAP server
+func NewLDAPProvider(ldap *LDAP) Provider {
+	return ldapProvider{ldap: ldap}
+}
+
+func (p ldapProvider) Name() string {
+	return types.LDAPProvider
+}
+
+func (p ldapProvider) Login(query *LoginUserQuery) (*UserInfo, error) {
+	ldapUser, err := p.ldap.Login(query)
+	if err != nil {
+		return nil, err
+	}
+	return NewLDAPUserInfo(ldapUser, p.ldap.Mappings()), nil
+}
+
+// NewLDAPUserInfo returns the identity of a LDAP user, with the role and entities given by its groups
+func NewLDAPUserInfo(ldapUser *LDAPUserInfo, mappings GroupMappings) *UserInfo {
+	role, entities := mappings.Apply(ldapUser.Groups)
+	return &UserInfo{
+		Username:  ldapUser.Username,
+		FirstName: ldapUser.FirstName,
+		LastName:  ldapUser.LastName,
+		Email:     ldapUser.Email,
+		Role:      role,
+		Entities:  entities,
+	}
+}
+
+func (p ldapProvider) CanRegister() bool {
+	return true
+}
@@ -0,0 +1,187 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/soprasteria/dad/server/types"
+	"github.com/spf13/viper"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// APITokenPrefix starts every API token, so that they are not mistaken for access tokens
+const APITokenPrefix = "dad_"
+
+var (
+	// ErrInvalidRefreshToken is an error message when a refresh token is unknown, expired or already used
+	ErrInvalidRefreshToken = errors.New("Your session has expired. Please login again")
+	// ErrInvalidAccessToken is an error message when an access token is invalid
+	ErrInvalidAccessToken = errors.New("Invalid access token")
+)
+
+// LoginTokens are the tokens given to an authenticated user
+// The short-lived access token authenticates the requests, the refresh token is used once to get new tokens
+type LoginTokens struct {
+	AccessToken  string
+	RefreshToken string
+	ExpiresIn    time.Duration // Validity of the access token
+}
+
+func accessTokenValidity() time.Duration {
+	return viper.GetDuration("auth.access-token-validity")
+}
+
+func refreshTokenValidity() time.Duration {
+	return viper.GetDuration("auth.refresh-token-validity")
+}
+
+// CreateLoginTokens generates the access and refresh tokens of a user who just logged in
+func (a *Authentication) CreateLoginTokens(username string) (LoginTokens, error) {
+	return a.createLoginTokens(username, bson.NewObjectId())
+}
+
+func (a *Authentication) createLoginTokens(username string, family bson.ObjectId) (LoginTokens, error) {
+	now := time.Now()
+	claims := MyCustomClaims{
+		username,
+		jwt.StandardClaims{
+			Id:        bson.NewObjectId().Hex(),
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(accessTokenValidity()).Unix(),
+			Issuer:    "dad",
+		},
+	}
+	accessToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(viper.GetString("auth.jwt-secret")))
+	if err != nil {
+		return LoginTokens{}, err
+	}
+
+	refreshToken, err := randomToken()
+	if err != nil {
+		return LoginTokens{}, err
+	}
+	_, err = a.RefreshTokens.Save(types.RefreshToken{
+		Hash:      hashToken(refreshToken),
+		Username:  username,
+		Family:    family,
+		Created:   now,
+		ExpiresAt: now.Add(refreshTokenValidity()),
+	})
+	if err != nil {
+		return LoginTokens{}, err
+	}
+
+	return LoginTokens{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    accessTokenValidity(),
+	}, nil
+}
+
+// RefreshLoginTokens replaces a refresh token by new access and refresh tokens
+// A refresh token used twice means it was stolen: all tokens of its family are then revoked
+func (a *Authentication) RefreshLoginTokens(refreshToken string) (types.User, LoginTokens, error) {
+	token, err := a.RefreshTokens.FindByHash(hashToken(refreshToken))
+	if err != nil || token.ExpiresAt.Before(time.Now()) {
+		return types.User{}, LoginTokens{}, ErrInvalidRefreshToken
+	}
+
+	err = a.RefreshTokens.Use(token.ID)
+	if err == mgo.ErrNotFound {
+		log.WithField("username", token.Username).Warn("Refresh token used twice, revoking all tokens of its family")
+		if err = a.RefreshTokens.DeleteFamily(token.Family); err != nil {
+			return types.User{}, LoginTokens{}, err
+		}
+		return types.User{}, LoginTokens{}, ErrInvalidRefreshToken
+	} else if err != nil {
+		return types.User{}, LoginTokens{}, err
+	}
+
+	// The user may have been removed or disabled since the login
+	user, err := a.Users.FindByUsername(token.Username)
+	if err != nil || user.Disabled {
+		return types.User{}, LoginTokens{}, ErrInvalidRefreshToken
+	}
+
+	tokens, err := a.createLoginTokens(user.Username, token.Family)
+	return user, tokens, err
+}
+
+// Logout revokes the given refresh token with its family, and the access token
+// Both tokens are optional, and ignored when they are invalid
+func (a *Authentication) Logout(refreshToken, accessToken string) error {
+	if refreshToken != "" {
+		token, err := a.RefreshTokens.FindByHash(hashToken(refreshToken))
+		if err == nil {
+			if err = a.RefreshTokens.DeleteFamily(token.Family); err != nil {
+				return err
+			}
+		}
+	}
+	if accessToken != "" {
+		claims, err := ParseAccessToken(accessToken)
+		if err == nil && claims.Id != "" {
+			return a.RevokedTokens.RevokeToken(claims.Id, time.Unix(claims.ExpiresAt, 0))
+		}
+	}
+	return nil
+}
+
+// RevokeUser logs out the user from all its sessions: its refresh tokens are deleted and its access tokens revoked
+func (a *Authentication) RevokeUser(username string) error {
+	if err := a.RefreshTokens.DeleteByUsername(username); err != nil {
+		return err
+	}
+	return a.RevokedTokens.RevokeUser(username, time.Now().Add(accessTokenValidity()))
+}
+
+// ParseAccessToken checks the signature and expiration of an access token, and returns its claims
+func ParseAccessToken(accessToken string) (*MyCustomClaims, error) {
+	claims := &MyCustomClaims{}
+	_, err := jwt.ParseWithClaims(accessToken, claims, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, ErrInvalidAccessToken
+		}
+		return []byte(viper.GetString("auth.jwt-secret")), nil
+	})
+	if err != nil {
+		return nil, ErrInvalidAccessToken
+	}
+	return claims, nil
+}
+
+// NewAPIToken generates an API token for machine clients
+func NewAPIToken() (string, error) {
+	token, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	return APITokenPrefix + token, nil
+}
+
+// HashAPIToken hashes an API token, as stored in database
+func HashAPIToken(token string) string {
+	return hashToken(token)
+}
+
+// randomToken generates an opaque token, used as refresh token or API token
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken hashes the refresh or API token before storing it, so that a database leak does not leak sessions
+func hashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,185 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/soprasteria/dad/server/types"
+	"github.com/spf13/viper"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// fakeUsers stores users in memory, found by username regardless of the case as in database
+type fakeUsers struct {
+	users []types.User
+}
+
+func (f *fakeUsers) FindByUsername(username string) (types.User, error) {
+	for _, user := range f.users {
+		if strings.EqualFold(user.Username, username) {
+			return user, nil
+		}
+	}
+	return types.User{}, mgo.ErrNotFound
+}
+
+func (f *fakeUsers) Save(user types.User) (types.User, error) {
+	for i := range f.users {
+		if f.users[i].ID == user.ID {
+			f.users[i] = user
+			return user, nil
+		}
+	}
+	f.users = append(f.users, user)
+	return user, nil
+}
+
+// fakeRefreshTokens stores refresh tokens in memory, usernames being matched exactly as in database
+type fakeRefreshTokens struct {
+	tokens []types.RefreshToken
+}
+
+func (f *fakeRefreshTokens) FindByHash(hash string) (types.RefreshToken, error) {
+	for _, token := range f.tokens {
+		if token.Hash == hash {
+			return token, nil
+		}
+	}
+	return types.RefreshToken{}, mgo.ErrNotFound
+}
+
+func (f *fakeRefreshTokens) Save(token types.RefreshToken) (types.RefreshToken, error) {
+	if token.ID.Hex() == "" {
+		token.ID = bson.NewObjectId()
+	}
+	f.tokens = append(f.tokens, token)
+	return token, nil
+}
+
+func (f *fakeRefreshTokens) Use(id bson.ObjectId) error {
+	for i := range f.tokens {
+		if f.tokens[i].ID == id && !f.tokens[i].Used {
+			f.tokens[i].Used = true
+			return nil
+		}
+	}
+	return mgo.ErrNotFound
+}
+
+func (f *fakeRefreshTokens) remove(keep func(types.RefreshToken) bool) {
+	tokens := []types.RefreshToken{}
+	for _, token := range f.tokens {
+		if keep(token) {
+			tokens = append(tokens, token)
+		}
+	}
+	f.tokens = tokens
+}
+
+func (f *fakeRefreshTokens) DeleteFamily(family bson.ObjectId) error {
+	f.remove(func(token types.RefreshToken) bool { return token.Family != family })
+	return nil
+}
+
+func (f *fakeRefreshTokens) DeleteByUsername(username string) error {
+	f.remove(func(token types.RefreshToken) bool { return token.Username != username })
+	return nil
+}
+
+// fakeRevokedTokens is an in-memory revocation list, usernames being matched exactly as in database
+// The revocation date is not truncated to the second, so that tokens issued in the same second are revoked too
+type fakeRevokedTokens struct {
+	tokenIDs []string
+	users    map[string]time.Time
+}
+
+func (f *fakeRevokedTokens) RevokeToken(tokenID string, expiresAt time.Time) error {
+	f.tokenIDs = append(f.tokenIDs, tokenID)
+	return nil
+}
+
+func (f *fakeRevokedTokens) RevokeUser(username string, expiresAt time.Time) error {
+	f.users[username] = time.Now()
+	return nil
+}
+
+func (f *fakeRevokedTokens) IsRevoked(tokenID, username string, issuedAt time.Time) (bool, error) {
+	for _, id := range f.tokenIDs {
+		if id == tokenID {
+			return true, nil
+		}
+	}
+	revokedBefore, ok := f.users[username]
+	return ok && revokedBefore.After(issuedAt), nil
+}
+
+// acceptingProvider is a provider accepting all logins, and returning the identity it knows
+type acceptingProvider struct {
+	info UserInfo
+}
+
+func (p acceptingProvider) Name() string {
+	return types.LDAPProvider
+}
+
+func (p acceptingProvider) Login(query *LoginUserQuery) (*UserInfo, error) {
+	info := p.info
+	return &info, nil
+}
+
+func (p acceptingProvider) CanRegister() bool {
+	return true
+}
+
+func TestLoginTokens(t *testing.T) {
+
+	viper.Set("auth.jwt-secret", "secret")
+	viper.Set("auth.access-token-validity", time.Minute)
+	viper.Set("auth.refresh-token-validity", time.Hour)
+
+	Convey("Given a user logging in with its username typed in another case", t, func() {
+		users := &fakeUsers{users: []types.User{{ID: bson.NewObjectId(), Username: "jdoe", Provider: types.LDAPProvider}}}
+		refreshTokens := &fakeRefreshTokens{}
+		revokedTokens := &fakeRevokedTokens{users: map[string]time.Time{}}
+		login := Authentication{
+			Users:         users,
+			RefreshTokens: refreshTokens,
+			RevokedTokens: revokedTokens,
+			Providers:     []Provider{acceptingProvider{info: UserInfo{Username: "jdoe", FirstName: "John", LastName: "Doe"}}},
+		}
+
+		user, err := login.AuthenticateUser(&LoginUserQuery{Username: "JDoe", Password: "password"})
+		So(err, ShouldBeNil)
+		So(user.Username, ShouldEqual, "jdoe")
+		tokens, err := login.CreateLoginTokens(user.Username)
+		So(err, ShouldBeNil)
+
+		Convey("Then its tokens are issued for the stored username", func() {
+			claims, err := ParseAccessToken(tokens.AccessToken)
+			So(err, ShouldBeNil)
+			So(claims.Username, ShouldEqual, "jdoe")
+			So(refreshTokens.tokens, ShouldHaveLength, 1)
+			So(refreshTokens.tokens[0].Username, ShouldEqual, "jdoe")
+		})
+
+		Convey("When the user is revoked", func() {
+			So(login.RevokeUser("jdoe"), ShouldBeNil)
+
+			Convey("Then its access token is revoked", func() {
+				claims, err := ParseAccessToken(tokens.AccessToken)
+				So(err, ShouldBeNil)
+				revoked, err := revokedTokens.IsRevoked(claims.Id, claims.Username, time.Unix(claims.IssuedAt, 0))
+				So(err, ShouldBeNil)
+				So(revoked, ShouldBeTrue)
+			})
+
+			Convey("Then its refresh token can't be used anymore", func() {
+				_, _, err := login.RefreshLoginTokens(tokens.RefreshToken)
+				So(err, ShouldEqual, ErrInvalidRefreshToken)
+			})
+		})
+	})
+}
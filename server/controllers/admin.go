@@ -1,24 +1,176 @@
 package controllers
 
 import (
+	"fmt"
 	"net/http"
+	"strconv"
 
 	"github.com/labstack/echo"
 	"github.com/soprasteria/dad/server/jobs"
+	"github.com/soprasteria/dad/server/mongo"
 	"github.com/soprasteria/dad/server/types"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	defaultJobRunsLimit = 50
+	maxJobRunsLimit     = 500
 )
 
 // Admin is the entrypoint of endpoints used for admin operations.
 type Admin struct {
 }
 
+// runJob starts a job requested by the authenticated user, and returns its run in progress
+// The job is executed in background, its result is read from /api/admin/jobs/runs/:id. It is refused when it is already running
+func (a *Admin) runJob(c echo.Context, job string) error {
+	return a.startJob(c, job, jobs.Start)
+}
+
+// startJob starts a run of a job with the given function, e.g. to start a dry run
+func (a *Admin) startJob(c echo.Context, job string, start func(string, types.JobTrigger, string) (types.JobRun, error)) error {
+	authUser := c.Get("authuser").(types.User)
+
+	run, err := start(job, types.ManualTrigger, authUser.Username)
+	if err == types.ErrJobRunning {
+		return c.JSON(http.StatusConflict, types.NewErr(fmt.Sprintf("Job %v is already running", job)))
+	} else if err != nil {
+		return c.JSON(http.StatusInternalServerError, types.NewErr(err.Error()))
+	}
+
+	c.Response().Header().Set("X-Job-Run", run.ID.Hex())
+	return c.JSON(http.StatusAccepted, run)
+}
+
 // ExecuteDeploymentJobAnalytics run the analytics of deployment status on projects.
+// With the dryRun query parameter, the projects are not saved and the proposed changes are stored on the run instead.
 func (a *Admin) ExecuteDeploymentJobAnalytics(c echo.Context) error {
+	dryRun, _ := strconv.ParseBool(c.QueryParam("dryRun"))
+	if dryRun {
+		return a.startJob(c, jobs.DeploymentJob, jobs.StartDryRun)
+	}
+	return a.runJob(c, jobs.DeploymentJob)
+}
+
+// ApplyDeploymentChanges saves the changes of deployment status approved by the user, among the ones proposed in dry run.
+func (a *Admin) ApplyDeploymentChanges(c echo.Context) error {
+	authUser := c.Get("authuser").(types.User)
 
-	res, err := jobs.ExecuteDeploymentStatusAnalytics()
+	var diffs []types.DeploymentDiff
+	err := c.Bind(&diffs)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, types.NewErr(fmt.Sprintf("Posted deployment changes are not valid: %v", err)))
+	}
+
+	results, err := jobs.ApplyDeploymentChanges(diffs, authUser.Username)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, types.NewErr(err.Error()))
 	}
+	return c.JSON(http.StatusOK, results)
+}
+
+// ExecuteProjectsSnapshot freezes the maturity of all projects, used to compute trends.
+func (a *Admin) ExecuteProjectsSnapshot(c echo.Context) error {
+	return a.runJob(c, jobs.SnapshotJob)
+}
+
+// ExecuteArchivedProjectsPurge permanently deletes the projects archived for longer than the retention period.
+func (a *Admin) ExecuteArchivedProjectsPurge(c echo.Context) error {
+	return a.runJob(c, jobs.PurgeJob)
+}
+
+// ExecuteExportsCleanup deletes the export files whose retention period is over.
+func (a *Admin) ExecuteExportsCleanup(c echo.Context) error {
+	return a.runJob(c, jobs.ExportsCleanupJob)
+}
+
+// ExecuteLDAPSync synchronizes the LDAP users with the directory.
+func (a *Admin) ExecuteLDAPSync(c echo.Context) error {
+	return a.runJob(c, jobs.LDAPSyncJob)
+}
+
+// JobSettingUpdate is the setting of a job changed by an administrator
+type JobSettingUpdate struct {
+	Enabled bool `json:"enabled"`
+}
+
+// GetJobs returns all the background jobs, with their schedule, their state and their last run
+func (a *Admin) GetJobs(c echo.Context) error {
+	database := c.Get("database").(*mongo.DadMongo)
+
+	statuses, err := jobs.Statuses(database)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, types.NewErr(fmt.Sprintf("Error while retrieving the jobs: %v", err)))
+	}
+	return c.JSON(http.StatusOK, statuses)
+}
+
+// UpdateJob enables or disables the scheduled runs of a job, on all the instances of DAD
+func (a *Admin) UpdateJob(c echo.Context) error {
+	database := c.Get("database").(*mongo.DadMongo)
+	authUser := c.Get("authuser").(types.User)
+	name := c.Param("name")
+
+	if _, ok := jobs.Lookup(name); !ok {
+		return c.JSON(http.StatusNotFound, types.NewErr(fmt.Sprintf("Job %v does not exist", name)))
+	}
+
+	var update JobSettingUpdate
+	err := c.Bind(&update)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, types.NewErr(fmt.Sprintf("Posted job setting is not valid: %v", err)))
+	}
 
-	return c.String(http.StatusOK, res)
+	setting, err := database.JobSettings.Save(types.JobSetting{Job: name, Enabled: update.Enabled, Username: authUser.Username})
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, types.NewErr(fmt.Sprintf("Error while saving the setting of the job %v", name)))
+	}
+	return c.JSON(http.StatusOK, setting)
+}
+
+// RunJob executes any job on demand, even when its scheduled runs are disabled
+func (a *Admin) RunJob(c echo.Context) error {
+	name := c.Param("name")
+	if _, ok := jobs.Lookup(name); !ok {
+		return c.JSON(http.StatusNotFound, types.NewErr(fmt.Sprintf("Job %v does not exist", name)))
+	}
+	return a.runJob(c, name)
+}
+
+// GetJobRuns returns the most recent runs of the jobs, optionally filtered by job
+func (a *Admin) GetJobRuns(c echo.Context) error {
+	database := c.Get("database").(*mongo.DadMongo)
+
+	limit := defaultJobRunsLimit
+	if value := c.QueryParam("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			return c.JSON(http.StatusBadRequest, types.NewErr(fmt.Sprintf("Limit should be a positive number, got %v", value)))
+		}
+		limit = parsed
+		if limit > maxJobRunsLimit {
+			limit = maxJobRunsLimit
+		}
+	}
+
+	runs, err := database.JobRuns.FindRecent(c.QueryParam("job"), limit)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, types.NewErr("Error while retrieving the job runs"))
+	}
+	return c.JSON(http.StatusOK, runs)
+}
+
+// GetJobRun returns a job run, with the outcome of each project it processed
+func (a *Admin) GetJobRun(c echo.Context) error {
+	database := c.Get("database").(*mongo.DadMongo)
+	id := c.Param("id")
+
+	run, err := database.JobRuns.FindByID(bson.ObjectIdHex(id))
+	if err == mgo.ErrNotFound {
+		return c.JSON(http.StatusNotFound, types.NewErr(fmt.Sprintf("Job run %v does not exist", id)))
+	} else if err != nil {
+		return c.JSON(http.StatusInternalServerError, types.NewErr(fmt.Sprintf("Error while retrieving the job run %v", id)))
+	}
+	return c.JSON(http.StatusOK, run)
 }
@@ -0,0 +1,116 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/labstack/echo"
+	"github.com/soprasteria/dad/server/auth"
+	"github.com/soprasteria/dad/server/mongo"
+	"github.com/soprasteria/dad/server/types"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// APITokens contains all handlers for managing the API tokens of machine clients
+type APITokens struct {
+}
+
+// createdAPIToken is the API token returned at creation, the only time the token itself is given
+type createdAPIToken struct {
+	types.APIToken
+	Token string `json:"token"`
+}
+
+// GetAll returns the API tokens of the connected user
+// Users allowed to see all tokens get the tokens of all users with the all=true query param
+func (a *APITokens) GetAll(c echo.Context) error {
+	database := c.Get("database").(*mongo.DadMongo)
+	authUser := c.Get("authuser").(types.User)
+
+	var tokens []types.APIToken
+	var err error
+	if authUser.Can(types.APITokensAllPermission) && c.QueryParam("all") == "true" {
+		tokens, err = database.APITokens.FindAll()
+	} else {
+		tokens, err = database.APITokens.FindByUsername(authUser.Username)
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, types.NewErr(fmt.Sprintf("Error while retrieving API tokens: %v", err)))
+	}
+	return c.JSON(http.StatusOK, tokens)
+}
+
+// Create creates an API token for the connected user, with given name, scopes and optional expiration date
+func (a *APITokens) Create(c echo.Context) error {
+	database := c.Get("database").(*mongo.DadMongo)
+	authUser := c.Get("authuser").(types.User)
+
+	var apiToken types.APIToken
+	err := c.Bind(&apiToken)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, types.NewErr(fmt.Sprintf("Posted API token is not valid: %v", err)))
+	}
+	if apiToken.Name == "" {
+		return c.JSON(http.StatusBadRequest, types.NewErr("Name of the API token should not be empty"))
+	}
+	if len(apiToken.Scopes) == 0 {
+		return c.JSON(http.StatusBadRequest, types.NewErr(fmt.Sprintf("API token should have at least one scope among %v", types.APITokenScopes)))
+	}
+	for _, scope := range apiToken.Scopes {
+		if !types.IsValidScope(scope) {
+			return c.JSON(http.StatusBadRequest, types.NewErr(fmt.Sprintf("Scope %q is not valid, it should be one of %v", scope, types.APITokenScopes)))
+		}
+	}
+	if apiToken.ExpiresAt != nil && apiToken.ExpiresAt.Before(time.Now()) {
+		return c.JSON(http.StatusBadRequest, types.NewErr("Expiration date of the API token should be in the future"))
+	}
+
+	token, err := auth.NewAPIToken()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, types.NewErr(fmt.Sprintf("Error while generating API token: %v", err)))
+	}
+	apiToken.ID = ""
+	apiToken.Username = authUser.Username
+	apiToken.Prefix = token[:len(auth.APITokenPrefix)+6]
+	apiToken.Hash = auth.HashAPIToken(token)
+	apiToken.Created = time.Now()
+	apiToken.LastUsed = nil
+
+	apiToken, err = database.APITokens.Save(apiToken)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, types.NewErr(fmt.Sprintf("Failed to save API token to database: %v", err)))
+	}
+	log.WithFields(log.Fields{
+		"username": authUser.Username,
+		"name":     apiToken.Name,
+		"scopes":   apiToken.Scopes,
+	}).Info("API token created")
+
+	return c.JSON(http.StatusOK, createdAPIToken{APIToken: apiToken, Token: token})
+}
+
+// Delete revokes an API token. Only its owner or a user allowed to see all tokens can revoke it
+func (a *APITokens) Delete(c echo.Context) error {
+	database := c.Get("database").(*mongo.DadMongo)
+	authUser := c.Get("authuser").(types.User)
+	id := c.Param("id")
+
+	apiToken, err := database.APITokens.FindByID(bson.ObjectIdHex(id))
+	if err != nil || (apiToken.Username != authUser.Username && !authUser.Can(types.APITokensAllPermission)) {
+		return c.JSON(http.StatusNotFound, types.NewErr(fmt.Sprintf("API token not found %v", id)))
+	}
+
+	res, err := database.APITokens.Delete(apiToken.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, types.NewErr(fmt.Sprintf("Error while removing API token: %v", err)))
+	}
+	log.WithFields(log.Fields{
+		"username": authUser.Username,
+		"owner":    apiToken.Username,
+		"name":     apiToken.Name,
+	}).Info("API token revoked")
+
+	return c.JSON(http.StatusOK, res)
+}
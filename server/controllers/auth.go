@@ -2,6 +2,8 @@ package controllers
 
 import (
 	"net/http"
+	"net/url"
+	"strings"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/labstack/echo"
@@ -17,24 +19,51 @@ type Auth struct {
 
 // Token is a JWT Token
 type Token struct {
-	ID   string     `json:"id_token,omitempty"`
-	User types.User `json:"user,omitempty"`
+	ID           string     `json:"id_token,omitempty"`
+	RefreshToken string     `json:"refresh_token,omitempty"`
+	ExpiresIn    int        `json:"expires_in,omitempty"` // Validity of the token, in seconds
+	User         types.User `json:"user,omitempty"`
 }
 
 func newAuthAPI(c echo.Context) auth.Authentication {
 	// Handle APIs from Echo context
 	database := c.Get("database").(*mongo.DadMongo)
-	ldapAPI := c.Get("ldap")
-	var ldap *auth.LDAP
-	if ldapAPI != nil {
-		ldap = ldapAPI.(*auth.LDAP)
+	providers := []auth.Provider{}
+	if ldapAPI := c.Get("ldap"); ldapAPI != nil {
+		providers = append(providers, auth.NewLDAPProvider(ldapAPI.(*auth.LDAP)))
 	}
+	providers = append(providers, newLocalProvider(database))
 	return auth.Authentication{
-		Users: database.Users,
-		LDAP:  ldap,
+		Users:         &database.Users,
+		RefreshTokens: &database.RefreshTokens,
+		RevokedTokens: &database.RevokedTokens,
+		Providers:     providers,
 	}
 }
 
+func newLocalProvider(database *mongo.DadMongo) auth.LocalProvider {
+	return auth.LocalProvider{
+		Users:       database.Users,
+		Pepper:      viper.GetString("auth.bcrypt-pepper"),
+		ResetSecret: viper.GetString("auth.reset-pwd-secret"),
+	}
+}
+
+// resetPasswordLink returns the link of the page where a local user chooses a new password
+func resetPasswordLink(token string) string {
+	return strings.TrimSuffix(viper.GetString("server.url"), "/") + "/reset-password?token=" + url.QueryEscape(token)
+}
+
+// sendResetPasswordEmail generates a reset token for the local user and sends it by email
+func sendResetPasswordEmail(database *mongo.DadMongo, user types.User) error {
+	token, err := newLocalProvider(database).CreateResetToken(user)
+	if err != nil {
+		return err
+	}
+	go auth.SendResetPasswordEmail(user, resetPasswordLink(token))
+	return nil
+}
+
 //Login handles the login of a user
 //When user is authorized, it creates a JWT Token https://jwt.io/introduction/ that will be store on client
 func (a *Auth) Login(c echo.Context) error {
@@ -55,39 +84,195 @@ func (a *Auth) Login(c echo.Context) error {
 	login := newAuthAPI(c)
 
 	// Log in the application
-	var err error
-	if viper.GetBool("ldap.enable") {
-		log.Debug("Authenticating to LDAP...")
-		err = login.AuthenticateUser(&auth.LoginUserQuery{
-			Username: username,
-			Password: password,
-		})
-	}
+	log.Debug("Authenticating user...")
+	user, err := login.AuthenticateUser(&auth.LoginUserQuery{
+		Username: username,
+		Password: password,
+	})
 	if err != nil {
 		log.WithError(err).WithField("username", username).Error("User authentication failed")
-		if err == auth.ErrInvalidCredentials {
-			return c.JSON(http.StatusForbidden, types.NewErr(auth.ErrInvalidCredentials.Error()))
+		if err == auth.ErrInvalidCredentials || err == auth.ErrUserDisabled {
+			return c.JSON(http.StatusForbidden, types.NewErr(err.Error()))
+		} else if err == auth.ErrAuthenticationUnavailable || err == auth.ErrLDAPPoolExhausted {
+			return c.JSON(http.StatusServiceUnavailable, types.NewErr(err.Error()))
 		}
 		return c.JSON(http.StatusInternalServerError, types.NewErr(err.Error()))
 	}
-	log.Debug("Authenticated to LDAP [OK]")
+	log.Debug("Authenticated user [OK]")
+
+	return a.loginResponse(c, login, user)
+}
 
-	// Generates a valid token
-	token, err := login.CreateLoginToken(username)
+// loginResponse creates the login tokens of an authenticated user
+// Tokens are issued for the username stored in DAD, as the revocation of the user matches it exactly
+func (a *Auth) loginResponse(c echo.Context, login auth.Authentication, user types.User) error {
+	// Generates valid tokens
+	tokens, err := login.CreateLoginTokens(user.Username)
 	if err != nil {
-		log.WithError(err).WithField("username", username).Error("Login token creation failed")
+		log.WithError(err).WithField("username", user.Username).Error("Login token creation failed")
 		return c.JSON(http.StatusInternalServerError, types.NewErr(err.Error()))
 	}
 
-	// Get the user from database
-	log.Debug("Getting user from database...")
+	return c.JSON(http.StatusOK, newToken(tokens, user))
+}
+
+func newToken(tokens auth.LoginTokens, user types.User) Token {
+	return Token{
+		ID:           tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		ExpiresIn:    int(tokens.ExpiresIn.Seconds()),
+		User:         user,
+	}
+}
+
+// Refresh gives new tokens in exchange of a refresh token, which can't be used again
+func (a *Auth) Refresh(c echo.Context) error {
+	refreshToken := c.FormValue("refresh_token")
+	if refreshToken == "" {
+		return c.JSON(http.StatusBadRequest, types.NewErr("Refresh token should not be empty"))
+	}
+
+	login := newAuthAPI(c)
+	user, tokens, err := login.RefreshLoginTokens(refreshToken)
+	if err == auth.ErrInvalidRefreshToken {
+		return c.JSON(http.StatusUnauthorized, types.NewErr(err.Error()))
+	} else if err != nil {
+		log.WithError(err).Error("Tokens refresh failed")
+		return c.JSON(http.StatusInternalServerError, types.NewErr(err.Error()))
+	}
+	return c.JSON(http.StatusOK, newToken(tokens, user))
+}
+
+// Logout revokes the refresh token given in form, and the access token given in the Authorization header
+func (a *Auth) Logout(c echo.Context) error {
+	accessToken := strings.TrimPrefix(c.Request().Header.Get(echo.HeaderAuthorization), "Bearer ")
+	login := newAuthAPI(c)
+	err := login.Logout(c.FormValue("refresh_token"), accessToken)
+	if err != nil {
+		log.WithError(err).Error("Logout failed")
+		return c.JSON(http.StatusInternalServerError, types.NewErr(err.Error()))
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+// RequestPasswordReset sends an email allowing a local user to choose a new password
+// The response is the same whether the user exists or not, so that usernames can't be guessed
+func (a *Auth) RequestPasswordReset(c echo.Context) error {
+	username := c.FormValue("username")
+	if username == "" {
+		return c.JSON(http.StatusBadRequest, types.NewErr("Username should not be empty"))
+	}
+
 	database := c.Get("database").(*mongo.DadMongo)
 	user, err := database.Users.FindByUsername(username)
+	if err == nil && user.AuthProvider() == types.LocalProvider && user.Email != "" {
+		if err = sendResetPasswordEmail(database, user); err != nil {
+			log.WithError(err).WithField("username", username).Error("Can't send reset password email")
+		}
+	} else {
+		log.WithField("username", username).Warn("Password reset requested for an unknown or non local user")
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+// ResetPassword sets the password of a local user, given the token received by email, and logs him in
+func (a *Auth) ResetPassword(c echo.Context) error {
+	token := c.FormValue("token")
+	password := c.FormValue("password")
+	if token == "" || password == "" {
+		return c.JSON(http.StatusBadRequest, types.NewErr("Token and password should not be empty"))
+	}
+
+	database := c.Get("database").(*mongo.DadMongo)
+	user, err := newLocalProvider(database).ResetPassword(token, password)
+	if err == auth.ErrInvalidResetToken || err == auth.ErrPasswordTooShort {
+		return c.JSON(http.StatusBadRequest, types.NewErr(err.Error()))
+	} else if err == auth.ErrUserDisabled {
+		return c.JSON(http.StatusForbidden, types.NewErr(err.Error()))
+	} else if err != nil {
+		log.WithError(err).Error("Password reset failed")
+		return c.JSON(http.StatusInternalServerError, types.NewErr(err.Error()))
+	}
+	log.WithField("username", user.Username).Info("Password reset")
+
+	// Sessions opened with the previous password are closed, as they may belong to whoever knew it
+	login := newAuthAPI(c)
+	if err = login.RevokeUser(user.Username); err != nil {
+		log.WithError(err).WithField("username", user.Username).Error("Can't revoke the sessions of the user after the password reset")
+		return c.JSON(http.StatusInternalServerError, types.NewErr(err.Error()))
+	}
+	return a.loginResponse(c, login, user)
+}
+
+// oidcNonceCookie is the cookie binding the OpenID Connect login to the browser which started it
+const oidcNonceCookie = "dad-oidc-nonce"
+
+// oidcNonce returns the cookie keeping the nonce of an OpenID Connect login. An empty nonce deletes the cookie
+func oidcNonce(nonce string) *http.Cookie {
+	cookie := &http.Cookie{
+		Name:     oidcNonceCookie,
+		Value:    nonce,
+		Path:     "/auth/oidc",
+		MaxAge:   int(auth.OIDCStateValidity.Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(viper.GetString("server.url"), "https://"),
+		SameSite: http.SameSiteLaxMode,
+	}
+	if nonce == "" {
+		cookie.MaxAge = -1
+	}
+	return cookie
+}
+
+// OIDCLogin returns the URL of the OpenID Connect identity provider where the user logs in
+// The nonce of the login is kept in a cookie, so that the state can only be used by this browser
+func (a *Auth) OIDCLogin(c echo.Context) error {
+	oidcAPI, ok := c.Get("oidc").(*auth.OIDC)
+	if !ok {
+		return c.JSON(http.StatusNotFound, types.NewErr("OpenID Connect authentication is not configured"))
+	}
+	loginURL, nonce, err := oidcAPI.AuthCodeURL()
 	if err != nil {
-		log.WithError(err).WithField("username", username).Error("User retrieval failed")
+		log.WithError(err).Error("Can't generate the OpenID Connect login URL")
+		return c.JSON(http.StatusInternalServerError, types.NewErr(err.Error()))
+	}
+	c.SetCookie(oidcNonce(nonce))
+	return c.JSON(http.StatusOK, map[string]string{"url": loginURL})
+}
+
+// OIDCToken logs in the user redirected by the OpenID Connect identity provider, with the given code and state
+func (a *Auth) OIDCToken(c echo.Context) error {
+	oidcAPI, ok := c.Get("oidc").(*auth.OIDC)
+	if !ok {
+		return c.JSON(http.StatusNotFound, types.NewErr("OpenID Connect authentication is not configured"))
+	}
+	code := c.FormValue("code")
+	state := c.FormValue("state")
+	if code == "" || state == "" {
+		return c.JSON(http.StatusBadRequest, types.NewErr("Code and state should not be empty"))
+	}
+
+	nonce := ""
+	if cookie, err := c.Cookie(oidcNonceCookie); err == nil {
+		nonce = cookie.Value
+	}
+	// The nonce is only used once
+	c.SetCookie(oidcNonce(""))
+
+	info, err := oidcAPI.Exchange(code, state, nonce)
+	if err != nil {
+		log.WithError(err).Error("OpenID Connect authentication failed")
+		return c.JSON(http.StatusForbidden, types.NewErr(err.Error()))
+	}
+
+	login := newAuthAPI(c)
+	user, err := login.AuthenticateExternalUser(oidcAPI.Name(), info)
+	if err == auth.ErrUsernameAlreadyTaken || err == auth.ErrUserDisabled {
+		return c.JSON(http.StatusForbidden, types.NewErr(err.Error()))
+	} else if err != nil {
+		log.WithError(err).WithField("username", info.Username).Error("Can't save user authenticated with OpenID Connect")
 		return c.JSON(http.StatusInternalServerError, types.NewErr(err.Error()))
 	}
-	log.Debug("Got user from database [OK]")
 
-	return c.JSON(http.StatusOK, Token{ID: token, User: user})
+	return a.loginResponse(c, login, user)
 }
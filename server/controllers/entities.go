@@ -3,9 +3,11 @@ package controllers
 import (
 	"fmt"
 	"net/http"
+	"time"
 
 	"gopkg.in/mgo.v2/bson"
 
+	log "github.com/Sirupsen/logrus"
 	"github.com/labstack/echo"
 	"github.com/soprasteria/dad/server/mongo"
 	"github.com/soprasteria/dad/server/types"
@@ -37,27 +39,46 @@ func (u *Entities) Get(c echo.Context) error {
 }
 
 // Delete entity from database
+// An entity containing other entities is only deleted with the cascade query parameter, and then its whole subtree is deleted
 func (u *Entities) Delete(c echo.Context) error {
 	database := c.Get("database").(*mongo.DadMongo)
 	id := c.Param("id")
+	cascade := c.QueryParam("cascade") == "true"
 
-	res, err := database.Entities.Delete(bson.ObjectIdHex(id))
+	tree, err := database.Entities.FindTree()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, types.NewErr(fmt.Sprintf("Error while retrieving the tree of entities: %v", err)))
+	}
+	if _, ok := tree.GetHex(id); !ok {
+		return c.JSON(http.StatusNotFound, types.NewErr(fmt.Sprintf("Entity not found %v", id)))
+	}
+
+	ids := tree.Subtree(bson.ObjectIdHex(id))
+	if len(ids) > 1 && !cascade {
+		return c.JSON(http.StatusConflict, types.NewErr(fmt.Sprintf("Entity %v contains %d other entities, use cascade=true to delete them too", id, len(ids)-1)))
+	}
+
+	err = database.Entities.DeleteAll(ids)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, types.NewErr(fmt.Sprintf("Error while removing entity: %v", err)))
 	}
 
 	// Cascade remove in projects and users
-	err = database.Projects.RemoveEntity(id)
+	idsString := []string{}
+	for _, i := range ids {
+		idsString = append(idsString, i.Hex())
+	}
+	err = database.Projects.RemoveEntities(idsString)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, types.NewErr(fmt.Sprintf("Error while cascade removing entity %v from projects", err)))
 	}
 
-	err = database.Users.RemoveEntity(bson.ObjectIdHex(id))
+	err = database.Users.RemoveEntities(ids)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, types.NewErr(fmt.Sprintf("Error while cascade removing entity %v from users", err)))
 	}
 
-	return c.JSON(http.StatusOK, res)
+	return c.JSON(http.StatusOK, ids)
 }
 
 // Save creates or update given entity
@@ -77,13 +98,8 @@ func (u *Entities) Save(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, types.NewErr("Name field cannot be empty"))
 	}
 
-	exists, err := database.Entities.Exists(entity.Name)
-	if err != nil {
-		return c.JSON(http.StatusBadRequest, types.NewErr(fmt.Sprintf("Error checking while checking if the entity already exists: %v", err)))
-	}
-
-	if exists {
-		return c.JSON(http.StatusConflict, types.NewErr(fmt.Sprintf("Received entity already exists")))
+	if !entity.Type.IsValid() {
+		return c.JSON(http.StatusBadRequest, types.NewErr(fmt.Sprintf("Entity type %q is not valid", entity.Type)))
 	}
 
 	if id != "" {
@@ -94,6 +110,29 @@ func (u *Entities) Save(c echo.Context) error {
 		entity.ID = ""
 	}
 
+	exists, err := database.Entities.Exists(entity.Name, entity.ID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, types.NewErr(fmt.Sprintf("Error checking while checking if the entity already exists: %v", err)))
+	}
+
+	if exists {
+		return c.JSON(http.StatusConflict, types.NewErr(fmt.Sprintf("Received entity already exists")))
+	}
+
+	if entity.Parent != "" {
+		tree, err := database.Entities.FindTree()
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, types.NewErr(fmt.Sprintf("Error while retrieving the tree of entities: %v", err)))
+		}
+		if _, ok := tree.Get(entity.Parent); !ok {
+			return c.JSON(http.StatusBadRequest, types.NewErr(fmt.Sprintf("Parent entity not found %v", entity.Parent.Hex())))
+		}
+		// The parent can't be the entity itself or one of its descendants, otherwise the tree would have a cycle
+		if entity.ID != "" && tree.IsInSubtree(entity.Parent, entity.ID) {
+			return c.JSON(http.StatusBadRequest, types.NewErr("Parent entity cannot be the entity itself or one of its descendants"))
+		}
+	}
+
 	entitySaved, err := database.Entities.Save(entity)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, types.NewErr(fmt.Sprintf("Failed to save entity to database: %v", err)))
@@ -101,3 +140,166 @@ func (u *Entities) Save(c echo.Context) error {
 
 	return c.JSON(http.StatusOK, entitySaved)
 }
+
+// mergeQuery is the entity which takes over the references to another entity
+type mergeQuery struct {
+	Into string `json:"into"` // ID of the entity
+}
+
+// Merge replaces an entity by another one of the same type in every project, user and child entity, then deletes it
+func (u *Entities) Merge(c echo.Context) error {
+	database := c.Get("database").(*mongo.DadMongo)
+	authUser := c.Get("authuser").(types.User)
+	id := c.Param("id")
+
+	var query mergeQuery
+	err := c.Bind(&query)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, types.NewErr(fmt.Sprintf("Posted merge is not valid: %v", err)))
+	}
+
+	tree, err := database.Entities.FindTree()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, types.NewErr(fmt.Sprintf("Error while retrieving the tree of entities: %v", err)))
+	}
+	entity, ok := tree.GetHex(id)
+	if !ok {
+		return c.JSON(http.StatusNotFound, types.NewErr(fmt.Sprintf("Entity not found %v", id)))
+	}
+	into, ok := tree.GetHex(query.Into)
+	if !ok {
+		return c.JSON(http.StatusBadRequest, types.NewErr(fmt.Sprintf("Entity %v to merge into does not exist", query.Into)))
+	}
+	if entity.Type != into.Type {
+		return c.JSON(http.StatusBadRequest, types.NewErr(fmt.Sprintf("Entity %s of type %s can't be merged into entity %s of type %s", entity.Name, entity.Type, into.Name, into.Type)))
+	}
+	// The children of the entity are moved to the other entity, which can't be one of them
+	if tree.IsInSubtree(into.ID, entity.ID) {
+		return c.JSON(http.StatusBadRequest, types.NewErr("Entity cannot be merged into itself or one of its descendants"))
+	}
+
+	log.WithFields(log.Fields{
+		"username": authUser.Username,
+		"from":     entity.Name,
+		"into":     into.Name,
+	}).Info("User trying to merge entities")
+
+	// Projects are read before the merge to record their changes in history
+	projects, err := database.Projects.FindByEntity(entity.ID.Hex())
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, types.NewErr(fmt.Sprintf("Error while retrieving the projects of the entity %s", entity.Name)))
+	}
+
+	// Every step replaces all the references at once, and the entity is only deleted when no reference remains,
+	// so a failed merge leaves the entity in place and can be run again
+	results := types.MergeEntitiesResults{Projects: len(projects)}
+	if err = database.Projects.ReplaceEntity(entity.ID.Hex(), into.ID.Hex()); err != nil {
+		return c.JSON(http.StatusInternalServerError, types.NewErr(fmt.Sprintf("Error while replacing entity %s in projects: %v", entity.Name, err)))
+	}
+	for _, project := range projects {
+		projectMerged, _ := project.ReplaceEntity(entity.ID.Hex(), into.ID.Hex())
+		recordHistory(database, types.UpdateAction, authUser.Username, project, projectMerged)
+	}
+
+	if results.Users, err = database.Users.ReplaceEntity(entity.ID, into.ID); err != nil {
+		return c.JSON(http.StatusInternalServerError, types.NewErr(fmt.Sprintf("Error while replacing entity %s in users: %v", entity.Name, err)))
+	}
+	if results.Children, err = database.Entities.Reparent(entity.ID, into.ID); err != nil {
+		return c.JSON(http.StatusInternalServerError, types.NewErr(fmt.Sprintf("Error while moving the children of entity %s: %v", entity.Name, err)))
+	}
+	if _, err = database.Entities.Delete(entity.ID); err != nil {
+		return c.JSON(http.StatusInternalServerError, types.NewErr(fmt.Sprintf("Error while removing entity: %v", err)))
+	}
+
+	return c.JSON(http.StatusOK, results)
+}
+
+// GetIntegrity reports the problematic references to entities from projects, users and entities
+func (u *Entities) GetIntegrity(c echo.Context) error {
+	return checkIntegrity(c, false)
+}
+
+// RepairIntegrity repairs the problematic references to entities from projects, users and entities, and reports them
+func (u *Entities) RepairIntegrity(c echo.Context) error {
+	return checkIntegrity(c, true)
+}
+
+func checkIntegrity(c echo.Context, repair bool) error {
+	database := c.Get("database").(*mongo.DadMongo)
+	authUser := c.Get("authuser").(types.User)
+
+	entities, err := database.Entities.FindAll()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, types.NewErr("Error while retreiving all entities"))
+	}
+	projects, err := database.Projects.FindAll()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, types.NewErr("Error while retrieving projects"))
+	}
+	archivedProjects, err := database.Projects.FindArchived()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, types.NewErr("Error while retrieving archived projects"))
+	}
+	users, err := database.Users.FindAll()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, types.NewErr("Error while retreiving all users"))
+	}
+
+	tree := types.NewEntityTree(entities)
+	results := types.EntityIntegrityResults{References: []types.EntityReference{}, Errors: []string{}}
+	// repaired records the result of the repair of a document
+	repaired := func(err error) {
+		if err != nil {
+			results.Errors = append(results.Errors, err.Error())
+		} else {
+			results.Repaired++
+		}
+	}
+
+	for _, project := range append(projects, archivedProjects...) {
+		projectToSave, references := tree.CheckProject(project)
+		results.References = append(results.References, references...)
+		if repair && len(references) > 0 {
+			projectToSave.Updated = time.Now()
+			projectSaved, err := database.Projects.Save(projectToSave)
+			if err == nil {
+				recordHistory(database, types.UpdateAction, authUser.Username, project, projectSaved)
+			} else {
+				err = fmt.Errorf("Failed to repair project %s: %v", project.Name, err)
+			}
+			repaired(err)
+		}
+	}
+	for _, user := range users {
+		userToSave, references := tree.CheckUser(user)
+		results.References = append(results.References, references...)
+		if repair && len(references) > 0 {
+			_, err := database.Users.Save(userToSave)
+			if err != nil {
+				err = fmt.Errorf("Failed to repair user %s: %v", user.Username, err)
+			}
+			repaired(err)
+		}
+	}
+	for _, entity := range entities {
+		entityToSave, references := tree.CheckEntity(entity)
+		results.References = append(results.References, references...)
+		if repair && len(references) > 0 {
+			_, err := database.Entities.Save(entityToSave)
+			if err != nil {
+				err = fmt.Errorf("Failed to repair entity %s: %v", entity.Name, err)
+			}
+			repaired(err)
+		}
+	}
+
+	if repair {
+		log.WithFields(log.Fields{
+			"username":   authUser.Username,
+			"references": len(results.References),
+			"repaired":   results.Repaired,
+			"errors":     len(results.Errors),
+		}).Info("References to entities repaired")
+	}
+	return c.JSON(http.StatusOK, results)
+}
@@ -5,57 +5,117 @@ import (
 	"io"
 	"mime"
 	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/labstack/echo"
 	"github.com/soprasteria/dad/server/export"
+	"github.com/soprasteria/dad/server/jobs"
 	"github.com/soprasteria/dad/server/mongo"
 	"github.com/soprasteria/dad/server/types"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
 )
 
 // Export contains all handlers for exporting data as CSV/XSLX...
 type Export struct {
 }
 
-// ExportAll exports all the data as a file
+// exportDateFormat is the format of the dates used to filter exported projects
+const exportDateFormat = "2006-01-02"
+
+// parseExportFilter reads the filters of the exported projects from the query params
+// Several entities can be given by repeating the entity param
+func parseExportFilter(c echo.Context, database *mongo.DadMongo) (types.ProjectFilter, error) {
+	filter := types.ProjectFilter{
+		Entities:   c.QueryParams()["entity"],
+		Client:     c.QueryParam("client"),
+		Domain:     c.QueryParam("domain"),
+		Mode:       c.QueryParam("mode"),
+		Technology: c.QueryParam("technology"),
+	}
+	for _, entity := range filter.Entities {
+		if !bson.IsObjectIdHex(entity) {
+			return filter, fmt.Errorf("Entity %q is not a valid ID", entity)
+		}
+	}
+
+	if pkg := c.QueryParam("package"); pkg != "" {
+		services, err := database.FunctionalServices.FindByPackage(pkg)
+		if err != nil {
+			return filter, err
+		}
+		if len(services) == 0 {
+			return filter, fmt.Errorf("Package %q does not contain any functional service", pkg)
+		}
+		filter.Services = []bson.ObjectId{}
+		for _, service := range services {
+			filter.Services = append(filter.Services, service.ID)
+		}
+	}
+
+	dates := []struct {
+		param string
+		date  *time.Time
+		to    bool
+	}{
+		{"createdFrom", &filter.CreatedFrom, false},
+		{"createdTo", &filter.CreatedTo, true},
+		{"updatedFrom", &filter.UpdatedFrom, false},
+		{"updatedTo", &filter.UpdatedTo, true},
+	}
+	for _, d := range dates {
+		value := c.QueryParam(d.param)
+		if value == "" {
+			continue
+		}
+		date, err := time.Parse(exportDateFormat, value)
+		if err != nil {
+			return filter, fmt.Errorf("Date %s %q is not valid. Expected format is %s", d.param, value, exportDateFormat)
+		}
+		if d.to {
+			// Include the whole day
+			date = date.Add(24*time.Hour - time.Nanosecond)
+		}
+		*d.date = date
+	}
+
+	return filter, nil
+}
+
+// ExportAll exports the data of the projects visible by the user as a file
+// Exported projects can be filtered by entity, package, client, domain, deployment mode, technology, creation and update dates
 func (a *Export) ExportAll(c echo.Context) error {
 	language := c.QueryParam("language")
+	writer, err := export.GetWriter(c.QueryParam("format"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, types.NewErr(err.Error()))
+	}
 	database := c.Get("database").(*mongo.DadMongo)
-	exporter := export.Export{Database: database}
+	filter, err := parseExportFilter(c, database)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, types.NewErr(fmt.Sprintf("Export filters are not valid: %v", err)))
+	}
+	exporter := export.Export{Database: database, Package: c.QueryParam("package")}
 
 	authUser := c.Get("authuser").(types.User)
 	log.WithFields(log.Fields{
 		"username": authUser.Username,
 		"role":     authUser.Role,
+		"format":   c.QueryParam("format"),
 	}).Info("User trying to perform a data export")
 
-	projects, err := database.Projects.FindForUser(authUser)
-	if err != nil {
-		log.WithError(err).Error("Error while retrieving a user's projects")
-		return c.JSON(http.StatusInternalServerError, types.NewErr("Cannot export DAD data in a file"))
-	}
-
-	usageIndicatorRepo := database.UsageIndicators
-	projectToUsageIndicators := map[string][]types.UsageIndicator{}
-	for key, project := range projects {
-		usageIndicators, err2 := usageIndicatorRepo.FindAllFromGroup(project.DocktorGroupName)
-		if err2 != nil {
-			log.WithError(err2).Warn("Error while retrieving usageIndicators, indicators can't be reached for the project : " + project.Name)
-			continue
-		}
-		projectToUsageIndicators[project.Name] = usageIndicators
-		// Reverse field cdk applicable for export
-		projects[key].IsCDKApplicable = !project.IsCDKApplicable
-	}
-
-	data, err := exporter.Export(language, projects, projectToUsageIndicators)
+	data, err := exporter.ExportForUser(authUser, filter, language, writer)
 	if err != nil {
 		log.WithError(err).Error("Error occurred during the data export")
 		return c.JSON(http.StatusInternalServerError, types.NewErr("Cannot export DAD data in a file"))
 	}
 
-	return serveContent(c, data, fmt.Sprintf("DAD-Export-%s.xlsx", time.Now()), time.Now())
+	c.Response().Header().Set("Content-Type", writer.ContentType())
+	return serveContent(c, data, fmt.Sprintf("DAD-Export-%s%s", time.Now(), writer.Extension()), time.Now())
 }
 
 func serveContent(c echo.Context, content io.ReadSeeker, name string, modtime time.Time) error {
@@ -68,9 +128,92 @@ func serveContent(c echo.Context, content io.ReadSeeker, name string, modtime ti
 		return c.NoContent(http.StatusNotModified)
 	}
 
-	res.Header().Set("Content-Type", mime.TypeByExtension(name))
+	if res.Header().Get("Content-Type") == "" {
+		res.Header().Set("Content-Type", mime.TypeByExtension(filepath.Ext(name)))
+	}
 	res.Header().Set("Last-Modified", modtime.UTC().Format(http.TimeFormat))
 	res.WriteHeader(http.StatusOK)
 	_, err := io.Copy(res, content)
 	return err
 }
+
+// CreateJob queues an export, generated in background. The export accepts the same parameters as ExportAll,
+// and the notify query param to send an email to the user when the file is ready
+func (a *Export) CreateJob(c echo.Context) error {
+	database := c.Get("database").(*mongo.DadMongo)
+	authUser := c.Get("authuser").(types.User)
+
+	writer, err := export.GetWriter(c.QueryParam("format"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, types.NewErr(err.Error()))
+	}
+	filter, err := parseExportFilter(c, database)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, types.NewErr(fmt.Sprintf("Export filters are not valid: %v", err)))
+	}
+	notify, _ := strconv.ParseBool(c.QueryParam("notify"))
+
+	job, err := database.ExportJobs.Save(types.ExportJob{
+		Username:    authUser.Username,
+		Status:      types.ExportQueued,
+		Format:      strings.TrimPrefix(writer.Extension(), "."),
+		Language:    c.QueryParam("language"),
+		Package:     c.QueryParam("package"),
+		Filter:      filter,
+		Notify:      notify,
+		ContentType: writer.ContentType(),
+		Created:     time.Now(),
+	})
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, types.NewErr(fmt.Sprintf("Failed to queue the export: %v", err)))
+	}
+
+	log.WithFields(log.Fields{
+		"username":  authUser.Username,
+		"exportJob": job.ID,
+		"format":    job.Format,
+	}).Info("User queued a data export")
+
+	go jobs.ExecuteExportJob(job.ID)
+
+	return c.JSON(http.StatusAccepted, job)
+}
+
+// GetJobs returns the exports requested by the user
+func (a *Export) GetJobs(c echo.Context) error {
+	database := c.Get("database").(*mongo.DadMongo)
+	authUser := c.Get("authuser").(types.User)
+
+	exportJobs, err := database.ExportJobs.FindByUsername(authUser.Username)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, types.NewErr(fmt.Sprintf("Error while retrieving the exports of the user %s", authUser.Username)))
+	}
+	return c.JSON(http.StatusOK, exportJobs)
+}
+
+// GetJob returns the status of an export
+func (a *Export) GetJob(c echo.Context) error {
+	job := c.Get("exportJob").(types.ExportJob)
+	return c.JSON(http.StatusOK, job)
+}
+
+// DownloadJob serves the file generated by an export, until it expires
+func (a *Export) DownloadJob(c echo.Context) error {
+	database := c.Get("database").(*mongo.DadMongo)
+	job := c.Get("exportJob").(types.ExportJob)
+	if job.Status != types.ExportDone {
+		return c.JSON(http.StatusConflict, types.NewErr(fmt.Sprintf("Export is not ready, its status is %s", job.Status)))
+	}
+
+	file, err := database.ExportJobs.OpenFile(job.ID)
+	if err == mgo.ErrNotFound {
+		return c.JSON(http.StatusNotFound, types.NewErr("Export file has expired"))
+	} else if err != nil {
+		return c.JSON(http.StatusInternalServerError, types.NewErr(fmt.Sprintf("Error while opening the export file: %v", err)))
+	}
+	defer file.Close()
+
+	c.Response().Header().Set("Content-Type", job.ContentType)
+	c.Response().Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", job.FileName))
+	return serveContent(c, file, job.FileName, file.UploadDate())
+}
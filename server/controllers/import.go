@@ -0,0 +1,181 @@
+package controllers
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/labstack/echo"
+	"github.com/soprasteria/dad/server/export"
+	"github.com/soprasteria/dad/server/mongo"
+	"github.com/soprasteria/dad/server/types"
+	mgo "gopkg.in/mgo.v2"
+)
+
+// Statuses of a row of an imported file
+const (
+	importCreated = "created"
+	importUpdated = "updated"
+	importError   = "error"
+)
+
+// readImportedFile reads the file posted in the "file" form field, as XLSX or CSV depending on its extension
+func readImportedFile(c echo.Context) (export.ImportedFile, error) {
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		return export.ImportedFile{}, fmt.Errorf("A file should be posted in the 'file' field: %v", err)
+	}
+	file, err := fileHeader.Open()
+	if err != nil {
+		return export.ImportedFile{}, err
+	}
+	defer file.Close()
+
+	content, err := ioutil.ReadAll(file)
+	if err != nil {
+		return export.ImportedFile{}, err
+	}
+
+	switch strings.ToLower(filepath.Ext(fileHeader.Filename)) {
+	case ".xlsx":
+		return export.ReadXlsx(content)
+	case ".csv":
+		return export.ReadCsv(bytes.NewReader(content))
+	}
+	return export.ImportedFile{}, fmt.Errorf("File %q should be a XLSX or CSV file", fileHeader.Filename)
+}
+
+// Import creates or updates projects from a XLSX file (with the same layout as the export) or a CSV file
+// Projects are matched by name. Each row is validated as if the project was saved by the user.
+// With the dryRun query param, projects are only validated and nothing is saved
+func (p *Projects) Import(c echo.Context) error {
+	database := c.Get("database").(*mongo.DadMongo)
+	authUser := c.Get("authuser").(types.User)
+
+	dryRun, _ := strconv.ParseBool(c.QueryParam("dryRun"))
+	log.WithFields(log.Fields{
+		"username": authUser.Username,
+		"role":     authUser.Role,
+		"dryRun":   dryRun,
+	}).Info("User trying to import projects")
+
+	file, err := readImportedFile(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, types.NewErr(fmt.Sprintf("Imported file is not valid: %v", err)))
+	}
+
+	entities, err := database.Entities.FindAll()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, types.NewErr("Error while retrieving the entities"))
+	}
+	users, err := database.Users.FindAll()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, types.NewErr("Error while retrieving the users"))
+	}
+	services, err := database.FunctionalServices.FindAll()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, types.NewErr("Error while retrieving the functional services"))
+	}
+	modifiableProjects, err := database.Projects.FindModifiableForUser(authUser)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, types.NewErr(fmt.Sprintf("Error while retrieving the projects of the user %s", authUser.Username)))
+	}
+
+	refs := export.NewImportReferences(entities, users, services)
+	results := types.BulkImportProjectsResults{
+		DryRun:   dryRun,
+		All:      len(file.Rows),
+		Warnings: []string{},
+		Rows:     []types.ProjectImportRow{},
+	}
+	for _, column := range refs.UnknownColumns(file.Columns) {
+		results.Warnings = append(results.Warnings, fmt.Sprintf("Column %q is ignored", column))
+	}
+
+	importedNames := map[string]int{}
+	for _, row := range file.Rows {
+		result := p.importRow(database, authUser, refs, modifiableProjects, importedNames, row, dryRun)
+		switch result.Status {
+		case importCreated:
+			results.Created++
+		case importUpdated:
+			results.Updated++
+		default:
+			results.InError++
+		}
+		results.Rows = append(results.Rows, result)
+	}
+
+	return c.JSON(http.StatusOK, results)
+}
+
+// importRow creates or updates the project described by a row of an imported file
+func (p *Projects) importRow(database *mongo.DadMongo, authUser types.User, refs export.ImportReferences, modifiableProjects types.Projects, importedNames map[string]int, row export.ImportedRow, dryRun bool) types.ProjectImportRow {
+	result := types.ProjectImportRow{Line: row.Line, Name: row.ProjectName(), Status: importError}
+
+	name := strings.ToLower(row.ProjectName())
+	if line, ok := importedNames[name]; ok {
+		result.Message = fmt.Sprintf("Project is already imported at line %d", line)
+		return result
+	}
+	importedNames[name] = row.Line
+
+	// Existing projects are matched by name
+	existingProject, err := database.Projects.FindByName(row.ProjectName())
+	if err != nil && err != mgo.ErrNotFound {
+		result.Message = fmt.Sprintf("Can't check whether the project exist in database: %v", err)
+		return result
+	}
+	id := ""
+	if err == nil {
+		if !modifiableProjects.ContainsBsonID(existingProject.ID) {
+			result.Message = fmt.Sprintf("User %s isn't allowed to update the project", authUser.Username)
+			return result
+		}
+		id = existingProject.ID.Hex()
+	}
+
+	projectToSave, err := refs.ToProject(row, existingProject)
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+
+	saveProjectData, _, err := p.createProjectToSave(database, id, projectToSave, authUser, existingProject)
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+
+	result.Status = importUpdated
+	if id == "" {
+		result.Status = importCreated
+	}
+	if dryRun {
+		result.ID = existingProject.ID
+		return result
+	}
+
+	projectSaved, err := database.Projects.Save(saveProjectData.projectToSave)
+	if err != nil {
+		result.Status = importError
+		result.Message = fmt.Sprintf("Failed to save project to database: %v", err)
+		return result
+	}
+	result.ID = projectSaved.ID
+
+	if id == "" {
+		recordHistory(database, types.CreateAction, authUser.Username, types.Project{}, projectSaved)
+	} else {
+		recordHistory(database, types.UpdateAction, authUser.Username, existingProject, projectSaved)
+	}
+	if projectSaved.DocktorGroupURL != "" && saveProjectData.existingProject.DocktorGroupURL != projectSaved.DocktorGroupURL {
+		p.updateDocktorGroupNameInBackground(projectSaved)
+	}
+	return result
+}
@@ -0,0 +1,208 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/labstack/echo"
+	"github.com/soprasteria/dad/server/mongo"
+	"github.com/soprasteria/dad/server/types"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// matrixFieldPermissions gives the permission required to update each field of a matrix line
+// The deployed status is computed by the deployment job, so it can only be overridden with a dedicated permission, unless the deployment is declarative
+var matrixFieldPermissions = map[string]types.Permission{
+	"deployed": types.MatrixDeployedEditPermission,
+	"progress": types.MatrixEditPermission,
+	"goal":     types.MatrixGoalsEditPermission,
+	"priority": types.MatrixGoalsEditPermission,
+	"dueDate":  types.MatrixEditPermission,
+	"comment":  types.MatrixEditPermission,
+}
+
+// BulkMatrixLinePatch is the update of the matrix line of a functional service across several projects
+type BulkMatrixLinePatch struct {
+	Projects []bson.ObjectId       `json:"projects"`
+	Line     types.MatrixLinePatch `json:"line"`
+}
+
+// checkMatrixLinePatchRights checks that the user is allowed to update every field of the patch
+func checkMatrixLinePatchRights(authUser types.User, patch types.MatrixLinePatch, service types.FunctionalService) error {
+	for field := range patch.Fields() {
+		if field == "deployed" && service.DeclarativeDeployment {
+			continue
+		}
+		if !authUser.Can(matrixFieldPermissions[field]) {
+			return fmt.Errorf("User %s is not allowed to update the field %s of the matrix", authUser.Username, field)
+		}
+	}
+	return nil
+}
+
+// checkMatrixRights checks that the user is allowed to change the matrix of a project into the saved one
+// Changed lines are checked field by field like patches, and adding or removing lines requires the permission to edit the matrix
+func checkMatrixRights(authUser types.User, before, after types.Matrix, services map[bson.ObjectId]types.FunctionalService) error {
+	previous := map[bson.ObjectId]types.MatrixLine{}
+	for _, line := range before {
+		previous[line.Service] = line
+	}
+	kept := map[bson.ObjectId]bool{}
+	for _, line := range after {
+		kept[line.Service] = true
+		from, ok := previous[line.Service]
+		if !ok {
+			if !authUser.Can(types.MatrixEditPermission) {
+				return fmt.Errorf("User %s is not allowed to add lines to the matrix", authUser.Username)
+			}
+			continue
+		}
+		if err := checkMatrixLinePatchRights(authUser, from.Patch(line), services[line.Service]); err != nil {
+			return err
+		}
+	}
+	for _, line := range before {
+		if !kept[line.Service] && !authUser.Can(types.MatrixEditPermission) {
+			return fmt.Errorf("User %s is not allowed to remove lines from the matrix", authUser.Username)
+		}
+	}
+	return nil
+}
+
+// readMatrixLinePatch validates the patch and the functional service to update, with the rights of the user
+func readMatrixLinePatch(database *mongo.DadMongo, authUser types.User, serviceID string, patch types.MatrixLinePatch) (types.FunctionalService, int, error) {
+	if len(patch.Fields()) == 0 {
+		return types.FunctionalService{}, http.StatusBadRequest, errors.New("At least one field of the matrix line should be updated")
+	}
+	if err := patch.Validate(); err != nil {
+		return types.FunctionalService{}, http.StatusBadRequest, err
+	}
+
+	service, err := database.FunctionalServices.FindByID(serviceID)
+	if err != nil || service.ID.Hex() == "" {
+		return types.FunctionalService{}, http.StatusNotFound, fmt.Errorf("Functional service not found %v", serviceID)
+	}
+
+	if err := checkMatrixLinePatchRights(authUser, patch, service); err != nil {
+		return types.FunctionalService{}, http.StatusForbidden, err
+	}
+	return service, http.StatusOK, nil
+}
+
+// updateMatrixLine updates the matrix line of a single project and records the change in its history
+func (p *Projects) updateMatrixLine(database *mongo.DadMongo, authUser types.User, id, service bson.ObjectId, patch types.MatrixLinePatch) (types.Project, error) {
+	previousProject, err := database.Projects.FindByIDBson(id)
+	if err != nil {
+		return types.Project{}, fmt.Errorf("Project not found %v", id.Hex())
+	}
+
+	err = database.Projects.UpdateMatrixLine(id, service, patch)
+	if err != nil {
+		return types.Project{}, fmt.Errorf("Failed to update the matrix of the project: %v", err)
+	}
+
+	project, err := database.Projects.FindByIDBson(id)
+	if err != nil {
+		return types.Project{}, fmt.Errorf("Failed to get the updated project from database: %v", err)
+	}
+	recordHistory(database, types.UpdateAction, authUser.Username, previousProject, project)
+	return project, nil
+}
+
+// UpdateMatrixLine updates only the given fields of the matrix line of a functional service
+func (p *Projects) UpdateMatrixLine(c echo.Context) error {
+	database := c.Get("database").(*mongo.DadMongo)
+	id := c.Param("id")
+	serviceID := c.Param("serviceId")
+	authUser := c.Get("authuser").(types.User)
+
+	log.WithFields(log.Fields{
+		"username":  authUser.Username,
+		"role":      authUser.Role,
+		"projectID": id,
+		"serviceID": serviceID,
+	}).Info("User trying to update a matrix line")
+
+	var patch types.MatrixLinePatch
+	err := c.Bind(&patch)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, types.NewErr(fmt.Sprintf("Posted matrix line is not valid: %v", err)))
+	}
+
+	service, httpStatus, err := readMatrixLinePatch(database, authUser, serviceID, patch)
+	if err != nil {
+		return c.JSON(httpStatus, types.NewErr(err.Error()))
+	}
+
+	// Get only projects that the user can modify
+	userProjects, err := database.Projects.FindModifiableForUser(authUser)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, types.NewErr(fmt.Sprintf("Error while retrieving the projects of the user %s", authUser.Username)))
+	}
+	if !userProjects.ContainsBsonID(bson.ObjectIdHex(id)) {
+		return c.JSON(http.StatusForbidden, types.NewErr(fmt.Sprintf("User %s isn't allowed to update the project", authUser.Username)))
+	}
+
+	project, err := p.updateMatrixLine(database, authUser, bson.ObjectIdHex(id), service.ID, patch)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, types.NewErr(err.Error()))
+	}
+
+	return c.JSON(http.StatusOK, project)
+}
+
+// BulkUpdateMatrixLine updates the matrix line of a functional service across several projects at once
+// A project is skipped when an error occurred, without preventing other projects from being updated
+func (p *Projects) BulkUpdateMatrixLine(c echo.Context) error {
+	database := c.Get("database").(*mongo.DadMongo)
+	serviceID := c.Param("serviceId")
+	authUser := c.Get("authuser").(types.User)
+
+	var bulkPatch BulkMatrixLinePatch
+	err := c.Bind(&bulkPatch)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, types.NewErr(fmt.Sprintf("Posted matrix lines are not valid: %v", err)))
+	}
+
+	log.WithFields(log.Fields{
+		"username":  authUser.Username,
+		"role":      authUser.Role,
+		"projects":  bulkPatch.Projects,
+		"serviceID": serviceID,
+	}).Info("User trying to update a matrix line of several projects")
+
+	service, httpStatus, err := readMatrixLinePatch(database, authUser, serviceID, bulkPatch.Line)
+	if err != nil {
+		return c.JSON(httpStatus, types.NewErr(err.Error()))
+	}
+
+	// Get only projects that the user can modify
+	userProjects, err := database.Projects.FindModifiableForUser(authUser)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, types.NewErr(fmt.Sprintf("Error while retrieving the projects of the user %s", authUser.Username)))
+	}
+
+	errs := []types.ProjectInError{}
+	for i, id := range bulkPatch.Projects {
+		if !userProjects.ContainsBsonID(id) {
+			errs = append(errs, types.ProjectInError{
+				Project: id,
+				Message: fmt.Sprintf("User %s isn't allowed to update the project. Skipped", authUser.Username),
+				Index:   i,
+			})
+			continue
+		}
+		if _, err := p.updateMatrixLine(database, authUser, id, service.ID, bulkPatch.Line); err != nil {
+			errs = append(errs, types.ProjectInError{Project: id, Message: err.Error(), Index: i})
+		}
+	}
+
+	return c.JSON(http.StatusOK, types.BulkUpdateMatrixResults{
+		All:     len(bulkPatch.Projects),
+		Updated: len(bulkPatch.Projects) - len(errs),
+		InError: len(errs),
+		Errors:  errs,
+	})
+}
@@ -0,0 +1,56 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/soprasteria/dad/server/types"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestCheckMatrixRights(t *testing.T) {
+
+	Convey("Given the matrix of a project", t, func() {
+		jenkins := types.FunctionalService{ID: bson.NewObjectId(), Name: "Jenkins"}
+		wiki := types.FunctionalService{ID: bson.NewObjectId(), Name: "Wiki", DeclarativeDeployment: true}
+		services := map[bson.ObjectId]types.FunctionalService{jenkins.ID: jenkins, wiki.ID: wiki}
+		before := types.Matrix{
+			{Service: jenkins.ID, Deployed: types.Deployed[-1], Progress: 1, Goal: 3, Priority: types.Priority[1]},
+			{Service: wiki.ID, Deployed: types.Deployed[-1], Progress: 0, Goal: 2},
+		}
+		pm := types.User{Username: "pm", Role: types.PMRole}
+		ri := types.User{Username: "ri", Role: types.RIRole}
+		changed := func(change func(matrix types.Matrix)) types.Matrix {
+			matrix := append(types.Matrix{}, before...)
+			change(matrix)
+			return matrix
+		}
+
+		Convey("Then a project manager can save a new progress, comment and due date", func() {
+			due := time.Date(2017, 6, 1, 0, 0, 0, 0, time.UTC)
+			after := changed(func(m types.Matrix) { m[0].Progress, m[0].Comment, m[0].DueDate = 2, "Migrated", &due })
+			So(checkMatrixRights(pm, before, after, services), ShouldBeNil)
+		})
+
+		Convey("Then a project manager saving a new goal is rejected", func() {
+			after := changed(func(m types.Matrix) { m[0].Goal = 5 })
+			So(checkMatrixRights(pm, before, after, services), ShouldNotBeNil)
+		})
+
+		Convey("Then a project manager saving a new priority is rejected", func() {
+			after := changed(func(m types.Matrix) { m[0].Priority = types.Priority[0] })
+			So(checkMatrixRights(pm, before, after, services), ShouldNotBeNil)
+		})
+
+		Convey("Then a RI overwriting the deployed status is rejected, unless the deployment is declarative", func() {
+			So(checkMatrixRights(ri, before, changed(func(m types.Matrix) { m[0].Deployed = types.Deployed[0] }), services), ShouldNotBeNil)
+			So(checkMatrixRights(ri, before, changed(func(m types.Matrix) { m[1].Deployed = types.Deployed[0] }), services), ShouldBeNil)
+		})
+
+		Convey("Then lines can be added and removed with the permission to edit the matrix", func() {
+			after := types.Matrix{before[1], {Service: bson.NewObjectId(), Progress: 1, Goal: -1}}
+			So(checkMatrixRights(pm, before, after, services), ShouldBeNil)
+		})
+	})
+}
@@ -0,0 +1,105 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/labstack/echo"
+	"github.com/soprasteria/dad/server/mongo"
+	"github.com/soprasteria/dad/server/types"
+)
+
+// canManageMembers checks that the user is allowed to manage the members of the project
+// Owners can manage the members of their project, and users with the permission the members of the projects they can modify
+func canManageMembers(database *mongo.DadMongo, authUser types.User, project types.Project) (bool, error) {
+	if project.MemberRole(authUser.ID) == types.OwnerRole {
+		return true, nil
+	}
+	if !authUser.Can(types.ProjectMembersPermission) {
+		return false, nil
+	}
+	userProjects, err := database.Projects.FindModifiableForUser(authUser)
+	if err != nil {
+		return false, err
+	}
+	return userProjects.ContainsBsonID(project.ID), nil
+}
+
+// GetMembers returns the members of a project. The project was stored by a middleware which use id to get project informations
+func (p *Projects) GetMembers(c echo.Context) error {
+	project := c.Get("project").(types.Project)
+	members := project.Members
+	if members == nil {
+		members = []types.ProjectMember{}
+	}
+	return c.JSON(http.StatusOK, members)
+}
+
+// SetMember gives a role on the project to a user, replacing his previous role
+func (p *Projects) SetMember(c echo.Context) error {
+	database := c.Get("database").(*mongo.DadMongo)
+	authUser := c.Get("authuser").(types.User)
+	project := c.Get("project").(types.Project)
+	userID := c.Param("userId")
+
+	var member types.ProjectMember
+	err := c.Bind(&member)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, types.NewErr(fmt.Sprintf("Posted member is not valid: %v", err)))
+	}
+	if !member.Role.IsValid() {
+		return c.JSON(http.StatusBadRequest, types.NewErr(fmt.Sprintf("Project role %q is not valid, it should be one of %v", member.Role, types.ProjectRoles)))
+	}
+	user, err := database.Users.FindByID(userID)
+	if err != nil || user.ID.Hex() == "" {
+		return c.JSON(http.StatusNotFound, types.NewErr(fmt.Sprintf("User not found %v", userID)))
+	}
+	member.User = user.ID.Hex()
+
+	return p.updateMembers(c, database, authUser, project, member, func() error {
+		return database.Projects.SetMember(project.ID, member)
+	})
+}
+
+// RemoveMember removes a user from the members of the project
+func (p *Projects) RemoveMember(c echo.Context) error {
+	database := c.Get("database").(*mongo.DadMongo)
+	authUser := c.Get("authuser").(types.User)
+	project := c.Get("project").(types.Project)
+	member := types.ProjectMember{User: c.Param("userId")}
+
+	return p.updateMembers(c, database, authUser, project, member, func() error {
+		return database.Projects.RemoveMember(project.ID, member.User)
+	})
+}
+
+// updateMembers checks the rights of the user, then updates the members of the project and records the change in its history
+func (p *Projects) updateMembers(c echo.Context, database *mongo.DadMongo, authUser types.User, project types.Project, member types.ProjectMember, update func() error) error {
+	log.WithFields(log.Fields{
+		"username":   authUser.Username,
+		"role":       authUser.Role,
+		"projectID":  project.ID,
+		"member":     member.User,
+		"memberRole": member.Role,
+	}).Info("User trying to update the members of a project")
+
+	allowed, err := canManageMembers(database, authUser, project)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, types.NewErr(fmt.Sprintf("Error while retrieving the projects of the user %s", authUser.Username)))
+	}
+	if !allowed {
+		return c.JSON(http.StatusForbidden, types.NewErr(fmt.Sprintf("User %s isn't allowed to manage the members of the project", authUser.Username)))
+	}
+
+	if err = update(); err != nil {
+		return c.JSON(http.StatusInternalServerError, types.NewErr(fmt.Sprintf("Failed to update the members of the project: %v", err)))
+	}
+	projectSaved, err := database.Projects.FindByIDBson(project.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, types.NewErr(fmt.Sprintf("Failed to get the updated project from database: %v", err)))
+	}
+	recordHistory(database, types.UpdateAction, authUser.Username, project, projectSaved)
+
+	return c.JSON(http.StatusOK, projectSaved)
+}
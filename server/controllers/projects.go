@@ -1,6 +1,7 @@
 package controllers
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
@@ -33,6 +34,13 @@ type SaveProjectData struct {
 	projectToSave   types.Project
 }
 
+// ProjectConflict is the error returned when a project can't be saved because it has been modified by someone else in the meantime
+// It contains the current version of the project in database, so that the user can merge his changes
+type ProjectConflict struct {
+	types.ErrorMsg
+	Project types.Project `json:"project"`
+}
+
 // NewSaveProjectData is SaveProjectData Constructor
 func NewSaveProjectData(existingProject types.Project, projectToSave types.Project) SaveProjectData {
 	return SaveProjectData{existingProject: existingProject, projectToSave: projectToSave}
@@ -73,8 +81,50 @@ func (p *Projects) GetIndicators(c echo.Context) error {
 	return c.JSON(http.StatusOK, indicators)
 }
 
+// GetHistory returns the history of changes of a specific project. The project was stored by a middleware which use id to get project informations
+func (p *Projects) GetHistory(c echo.Context) error {
+	database := c.Get("database").(*mongo.DadMongo)
+	project := c.Get("project").(types.Project)
+	history, err := database.ProjectHistory.FindByProject(project.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, types.NewErr(fmt.Sprintf("Error while retrieving the history of the project %s: %v", project.Name, err.Error())))
+	}
+	return c.JSON(http.StatusOK, history)
+}
+
+// GetTrend returns the maturity of a specific project over time, optionally for a single functional service. The project was stored by a middleware which use id to get project informations
+func (p *Projects) GetTrend(c echo.Context) error {
+	database := c.Get("database").(*mongo.DadMongo)
+	project := c.Get("project").(types.Project)
+
+	service, query, err := parseTrendQuery(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, types.NewErr(err.Error()))
+	}
+	query.Projects = []bson.ObjectId{project.ID}
+
+	snapshots, err := database.ProjectSnapshots.Find(query)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, types.NewErr(fmt.Sprintf("Error while retrieving the snapshots of the project %s: %v", project.Name, err.Error())))
+	}
+	return c.JSON(http.StatusOK, types.ComputeTrend(snapshots, service))
+}
+
+// recordHistory saves the changes made on a project in its history
+// Errors are only logged because the history should not prevent the project from being modified
+func recordHistory(database *mongo.DadMongo, action types.HistoryAction, author string, oldProject, newProject types.Project) {
+	_, err := database.ProjectHistory.Record(action, author, oldProject, newProject)
+	if err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"action":    action,
+			"author":    author,
+			"projectID": oldProject.ID,
+		}).Error("Unable to record the project history")
+	}
+}
+
 // sendEmail sets the email body and send it
-func sendEmail(project types.Project, to types.User, userRepo types.UserRepo, name, address string) {
+func sendEmail(project types.Project, to types.User, userRepo types.UserRepo, entityRepo types.EntityRepo, name, address string) {
 
 	var option = email.SendOptions{
 		To: []mail.Address{
@@ -106,10 +156,20 @@ func sendEmail(project types.Project, to types.User, userRepo types.UserRepo, na
 			},
 		}}
 
-	// Add the RI as email receiver
-	entityIDs := []bson.ObjectId{bson.ObjectIdHex(project.BusinessUnit)}
-	for _, sC := range project.ServiceCenter {
-		entityIDs[len(entityIDs)] = bson.ObjectIdHex(sC)
+	// Add the RI as email receiver, including the RI of the ancestors of the entities as their rights cascade down the tree
+	tree, err := entityRepo.FindTree()
+	if err != nil {
+		log.Error("Error while retrieving the tree of entities: ", err)
+	}
+	entityIDs := []bson.ObjectId{}
+	for _, e := range append([]string{project.BusinessUnit}, project.ServiceCenter...) {
+		if !bson.IsObjectIdHex(e) {
+			continue
+		}
+		entityIDs = append(entityIDs, bson.ObjectIdHex(e))
+		for _, ancestor := range tree.Ancestors(bson.ObjectIdHex(e)) {
+			entityIDs = append(entityIDs, ancestor.ID)
+		}
 	}
 	riUsers, err := userRepo.FindRIWithEntity(entityIDs)
 	if err != nil {
@@ -176,22 +236,78 @@ func (p *Projects) Delete(c echo.Context) error {
 		log.Error("Error while retrieving the project from database", err)
 	}
 
-	// Deleting the project
-	res, err := database.Projects.Delete(bson.ObjectIdHex(id))
+	// Deleting the project: it's only archived, so that an admin can restore it until it's purged
+	res := bson.ObjectIdHex(id)
+	err = database.Projects.Archive(res, authUser.Username)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, types.NewErr(fmt.Sprintf("Error while removing project: %v", err)))
 	}
+	archivedProject, err := database.Projects.FindByIDBson(res)
+	if err != nil {
+		log.WithError(err).WithField("projectID", id).Error("Error while retrieving the archived project from database")
+	}
+	recordHistory(database, types.DeleteAction, authUser.Username, projectStats, archivedProject)
 
 	// checks if deleted project had a linked Docktor URL.
 	if projectStats.DocktorURL.DocktorGroupURL != "" {
-		sendEmail(projectStats, authUser, userRepo, viper.GetString("name.receiver"), viper.GetString("admin.email"))
+		sendEmail(projectStats, authUser, userRepo, database.Entities, viper.GetString("name.receiver"), viper.GetString("admin.email"))
 	}
 
 	return c.JSON(http.StatusOK, res)
 }
 
-func canAddEntityToProject(entityToSet string, entityFromDB []string, authUser types.User) bool {
-	if authUser.Role == types.AdminRole {
+// GetArchived returns all the archived projects, which can be restored
+func (p *Projects) GetArchived(c echo.Context) error {
+	database := c.Get("database").(*mongo.DadMongo)
+	projects, err := database.Projects.FindArchived()
+	if err != nil {
+		log.WithError(err).Error("Error while retrieving archived projects")
+		return c.JSON(http.StatusInternalServerError, types.NewErr("Error while retrieving archived projects"))
+	}
+	return c.JSON(http.StatusOK, projects)
+}
+
+// Restore makes an archived project visible again
+func (p *Projects) Restore(c echo.Context) error {
+	database := c.Get("database").(*mongo.DadMongo)
+	id := c.Param("id")
+	authUser := c.Get("authuser").(types.User)
+
+	log.WithFields(log.Fields{
+		"username":  authUser.Username,
+		"role":      authUser.Role,
+		"projectID": id,
+	}).Info("User trying to restore a project")
+
+	archivedProject, err := database.Projects.FindByID(id)
+	if err != nil || !archivedProject.Archived {
+		return c.JSON(http.StatusNotFound, types.NewErr(fmt.Sprintf("Archived project not found %v", id)))
+	}
+
+	// The name may have been reused by another project since the deletion
+	existingProject, err := database.Projects.FindByName(archivedProject.Name)
+	if err == nil {
+		return c.JSON(http.StatusConflict, types.NewErr(fmt.Sprintf("Another project already exists with the same name %q", existingProject.Name)))
+	} else if err != mgo.ErrNotFound {
+		return c.JSON(http.StatusInternalServerError, types.NewErr(fmt.Sprintf("Can't check whether the project exist in database: %v", err)))
+	}
+
+	err = database.Projects.Restore(archivedProject.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, types.NewErr(fmt.Sprintf("Error while restoring project: %v", err)))
+	}
+
+	project, err := database.Projects.FindByIDBson(archivedProject.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, types.NewErr(fmt.Sprintf("Failed to get the restored project from database: %v", err)))
+	}
+	recordHistory(database, types.RestoreAction, authUser.Username, archivedProject, project)
+
+	return c.JSON(http.StatusOK, project)
+}
+
+func canAddEntityToProject(tree types.EntityTree, entityToSet string, entityFromDB []string, authUser types.User) bool {
+	if authUser.Can(types.ProjectEntitiesAnyPermission) {
 		return true
 	}
 
@@ -199,41 +315,55 @@ func canAddEntityToProject(entityToSet string, entityFromDB []string, authUser t
 		return false
 	}
 
-	// If the user is a RI, he can only add an entity if:
+	// Otherwise, the user can only add an entity if:
 	// * it's the currently assigned entity of the project
 	for _, eDB := range entityFromDB {
 		if bson.IsObjectIdHex(eDB) && bson.ObjectIdHex(entityToSet) == bson.ObjectIdHex(eDB) {
 			return true
 		}
 	}
-	// * it's one of his own assigned entities
+	// * it's one of his own assigned entities, or one of their descendants
 	for _, allowedEntity := range authUser.Entities {
-		if bson.ObjectIdHex(entityToSet) == allowedEntity {
+		if tree.IsInSubtree(bson.ObjectIdHex(entityToSet), allowedEntity) {
 			return true
 		}
 	}
 	return false
 }
 
-func validateEntity(entityRepo types.EntityRepo, entityToSet, entityFromDB []string, entityType types.EntityType, authUser types.User) (int, string) {
-	if len(entityToSet) > 0 {
-		for _, eS := range entityToSet {
-			// Retrieve entity check if exist
-			entity, err := entityRepo.FindByID(eS)
-			if err == mgo.ErrNotFound {
-				return http.StatusBadRequest, fmt.Sprintf("The %s %s does not exist", entityType, eS)
-			} else if err != nil {
-				return http.StatusInternalServerError, fmt.Sprintf("Failed to retrieve %s %s from database: %v", entityType, eS, err)
-			}
-			// Check type if BU or service center ...
-			if entity.Type != entityType {
-				return http.StatusBadRequest, fmt.Sprintf("The entity %s (%s) is not of type %s but  %s", entity.Name, eS, entityType, entity.Type)
-			}
+func validateEntity(tree types.EntityTree, entityToSet, entityFromDB []string, entityTypes []types.EntityType, authUser types.User) (int, string) {
+	for _, eS := range entityToSet {
+		// Retrieve entity check if exist
+		entity, ok := tree.GetHex(eS)
+		if !ok {
+			return http.StatusBadRequest, fmt.Sprintf("The %s %s does not exist", entityTypes[0], eS)
+		}
+		// Check type if BU or service center ...
+		validType := false
+		for _, entityType := range entityTypes {
+			validType = validType || entity.Type == entityType
+		}
+		if !validType {
+			return http.StatusBadRequest, fmt.Sprintf("The entity %s (%s) is not of type %v but  %s", entity.Name, eS, entityTypes, entity.Type)
+		}
 
-			// Check if you have the access to change the entity
-			if !canAddEntityToProject(eS, entityFromDB, authUser) {
-				return http.StatusBadRequest, fmt.Sprintf("You can't add the entity %s to a project", eS)
-			}
+		// Check if you have the access to change the entity
+		if !canAddEntityToProject(tree, eS, entityFromDB, authUser) {
+			return http.StatusBadRequest, fmt.Sprintf("You can't add the entity %s to a project", eS)
+		}
+	}
+	return http.StatusOK, ""
+}
+
+// validateBusinessUnitLink checks that the service centers of the project belong to its business unit, when they are inside a business unit
+func validateBusinessUnitLink(tree types.EntityTree, project types.Project) (int, string) {
+	if project.BusinessUnit == "" {
+		return http.StatusOK, ""
+	}
+	for _, sC := range project.ServiceCenter {
+		businessUnit, ok := tree.Ancestor(bson.ObjectIdHex(sC), types.BusinessUnitType)
+		if ok && businessUnit.ID.Hex() != project.BusinessUnit {
+			return http.StatusBadRequest, fmt.Sprintf("The service center %s belongs to the business unit %s, not to the business unit of the project", tree.Path(bson.ObjectIdHex(sC)), businessUnit.Name)
 		}
 	}
 	return http.StatusOK, ""
@@ -244,19 +374,31 @@ func validateEntities(entityRepo types.EntityRepo, projectToSave, projectFromDB
 		return http.StatusBadRequest, "At least one of the business unit and service center fields is mandatory"
 	}
 
+	tree, err := entityRepo.FindTree()
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Sprintf("Failed to retrieve the entities from database: %v", err)
+	}
+
 	// Check if BusinessUnit is set because if projectToSave.BusinessUnit is nil, len([]string{projectToSave.BusinessUnit}) will return 1
 	if projectToSave.BusinessUnit != "" {
 		// If a business unit is provided, check it exists in the entity collection
-		if statusCode, errMessage := validateEntity(entityRepo, []string{projectToSave.BusinessUnit}, []string{projectFromDB.BusinessUnit}, types.BusinessUnitType, authUser); errMessage != "" {
+		if statusCode, errMessage := validateEntity(tree, []string{projectToSave.BusinessUnit}, []string{projectFromDB.BusinessUnit}, []types.EntityType{types.BusinessUnitType}, authUser); errMessage != "" {
 			return statusCode, errMessage
 		}
 	}
 
-	// If a service center is provided, check it exists in the entity collection
-	if statusCode, errMessage := validateEntity(entityRepo, projectToSave.ServiceCenter, projectFromDB.ServiceCenter, types.ServiceCenterType, authUser); errMessage != "" {
+	// If a service center is provided, check it exists in the entity collection. Teams can be used as service centers too
+	if statusCode, errMessage := validateEntity(tree, projectToSave.ServiceCenter, projectFromDB.ServiceCenter, []types.EntityType{types.ServiceCenterType, types.TeamType}, authUser); errMessage != "" {
 		return statusCode, errMessage
 	}
 
+	// Projects saved before entities were organized as a tree are only checked when their entities change
+	if projectToSave.BusinessUnit != projectFromDB.BusinessUnit || !reflect.DeepEqual(projectToSave.ServiceCenter, projectFromDB.ServiceCenter) {
+		if statusCode, errMessage := validateBusinessUnitLink(tree, projectToSave); errMessage != "" {
+			return statusCode, errMessage
+		}
+	}
+
 	return http.StatusOK, ""
 }
 
@@ -303,42 +445,33 @@ func (p *Projects) Save(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, types.NewErr(fmt.Sprintf("Posted project is not valid: %v", err)))
 	}
 
+	// The project should be saved from its latest version, otherwise modifications of someone else would be overwritten
+	if id != "" && projectToSave.Version != projectFromDB.Version {
+		return p.conflict(c, projectFromDB)
+	}
+
 	saveProjectData, httpStatus, err := p.createProjectToSave(database, id, projectToSave, authUser, projectFromDB)
 	if err != nil {
 		return c.JSON(httpStatus, types.NewErr(fmt.Sprintf("Error while creating the new project to save: %v", err.Error())))
 	}
 
 	projectSaved, err := database.Projects.Save(saveProjectData.projectToSave)
-	if err != nil {
+	if err == types.ErrProjectVersionConflict {
+		currentProject, errFind := database.Projects.FindByID(id)
+		if errFind != nil {
+			return c.JSON(http.StatusInternalServerError, types.NewErr(fmt.Sprintf("Failed to get the current project from database: %v", errFind)))
+		}
+		return p.conflict(c, currentProject)
+	} else if err != nil {
 		return c.JSON(http.StatusInternalServerError, types.NewErr(fmt.Sprintf("Failed to save project to database: %v", err)))
 	}
+	if id == "" {
+		recordHistory(database, types.CreateAction, authUser.Username, types.Project{}, projectSaved)
+	} else {
+		recordHistory(database, types.UpdateAction, authUser.Username, projectFromDB, projectSaved)
+	}
 	if projectSaved.DocktorGroupURL != "" && saveProjectData.existingProject.DocktorGroupURL != projectSaved.DocktorGroupURL {
-		// Updates Docktor group name from url in background, when url changed
-		// because we don't want to block the project update with calls to external APIs.
-		go func() {
-			logFields := log.Fields{
-				"dad.project.id":                       projectSaved.ID,
-				"dad.project.name":                     projectSaved.Name,
-				"dad.project.docktorGroupURL":          projectSaved.DocktorGroupURL,
-				"dad.project.previousDocktorGroupName": projectSaved.DocktorGroupName,
-			}
-			log.WithFields(logFields).Debug("Updating DocktorGroupURL and Name to DAD project...")
-
-			// Open new Mongo session because function is called in a goroutine
-			database, err := mongo.Get()
-			if err != nil {
-				log.WithField("database", database).WithError(err).Error("Unable to open a connection to the database")
-				return
-			}
-			defer database.Session.Close()
-
-			err = p.updateDocktorGroupName(database, projectSaved.ID, projectSaved.DocktorGroupURL)
-			if err != nil {
-				log.WithFields(logFields).WithError(err).Error("Unable to fetch and/or save Docktor Group Name to the project")
-			} else {
-				log.WithFields(logFields).Debug("Saved DocktorGroupURL and Name to DAD project")
-			}
-		}()
+		p.updateDocktorGroupNameInBackground(projectSaved)
 	}
 
 	log.WithFields(log.Fields{
@@ -349,6 +482,18 @@ func (p *Projects) Save(c echo.Context) error {
 	return c.JSON(http.StatusOK, projectSaved)
 }
 
+// conflict returns a 409 Conflict response with the current version of the project in database
+func (p *Projects) conflict(c echo.Context, currentProject types.Project) error {
+	log.WithFields(log.Fields{
+		"projectID": currentProject.ID,
+		"version":   currentProject.Version,
+	}).Warn("Project has been modified by someone else in the meantime")
+	return c.JSON(http.StatusConflict, ProjectConflict{
+		ErrorMsg: types.NewErr(types.ErrProjectVersionConflict.Error()),
+		Project:  currentProject,
+	})
+}
+
 func (p *Projects) createProjectToSave(database *mongo.DadMongo, id string, projectToSave types.Project, authUser types.User, projectFromDB types.Project) (SaveProjectData, int, error) {
 	log.WithField("project", projectToSave).Info("Received project to save")
 
@@ -357,17 +502,22 @@ func (p *Projects) createProjectToSave(database *mongo.DadMongo, id string, proj
 	}
 
 	// Not possible to create or update a project with a name already used by another one project
-	existingProject, err := database.Projects.FindByName(projectToSave.Name)
+	projectID := projectToSave.ID
+	if id != "" {
+		projectID = bson.ObjectIdHex(id)
+	}
+	projectWithName, err := database.Projects.FindByName(projectToSave.Name)
 	if err != nil {
 		if err != mgo.ErrNotFound {
 			return SaveProjectData{}, http.StatusInternalServerError, fmt.Errorf("Can't check whether the project exist in database: %v", err)
 		}
-	} else if existingProject.ID != projectToSave.ID {
-		return SaveProjectData{}, http.StatusBadRequest, fmt.Errorf("Another project already exists with the same name %q", existingProject.Name)
+	} else if projectWithName.ID != projectID {
+		return SaveProjectData{}, http.StatusBadRequest, fmt.Errorf("Another project already exists with the same name %q", projectWithName.Name)
 	}
 
-	// check if id is valid (for project creation)
-	if projectToSave.ID.Valid() {
+	// The project is compared to its version in database, which is the one of the URL when the project is updated
+	existingProject := projectFromDB
+	if id == "" && projectToSave.ID.Valid() {
 		existingProject, err = database.Projects.FindByIDBson(projectToSave.ID)
 		if err != nil {
 			if err != mgo.ErrNotFound {
@@ -383,8 +533,8 @@ func (p *Projects) createProjectToSave(database *mongo.DadMongo, id string, proj
 		projectToSave.BusinessUnit != existingProject.BusinessUnit ||
 		projectToSave.DocktorGroupURL != existingProject.DocktorGroupURL
 
-	// A Project Manager or Deputy can't update details, if any of the details has changed it's an issue and we shouldn't update the project
-	if (authUser.Role == types.PMRole || authUser.Role == types.DeputyRole) && modifiedDetails {
+	// Without the permission to edit details, if any of the details has changed it's an issue and we shouldn't update the project
+	if modifiedDetails && !authUser.Can(types.ProjectDetailsEditPermission) {
 
 		log.WithFields(log.Fields{
 			"username":                        authUser.Username,
@@ -403,10 +553,23 @@ func (p *Projects) createProjectToSave(database *mongo.DadMongo, id string, proj
 			"projectToSave.DocktorGroupURL":   projectToSave.DocktorGroupURL,
 			"existingProject.DocktorGroupURL": existingProject.DocktorGroupURL,
 		}).Warn("User isn't allowed to update the project")
-		return SaveProjectData{}, http.StatusBadRequest, errors.New("Project managers and deputies are not allowed to update project details")
-	} else if authUser.Role == types.RIRole {
-		if projectToSave.Mode != existingProject.Mode {
-			return SaveProjectData{}, http.StatusBadRequest, errors.New("RIs are not allowed to update deployment mode")
+		return SaveProjectData{}, http.StatusBadRequest, fmt.Errorf("User %s is not allowed to update project details", authUser.Username)
+	} else if projectToSave.Mode != existingProject.Mode && !authUser.Can(types.ProjectModeEditPermission) {
+		return SaveProjectData{}, http.StatusBadRequest, fmt.Errorf("User %s is not allowed to update deployment mode", authUser.Username)
+	}
+
+	// The matrix follows the same rules as its updates through patches
+	if !reflect.DeepEqual(projectToSave.Matrix, existingProject.Matrix) {
+		services, err := database.FunctionalServices.FindAll()
+		if err != nil {
+			return SaveProjectData{}, http.StatusInternalServerError, fmt.Errorf("Can't retrieve the functional services: %v", err)
+		}
+		servicesByID := map[bson.ObjectId]types.FunctionalService{}
+		for _, service := range services {
+			servicesByID[service.ID] = service
+		}
+		if err := checkMatrixRights(authUser, existingProject.Matrix, projectToSave.Matrix, servicesByID); err != nil {
+			return SaveProjectData{}, http.StatusForbidden, err
 		}
 	}
 
@@ -416,6 +579,14 @@ func (p *Projects) createProjectToSave(database *mongo.DadMongo, id string, proj
 		return SaveProjectData{}, httpStatusCode, errors.New(errorMessage)
 	}
 
+	// Members are managed apart from the project, so that contributors can't give themselves more rights
+	projectToSave.Members = existingProject.Members
+
+	// Projects can only be archived through deletion
+	projectToSave.Archived = false
+	projectToSave.ArchivedBy = ""
+	projectToSave.ArchivedAt = nil
+
 	// Fill ID, Created and Updated fields
 	projectToSave.Updated = time.Now()
 	if id != "" {
@@ -434,46 +605,80 @@ func (p *Projects) createProjectToSave(database *mongo.DadMongo, id string, proj
 	return NewSaveProjectData(existingProject, projectToSave), 0, nil
 }
 
-// updateDocktorGroupName updates the Docktor Group Name in saved project
+// updateDocktorGroupNameInBackground updates Docktor group name from url in background,
+// because we don't want to block the project update with calls to external APIs.
+func (p *Projects) updateDocktorGroupNameInBackground(projectSaved types.Project) {
+	go func() {
+		logFields := log.Fields{
+			"dad.project.id":                       projectSaved.ID,
+			"dad.project.name":                     projectSaved.Name,
+			"dad.project.docktorGroupURL":          projectSaved.DocktorGroupURL,
+			"dad.project.previousDocktorGroupName": projectSaved.DocktorGroupName,
+		}
+		log.WithFields(logFields).Debug("Updating DocktorGroupURL and Name to DAD project...")
+
+		// Open new Mongo session because function is called in a goroutine
+		database, err := mongo.Get()
+		if err != nil {
+			log.WithField("database", database).WithError(err).Error("Unable to open a connection to the database")
+			return
+		}
+		defer database.Session.Close()
+
+		groupName, err := p.updateDocktorGroupName(database, projectSaved.ID, projectSaved.DocktorGroupURL)
+		if err != nil {
+			log.WithFields(logFields).WithError(err).Error("Unable to fetch and/or save Docktor Group Name to the project")
+			return
+		}
+		renamed := projectSaved
+		renamed.DocktorGroupName = groupName
+		recordHistory(database, types.UpdateAction, types.DocktorGroupsAuthor, projectSaved, renamed)
+		log.WithFields(logFields).Debug("Saved DocktorGroupURL and Name to DAD project")
+	}()
+}
+
+// updateDocktorGroupName updates the Docktor Group Name in saved project, and returns it
 // It gets the Group name from Docktor Group URL by fetching Docktor API directly
-func (p *Projects) updateDocktorGroupName(database *mongo.DadMongo, idProject bson.ObjectId, docktorGroupURL string) error {
+func (p *Projects) updateDocktorGroupName(database *mongo.DadMongo, idProject bson.ObjectId, docktorGroupURL string) (string, error) {
 
 	// Call Docktor API to get the real name of the group
-	docktorAPI, err := docktor.NewExternalAPI(
-		viper.GetString("docktor.addr"),
-		viper.GetString("docktor.user"),
-		viper.GetString("docktor.password"),
-	)
+	docktorAPI, err := docktor.Get()
 	if err != nil {
-		return err
+		return "", err
 	}
 	// Parse Docktor URL to get the Docktor group ID
 	idDocktorGroup, err := docktorAPI.GetGroupIDFromURL(docktorGroupURL)
 	if err != nil {
-		return err
+		return "", err
 	}
-	group, err := docktorAPI.GetGroup(idDocktorGroup)
+	group, err := docktorAPI.GetGroup(context.Background(), idDocktorGroup)
 	if err != nil {
-		return err
+		return "", err
 	}
 	// Update project in database
 	err = database.Projects.UpdateDocktorGroupURL(idProject, docktorGroupURL, group.Title)
 	if err != nil {
-		return fmt.Errorf("Unable to update project in Mongo database because: %v", err.Error())
+		return "", fmt.Errorf("Unable to update project in Mongo database because: %v", err.Error())
 	}
 
-	return nil
+	return group.Title, nil
 }
 
-// UpdateDocktorInfo updates docktor info of a specific project
+// UpdateDocktorInfo updates docktor info of a specific project, and records the change in its history
 func (p *Projects) UpdateDocktorInfo(c echo.Context) error {
 	database := c.Get("database").(*mongo.DadMongo)
+	authUser := c.Get("authuser").(types.User)
 	id := c.Param("id")
 
+	projectFromDB, err := database.Projects.FindByID(id)
+	if err != nil || projectFromDB.ID.Hex() == "" {
+		return c.JSON(http.StatusNotFound, types.NewErr(fmt.Sprintf("Project not found %v", id)))
+	}
+
 	// Get project from body
 	var projectToSave types.Project
 
-	err := c.Bind(&projectToSave)
+	err = c.Bind(&projectToSave)
 	if err != nil {
 		return c.JSON(http.StatusBadRequest, types.NewErr(fmt.Sprintf("Posted project is not valid: %v", err)))
 	}
@@ -483,7 +688,7 @@ func (p *Projects) UpdateDocktorInfo(c echo.Context) error {
 		"docktorGroupURL": projectToSave.DocktorGroupURL,
 	}).Debug("Updating Docktor Group for given project...")
 
-	err = p.updateDocktorGroupName(database, bson.ObjectIdHex(id), projectToSave.DocktorGroupURL)
+	_, err = p.updateDocktorGroupName(database, bson.ObjectIdHex(id), projectToSave.DocktorGroupURL)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, types.NewErr(fmt.Sprintf("Failed to update docktor info to database: %v", err)))
 	}
@@ -492,6 +697,7 @@ func (p *Projects) UpdateDocktorInfo(c echo.Context) error {
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, types.NewErr(fmt.Sprintf("Failed to get the updated project to database: %v", err)))
 	}
+	recordHistory(database, types.UpdateAction, authUser.Username, projectFromDB, project)
 
 	log.WithFields(log.Fields{
 		"id":                       id,
@@ -0,0 +1,38 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/soprasteria/dad/server/types"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestProjectConflict(t *testing.T) {
+
+	Convey("Given a project modified by someone else in the meantime", t, func() {
+		current := types.Project{ID: bson.NewObjectId(), Name: "DAD", Version: 4}
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(httptest.NewRequest(http.MethodPut, "/api/projects/"+current.ID.Hex(), nil), rec)
+
+		Convey("When the conflict is returned", func() {
+			err := (&Projects{}).conflict(c, current)
+
+			Convey("Then the response is a 409 with the current version of the project", func() {
+				So(err, ShouldBeNil)
+				So(rec.Code, ShouldEqual, http.StatusConflict)
+				body := struct {
+					Message string        `json:"message"`
+					Project types.Project `json:"project"`
+				}{}
+				So(json.Unmarshal(rec.Body.Bytes(), &body), ShouldBeNil)
+				So(body.Message, ShouldEqual, types.ErrProjectVersionConflict.Error())
+				So(body.Project.Version, ShouldEqual, 4)
+			})
+		})
+	})
+}
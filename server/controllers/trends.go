@@ -0,0 +1,86 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/labstack/echo"
+	"github.com/soprasteria/dad/server/mongo"
+	"github.com/soprasteria/dad/server/types"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// trendDateFormat is the expected format of the dates given as query params of trends APIs
+const trendDateFormat = "2006-01-02"
+
+// Trends is the controller type
+type Trends struct {
+}
+
+// parseTrendQuery reads the query params shared by trends APIs: service, from and to
+func parseTrendQuery(c echo.Context) (bson.ObjectId, types.SnapshotQuery, error) {
+	query := types.SnapshotQuery{}
+
+	var service bson.ObjectId
+	if serviceID := c.QueryParam("service"); serviceID != "" {
+		if !bson.IsObjectIdHex(serviceID) {
+			return "", query, fmt.Errorf("Service %q is not a valid ID", serviceID)
+		}
+		service = bson.ObjectIdHex(serviceID)
+	}
+
+	var err error
+	if from := c.QueryParam("from"); from != "" {
+		if query.From, err = time.Parse(trendDateFormat, from); err != nil {
+			return "", query, fmt.Errorf("From date %q is not valid. Expected format is %s", from, trendDateFormat)
+		}
+	}
+	if to := c.QueryParam("to"); to != "" {
+		if query.To, err = time.Parse(trendDateFormat, to); err != nil {
+			return "", query, fmt.Errorf("To date %q is not valid. Expected format is %s", to, trendDateFormat)
+		}
+		// Include the whole day
+		query.To = query.To.Add(24*time.Hour - time.Nanosecond)
+	}
+
+	return service, query, nil
+}
+
+// GetAll returns the maturity trend aggregated over all the projects visible by the user, optionally filtered by entity and functional service
+func (t *Trends) GetAll(c echo.Context) error {
+	database := c.Get("database").(*mongo.DadMongo)
+	authUser := c.Get("authuser").(types.User)
+
+	service, query, err := parseTrendQuery(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, types.NewErr(err.Error()))
+	}
+
+	query.Entity = c.QueryParam("entity")
+	if query.Entity != "" && !bson.IsObjectIdHex(query.Entity) {
+		return c.JSON(http.StatusBadRequest, types.NewErr(fmt.Sprintf("Entity %q is not a valid ID", query.Entity)))
+	}
+
+	// Some users can see the trend of every project, including deleted ones
+	if !authUser.Can(types.TrendsAllPermission) {
+		projects, err := database.Projects.FindForUser(authUser)
+		if err != nil {
+			log.WithError(err).Error("Error while retrieving projects")
+			return c.JSON(http.StatusInternalServerError, types.NewErr("Error while retrieving projects"))
+		}
+		query.Projects = []bson.ObjectId{}
+		for _, project := range projects {
+			query.Projects = append(query.Projects, project.ID)
+		}
+	}
+
+	snapshots, err := database.ProjectSnapshots.Find(query)
+	if err != nil {
+		log.WithError(err).Error("Error while retrieving snapshots")
+		return c.JSON(http.StatusInternalServerError, types.NewErr("Error while retrieving snapshots"))
+	}
+
+	return c.JSON(http.StatusOK, types.ComputeTrend(snapshots, service))
+}
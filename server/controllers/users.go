@@ -4,9 +4,11 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"time"
 
 	"gopkg.in/mgo.v2/bson"
 
+	log "github.com/Sirupsen/logrus"
 	"github.com/dgrijalva/jwt-go"
 	"github.com/labstack/echo"
 	"github.com/soprasteria/dad/server/auth"
@@ -34,17 +36,126 @@ func (u *Users) Get(c echo.Context) error {
 	return c.JSON(http.StatusOK, user)
 }
 
-//Delete user from database
-func (u *Users) Delete(c echo.Context) error {
+// Deactivate disables a user instead of deleting it, so that projects keep referencing him
+// The user can't log in anymore, and his sessions and API tokens are revoked
+func (u *Users) Deactivate(c echo.Context) error {
 	database := c.Get("database").(*mongo.DadMongo)
-	id := c.Param("id")
+	authUser := c.Get("authuser").(types.User)
+	user := c.Get("user").(types.User)
 
-	res, err := database.Users.Delete(bson.ObjectIdHex(id))
+	if user.ID == authUser.ID {
+		return c.JSON(http.StatusBadRequest, types.NewErr("You can't deactivate yourself"))
+	}
+
+	user.Disabled = true
+	user.DisabledReason = types.DisabledByAdmin
+	userSaved, err := database.Users.Save(user)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, types.NewErr(fmt.Sprintf("Error while removing user: %v", err)))
+		return c.JSON(http.StatusInternalServerError, types.NewErr(fmt.Sprintf("Error while deactivating user: %v", err)))
+	}
+
+	// Sessions and API tokens of the deactivated user must not be used anymore
+	login := newAuthAPI(c)
+	if err = login.RevokeUser(user.Username); err != nil {
+		return c.JSON(http.StatusInternalServerError, types.NewErr(fmt.Sprintf("User deactivated, but its sessions can't be revoked: %v", err)))
+	}
+	if err = database.APITokens.DeleteByUsername(user.Username); err != nil {
+		return c.JSON(http.StatusInternalServerError, types.NewErr(fmt.Sprintf("User deactivated, but its API tokens can't be revoked: %v", err)))
 	}
+	log.WithField("username", user.Username).WithField("admin", authUser.Username).Info("User deactivated")
+
+	return c.JSON(http.StatusOK, userSaved)
+}
+
+// Activate enables a deactivated user, who can log in again
+func (u *Users) Activate(c echo.Context) error {
+	database := c.Get("database").(*mongo.DadMongo)
+	authUser := c.Get("authuser").(types.User)
+	user := c.Get("user").(types.User)
+
+	user.Disabled = false
+	user.DisabledReason = ""
+	userSaved, err := database.Users.Save(user)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, types.NewErr(fmt.Sprintf("Error while activating user: %v", err)))
+	}
+	log.WithField("username", user.Username).WithField("admin", authUser.Username).Info("User activated")
+
+	return c.JSON(http.StatusOK, userSaved)
+}
 
-	return c.JSON(http.StatusOK, res)
+// reassignQuery is the user who takes over the duties of another user
+type reassignQuery struct {
+	To string `json:"to"` // ID of the user
+}
+
+// Reassign moves the project manager, deputy and member duties of a user to another user, on all his projects
+// A project is skipped when an error occurred, without preventing other projects from being reassigned
+func (u *Users) Reassign(c echo.Context) error {
+	database := c.Get("database").(*mongo.DadMongo)
+	authUser := c.Get("authuser").(types.User)
+	user := c.Get("user").(types.User)
+
+	var query reassignQuery
+	err := c.Bind(&query)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, types.NewErr(fmt.Sprintf("Posted reassignment is not valid: %v", err)))
+	}
+	target, err := database.Users.FindByID(query.To)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, types.NewErr(fmt.Sprintf("User %v to reassign the projects to does not exist", query.To)))
+	}
+	if target.ID == user.ID {
+		return c.JSON(http.StatusBadRequest, types.NewErr("Projects can't be reassigned to the same user"))
+	}
+	if target.Disabled {
+		return c.JSON(http.StatusBadRequest, types.NewErr(fmt.Sprintf("Projects can't be reassigned to the deactivated user %s", target.Username)))
+	}
+
+	log.WithFields(log.Fields{
+		"username": authUser.Username,
+		"from":     user.Username,
+		"to":       target.Username,
+	}).Info("User trying to reassign projects")
+
+	projects, err := database.Projects.FindByMember(user.ID, types.ProjectRoles...)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, types.NewErr(fmt.Sprintf("Error while retrieving the projects of the user %s", user.Username)))
+	}
+
+	results := types.BulkReassignResults{All: len(projects), Errors: []types.ProjectInError{}}
+	for i, project := range projects {
+		projectToSave, _ := project.ReplaceUser(user.ID.Hex(), target.ID.Hex())
+		projectToSave.Updated = time.Now()
+		projectSaved, err := database.Projects.Save(projectToSave)
+		if err != nil {
+			results.Errors = append(results.Errors, types.ProjectInError{
+				Project: project.ID,
+				Message: fmt.Sprintf("Failed to reassign project %s: %v", project.Name, err),
+				Index:   i,
+			})
+			continue
+		}
+		recordHistory(database, types.UpdateAction, authUser.Username, project, projectSaved)
+		results.Updated++
+	}
+	results.InError = len(results.Errors)
+
+	return c.JSON(http.StatusOK, results)
+}
+
+// GetDanglingReferences returns the references of projects, as project manager, deputy or member, to missing or inactive users
+func (u *Users) GetDanglingReferences(c echo.Context) error {
+	database := c.Get("database").(*mongo.DadMongo)
+	projects, err := database.Projects.FindAll()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, types.NewErr("Error while retrieving projects"))
+	}
+	users, err := database.Users.FindAll()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, types.NewErr("Error while retreiving all users"))
+	}
+	return c.JSON(http.StatusOK, types.FindDanglingReferences(projects, users))
 }
 
 // Update updates existing user, given its id
@@ -85,12 +196,12 @@ func (u *Users) updateUserFields(database *mongo.DadMongo, userUpdated types.Use
 	if err != nil || userFromDB.GetID().Hex() == "" {
 		return types.User{}, errors.New("User does not exist. Please register user first")
 	}
-	if connectedUser.IsAdmin() && userUpdated.Role.IsValid() {
+	if connectedUser.Can(types.UsersManagePermission) && userUpdated.Role.IsValid() {
 		userFromDB.Role = userUpdated.Role
 	}
 	// Updates entities, but only keep existing ones
 	// When error occurs, just keep previous ones
-	if connectedUser.IsAdmin() {
+	if connectedUser.Can(types.UsersManagePermission) {
 		// PMs or Deputies cannot have entities
 		if userFromDB.IsPMOrDeputy() {
 			userFromDB.Entities = []bson.ObjectId{}
@@ -116,3 +227,60 @@ func (u *Users) Profile(c echo.Context) error {
 	}
 	return c.JSON(http.StatusOK, user)
 }
+
+// CreateLocal creates a local user, whose password is stored in DAD
+// The user receives an email to choose his password
+func (u *Users) CreateLocal(c echo.Context) error {
+	database := c.Get("database").(*mongo.DadMongo)
+
+	var user types.User
+	err := c.Bind(&user)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, types.NewErr(fmt.Sprintf("Posted user is not valid: %v", err)))
+	}
+	if user.Username == "" || user.Email == "" {
+		return c.JSON(http.StatusBadRequest, types.NewErr("Username and email should not be empty"))
+	}
+	if user.Role == "" {
+		user.Role = types.DefaultRole()
+	} else if !user.HasValidRole() {
+		return c.JSON(http.StatusBadRequest, types.NewErr(fmt.Sprintf("Role %q is not valid", user.Role)))
+	}
+	if _, err = database.Users.FindByUsername(user.Username); err == nil {
+		return c.JSON(http.StatusConflict, types.NewErr(auth.ErrUsernameAlreadyTaken.Error()))
+	}
+
+	user.ID = ""
+	user.Provider = types.LocalProvider
+	user.Password = ""
+	user.DisplayName = user.FirstName + " " + user.LastName
+	user.Created = time.Now()
+	userSaved, err := database.Users.Save(user)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, types.NewErr(fmt.Sprintf("Failed to save user to database : %v", err)))
+	}
+
+	if err = sendResetPasswordEmail(database, userSaved); err != nil {
+		return c.JSON(http.StatusInternalServerError, types.NewErr(fmt.Sprintf("User created, but the email to choose the password can't be sent : %v", err)))
+	}
+	return c.JSON(http.StatusOK, userSaved)
+}
+
+// Logout logs out the user from all its sessions
+// Its refresh tokens are deleted, and the access tokens it already got are rejected
+func (u *Users) Logout(c echo.Context) error {
+	user := c.Get("user").(types.User)
+	login := newAuthAPI(c)
+	err := login.RevokeUser(user.Username)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, types.NewErr(fmt.Sprintf("Error while revoking sessions of user: %v", err)))
+	}
+	log.WithField("username", user.Username).WithField("admin", c.Get("authuser").(types.User).Username).Info("User logged out by an admin")
+	return c.NoContent(http.StatusOK)
+}
+
+// Permissions returns the permissions of the connected user, so that the UI only shows what he's allowed to do
+func (u *Users) Permissions(c echo.Context) error {
+	authUser := c.Get("authuser").(types.User)
+	return c.JSON(http.StatusOK, authUser.Permissions())
+}
@@ -2,58 +2,165 @@ package docktor
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/spf13/viper"
 )
 
-const timeout = time.Duration(15 * time.Second)
+const (
+	defaultTimeout          = 15 * time.Second
+	defaultRetryDelay       = 500 * time.Millisecond
+	defaultBreakerThreshold = 5
+	defaultBreakerCooldown  = time.Minute
+	// maxRetryDelay caps the exponential backoff between retries
+	maxRetryDelay = 10 * time.Second
+)
+
+// Conf is the configuration of the Docktor API client
+type Conf struct {
+	Address          string
+	Username         string
+	Password         string
+	Timeout          time.Duration // Timeout of each HTTP request
+	Retries          int           // Number of retries of a request failing with a transient error
+	RetryDelay       time.Duration // Delay before the first retry, doubled at each retry
+	RateLimit        float64       // Maximum number of requests per second, unlimited when 0
+	BreakerThreshold int           // Number of consecutive failures suspending the requests
+	BreakerCooldown  time.Duration // Delay during which requests are suspended, before trying again
+}
+
+// NewConf reads the configuration of the Docktor API client
+func NewConf() Conf {
+	return Conf{
+		Address:          viper.GetString("docktor.addr"),
+		Username:         viper.GetString("docktor.user"),
+		Password:         viper.GetString("docktor.password"),
+		Timeout:          viper.GetDuration("docktor.timeout"),
+		Retries:          viper.GetInt("docktor.retries"),
+		RetryDelay:       viper.GetDuration("docktor.retryDelay"),
+		RateLimit:        viper.GetFloat64("docktor.rateLimit"),
+		BreakerThreshold: viper.GetInt("docktor.breakerThreshold"),
+		BreakerCooldown:  viper.GetDuration("docktor.breakerCooldown"),
+	}
+}
 
 // ExternalAPI exposes methods to query the Docktor API
+// The session is shared by all requests and renewed when it expires, so the same ExternalAPI should be reused
 type ExternalAPI struct {
-	address  string
-	username string
-	password string
+	conf    Conf
+	client  *http.Client
+	limiter *rateLimiter
+	breaker *circuitBreaker
+
+	mu      sync.Mutex
+	cookies []*http.Cookie // Cookies of the current session, nil when not authenticated yet
 }
 
 // NewExternalAPI create a new ExternalAPI from authentication information
-func NewExternalAPI(address, username, password string) (ExternalAPI, error) {
-	if address == "" {
-		return ExternalAPI{}, fmt.Errorf("Docktor address is empty")
-	} else if username == "" {
-		return ExternalAPI{}, fmt.Errorf("Docktor username is empty")
-	} else if password == "" {
-		return ExternalAPI{}, fmt.Errorf("Docktor password is empty")
-	}
-
-	return ExternalAPI{
-		address:  address,
-		username: username,
-		password: password,
+func NewExternalAPI(conf Conf) (*ExternalAPI, error) {
+	if conf.Address == "" {
+		return nil, fmt.Errorf("Docktor address is empty")
+	} else if conf.Username == "" {
+		return nil, fmt.Errorf("Docktor username is empty")
+	} else if conf.Password == "" {
+		return nil, fmt.Errorf("Docktor password is empty")
+	}
+	if _, err := url.ParseRequestURI(conf.Address); err != nil {
+		return nil, fmt.Errorf("Failed to parse Docktor URL: %v", err.Error())
+	}
+
+	if conf.Timeout <= 0 {
+		conf.Timeout = defaultTimeout
+	}
+	if conf.Retries < 0 {
+		conf.Retries = 0
+	}
+	if conf.RetryDelay <= 0 {
+		conf.RetryDelay = defaultRetryDelay
+	}
+	if conf.BreakerThreshold <= 0 {
+		conf.BreakerThreshold = defaultBreakerThreshold
+	}
+	if conf.BreakerCooldown <= 0 {
+		conf.BreakerCooldown = defaultBreakerCooldown
+	}
+
+	return &ExternalAPI{
+		conf:    conf,
+		client:  &http.Client{Timeout: conf.Timeout},
+		limiter: newRateLimiter(conf.RateLimit),
+		breaker: &circuitBreaker{threshold: conf.BreakerThreshold, cooldown: conf.BreakerCooldown},
 	}, nil
 }
 
-// authenticate authenticates user to Docktor through its login API
-// It updates self object with the generated JWT Token, used to authenticate through all protected API routes
-func (api *ExternalAPI) authenticate() ([]*http.Cookie, error) {
-	data := url.Values{}
-	data.Set("username", api.username)
-	data.Set("password", api.password)
+// shared is the Docktor API client of the process, created on first use
+var shared struct {
+	sync.Mutex
+	api *ExternalAPI
+}
+
+// Get returns the Docktor API client shared by the whole process, created from the configuration on first use
+// Its session, rate limit and circuit breaker are common to all the requests to Docktor, whether they come from jobs or from users
+func Get() (*ExternalAPI, error) {
+	shared.Lock()
+	defer shared.Unlock()
+	if shared.api == nil {
+		api, err := NewExternalAPI(NewConf())
+		if err != nil {
+			return nil, err
+		}
+		shared.api = api
+	}
+	return shared.api, nil
+}
 
-	u, err := url.ParseRequestURI(api.address)
+// url returns the URL of a Docktor API route
+func (api *ExternalAPI) url(path string) string {
+	u, _ := url.ParseRequestURI(api.conf.Address)
+	u.Path = path
+	return u.String()
+}
+
+// send sends a request to Docktor, once the rate limit allows it
+// Network errors and server errors are transient, as Docktor may be restarting or overloaded
+func (api *ExternalAPI) send(ctx context.Context, req *http.Request) (*http.Response, error) {
+	if err := api.limiter.wait(ctx); err != nil {
+		return nil, err
+	}
+
+	resp, err := api.client.Do(req.WithContext(ctx))
 	if err != nil {
-		return nil, fmt.Errorf("Failed to parse Docktor URL: %v", err.Error())
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, transientError{err}
+	}
+	if resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusTooManyRequests {
+		resp.Body.Close()
+		return nil, transientError{fmt.Errorf("Docktor server did not return OK: %v", resp.Status)}
 	}
-	u.Path = "/auth/signin"
+	return resp, nil
+}
 
-	client := &http.Client{Timeout: timeout}
-	req, err := http.NewRequest("POST", u.String(), bytes.NewBufferString(data.Encode()))
+// authenticate authenticates user to Docktor through its login API
+// It returns the cookies of the session, used to authenticate through all protected API routes
+func (api *ExternalAPI) authenticate(ctx context.Context) ([]*http.Cookie, error) {
+	data := url.Values{}
+	data.Set("username", api.conf.Username)
+	data.Set("password", api.conf.Password)
+
+	req, err := http.NewRequest("POST", api.url("/auth/signin"), bytes.NewBufferString(data.Encode()))
 	if err != nil {
 		return nil, fmt.Errorf("Failed to initiate HTTP request: %v", err.Error())
 	}
@@ -62,24 +169,24 @@ func (api *ExternalAPI) authenticate() ([]*http.Cookie, error) {
 	req.Header.Add("Content-Length", strconv.Itoa(len(data.Encode())))
 
 	log.WithFields(log.Fields{
-		"address":  api.address,
-		"username": api.username,
+		"address":  api.conf.Address,
+		"username": api.conf.Username,
 	}).Debug("Authenticating to Docktor")
 
-	resp, err := client.Do(req)
+	resp, err := api.send(ctx, req)
 	if err != nil {
 		log.WithFields(log.Fields{
-			"address":  api.address,
-			"username": api.username,
+			"address":  api.conf.Address,
+			"username": api.conf.Username,
 		}).WithError(err).Error("Failed to authenticate to Docktor")
-		return nil, fmt.Errorf("Failed to authenticate to Docktor: %v", err.Error())
+		return nil, wrap(err, "Failed to authenticate to Docktor")
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		log.WithFields(log.Fields{
-			"address":    api.address,
-			"username":   api.username,
+			"address":    api.conf.Address,
+			"username":   api.conf.Username,
 			"statusCode": resp.StatusCode,
 			"status":     resp.Status,
 		}).Error("Failed to authenticate to Docktor because server did not return OK")
@@ -87,76 +194,157 @@ func (api *ExternalAPI) authenticate() ([]*http.Cookie, error) {
 	}
 
 	log.WithFields(log.Fields{
-		"address":  api.address,
-		"username": api.username,
+		"address":  api.conf.Address,
+		"username": api.conf.Username,
 	}).Debug("Docktor authentication successful")
 
 	return resp.Cookies(), nil
 }
 
-// GroupDocktor is a group fetched from Docktor API
-type GroupDocktor struct {
-	ID         string `json:"_id,omitempty"`
-	Title      string `json:"title,omitempty"`
-	Containers []struct {
-		ServiceTitle string `json:"serviceTitle"`
-	} `json:"containers"`
-}
+// session returns the cookies of the current session, authenticating when there is no session or when it expired
+func (api *ExternalAPI) session(ctx context.Context) ([]*http.Cookie, error) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
 
-// GetGroup gets a Docktor group name from its ID
-func (api *ExternalAPI) GetGroup(groupID string) (GroupDocktor, error) {
-	cookies, err := api.authenticate()
+	if api.cookies != nil && !expired(api.cookies) {
+		return api.cookies, nil
+	}
+	cookies, err := api.authenticate(ctx)
 	if err != nil {
-		return GroupDocktor{}, err
+		return nil, err
 	}
+	api.cookies = cookies
+	return cookies, nil
+}
 
-	u, _ := url.ParseRequestURI(api.address)
-	u.Path = fmt.Sprintf("/groups/%v", groupID)
+// invalidateSession forgets the session, when Docktor doesn't accept it anymore
+func (api *ExternalAPI) invalidateSession() {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	api.cookies = nil
+}
 
-	client := &http.Client{Timeout: timeout}
-	req, _ := http.NewRequest("GET", u.String(), nil)
+func expired(cookies []*http.Cookie) bool {
+	now := time.Now()
 	for _, cookie := range cookies {
-		req.AddCookie(cookie)
+		if !cookie.Expires.IsZero() && cookie.Expires.Before(now) {
+			return true
+		}
 	}
+	return false
+}
 
-	log.WithFields(log.Fields{
-		"url":     u.String(),
-		"groupID": groupID,
-	}).Debugf("Getting group from Docktor")
+// get gets a resource from Docktor and decodes it from JSON
+// Requests failing with a transient error are retried with an exponential backoff. After too many failures,
+// requests fail with ErrCircuitOpen without reaching Docktor, until the circuit breaker tries again
+// Cancelled requests fail with the error of the context, and don't count as failures
+func (api *ExternalAPI) get(ctx context.Context, path string, result interface{}) error {
+	if err := api.breaker.allow(); err != nil {
+		return err
+	}
+
+	delay := api.conf.RetryDelay
+	for attempt := 0; ; attempt++ {
+		err := api.getOnce(ctx, path, result)
+		if ctx.Err() != nil {
+			api.breaker.release()
+			return ctx.Err()
+		}
+		if err == nil || !isTransient(err) || attempt >= api.conf.Retries {
+			api.breaker.record(isTransient(err))
+			return err
+		}
 
-	resp, err := client.Do(req)
-	if err != nil {
 		log.WithError(err).WithFields(log.Fields{
-			"docktorURL": u.String(),
-			"user":       api.username,
-		}).Error("Failed to get group from Docktor")
-		return GroupDocktor{}, err
+			"path":    path,
+			"attempt": attempt + 1,
+			"delay":   delay,
+		}).Warn("Transient error while requesting Docktor, retrying")
+		select {
+		case <-ctx.Done():
+			api.breaker.release()
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+		if delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
 	}
-	defer resp.Body.Close()
+}
+
+// getOnce gets a resource from Docktor, renewing the session once when Docktor rejects it
+func (api *ExternalAPI) getOnce(ctx context.Context, path string, result interface{}) error {
+	for renewed := false; ; renewed = true {
+		cookies, err := api.session(ctx)
+		if err != nil {
+			return err
+		}
 
+		req, err := http.NewRequest("GET", api.url(path), nil)
+		if err != nil {
+			return fmt.Errorf("Failed to initiate HTTP request: %v", err.Error())
+		}
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+
+		resp, err := api.send(ctx, req)
+		if err != nil {
+			return err
+		}
+		if (resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden) && !renewed {
+			resp.Body.Close()
+			log.WithField("path", path).Debug("Docktor session expired, authenticating again")
+			api.invalidateSession()
+			continue
+		}
+		return decode(resp, result)
+	}
+}
+
+func decode(resp *http.Response, result interface{}) error {
+	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		log.WithFields(log.Fields{
-			"address":    api.address,
-			"username":   api.username,
-			"statusCode": resp.StatusCode,
-			"status":     resp.Status,
-		}).Error("Failed to get group from Docktor because server did not return OK")
-		return GroupDocktor{}, fmt.Errorf("Failed to get group from Docktor because server did not return OK: %v", resp.Status)
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		return fmt.Errorf("Docktor server did not return OK: %v", resp.Status)
 	}
+	err := json.NewDecoder(resp.Body).Decode(result)
+	if err != nil {
+		return fmt.Errorf("Failed to decode JSON result: %v", err.Error())
+	}
+	return nil
+}
 
-	var docktorGroup GroupDocktor
+// GroupDocktor is a group fetched from Docktor API
+type GroupDocktor struct {
+	ID         string `json:"_id,omitempty"`
+	Title      string `json:"title,omitempty"`
+	Containers []struct {
+		ServiceTitle string `json:"serviceTitle"`
+	} `json:"containers"`
+}
+
+// GetGroup gets a Docktor group name from its ID
+func (api *ExternalAPI) GetGroup(ctx context.Context, groupID string) (GroupDocktor, error) {
+	log.WithFields(log.Fields{
+		"address": api.conf.Address,
+		"groupID": groupID,
+	}).Debugf("Getting group from Docktor")
 
-	err = json.NewDecoder(resp.Body).Decode(&docktorGroup)
+	var docktorGroup GroupDocktor
+	err := api.get(ctx, fmt.Sprintf("/groups/%v", groupID), &docktorGroup)
 	if err != nil {
-		log.WithFields(log.Fields{
-			"address":  api.address,
-			"username": api.username,
-		}).WithError(err).Error("Failed to get group from Docktor while decoding JSON result")
-		return GroupDocktor{}, fmt.Errorf("Failed to get group from Docktor while decoding JSON result: %v", err.Error())
+		log.WithError(err).WithFields(log.Fields{
+			"address": api.conf.Address,
+			"groupID": groupID,
+			"user":    api.conf.Username,
+		}).Error("Failed to get group from Docktor")
+		return GroupDocktor{}, wrap(err, "Failed to get group from Docktor")
 	}
 
 	log.WithFields(log.Fields{
-		"address":    api.address,
+		"address":    api.conf.Address,
 		"groupID":    docktorGroup.ID,
 		"groupTitle": docktorGroup.Title,
 	}).Debug("Fetch group from Docktor")
@@ -168,6 +356,12 @@ func (api *ExternalAPI) GetGroup(groupID string) (GroupDocktor, error) {
 // GetGroupIDFromURL returns the Docktor group ID from its URL
 // URL is expected to be format : http://<docktor-host>/groups/<id>
 func (api *ExternalAPI) GetGroupIDFromURL(docktorURL string) (string, error) {
+	return GetGroupIDFromURL(docktorURL)
+}
+
+// GetGroupIDFromURL returns the Docktor group ID from its URL
+// URL is expected to be format : http://<docktor-host>/groups/<id>
+func GetGroupIDFromURL(docktorURL string) (string, error) {
 	u, err := url.ParseRequestURI(docktorURL)
 	if err != nil {
 		return "", fmt.Errorf("docktorGroupURL is not a valid URL. Expected 'http://<docktor>/groups/<id>', Got '%v'", docktorURL)
@@ -0,0 +1,210 @@
+package docktor
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/spf13/viper"
+)
+
+// fakeDocktor is a Docktor stand-in, serving groups to authenticated users
+type fakeDocktor struct {
+	mu       sync.Mutex
+	signins  int
+	requests int
+	session  string
+	failures int // Number of next group requests failing with a server error
+	status   int // Status of group requests, when not zero
+}
+
+func (d *fakeDocktor) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if r.URL.Path == "/auth/signin" {
+		if r.FormValue("username") != "user" || r.FormValue("password") != "password" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		d.signins++
+		d.session = fmt.Sprintf("session-%d", d.signins)
+		http.SetCookie(w, &http.Cookie{Name: "connect.sid", Value: d.session})
+		return
+	}
+
+	d.requests++
+	if cookie, err := r.Cookie("connect.sid"); err != nil || cookie.Value != d.session {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if d.failures > 0 {
+		d.failures--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	if d.status != 0 {
+		w.WriteHeader(d.status)
+		return
+	}
+	fmt.Fprintf(w, `{"_id": %q, "title": "Group", "containers": [{"serviceTitle": "jenkins"}]}`, r.URL.Path[len("/groups/"):])
+}
+
+func newTestAPI(address string, conf Conf) *ExternalAPI {
+	conf.Address = address
+	conf.Username = "user"
+	conf.Password = "password"
+	conf.RetryDelay = time.Millisecond
+	api, err := NewExternalAPI(conf)
+	So(err, ShouldBeNil)
+	return api
+}
+
+func TestGetGroup(t *testing.T) {
+
+	Convey("Given a Docktor server", t, func() {
+		docktor := &fakeDocktor{}
+		server := httptest.NewServer(docktor)
+		defer server.Close()
+
+		Convey("When getting several groups", func() {
+			api := newTestAPI(server.URL, Conf{})
+			for _, id := range []string{"a", "b", "c"} {
+				group, err := api.GetGroup(context.Background(), id)
+				So(err, ShouldBeNil)
+				So(group.ID, ShouldEqual, id)
+				So(group.Containers[0].ServiceTitle, ShouldEqual, "jenkins")
+			}
+			Convey("Then the session is reused", func() {
+				So(docktor.signins, ShouldEqual, 1)
+				So(docktor.requests, ShouldEqual, 3)
+			})
+		})
+
+		Convey("When the session expires", func() {
+			api := newTestAPI(server.URL, Conf{})
+			_, err := api.GetGroup(context.Background(), "a")
+			So(err, ShouldBeNil)
+			docktor.session = "renewed"
+			_, err = api.GetGroup(context.Background(), "b")
+			Convey("Then the client authenticates again", func() {
+				So(err, ShouldBeNil)
+				So(docktor.signins, ShouldEqual, 2)
+			})
+		})
+
+		Convey("When Docktor fails temporarily", func() {
+			docktor.failures = 2
+			api := newTestAPI(server.URL, Conf{Retries: 2})
+			group, err := api.GetGroup(context.Background(), "a")
+			Convey("Then the request is retried", func() {
+				So(err, ShouldBeNil)
+				So(group.ID, ShouldEqual, "a")
+				So(docktor.requests, ShouldEqual, 3)
+			})
+		})
+
+		Convey("When the group does not exist", func() {
+			docktor.status = http.StatusNotFound
+			api := newTestAPI(server.URL, Conf{Retries: 2})
+			_, err := api.GetGroup(context.Background(), "a")
+			Convey("Then the request is not retried", func() {
+				So(err, ShouldNotBeNil)
+				So(docktor.requests, ShouldEqual, 1)
+			})
+		})
+
+		Convey("When the credentials are wrong", func() {
+			api, err := NewExternalAPI(Conf{Address: server.URL, Username: "user", Password: "wrong", Retries: 2})
+			So(err, ShouldBeNil)
+			_, err = api.GetGroup(context.Background(), "a")
+			Convey("Then the authentication is not retried", func() {
+				So(err, ShouldNotBeNil)
+				So(docktor.requests, ShouldEqual, 0)
+			})
+		})
+
+		Convey("When Docktor is down", func() {
+			docktor.failures = 1000
+			api := newTestAPI(server.URL, Conf{BreakerThreshold: 2, BreakerCooldown: 50 * time.Millisecond})
+			for i := 0; i < 2; i++ {
+				_, err := api.GetGroup(context.Background(), "a")
+				So(err, ShouldNotBeNil)
+				So(err, ShouldNotEqual, ErrCircuitOpen)
+			}
+			_, err := api.GetGroup(context.Background(), "a")
+			Convey("Then requests are suspended without reaching Docktor", func() {
+				So(err, ShouldEqual, ErrCircuitOpen)
+				So(docktor.requests, ShouldEqual, 2)
+			})
+
+			Convey("Then requests are resumed once Docktor is up again and the cooldown is over", func() {
+				docktor.failures = 0
+				time.Sleep(60 * time.Millisecond)
+				_, err = api.GetGroup(context.Background(), "a")
+				So(err, ShouldBeNil)
+				_, err = api.GetGroup(context.Background(), "b")
+				So(err, ShouldBeNil)
+			})
+		})
+
+		Convey("When the request is cancelled while Docktor fails", func() {
+			docktor.failures = 1000
+			api := newTestAPI(server.URL, Conf{Retries: 100, BreakerThreshold: 1})
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			defer cancel()
+			_, err := api.GetGroup(ctx, "a")
+			Convey("Then retries are stopped and the failure doesn't suspend requests", func() {
+				So(err, ShouldResemble, context.DeadlineExceeded)
+				So(api.breaker.allow(), ShouldBeNil)
+			})
+		})
+
+		Convey("When requests are rate limited", func() {
+			api := newTestAPI(server.URL, Conf{RateLimit: 50})
+			start := time.Now()
+			for i := 0; i < 4; i++ {
+				_, err := api.GetGroup(context.Background(), "a")
+				So(err, ShouldBeNil)
+			}
+			Convey("Then they are spaced out", func() {
+				// The authentication and the 4 requests are spaced out by 20ms
+				So(time.Since(start), ShouldBeGreaterThanOrEqualTo, 80*time.Millisecond)
+			})
+		})
+	})
+}
+
+func TestGetGroupIDFromURL(t *testing.T) {
+
+	Convey("Given the URL of a Docktor group", t, func() {
+		api := &ExternalAPI{}
+		id, err := api.GetGroupIDFromURL("http://docktor/groups/5a1b")
+		Convey("Then the group ID is the last part of its path", func() {
+			So(err, ShouldBeNil)
+			So(id, ShouldEqual, "5a1b")
+		})
+	})
+}
+
+func TestGet(t *testing.T) {
+
+	Convey("Given the configuration of Docktor", t, func() {
+		viper.Set("docktor.addr", "http://docktor")
+		viper.Set("docktor.user", "user")
+		viper.Set("docktor.password", "password")
+
+		Convey("Then the same client is returned to every caller", func() {
+			first, err := Get()
+			So(err, ShouldBeNil)
+			second, err := Get()
+			So(err, ShouldBeNil)
+			So(second, ShouldEqual, first)
+		})
+	})
+}
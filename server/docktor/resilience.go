@@ -0,0 +1,131 @@
+package docktor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// ErrCircuitOpen is an error message when requests to Docktor are suspended after too many failures
+var ErrCircuitOpen = errors.New("Docktor is unavailable, requests are suspended after too many failures")
+
+// transientError is an error which may not happen again when retrying, like a network error or an overloaded server
+type transientError struct {
+	err error
+}
+
+func (e transientError) Error() string {
+	return e.err.Error()
+}
+
+func isTransient(err error) bool {
+	_, ok := err.(transientError)
+	return ok
+}
+
+// wrap adds a message to an error, keeping it transient. ErrCircuitOpen and the errors of cancelled contexts are kept as is
+func wrap(err error, message string) error {
+	if err == ErrCircuitOpen || err == context.Canceled || err == context.DeadlineExceeded {
+		return err
+	}
+	wrapped := fmt.Errorf("%s: %v", message, err)
+	if isTransient(err) {
+		return transientError{wrapped}
+	}
+	return wrapped
+}
+
+// rateLimiter spaces requests out evenly, so that Docktor is never flooded
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration // Minimum delay between two requests
+	next     time.Time     // Date of the next available slot
+}
+
+// newRateLimiter creates a rate limiter allowing the number of requests per second, or nil when requests are unlimited
+func newRateLimiter(rate float64) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &rateLimiter{interval: time.Duration(float64(time.Second) / rate)}
+}
+
+// wait blocks until the next request is allowed, or until the context is cancelled
+func (l *rateLimiter) wait(ctx context.Context) error {
+	if l == nil {
+		return ctx.Err()
+	}
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	l.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(delay):
+		return nil
+	}
+}
+
+// circuitBreaker suspends requests after consecutive failures, then lets a single request try again once the cooldown is over
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int       // Number of consecutive failures
+	openedAt  time.Time // Date when requests were suspended
+	probing   bool      // Whether a request is trying again
+}
+
+// allow returns ErrCircuitOpen when requests are suspended
+func (b *circuitBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < b.threshold {
+		return nil
+	}
+	if b.probing || time.Since(b.openedAt) < b.cooldown {
+		return ErrCircuitOpen
+	}
+	b.probing = true
+	return nil
+}
+
+// release gives up an allowed request without result, like a cancelled one
+func (b *circuitBreaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+// record records the result of an allowed request
+func (b *circuitBreaker) record(failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+	if !failed {
+		if b.failures >= b.threshold {
+			log.Info("Docktor is available again, requests are resumed")
+		}
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		if b.failures == b.threshold {
+			log.WithField("cooldown", b.cooldown).Warn("Too many failures while requesting Docktor, requests are suspended")
+		}
+		b.openedAt = time.Now()
+	}
+}
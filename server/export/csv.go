@@ -0,0 +1,36 @@
+package export
+
+import (
+	"encoding/csv"
+	"io"
+)
+
+// csvWriter writes the deployment plan as a CSV file, with one line per project
+// Columns of functional services are named "<service> - <column>", so that the file can be imported back
+type csvWriter struct{}
+
+func (csvWriter) Extension() string {
+	return ".csv"
+}
+
+func (csvWriter) ContentType() string {
+	return "text/csv; charset=utf-8"
+}
+
+func (csvWriter) Write(w io.Writer, data Data) error {
+	csvW := csv.NewWriter(w)
+	if err := csvW.Write(headers(data)); err != nil {
+		return err
+	}
+	for _, project := range data.Projects {
+		record := []string{}
+		for _, value := range rowValues(project) {
+			record = append(record, formatValue(value))
+		}
+		if err := csvW.Write(record); err != nil {
+			return err
+		}
+	}
+	csvW.Flush()
+	return csvW.Error()
+}
@@ -10,12 +10,12 @@ import (
 	log "github.com/Sirupsen/logrus"
 	"github.com/soprasteria/dad/server/mongo"
 	"github.com/soprasteria/dad/server/types"
-	"github.com/tealeg/xlsx"
 )
 
 // Export contains APIs entrypoints needed for accessing users
 type Export struct {
 	Database *mongo.DadMongo
+	Package  string // When set, only the functional services of this package are exported
 }
 
 // ServiceProjectEntry contains a specific service name for a specific project name
@@ -70,11 +70,11 @@ func GetStatus(status string) (Status, error) {
 	return Undetermined, fmt.Errorf("Status %q does not exists", status)
 }
 
-func (e *Export) findDeputies(project types.Project) []string {
+func findDeputies(project types.Project, users map[string]types.User) []string {
 	var deputies []string
 	for _, deputyID := range project.Deputies {
-		deputy, err := e.Database.Users.FindByID(deputyID)
-		if err != nil {
+		deputy, ok := users[deputyID]
+		if !ok {
 			deputy = types.User{DisplayName: "Invalid User"}
 		}
 		deputies = append(deputies, deputy.DisplayName)
@@ -141,49 +141,144 @@ func getServiceIndicatorMap(projects []types.Project, servicesMapSortedKeys []st
 	return serviceIndicatorMap
 }
 
-func (e *Export) generateXlsx(language string, projects []types.Project, services []types.FunctionalService, projectToUsageIndicators map[string][]types.UsageIndicator) (*bytes.Reader, error) {
+// Name of columns contained inside the Matrix maturity column
+var matrixMaturityColumns = []string{
+	projectColumn,
+	"Description",
+	"Business",
+	"Service Center",
+	"Consolidation Criteria",
+	"Client",
+	"Project Manager",
+	"Deputies",
+	"Docktor Group Name",
+	"Docktor Group URL",
+	"Technologies",
+	"Deployment Mode",
+	"Version Control System",
+	"Deliverables in VCS",
+	"Source Code in VCS",
+	"Specifications in VCS",
+	"Creation Date",
+	"Last Update",
+	"IsCDKApplicable",
+	"Explanation"}
+
+// Name of columns generated for each functional service
+var serviceColumns = []string{
+	"Deployed",
+	"Progress",
+	"Goal",
+	"Priority",
+	"Due Date",
+	"Indicator",
+	"Comment"}
+
+// projectColumn is the name of the column containing the project name, which identifies a project
+const projectColumn = "Project"
+
+// ServiceHeader is a functional service exported as a group of columns
+type ServiceHeader struct {
+	Package string                  // Package of the functional service
+	Name    string                  // Name of the functional service, translated in the export language
+	Service types.FunctionalService // Exported functional service
+}
 
-	file := xlsx.NewFile()
-	sheet, err := file.AddSheet("Plan de déploiement")
-	if err != nil {
-		return nil, err
+// ExportedService contains the maturity of a project for a functional service
+type ExportedService struct {
+	Applicable bool             // False when the project has no matrix line for the functional service
+	Line       types.MatrixLine // Matrix line of the project for the functional service
+	Indicator  string           // Best usage indicator status for the functional service, or N/A
+}
+
+// ExportedProject contains the data of a project, with its references resolved to names
+type ExportedProject struct {
+	Project        types.Project
+	BusinessUnit   string
+	ServiceCenter  string   // Paths of the service centers, separated by commas
+	ServiceCenters []string // Paths of the service centers
+	ProjectManager string
+	Deputies       []string
+	Services       []ExportedService // Maturity for each functional service, in the same order as Data.Services
+}
+
+// Data contains everything that is exported, independently of the file format
+type Data struct {
+	Date     time.Time
+	Services []ServiceHeader // Functional services, sorted by package
+	Projects []ExportedProject
+	Summary  Summary // Statistics computed from the exported projects
+}
+
+// percentage is a progress value (e.g. 20%), formatted as a percentage in spreadsheets
+type percentage string
+
+// Details returns the values of the project columns, in the order of matrixMaturityColumns
+// Values are either strings, booleans, dates or percentages
+func (p ExportedProject) Details() []interface{} {
+	domain := p.Project.Domain
+	if len(domain) == 0 {
+		domain = []string{"N/A"}
+	}
+	return []interface{}{
+		p.Project.Name,
+		p.Project.Description,
+		p.BusinessUnit,
+		p.ServiceCenter,
+		strings.Join(domain, "; "),
+		p.Project.Client,
+		p.ProjectManager,
+		strings.Join(p.Deputies, ", "),
+		p.Project.DocktorGroupName,
+		p.Project.DocktorGroupURL,
+		strings.Join(p.Project.Technologies, ", "),
+		p.Project.Mode,
+		p.Project.VersionControlSystem,
+		p.Project.DeliverablesInVersionControl,
+		p.Project.SourceCodeInVersionControl,
+		p.Project.SpecificationsInVersionControl,
+		p.Project.Created,
+		p.Project.Updated,
+		p.Project.IsCDKApplicable,
+		p.Project.Explanation,
+	}
+}
+
+// Values returns the values of the functional service columns, in the order of serviceColumns
+func (s ExportedService) Values() []interface{} {
+	if !s.Applicable {
+		return []interface{}{"N/A", "N/A", "N/A", "N/A", "N/A", s.Indicator, ""}
 	}
+	var dueDate interface{} = "N/A"
+	if s.Line.DueDate != nil {
+		dueDate = *s.Line.DueDate
+	}
+	return []interface{}{
+		s.Line.Deployed,
+		percentage(types.Progress[s.Line.Progress]),
+		percentage(types.Progress[s.Line.Goal]),
+		s.Line.Priority,
+		dueDate,
+		s.Indicator,
+		s.Line.Comment,
+	}
+}
+
+// collect resolves the data of all projects needed by the export
+// Entities and users are loaded once, instead of once per project
+func (e *Export) collect(language string, projects []types.Project, services []types.FunctionalService, projectToUsageIndicators map[string][]types.UsageIndicator) (Data, error) {
 
-	servicePkgRow := sheet.AddRow()
-	serviceNameRow := sheet.AddRow()
-	serviceMaturityRow := sheet.AddRow()
-
-	serviceNameRow.SetHeightCM(10)
-
-	// Name of columns contained inside the Matrix maturity column
-	matrixMaturityColumns := []string{
-		"Project",
-		"Description",
-		"Business",
-		"Service Center",
-		"Consolidation Criteria",
-		"Client",
-		"Project Manager",
-		"Deputies",
-		"Docktor Group Name",
-		"Docktor Group URL",
-		"Technologies",
-		"Deployment Mode",
-		"Version Control System",
-		"Deliverables in VCS",
-		"Source Code in VCS",
-		"Specifications in VCS",
-		"Creation Date",
-		"Last Update",
-		"IsCDKApplicable",
-		"Explanation"}
-
-	createMergedCell(servicePkgRow, "Matrix Maturity", len(matrixMaturityColumns))
-
-	createMergedCell(serviceNameRow, "Export Date: "+time.Now().Format("02/01/2006"), len(matrixMaturityColumns))
-
-	for _, column := range matrixMaturityColumns {
-		createCell(serviceMaturityRow, column)
+	entities, err := e.Database.Entities.FindTree()
+	if err != nil {
+		return Data{}, err
+	}
+	allUsers, err := e.Database.Users.FindAll()
+	if err != nil {
+		return Data{}, err
+	}
+	users := map[string]types.User{}
+	for _, user := range allUsers {
+		users[user.ID.Hex()] = user
 	}
 
 	// Build a map of services indexed by their package name
@@ -199,18 +294,9 @@ func (e *Export) generateXlsx(language string, projects []types.Project, service
 	}
 	sort.Strings(servicesMapSortedKeys)
 
-	allServiceIndicatorMap := getServiceIndicatorMap(projects, servicesMapSortedKeys, servicesMap, projectToUsageIndicators)
-
-	// Number of columns by service
-	const nbColsService = 7
-
-	// Header generation: package and associated functional services
+	data := Data{Date: time.Now(), Services: []ServiceHeader{}, Projects: []ExportedProject{}}
 	for _, pkg := range servicesMapSortedKeys {
-		services := servicesMap[pkg]
-
-		createMergedCell(servicePkgRow, pkg, len(services)*nbColsService)
-		for _, service := range services {
-
+		for _, service := range servicesMap[pkg] {
 			// Find the translation of the service name, default service name value
 			var serviceTranslation = service.Name
 			for _, translation := range service.Translations {
@@ -218,140 +304,115 @@ func (e *Export) generateXlsx(language string, projects []types.Project, service
 					serviceTranslation = translation.Translation
 				}
 			}
-
-			nameCell := createMergedCell(serviceNameRow, serviceTranslation, nbColsService)
-			rotateCell(nameCell, 90)
-			createCell(serviceMaturityRow, "Deployed")
-			createCell(serviceMaturityRow, "Progress")
-			createCell(serviceMaturityRow, "Goal")
-			createCell(serviceMaturityRow, "Priority")
-			createCell(serviceMaturityRow, "Due Date")
-			createCell(serviceMaturityRow, "Indicator")
-			createCell(serviceMaturityRow, "Comment")
+			data.Services = append(data.Services, ServiceHeader{Package: pkg, Name: serviceTranslation, Service: service})
 		}
 	}
 
-	// Generate a project row
+	allServiceIndicatorMap := getServiceIndicatorMap(projects, servicesMapSortedKeys, servicesMap, projectToUsageIndicators)
+
 	for _, project := range projects {
-		projectRow := sheet.AddRow()
+		exported := ExportedProject{Project: project, Services: []ExportedService{}}
 
-		var businessUnit types.Entity
-		businessUnit, err = e.Database.Entities.FindByID(project.BusinessUnit)
-		if err != nil {
-			businessUnit = types.Entity{Name: "N/A"}
+		// Entities are exported with their full path in the tree of entities
+		exported.BusinessUnit = "N/A"
+		if businessUnit, ok := entities.GetHex(project.BusinessUnit); ok {
+			exported.BusinessUnit = entities.Path(businessUnit.ID)
 		}
 
-		var serviceCenter string
 		if len(project.ServiceCenter) > 0 {
 			for key, sC := range project.ServiceCenter {
-				s, r := e.Database.Entities.FindByID(sC)
-				if r == nil {
-					serviceCenter += s.Name
+				if s, ok := entities.GetHex(sC); ok {
+					path := entities.Path(s.ID)
+					exported.ServiceCenters = append(exported.ServiceCenters, path)
+					exported.ServiceCenter += path
 					if key < len(project.ServiceCenter)-1 {
-						serviceCenter += ","
+						exported.ServiceCenter += ","
 					}
 				}
 			}
 		} else {
-			serviceCenter = "N/A"
+			exported.ServiceCenter = "N/A"
 		}
 
-		var projectManager types.User
-		projectManager, err = e.Database.Users.FindByID(project.ProjectManager)
-		if err != nil {
+		projectManager, ok := users[project.ProjectManager]
+		if !ok {
 			projectManager = types.User{DisplayName: "N/A"}
 		}
-
-		deputies := e.findDeputies(project)
-
-		if len(project.Domain) == 0 {
-			project.Domain = []string{"N/A"}
-		}
-
-		createCell(projectRow, project.Name)
-		createCell(projectRow, project.Description)
-		createCell(projectRow, businessUnit.Name)
-		createCell(projectRow, serviceCenter)
-		createCell(projectRow, strings.Join(project.Domain, "; "))
-		createCell(projectRow, project.Client)
-		createCell(projectRow, projectManager.DisplayName)
-		createCell(projectRow, strings.Join(deputies, ", "))
-		createCell(projectRow, project.DocktorGroupName)
-		createCell(projectRow, project.DocktorGroupURL)
-		createCell(projectRow, strings.Join(project.Technologies, ", "))
-		createCell(projectRow, project.Mode)
-		createCell(projectRow, project.VersionControlSystem)
-		createBoolCell(projectRow, project.DeliverablesInVersionControl)
-		createBoolCell(projectRow, project.SourceCodeInVersionControl)
-		createBoolCell(projectRow, project.SpecificationsInVersionControl)
-		createDateCell(projectRow, project.Created)
-		createDateCell(projectRow, project.Updated)
-		createBoolCell(projectRow, project.IsCDKApplicable)
-		createCell(projectRow, project.Explanation)
+		exported.ProjectManager = projectManager.DisplayName
+		exported.Deputies = findDeputies(project, users)
 
 		// Iterate on each service in the correct order
-		for _, pkg := range servicesMapSortedKeys {
-			services := servicesMap[pkg]
-			for _, service := range services {
-				applicable := false
-				// Iterate on the project matrix and print the data for the current service
-				for _, line := range project.Matrix {
-					if line.Service == service.ID {
-						createCell(projectRow, line.Deployed)
-						createFormattedValueCell(projectRow, types.Progress[line.Progress])
-						createFormattedValueCell(projectRow, types.Progress[line.Goal])
-						createCell(projectRow, line.Priority)
-						if line.DueDate != nil {
-							createDateCell(projectRow, *line.DueDate)
-						} else {
-							createCell(projectRow, "N/A")
-						}
-						key := ServiceProjectEntry{ProjectName: project.Name, ServiceName: service.Name}
-						createCell(projectRow, allServiceIndicatorMap[key])
-						createCell(projectRow, line.Comment)
-						applicable = true
-						break
-					}
-				}
-
-				if !applicable {
-					createCell(projectRow, "N/A")
-					createCell(projectRow, "N/A")
-					createCell(projectRow, "N/A")
-					createCell(projectRow, "N/A")
-					createCell(projectRow, "N/A")
-					key := ServiceProjectEntry{ProjectName: project.Name, ServiceName: service.Name}
-					createCell(projectRow, allServiceIndicatorMap[key])
-					createCell(projectRow, "")
+		for _, header := range data.Services {
+			key := ServiceProjectEntry{ProjectName: project.Name, ServiceName: header.Service.Name}
+			service := ExportedService{Indicator: allServiceIndicatorMap[key]}
+			// Iterate on the project matrix and keep the data for the current service
+			for _, line := range project.Matrix {
+				if line.Service == header.Service.ID {
+					service.Applicable = true
+					service.Line = line
+					break
 				}
 			}
+			exported.Services = append(exported.Services, service)
 		}
+		data.Projects = append(data.Projects, exported)
 	}
+	data.Summary = ComputeSummary(data)
+	return data, nil
+}
 
-	colorRow(servicePkgRow, red, white)
-	colorRow(serviceNameRow, red, white)
-	colorRow(serviceMaturityRow, red, white)
-	modifySheetAlignment(sheet, "center", "center")
-	modifySheetBorder(sheet, black)
-
-	// Width for all cells
-	const widthDate = 12.0
-	setWidthCols(sheet, widthDate)
+//Export exports some business data as a file, in the given format
+func (e *Export) Export(language string, writer Writer, projects []types.Project, projectToUsageIndicators map[string][]types.UsageIndicator) (*bytes.Reader, error) {
+	var services []types.FunctionalService
+	var err error
+	if e.Package != "" {
+		services, err = e.Database.FunctionalServices.FindByPackage(e.Package)
+	} else {
+		services, err = e.Database.FunctionalServices.FindAll()
+	}
+	if err != nil {
+		return nil, err
+	}
+	data, err := e.collect(language, projects, services, projectToUsageIndicators)
+	if err != nil {
+		return nil, err
+	}
 
 	// Write the file in-memory and returns is as a readable stream
 	var b bytes.Buffer
-	err = file.Write(&b)
+	err = writer.Write(&b, data)
 	if err != nil {
 		return nil, err
 	}
 	return bytes.NewReader(b.Bytes()), nil
 }
 
-//Export exports some business data as a file
-func (e *Export) Export(language string, projects []types.Project, projectToUsageIndicators map[string][]types.UsageIndicator) (*bytes.Reader, error) {
-	services, err := e.Database.FunctionalServices.FindAll()
+// ExportForUser exports the projects visible by the user and matching the filter, in the given format
+// Users allowed to export all projects export every project matching the filter
+func (e *Export) ExportForUser(user types.User, filter types.ProjectFilter, language string, writer Writer) (*bytes.Reader, error) {
+	var projects types.Projects
+	var err error
+	if user.Can(types.ExportAllPermission) {
+		projects, err = e.Database.Projects.FindWithFilter(filter)
+	} else {
+		projects, err = e.Database.Projects.FindForUserWithFilter(user, filter)
+	}
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("Error while retrieving the projects of the user: %v", err)
+	}
+
+	usageIndicatorRepo := e.Database.UsageIndicators
+	projectToUsageIndicators := map[string][]types.UsageIndicator{}
+	for key, project := range projects {
+		usageIndicators, err2 := usageIndicatorRepo.FindAllFromGroup(project.DocktorGroupName)
+		if err2 != nil {
+			log.WithError(err2).Warn("Error while retrieving usageIndicators, indicators can't be reached for the project : " + project.Name)
+			continue
+		}
+		projectToUsageIndicators[project.Name] = usageIndicators
+		// Reverse field cdk applicable for export
+		projects[key].IsCDKApplicable = !project.IsCDKApplicable
 	}
-	return e.generateXlsx(language, projects, services, projectToUsageIndicators)
+
+	return e.Export(language, writer, projects, projectToUsageIndicators)
 }
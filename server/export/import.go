@@ -0,0 +1,490 @@
+package export
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/soprasteria/dad/server/types"
+	"github.com/tealeg/xlsx"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// notApplicable is the value written in exported files when a data is not set
+const notApplicable = "N/A"
+
+// importDateFormats are the accepted formats of dates in imported files
+var importDateFormats = []string{"2006-01-02", "02/01/2006"}
+
+// dateColumns are the columns containing dates, which are converted from the Excel format when importing a XLSX file
+var dateColumns = map[string]bool{
+	"Creation Date": true,
+	"Last Update":   true,
+	"Due Date":      true,
+}
+
+// ImportedFile contains the rows of an imported file
+type ImportedFile struct {
+	Columns []string      // Name of the columns of the file. Columns of functional services are named "<service> - <column>"
+	Rows    []ImportedRow // Rows of the file, containing data of projects
+}
+
+// ImportedRow is a row of an imported file
+type ImportedRow struct {
+	Line   int               // Line of the row in the file, starting at 1
+	Values map[string]string // Values of the row, indexed by column name
+}
+
+// ProjectName returns the name of the project described by the row
+func (row ImportedRow) ProjectName() string {
+	return row.Values[projectColumn]
+}
+
+// serviceColumn returns the name of the column of a functional service in an imported file
+func serviceColumn(service, column string) string {
+	return service + " - " + column
+}
+
+// ReadXlsx reads the first sheet of a file with the same layout as the one generated by the export:
+// packages on the first row, functional services on the second one and column names on the third one.
+func ReadXlsx(content []byte) (ImportedFile, error) {
+	file, err := xlsx.OpenBinary(content)
+	if err != nil {
+		return ImportedFile{}, fmt.Errorf("File is not a valid XLSX file: %v", err)
+	}
+	if len(file.Sheets) == 0 || len(file.Sheets[0].Rows) < 3 {
+		return ImportedFile{}, errors.New("File should contain a sheet with at least 3 header rows")
+	}
+	sheet := file.Sheets[0]
+
+	// Find name of columns, from functional services and column names rows
+	isProjectColumn := map[string]bool{}
+	for _, column := range matrixMaturityColumns {
+		isProjectColumn[column] = true
+	}
+	serviceRow := sheet.Rows[1]
+	columns := []string{}
+	currentService := ""
+	for i, cell := range sheet.Rows[2].Cells {
+		header := strings.TrimSpace(cell.Value)
+		if !isProjectColumn[header] {
+			// Name of the service is only set on the first cell of merged ones
+			if i < len(serviceRow.Cells) && strings.TrimSpace(serviceRow.Cells[i].Value) != "" {
+				currentService = strings.TrimSpace(serviceRow.Cells[i].Value)
+			}
+			if currentService != "" {
+				header = serviceColumn(currentService, header)
+			}
+		}
+		columns = append(columns, header)
+	}
+
+	rows := []ImportedRow{}
+	for i, row := range sheet.Rows[3:] {
+		values := map[string]string{}
+		for j, cell := range row.Cells {
+			if j >= len(columns) || columns[j] == "" {
+				continue
+			}
+			values[columns[j]] = xlsxCellValue(cell, columns[j], file.Date1904)
+		}
+		if values[projectColumn] == "" {
+			// Empty rows are ignored
+			continue
+		}
+		rows = append(rows, ImportedRow{Line: i + 4, Values: values})
+	}
+
+	return ImportedFile{Columns: columns, Rows: rows}, nil
+}
+
+// xlsxCellValue returns the value of a cell as a string, converting Excel dates into the import date format
+func xlsxCellValue(cell *xlsx.Cell, column string, date1904 bool) string {
+	value := strings.TrimSpace(cell.Value)
+	if isDateColumn(column) {
+		if serial, err := strconv.ParseFloat(value, 64); err == nil {
+			return xlsx.TimeFromExcelTime(serial, date1904).Format(importDateFormats[0])
+		}
+	}
+	return value
+}
+
+// isDateColumn checks that a project or functional service column contains dates
+func isDateColumn(column string) bool {
+	parts := strings.Split(column, " - ")
+	return dateColumns[parts[len(parts)-1]]
+}
+
+// ReadCsv reads a CSV file whose first line contains the column names.
+// Columns have the same names as in the exported XLSX file, and columns of functional services are named "<service> - <column>"
+func ReadCsv(reader io.Reader) (ImportedFile, error) {
+	csvReader := csv.NewReader(reader)
+	csvReader.FieldsPerRecord = -1
+	csvReader.TrimLeadingSpace = true
+
+	records, err := csvReader.ReadAll()
+	if err != nil {
+		return ImportedFile{}, fmt.Errorf("File is not a valid CSV file: %v", err)
+	}
+	if len(records) == 0 {
+		return ImportedFile{}, errors.New("File should contain a header line with the name of the columns")
+	}
+
+	columns := []string{}
+	for _, column := range records[0] {
+		columns = append(columns, strings.TrimSpace(column))
+	}
+
+	rows := []ImportedRow{}
+	for i, record := range records[1:] {
+		values := map[string]string{}
+		for j, value := range record {
+			if j < len(columns) && columns[j] != "" {
+				values[columns[j]] = strings.TrimSpace(value)
+			}
+		}
+		if values[projectColumn] == "" {
+			// Empty lines are ignored
+			continue
+		}
+		rows = append(rows, ImportedRow{Line: i + 2, Values: values})
+	}
+
+	return ImportedFile{Columns: columns, Rows: rows}, nil
+}
+
+// ImportReferences resolves the names used in imported files to the IDs of entities, users and functional services
+type ImportReferences struct {
+	entities map[string]types.Entity
+	users    map[string]types.User
+	services map[string]types.FunctionalService
+}
+
+// NewImportReferences indexes entities, users and functional services by their names (case insensitive)
+// Entities can be referenced by their name or their full path in the tree of entities, as exported
+// Users can be referenced by their display name or their username, services by their name or one of their translations
+func NewImportReferences(entities []types.Entity, users []types.User, services []types.FunctionalService) ImportReferences {
+	refs := ImportReferences{
+		entities: map[string]types.Entity{},
+		users:    map[string]types.User{},
+		services: map[string]types.FunctionalService{},
+	}
+	tree := types.NewEntityTree(entities)
+	for _, entity := range entities {
+		refs.entities[entityKey(entity.Type, entity.Name)] = entity
+		refs.entities[entityKey(entity.Type, tree.Path(entity.ID))] = entity
+	}
+	for _, user := range users {
+		if _, ok := refs.users[strings.ToLower(user.DisplayName)]; !ok {
+			refs.users[strings.ToLower(user.DisplayName)] = user
+		}
+	}
+	// Usernames are unique, so they take precedence over display names
+	for _, user := range users {
+		refs.users[strings.ToLower(user.Username)] = user
+	}
+	for _, service := range services {
+		for _, translation := range service.Translations {
+			refs.services[strings.ToLower(translation.Translation)] = service
+		}
+	}
+	// Names take precedence over translations
+	for _, service := range services {
+		refs.services[strings.ToLower(service.Name)] = service
+	}
+	return refs
+}
+
+func entityKey(entityType types.EntityType, name string) string {
+	return string(entityType) + "/" + strings.ToLower(name)
+}
+
+// UnknownColumns returns the columns of the file that will be ignored during import
+func (refs ImportReferences) UnknownColumns(columns []string) []string {
+	isKnown := map[string]bool{}
+	for _, column := range matrixMaturityColumns {
+		isKnown[column] = true
+	}
+	isServiceColumn := map[string]bool{}
+	for _, column := range serviceColumns {
+		isServiceColumn[column] = true
+	}
+
+	unknown := []string{}
+	for _, column := range columns {
+		if column == "" || isKnown[column] {
+			continue
+		}
+		parts := strings.Split(column, " - ")
+		if len(parts) > 1 && isServiceColumn[parts[len(parts)-1]] {
+			if _, ok := refs.services[strings.ToLower(strings.Join(parts[:len(parts)-1], " - "))]; ok {
+				continue
+			}
+		}
+		unknown = append(unknown, column)
+	}
+	return unknown
+}
+
+// ToProject updates the given project with the values of the row. Only the columns present in the file are updated.
+// Computed columns (Docktor group name, dates, indicators) are ignored.
+func (refs ImportReferences) ToProject(row ImportedRow, project types.Project) (types.Project, error) {
+	project.Matrix = append(types.Matrix{}, project.Matrix...)
+
+	for column, value := range row.Values {
+		var err error
+		switch column {
+		case projectColumn:
+			project.Name = value
+		case "Description":
+			project.Description = value
+		case "Business":
+			project.BusinessUnit, err = refs.entityID(value, types.BusinessUnitType)
+		case "Service Center":
+			project.ServiceCenter, err = refs.entityIDs(splitList(value, ","), types.ServiceCenterType, types.TeamType)
+		case "Consolidation Criteria":
+			project.Domain = splitList(value, ";")
+		case "Client":
+			project.Client = value
+		case "Project Manager":
+			project.ProjectManager, err = refs.userID(value)
+		case "Deputies":
+			project.Deputies, err = refs.userIDs(splitList(value, ","))
+		case "Docktor Group URL":
+			project.DocktorGroupURL = value
+		case "Technologies":
+			project.Technologies = splitList(value, ",")
+		case "Deployment Mode":
+			project.Mode = value
+		case "Version Control System":
+			project.VersionControlSystem = value
+		case "Deliverables in VCS":
+			project.DeliverablesInVersionControl, err = parseBool(column, value)
+		case "Source Code in VCS":
+			project.SourceCodeInVersionControl, err = parseBool(column, value)
+		case "Specifications in VCS":
+			project.SpecificationsInVersionControl, err = parseBool(column, value)
+		case "IsCDKApplicable":
+			// Field is reversed in exported files
+			var notApplicableCDK bool
+			notApplicableCDK, err = parseBool(column, value)
+			project.IsCDKApplicable = !notApplicableCDK
+		case "Explanation":
+			project.Explanation = value
+		}
+		if err != nil {
+			return types.Project{}, err
+		}
+	}
+
+	return refs.importMatrix(row, project)
+}
+
+// importMatrix updates the matrix of the project with the columns of every known functional service
+// N/A values are applied like other values. A functional service whose deployed status and other columns are all N/A
+// is not applicable, as exported for projects without matrix line, so its matrix line is removed.
+// Functional services without any column in the file are left unchanged.
+func (refs ImportReferences) importMatrix(row ImportedRow, project types.Project) (types.Project, error) {
+	imported := map[bson.ObjectId]bool{}
+	for column := range row.Values {
+		parts := strings.Split(column, " - ")
+		if len(parts) < 2 {
+			continue
+		}
+		service, ok := refs.services[strings.ToLower(strings.Join(parts[:len(parts)-1], " - "))]
+		if !ok || imported[service.ID] {
+			continue
+		}
+		imported[service.ID] = true
+
+		serviceValues := map[string]string{}
+		applicable := false
+		for _, serviceColumnName := range serviceColumns {
+			value, ok := row.Values[serviceColumn(strings.Join(parts[:len(parts)-1], " - "), serviceColumnName)]
+			if !ok {
+				continue
+			}
+			serviceValues[serviceColumnName] = value
+			if value != "" && value != notApplicable && serviceColumnName != "Indicator" {
+				applicable = true
+			}
+		}
+
+		index := -1
+		for i, line := range project.Matrix {
+			if line.Service == service.ID {
+				index = i
+				break
+			}
+		}
+		if deployed, ok := serviceValues["Deployed"]; ok && !applicable && (deployed == notApplicable || deployed == "") {
+			if index >= 0 {
+				project.Matrix = append(project.Matrix[:index], project.Matrix[index+1:]...)
+			}
+			continue
+		}
+		if index < 0 {
+			if !applicable {
+				// The functional service is already not applicable
+				continue
+			}
+			project.Matrix = append(project.Matrix, types.MatrixLine{Service: service.ID, Progress: -1, Goal: -1})
+			index = len(project.Matrix) - 1
+		}
+
+		line, err := importMatrixLine(service, serviceValues, project.Matrix[index])
+		if err != nil {
+			return types.Project{}, err
+		}
+		project.Matrix[index] = line
+	}
+	return project, nil
+}
+
+// importMatrixLine updates a matrix line with the values of the columns of a functional service
+func importMatrixLine(service types.FunctionalService, values map[string]string, line types.MatrixLine) (types.MatrixLine, error) {
+	var err error
+	for column, value := range values {
+		switch column {
+		case "Deployed":
+			if value == notApplicable {
+				value = ""
+			}
+			if value != "" && !types.ContainsValue(types.Deployed, value) {
+				return line, fmt.Errorf("Deployed status %q of %s is not valid", value, service.Name)
+			}
+			line.Deployed = value
+		case "Progress":
+			line.Progress, err = parseProgress(value)
+		case "Goal":
+			line.Goal, err = parseProgress(value)
+		case "Priority":
+			if value != "" && !types.ContainsValue(types.Priority, value) {
+				return line, fmt.Errorf("Priority %q of %s is not valid", value, service.Name)
+			}
+			line.Priority = value
+		case "Due Date":
+			line.DueDate, err = parseDate(value)
+		case "Comment":
+			line.Comment = value
+		}
+		if err != nil {
+			return line, fmt.Errorf("%s of %s is not valid: %v", column, service.Name, err)
+		}
+	}
+	return line, nil
+}
+
+// entityID resolves the name of an entity of one of the given types, the first type being the expected one
+func (refs ImportReferences) entityID(name string, entityTypes ...types.EntityType) (string, error) {
+	if name == "" || name == notApplicable {
+		return "", nil
+	}
+	for _, entityType := range entityTypes {
+		if entity, ok := refs.entities[entityKey(entityType, name)]; ok {
+			return entity.ID.Hex(), nil
+		}
+	}
+	return "", fmt.Errorf("The %s %q does not exist", entityTypes[0], name)
+}
+
+func (refs ImportReferences) entityIDs(names []string, entityTypes ...types.EntityType) ([]string, error) {
+	ids := []string{}
+	for _, name := range names {
+		id, err := refs.entityID(name, entityTypes...)
+		if err != nil {
+			return nil, err
+		}
+		if id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+func (refs ImportReferences) userID(name string) (string, error) {
+	if name == "" || name == notApplicable {
+		return "", nil
+	}
+	user, ok := refs.users[strings.ToLower(name)]
+	if !ok {
+		return "", fmt.Errorf("The user %q does not exist", name)
+	}
+	return user.ID.Hex(), nil
+}
+
+func (refs ImportReferences) userIDs(names []string) ([]string, error) {
+	ids := []string{}
+	for _, name := range names {
+		id, err := refs.userID(name)
+		if err != nil {
+			return nil, err
+		}
+		if id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// splitList splits a list of values, ignoring empty and N/A values
+func splitList(value, separator string) []string {
+	values := []string{}
+	for _, v := range strings.Split(value, separator) {
+		v = strings.TrimSpace(v)
+		if v != "" && v != notApplicable {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+func parseBool(column, value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "true", "1", "yes":
+		return true, nil
+	case "false", "0", "no", "":
+		return false, nil
+	}
+	return false, fmt.Errorf("%s %q is not a valid boolean", column, value)
+}
+
+// parseProgress converts a progress (e.g. 20%, 0.2 or N/A) to its code
+func parseProgress(value string) (int, error) {
+	if value == "" || value == notApplicable {
+		return -1, nil
+	}
+	percent := strings.TrimSuffix(value, "%")
+	number, err := strconv.ParseFloat(percent, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a percentage", value)
+	}
+	if !strings.HasSuffix(value, "%") && number <= 1 {
+		// Percentage formatted as a number by a spreadsheet
+		number = number * 100
+	}
+	formatted := fmt.Sprintf("%v%%", math.Round(number))
+	for code, progress := range types.Progress {
+		if progress == formatted {
+			return code, nil
+		}
+	}
+	return 0, fmt.Errorf("%q is not one of the available progress values", value)
+}
+
+func parseDate(value string) (*time.Time, error) {
+	if value == "" || value == notApplicable {
+		return nil, nil
+	}
+	for _, format := range importDateFormats {
+		if date, err := time.Parse(format, value); err == nil {
+			return &date, nil
+		}
+	}
+	return nil, fmt.Errorf("%q is not a date. Expected format is %s", value, importDateFormats[0])
+}
@@ -0,0 +1,135 @@
+package export
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/soprasteria/dad/server/types"
+	"github.com/tealeg/xlsx"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestReadImportedFiles(t *testing.T) {
+
+	Convey("Given a CSV file with an empty line", t, func() {
+		content := "Project,Client,Jenkins - Progress\nDAD,Sopra Steria,40%\n,,\nDocktor,,N/A\n"
+		Convey("When calling the ReadCsv function", func() {
+			file, err := ReadCsv(strings.NewReader(content))
+			Convey("Then the rows are read with their line number", func() {
+				So(err, ShouldBeNil)
+				So(file.Columns, ShouldResemble, []string{"Project", "Client", "Jenkins - Progress"})
+				So(file.Rows, ShouldHaveLength, 2)
+				So(file.Rows[0], ShouldResemble, ImportedRow{Line: 2, Values: map[string]string{"Project": "DAD", "Client": "Sopra Steria", "Jenkins - Progress": "40%"}})
+				So(file.Rows[1].Line, ShouldEqual, 4)
+			})
+		})
+	})
+
+	Convey("Given a XLSX file with the layout of the export", t, func() {
+		file := xlsx.NewFile()
+		sheet, _ := file.AddSheet("Matrix Maturity")
+		pkgRow, serviceRow, columnRow := sheet.AddRow(), sheet.AddRow(), sheet.AddRow()
+		createMergedCell(pkgRow, "Matrix Maturity", 2)
+		createMergedCell(serviceRow, "Export Date: 01/01/2017", 2)
+		createCell(columnRow, "Project")
+		createCell(columnRow, "Client")
+		createMergedCell(pkgRow, "Build", 2)
+		createMergedCell(serviceRow, "Jenkins", 2)
+		createCell(columnRow, "Progress")
+		createCell(columnRow, "Comment")
+		projectRow := sheet.AddRow()
+		createCell(projectRow, "DAD")
+		createCell(projectRow, "Sopra Steria")
+		createFormattedValueCell(projectRow, "40%")
+		createCell(projectRow, "In progress")
+		var b bytes.Buffer
+		So(file.Write(&b), ShouldBeNil)
+
+		Convey("When calling the ReadXlsx function", func() {
+			imported, err := ReadXlsx(b.Bytes())
+			Convey("Then columns of functional services are prefixed by the service name", func() {
+				So(err, ShouldBeNil)
+				So(imported.Columns, ShouldResemble, []string{"Project", "Client", "Jenkins - Progress", "Jenkins - Comment"})
+				So(imported.Rows, ShouldHaveLength, 1)
+				So(imported.Rows[0].Line, ShouldEqual, 4)
+				So(imported.Rows[0].Values["Jenkins - Comment"], ShouldEqual, "In progress")
+			})
+		})
+	})
+}
+
+func TestToProject(t *testing.T) {
+
+	bu := types.Entity{ID: bson.NewObjectId(), Name: "Banking", Type: types.BusinessUnitType}
+	pm := types.User{ID: bson.NewObjectId(), Username: "jdoe", DisplayName: "John Doe"}
+	jenkins := types.FunctionalService{ID: bson.NewObjectId(), Name: "Jenkins"}
+	sonar := types.FunctionalService{ID: bson.NewObjectId(), Name: "Sonar"}
+	refs := NewImportReferences([]types.Entity{bu}, []types.User{pm}, []types.FunctionalService{jenkins, sonar})
+
+	Convey("Given a row with details and matrix of a project", t, func() {
+		row := ImportedRow{Line: 2, Values: map[string]string{
+			"Project":            "DAD",
+			"Business":           "banking",
+			"Project Manager":    "John Doe",
+			"IsCDKApplicable":    "false",
+			"Jenkins - Deployed": "yes",
+			"Jenkins - Progress": "0.4",
+			"Jenkins - Goal":     "100%",
+			"Sonar - Progress":   "N/A",
+			"Sonar - Goal":       "N/A",
+		}}
+		existing := types.Project{Name: "DAD", Client: "Sopra Steria", Matrix: types.Matrix{{Service: sonar.ID, Progress: 1, Goal: 2, Priority: "P1"}}}
+		Convey("When calling the ToProject function", func() {
+			project, err := refs.ToProject(row, existing)
+			Convey("Then names are resolved and only the columns of the file are updated, N/A values included", func() {
+				So(err, ShouldBeNil)
+				So(project.BusinessUnit, ShouldEqual, bu.ID.Hex())
+				So(project.ProjectManager, ShouldEqual, pm.ID.Hex())
+				So(project.Client, ShouldEqual, "Sopra Steria")
+				So(project.IsCDKApplicable, ShouldBeTrue)
+				So(project.Matrix, ShouldResemble, types.Matrix{
+					{Service: sonar.ID, Progress: -1, Goal: -1, Priority: "P1"},
+					{Service: jenkins.ID, Deployed: "yes", Progress: 2, Goal: 5},
+				})
+				So(existing.Matrix, ShouldHaveLength, 1)
+			})
+		})
+	})
+
+	Convey("Given a row where a functional service is not applicable, as exported", t, func() {
+		values := map[string]string{"Project": "DAD"}
+		for i, column := range serviceColumns {
+			values[serviceColumn("Sonar", column)] = ExportedService{Indicator: "N/A"}.Values()[i].(string)
+		}
+		row := ImportedRow{Line: 2, Values: values}
+		Convey("When calling the ToProject function on a project having a line for the service", func() {
+			existing := types.Project{Name: "DAD", Matrix: types.Matrix{{Service: sonar.ID, Deployed: "yes", Progress: 1, Goal: 2}, {Service: jenkins.ID, Progress: 3}}}
+			project, err := refs.ToProject(row, existing)
+			Convey("Then the line is removed", func() {
+				So(err, ShouldBeNil)
+				So(project.Matrix, ShouldResemble, types.Matrix{{Service: jenkins.ID, Progress: 3}})
+				So(existing.Matrix, ShouldHaveLength, 2)
+			})
+		})
+		Convey("When calling the ToProject function on a project without line for the service", func() {
+			project, err := refs.ToProject(row, types.Project{Name: "DAD"})
+			Convey("Then no line is added", func() {
+				So(err, ShouldBeNil)
+				So(project.Matrix, ShouldBeEmpty)
+			})
+		})
+	})
+
+	Convey("Given a row with an unknown user", t, func() {
+		row := ImportedRow{Line: 2, Values: map[string]string{"Project": "DAD", "Deputies": "jdoe, unknown"}}
+		Convey("When calling the ToProject function", func() {
+			_, err := refs.ToProject(row, types.Project{})
+			Convey("Then an error is returned", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "unknown")
+			})
+		})
+	})
+}
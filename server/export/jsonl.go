@@ -0,0 +1,46 @@
+package export
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// jsonLinesWriter writes the deployment plan as JSON lines, with one JSON object per project
+// Details are indexed by column name, and the maturity of the project is in the "Services" list
+type jsonLinesWriter struct{}
+
+func (jsonLinesWriter) Extension() string {
+	return ".jsonl"
+}
+
+func (jsonLinesWriter) ContentType() string {
+	return "application/x-ndjson"
+}
+
+func (jsonLinesWriter) Write(w io.Writer, data Data) error {
+	encoder := json.NewEncoder(w)
+	for _, project := range data.Projects {
+		object := map[string]interface{}{}
+		for i, value := range project.Details() {
+			object[matrixMaturityColumns[i]] = value
+		}
+
+		services := []map[string]interface{}{}
+		for i, service := range project.Services {
+			serviceObject := map[string]interface{}{
+				"Package": data.Services[i].Package,
+				"Service": data.Services[i].Name,
+			}
+			for j, value := range service.Values() {
+				serviceObject[serviceColumns[j]] = value
+			}
+			services = append(services, serviceObject)
+		}
+		object["Services"] = services
+
+		if err := encoder.Encode(object); err != nil {
+			return err
+		}
+	}
+	return nil
+}
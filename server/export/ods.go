@@ -0,0 +1,150 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const odsMimeType = "application/vnd.oasis.opendocument.spreadsheet"
+
+const odsManifest = `<?xml version="1.0" encoding="UTF-8"?>
+<manifest:manifest xmlns:manifest="urn:oasis:names:tc:opendocument:xmlns:manifest:1.0" manifest:version="1.2">
+ <manifest:file-entry manifest:full-path="/" manifest:media-type="` + odsMimeType + `"/>
+ <manifest:file-entry manifest:full-path="content.xml" manifest:media-type="text/xml"/>
+</manifest:manifest>
+`
+
+const odsContentHeader = `<?xml version="1.0" encoding="UTF-8"?>
+<office:document-content xmlns:office="urn:oasis:names:tc:opendocument:xmlns:office:1.0" xmlns:table="urn:oasis:names:tc:opendocument:xmlns:table:1.0" xmlns:text="urn:oasis:names:tc:opendocument:xmlns:text:1.0" office:version="1.2">
+<office:body><office:spreadsheet>
+`
+
+const odsContentFooter = `</office:spreadsheet></office:body></office:document-content>
+`
+
+// odsWriter writes the deployment plan as an OpenDocument spreadsheet, with the same layout as the XLSX file
+type odsWriter struct{}
+
+func (odsWriter) Extension() string {
+	return ".ods"
+}
+
+func (odsWriter) ContentType() string {
+	return odsMimeType
+}
+
+func (odsWriter) Write(w io.Writer, data Data) error {
+	archive := zip.NewWriter(w)
+
+	// The mimetype file must be the first one of the archive, and must not be compressed
+	mimetype, err := archive.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err != nil {
+		return err
+	}
+	if _, err = io.WriteString(mimetype, odsMimeType); err != nil {
+		return err
+	}
+
+	manifest, err := archive.Create("META-INF/manifest.xml")
+	if err != nil {
+		return err
+	}
+	if _, err = io.WriteString(manifest, odsManifest); err != nil {
+		return err
+	}
+
+	content, err := archive.Create("content.xml")
+	if err != nil {
+		return err
+	}
+	if _, err = content.Write(odsContent(data)); err != nil {
+		return err
+	}
+
+	return archive.Close()
+}
+
+// odsContent generates the content.xml file of the spreadsheet
+func odsContent(data Data) []byte {
+	var b bytes.Buffer
+	b.WriteString(odsContentHeader)
+	b.WriteString(`<table:table table:name="Plan de déploiement">` + "\n")
+
+	// Header generation: package and associated functional services
+	servicePkgRow := odsMergedCell("Matrix Maturity", len(matrixMaturityColumns))
+	serviceNameRow := odsMergedCell("Export Date: "+data.Date.Format("02/01/2006"), len(matrixMaturityColumns))
+	serviceMaturityRow := ""
+	for _, column := range matrixMaturityColumns {
+		serviceMaturityRow += odsCell(column)
+	}
+	for i, service := range data.Services {
+		if i == 0 || data.Services[i-1].Package != service.Package {
+			nbServices := 0
+			for _, s := range data.Services[i:] {
+				if s.Package != service.Package {
+					break
+				}
+				nbServices++
+			}
+			servicePkgRow += odsMergedCell(service.Package, nbServices*len(serviceColumns))
+		}
+		serviceNameRow += odsMergedCell(service.Name, len(serviceColumns))
+		for _, column := range serviceColumns {
+			serviceMaturityRow += odsCell(column)
+		}
+	}
+	for _, row := range []string{servicePkgRow, serviceNameRow, serviceMaturityRow} {
+		b.WriteString("<table:table-row>" + row + "</table:table-row>\n")
+	}
+
+	// Generate a project row
+	for _, project := range data.Projects {
+		b.WriteString("<table:table-row>")
+		for _, value := range rowValues(project) {
+			b.WriteString(odsCell(value))
+		}
+		b.WriteString("</table:table-row>\n")
+	}
+
+	b.WriteString("</table:table>\n")
+	b.WriteString(odsContentFooter)
+	return b.Bytes()
+}
+
+// odsCell generates a cell whose type depends on the exported value
+func odsCell(value interface{}) string {
+	attributes := `office:value-type="string"`
+	switch v := value.(type) {
+	case bool:
+		attributes = fmt.Sprintf(`office:value-type="boolean" office:boolean-value="%t"`, v)
+	case time.Time:
+		attributes = fmt.Sprintf(`office:value-type="date" office:date-value="%s"`, v.Format("2006-01-02T15:04:05"))
+	case percentage:
+		if number, err := strconv.ParseFloat(strings.TrimSuffix(string(v), "%"), 64); err == nil {
+			attributes = fmt.Sprintf(`office:value-type="percentage" office:value="%v"`, number/100)
+		}
+	}
+	return "<table:table-cell " + attributes + "><text:p>" + odsEscape(formatValue(value)) + "</text:p></table:table-cell>"
+}
+
+// odsMergedCell generates a string cell spanning several columns
+func odsMergedCell(content string, size int) string {
+	if size < 1 {
+		size = 1
+	}
+	cell := fmt.Sprintf(`<table:table-cell office:value-type="string" table:number-columns-spanned="%d"><text:p>%s</text:p></table:table-cell>`, size, odsEscape(content))
+	return cell + strings.Repeat("<table:covered-table-cell/>", size-1)
+}
+
+func odsEscape(content string) string {
+	var b bytes.Buffer
+	//nolint:errcheck
+	xml.EscapeText(&b, []byte(content))
+	return b.String()
+}
@@ -0,0 +1,202 @@
+package export
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/soprasteria/dad/server/types"
+)
+
+// PackageAdoption is the adoption of the functional services of a package
+type PackageAdoption struct {
+	Package  string
+	Lines    int     // Number of matrix lines of the package, in all projects
+	Deployed int     // Number of these lines which are deployed
+	Adoption float64 // Percentage of deployed lines
+}
+
+// EntityMaturity is the average maturity of the projects of an entity
+type EntityMaturity struct {
+	Entity   string
+	Type     types.EntityType
+	Projects int     // Number of projects of the entity
+	Lines    int     // Number of applicable matrix lines taken into account
+	Progress float64 // Average progress of the applicable matrix lines, in percent
+	Goal     float64 // Average goal of the applicable matrix lines having a goal, in percent
+}
+
+// OverdueLine is a matrix line whose due date is over while its goal is not reached
+type OverdueLine struct {
+	Project  string
+	Package  string
+	Service  string
+	DueDate  time.Time
+	Progress string
+	Goal     string
+}
+
+// IndicatorDistribution is the number of projects by usage indicator status, for a functional service
+type IndicatorDistribution struct {
+	Package string
+	Service string
+	Counts  map[string]int // Number of projects by status, including N/A when no indicator is found
+}
+
+// Summary contains the statistics computed from the exported data
+type Summary struct {
+	Packages   []PackageAdoption
+	Entities   []EntityMaturity
+	Overdue    []OverdueLine
+	Indicators []IndicatorDistribution
+}
+
+// indicatorStatuses are the columns of the usage indicators distribution, from the best status to the worst one
+var indicatorStatuses = []string{"Active", "Inactive", "Undetermined", "Empty", "N/A"}
+
+// progressPercent converts a progress code to its value in percent, or returns false when it's N/A
+func progressPercent(code int) (float64, bool) {
+	value, err := strconv.ParseFloat(strings.TrimSuffix(types.Progress[code], "%"), 64)
+	return value, err == nil
+}
+
+// ComputeSummary computes the statistics of the exported projects
+// Overdue lines are computed relatively to the export date
+func ComputeSummary(data Data) Summary {
+	return Summary{
+		Packages:   packageAdoption(data),
+		Entities:   entityMaturity(data),
+		Overdue:    overdueLines(data),
+		Indicators: indicatorDistribution(data),
+	}
+}
+
+func packageAdoption(data Data) []PackageAdoption {
+	adoptions := []PackageAdoption{}
+	index := map[string]int{}
+	for i, service := range data.Services {
+		if _, ok := index[service.Package]; !ok {
+			index[service.Package] = len(adoptions)
+			adoptions = append(adoptions, PackageAdoption{Package: service.Package})
+		}
+		adoption := &adoptions[index[service.Package]]
+		for _, project := range data.Projects {
+			if !project.Services[i].Applicable {
+				continue
+			}
+			adoption.Lines++
+			if project.Services[i].Line.Deployed == types.Deployed[0] {
+				adoption.Deployed++
+			}
+		}
+	}
+	for i := range adoptions {
+		if adoptions[i].Lines > 0 {
+			adoptions[i].Adoption = 100 * float64(adoptions[i].Deployed) / float64(adoptions[i].Lines)
+		}
+	}
+	return adoptions
+}
+
+func entityMaturity(data Data) []EntityMaturity {
+	type sums struct {
+		maturity  EntityMaturity
+		progress  float64
+		goal      float64
+		goalLines int // Number of lines having a goal, as lines whose goal is N/A are not part of the average goal
+	}
+	entities := map[types.EntityType]map[string]*sums{
+		types.BusinessUnitType:  {},
+		types.ServiceCenterType: {},
+	}
+
+	for _, project := range data.Projects {
+		names := map[types.EntityType][]string{
+			types.BusinessUnitType:  {project.BusinessUnit},
+			types.ServiceCenterType: project.ServiceCenters,
+		}
+		for entityType, entityNames := range names {
+			for _, name := range entityNames {
+				entity, ok := entities[entityType][name]
+				if !ok {
+					entity = &sums{maturity: EntityMaturity{Entity: name, Type: entityType}}
+					entities[entityType][name] = entity
+				}
+				entity.maturity.Projects++
+				for _, service := range project.Services {
+					progress, applicable := progressPercent(service.Line.Progress)
+					if !service.Applicable || !applicable {
+						continue
+					}
+					entity.maturity.Lines++
+					entity.progress += progress
+					if goal, ok := progressPercent(service.Line.Goal); ok {
+						entity.goal += goal
+						entity.goalLines++
+					}
+				}
+			}
+		}
+	}
+
+	maturities := []EntityMaturity{}
+	for _, entityType := range []types.EntityType{types.BusinessUnitType, types.ServiceCenterType} {
+		names := []string{}
+		for name := range entities[entityType] {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			entity := entities[entityType][name]
+			if entity.maturity.Lines > 0 {
+				entity.maturity.Progress = entity.progress / float64(entity.maturity.Lines)
+			}
+			if entity.goalLines > 0 {
+				entity.maturity.Goal = entity.goal / float64(entity.goalLines)
+			}
+			maturities = append(maturities, entity.maturity)
+		}
+	}
+	return maturities
+}
+
+func overdueLines(data Data) []OverdueLine {
+	lines := []OverdueLine{}
+	for _, project := range data.Projects {
+		for i, service := range project.Services {
+			line := service.Line
+			if !service.Applicable || !line.IsOverdue(data.Date) {
+				continue
+			}
+			lines = append(lines, OverdueLine{
+				Project:  project.Project.Name,
+				Package:  data.Services[i].Package,
+				Service:  data.Services[i].Name,
+				DueDate:  *line.DueDate,
+				Progress: types.Progress[line.Progress],
+				Goal:     types.Progress[line.Goal],
+			})
+		}
+	}
+	sort.SliceStable(lines, func(i, j int) bool {
+		return lines[i].DueDate.Before(lines[j].DueDate)
+	})
+	return lines
+}
+
+func indicatorDistribution(data Data) []IndicatorDistribution {
+	distributions := []IndicatorDistribution{}
+	for i, service := range data.Services {
+		distribution := IndicatorDistribution{Package: service.Package, Service: service.Name, Counts: map[string]int{}}
+		for _, project := range data.Projects {
+			status := project.Services[i].Indicator
+			if _, err := GetStatus(status); err != nil {
+				status = "N/A"
+			}
+			distribution.Counts[status]++
+		}
+		distributions = append(distributions, distribution)
+	}
+	return distributions
+}
@@ -0,0 +1,102 @@
+package export
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/soprasteria/dad/server/types"
+	"github.com/tealeg/xlsx"
+)
+
+func TestComputeSummary(t *testing.T) {
+
+	now := time.Date(2017, 6, 1, 0, 0, 0, 0, time.UTC)
+	past := now.AddDate(0, -1, 0)
+	future := now.AddDate(0, 1, 0)
+
+	data := Data{
+		Date: now,
+		Services: []ServiceHeader{
+			{Package: "Build", Name: "Jenkins"},
+			{Package: "Build", Name: "Sonar"},
+			{Package: "Run", Name: "Docker"},
+		},
+		Projects: []ExportedProject{
+			{
+				Project:        types.Project{Name: "DAD"},
+				BusinessUnit:   "Banking",
+				ServiceCenters: []string{"Paris"},
+				Services: []ExportedService{
+					{Applicable: true, Line: types.MatrixLine{Deployed: "yes", Progress: 5, Goal: 5, DueDate: &past}, Indicator: "Active"},
+					{Applicable: true, Line: types.MatrixLine{Deployed: "no", Progress: 1, Goal: 3, DueDate: &past}, Indicator: "Inactive"},
+					{Applicable: false, Indicator: "N/A"},
+				},
+			},
+			{
+				Project:        types.Project{Name: "Docktor"},
+				BusinessUnit:   "Banking",
+				ServiceCenters: []string{"Paris", "Lyon"},
+				Services: []ExportedService{
+					{Applicable: true, Line: types.MatrixLine{Deployed: "no", Progress: 2, Goal: -1, DueDate: &future}, Indicator: "Empty"},
+					{Applicable: true, Line: types.MatrixLine{Deployed: "no", Progress: -1, Goal: -1}, Indicator: "Active"},
+					{Applicable: true, Line: types.MatrixLine{Deployed: "yes", Progress: 4, Goal: -1, DueDate: &past}, Indicator: "Unknown"},
+				},
+			},
+		},
+	}
+
+	Convey("Given exported projects", t, func() {
+		Convey("When calling the ComputeSummary function", func() {
+			summary := ComputeSummary(data)
+
+			Convey("Then the adoption is computed by package", func() {
+				So(summary.Packages, ShouldResemble, []PackageAdoption{
+					{Package: "Build", Lines: 4, Deployed: 1, Adoption: 25},
+					{Package: "Run", Lines: 1, Deployed: 1, Adoption: 100},
+				})
+			})
+
+			Convey("Then the maturity is averaged by entity, without N/A progress nor N/A goals", func() {
+				So(summary.Entities, ShouldHaveLength, 3)
+				So(summary.Entities[0], ShouldResemble, EntityMaturity{Entity: "Banking", Type: types.BusinessUnitType, Projects: 2, Lines: 4, Progress: 60, Goal: 80})
+				So(summary.Entities[1], ShouldResemble, EntityMaturity{Entity: "Lyon", Type: types.ServiceCenterType, Projects: 1, Lines: 2, Progress: 60, Goal: 0})
+				So(summary.Entities[2].Entity, ShouldEqual, "Paris")
+				So(summary.Entities[2].Projects, ShouldEqual, 2)
+			})
+
+			Convey("Then overdue lines are the ones whose goal is not reached at due date", func() {
+				So(summary.Overdue, ShouldHaveLength, 2)
+				So(summary.Overdue[0].Project, ShouldEqual, "DAD")
+				So(summary.Overdue[0].Service, ShouldEqual, "Sonar")
+				So(summary.Overdue[1].Project, ShouldEqual, "Docktor")
+				So(summary.Overdue[1].Service, ShouldEqual, "Docker")
+				So(summary.Overdue[1].Goal, ShouldEqual, "N/A")
+			})
+
+			Convey("Then usage indicators are counted by status, unknown ones being N/A", func() {
+				So(summary.Indicators, ShouldHaveLength, 3)
+				So(summary.Indicators[0].Counts, ShouldResemble, map[string]int{"Active": 1, "Empty": 1})
+				So(summary.Indicators[2].Counts, ShouldResemble, map[string]int{"N/A": 2})
+			})
+		})
+	})
+
+	Convey("Given exported projects with their summary", t, func() {
+		data.Summary = ComputeSummary(data)
+		Convey("When writing them as XLSX", func() {
+			var b bytes.Buffer
+			So(xlsxWriter{}.Write(&b, data), ShouldBeNil)
+			file, err := xlsx.OpenBinary(b.Bytes())
+			Convey("Then the summary is written in additional sheets", func() {
+				So(err, ShouldBeNil)
+				So(file.Sheets, ShouldHaveLength, 5)
+				So(file.Sheets[1].Name, ShouldEqual, "Package adoption")
+				So(file.Sheets[1].Rows, ShouldHaveLength, 3)
+				So(file.Sheets[3].Name, ShouldEqual, "Overdue lines")
+				So(file.Sheets[3].Rows, ShouldHaveLength, 3)
+			})
+		})
+	})
+}
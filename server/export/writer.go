@@ -0,0 +1,77 @@
+package export
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Writer writes exported data to a file of a specific format
+type Writer interface {
+	// Write writes the whole exported data to w
+	Write(w io.Writer, data Data) error
+	// Extension is the extension of the generated file, including the dot
+	Extension() string
+	// ContentType is the MIME type of the generated file
+	ContentType() string
+}
+
+// DefaultFormat is the format used when none is requested
+const DefaultFormat = "xlsx"
+
+// writers contains the available writers, indexed by format name
+var writers = map[string]Writer{
+	"xlsx":  xlsxWriter{},
+	"csv":   csvWriter{},
+	"jsonl": jsonLinesWriter{},
+	"ods":   odsWriter{},
+}
+
+// GetWriter returns the writer of the given format (xlsx, csv, jsonl or ods), or the XLSX one when format is empty
+// returns an error if format is unrecognized
+func GetWriter(format string) (Writer, error) {
+	if format == "" {
+		format = DefaultFormat
+	}
+	if w, ok := writers[format]; ok {
+		return w, nil
+	}
+	return nil, fmt.Errorf("Format %q does not exist. Available formats are xlsx, csv, jsonl and ods", format)
+}
+
+// formatValue converts an exported value to its text representation, for text based formats
+// Dates use the format expected by the import
+func formatValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case percentage:
+		return string(v)
+	case bool:
+		return strconv.FormatBool(v)
+	case time.Time:
+		return v.Format(importDateFormats[0])
+	}
+	return fmt.Sprint(value)
+}
+
+// headers returns the name of all the columns, as in a CSV file
+func headers(data Data) []string {
+	columns := append([]string{}, matrixMaturityColumns...)
+	for _, service := range data.Services {
+		for _, column := range serviceColumns {
+			columns = append(columns, serviceColumn(service.Name, column))
+		}
+	}
+	return columns
+}
+
+// rowValues returns the values of all the columns for a project, in the same order as headers
+func rowValues(project ExportedProject) []interface{} {
+	values := project.Details()
+	for _, service := range project.Services {
+		values = append(values, service.Values()...)
+	}
+	return values
+}
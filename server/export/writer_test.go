@@ -0,0 +1,104 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/soprasteria/dad/server/types"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestWriters(t *testing.T) {
+
+	jenkins := types.FunctionalService{ID: bson.NewObjectId(), Name: "Jenkins", Package: "Build"}
+	created := time.Date(2017, 3, 1, 0, 0, 0, 0, time.UTC)
+	data := Data{
+		Date:     created,
+		Services: []ServiceHeader{{Package: "Build", Name: "Jenkins", Service: jenkins}},
+		Projects: []ExportedProject{{
+			Project:        types.Project{Name: "DAD", Created: created, Updated: created},
+			BusinessUnit:   "Banking",
+			ServiceCenter:  "N/A",
+			ProjectManager: "John Doe",
+			Services: []ExportedService{{
+				Applicable: true,
+				Line:       types.MatrixLine{Service: jenkins.ID, Deployed: "yes", Progress: 2, Goal: 5, Priority: "P1", Comment: "OK"},
+				Indicator:  "Active",
+			}},
+		}},
+	}
+
+	Convey("Given the available formats", t, func() {
+		Convey("When getting the writer of an unknown format", func() {
+			_, err := GetWriter("pdf")
+			Convey("Then an error is returned", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+		Convey("When getting the writer without format", func() {
+			writer, err := GetWriter("")
+			Convey("Then the XLSX writer is returned", func() {
+				So(err, ShouldBeNil)
+				So(writer.Extension(), ShouldEqual, ".xlsx")
+			})
+		})
+	})
+
+	Convey("Given data exported as CSV and XLSX", t, func() {
+		var csvFile, xlsxFile bytes.Buffer
+		So(csvWriter{}.Write(&csvFile, data), ShouldBeNil)
+		So(xlsxWriter{}.Write(&xlsxFile, data), ShouldBeNil)
+
+		Convey("When reading the files back", func() {
+			fromCsv, errCsv := ReadCsv(&csvFile)
+			fromXlsx, errXlsx := ReadXlsx(xlsxFile.Bytes())
+			Convey("Then both files contain the same columns and values", func() {
+				So(errCsv, ShouldBeNil)
+				So(errXlsx, ShouldBeNil)
+				So(fromCsv.Columns, ShouldResemble, fromXlsx.Columns)
+				So(fromCsv.Rows, ShouldHaveLength, 1)
+				So(fromCsv.Rows[0].Values["Business"], ShouldEqual, "Banking")
+				So(fromCsv.Rows[0].Values["Jenkins - Progress"], ShouldEqual, "40%")
+				So(fromCsv.Rows[0].Values["Jenkins - Indicator"], ShouldEqual, "Active")
+				So(fromCsv.Rows[0].Values["Creation Date"], ShouldEqual, "2017-03-01")
+				So(fromXlsx.Rows[0].Values["Creation Date"], ShouldEqual, "2017-03-01")
+				So(fromXlsx.Rows[0].Values["Jenkins - Comment"], ShouldEqual, "OK")
+			})
+		})
+	})
+
+	Convey("Given data exported as JSON lines", t, func() {
+		var b bytes.Buffer
+		So(jsonLinesWriter{}.Write(&b, data), ShouldBeNil)
+		Convey("When decoding the first line", func() {
+			var object map[string]interface{}
+			err := json.NewDecoder(&b).Decode(&object)
+			Convey("Then it contains the project details and its maturity by service", func() {
+				So(err, ShouldBeNil)
+				So(object["Project"], ShouldEqual, "DAD")
+				services := object["Services"].([]interface{})
+				So(services, ShouldHaveLength, 1)
+				So(services[0].(map[string]interface{})["Progress"], ShouldEqual, "40%")
+				So(services[0].(map[string]interface{})["Service"], ShouldEqual, "Jenkins")
+			})
+		})
+	})
+
+	Convey("Given data exported as ODS", t, func() {
+		var b bytes.Buffer
+		So(odsWriter{}.Write(&b, data), ShouldBeNil)
+		Convey("When opening the archive", func() {
+			archive, err := zip.NewReader(bytes.NewReader(b.Bytes()), int64(b.Len()))
+			Convey("Then the mimetype is the first uncompressed file", func() {
+				So(err, ShouldBeNil)
+				So(archive.File[0].Name, ShouldEqual, "mimetype")
+				So(archive.File[0].Method, ShouldEqual, zip.Store)
+				So(archive.File, ShouldHaveLength, 3)
+			})
+		})
+	})
+}
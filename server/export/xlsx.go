@@ -0,0 +1,177 @@
+package export
+
+import (
+	"io"
+	"time"
+
+	"github.com/tealeg/xlsx"
+)
+
+// xlsxWriter writes the deployment plan as a XLSX file, with merged headers for packages and functional services
+type xlsxWriter struct{}
+
+func (xlsxWriter) Extension() string {
+	return ".xlsx"
+}
+
+func (xlsxWriter) ContentType() string {
+	return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+}
+
+func (xlsxWriter) Write(w io.Writer, data Data) error {
+
+	file := xlsx.NewFile()
+	sheet, err := file.AddSheet("Plan de déploiement")
+	if err != nil {
+		return err
+	}
+
+	servicePkgRow := sheet.AddRow()
+	serviceNameRow := sheet.AddRow()
+	serviceMaturityRow := sheet.AddRow()
+
+	serviceNameRow.SetHeightCM(10)
+
+	createMergedCell(servicePkgRow, "Matrix Maturity", len(matrixMaturityColumns))
+
+	createMergedCell(serviceNameRow, "Export Date: "+data.Date.Format("02/01/2006"), len(matrixMaturityColumns))
+
+	for _, column := range matrixMaturityColumns {
+		createCell(serviceMaturityRow, column)
+	}
+
+	// Number of columns by service
+	nbColsService := len(serviceColumns)
+
+	// Header generation: package and associated functional services
+	for i, service := range data.Services {
+		if i == 0 || data.Services[i-1].Package != service.Package {
+			nbServices := 0
+			for _, s := range data.Services[i:] {
+				if s.Package != service.Package {
+					break
+				}
+				nbServices++
+			}
+			createMergedCell(servicePkgRow, service.Package, nbServices*nbColsService)
+		}
+
+		nameCell := createMergedCell(serviceNameRow, service.Name, nbColsService)
+		rotateCell(nameCell, 90)
+		for _, column := range serviceColumns {
+			createCell(serviceMaturityRow, column)
+		}
+	}
+
+	// Generate a project row
+	for _, project := range data.Projects {
+		projectRow := sheet.AddRow()
+		for _, value := range rowValues(project) {
+			createValueCell(projectRow, value)
+		}
+	}
+
+	colorRow(servicePkgRow, red, white)
+	colorRow(serviceNameRow, red, white)
+	colorRow(serviceMaturityRow, red, white)
+	modifySheetAlignment(sheet, "center", "center")
+	modifySheetBorder(sheet, black)
+
+	// Width for all cells
+	const widthDate = 12.0
+	setWidthCols(sheet, widthDate)
+
+	if err = addSummarySheets(file, data.Summary); err != nil {
+		return err
+	}
+
+	return file.Write(w)
+}
+
+// addSummarySheets adds a sheet for each statistic of the summary
+func addSummarySheets(file *xlsx.File, summary Summary) error {
+
+	packages := [][]interface{}{}
+	for _, p := range summary.Packages {
+		packages = append(packages, []interface{}{p.Package, p.Lines, p.Deployed, p.Adoption / 100})
+	}
+	err := addTableSheet(file, "Package adoption", []string{"Package", "Lines", "Deployed", "Adoption"}, packages)
+	if err != nil {
+		return err
+	}
+
+	entities := [][]interface{}{}
+	for _, e := range summary.Entities {
+		entities = append(entities, []interface{}{e.Entity, string(e.Type), e.Projects, e.Lines, e.Progress / 100, e.Goal / 100})
+	}
+	err = addTableSheet(file, "Entity maturity", []string{"Entity", "Type", "Projects", "Lines", "Average Progress", "Average Goal"}, entities)
+	if err != nil {
+		return err
+	}
+
+	overdue := [][]interface{}{}
+	for _, o := range summary.Overdue {
+		overdue = append(overdue, []interface{}{o.Project, o.Package, o.Service, o.DueDate, percentage(o.Progress), percentage(o.Goal)})
+	}
+	err = addTableSheet(file, "Overdue lines", []string{"Project", "Package", "Service", "Due Date", "Progress", "Goal"}, overdue)
+	if err != nil {
+		return err
+	}
+
+	indicators := [][]interface{}{}
+	for _, i := range summary.Indicators {
+		row := []interface{}{i.Package, i.Service}
+		for _, status := range indicatorStatuses {
+			row = append(row, i.Counts[status])
+		}
+		indicators = append(indicators, row)
+	}
+	return addTableSheet(file, "Usage indicators", append([]string{"Package", "Service"}, indicatorStatuses...), indicators)
+}
+
+// addTableSheet adds a sheet with a header row and a row for each line of values
+func addTableSheet(file *xlsx.File, name string, columns []string, rows [][]interface{}) error {
+	sheet, err := file.AddSheet(name)
+	if err != nil {
+		return err
+	}
+
+	headerRow := sheet.AddRow()
+	for _, column := range columns {
+		createCell(headerRow, column)
+	}
+	for _, values := range rows {
+		row := sheet.AddRow()
+		for _, value := range values {
+			createValueCell(row, value)
+		}
+	}
+
+	colorRow(headerRow, red, white)
+	modifySheetBorder(sheet, black)
+	const widthColumn = 20.0
+	setWidthCols(sheet, widthColumn)
+	return nil
+}
+
+// createValueCell creates a cell whose type depends on the exported value
+// Floats are ratios, formatted as percentages
+func createValueCell(row *xlsx.Row, value interface{}) *xlsx.Cell {
+	switch v := value.(type) {
+	case int:
+		cell := row.AddCell()
+		cell.SetInt(v)
+		return cell
+	case float64:
+		cell := row.AddCell()
+		cell.SetFloatWithFormat(v, "0%")
+		return cell
+	case bool:
+		return createBoolCell(row, v)
+	case time.Time:
+		return createDateCell(row, v)
+	case percentage:
+		return createFormattedValueCell(row, string(v))
+	}
+	return createCell(row, formatValue(value))
+}
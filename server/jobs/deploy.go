@@ -1,39 +1,115 @@
 package jobs
 
 import (
+	"context"
 	"fmt"
 	"strings"
-	"time"
+	"sync"
 
 	log "github.com/Sirupsen/logrus"
-	"github.com/robfig/cron"
 	"github.com/soprasteria/dad/server/docktor"
 	"github.com/soprasteria/dad/server/mongo"
 	"github.com/soprasteria/dad/server/types"
 	"github.com/spf13/viper"
+	"gopkg.in/mgo.v2/bson"
 )
 
-func getDocktorGroupData(project types.Project) (docktor.GroupDocktor, error) {
-	docktorAPI, err := docktor.NewExternalAPI(
-		viper.GetString("docktor.addr"),
-		viper.GetString("docktor.user"),
-		viper.GetString("docktor.password"),
-	)
-	if err != nil {
-		log.WithFields(log.Fields{
-			"address":  viper.GetString("docktor.addr"),
-			"username": viper.GetString("docktor.user"),
-		}).WithError(err).Error("Unable to connect to Docktor")
-		return docktor.GroupDocktor{}, err
+const (
+	// maxSaveAttempts is the number of times the job tries to save a project modified concurrently by someone else
+	maxSaveAttempts = 3
+	// defaultDeployWorkers is the number of projects analyzed at the same time, when not configured
+	defaultDeployWorkers = 4
+)
+
+// groupGetter gets the groups of the projects from Docktor
+type groupGetter interface {
+	GetGroup(ctx context.Context, groupID string) (docktor.GroupDocktor, error)
+}
+
+// deploymentStore reads and saves the projects updated by the deployment analytics
+type deploymentStore interface {
+	FindProject(id bson.ObjectId) (types.Project, error)
+	SaveProject(project types.Project) (types.Project, error)
+	RecordHistory(oldProject, newProject types.Project) error
+}
+
+// mongoDeploymentStore is the deployment store backed by the database
+// Changes are recorded in the history of the projects with the given author
+type mongoDeploymentStore struct {
+	database *mongo.DadMongo
+	author   string
+}
+
+func (s mongoDeploymentStore) FindProject(id bson.ObjectId) (types.Project, error) {
+	return s.database.Projects.FindByIDBson(id)
+}
+
+func (s mongoDeploymentStore) SaveProject(project types.Project) (types.Project, error) {
+	return s.database.Projects.Save(project)
+}
+
+func (s mongoDeploymentStore) RecordHistory(oldProject, newProject types.Project) error {
+	_, err := s.database.ProjectHistory.Record(types.JobAction, s.author, oldProject, newProject)
+	return err
+}
+
+// deploymentAnalytics computes the deployment status of projects from their Docktor group
+// Functional services are loaded once per run, and projects are analyzed concurrently by a bounded number of workers
+// In dry run, the changes are computed without saving the projects
+type deploymentAnalytics struct {
+	docktor        groupGetter
+	store          deploymentStore
+	services       []types.FunctionalService
+	servicesByID   map[bson.ObjectId]types.FunctionalService
+	updateProgress bool
+	workers        int
+	dryRun         bool
+}
+
+func newDeploymentAnalytics(docktorAPI groupGetter, store deploymentStore, services []types.FunctionalService, updateProgress bool, workers int) *deploymentAnalytics {
+	if workers <= 0 {
+		workers = defaultDeployWorkers
 	}
+	servicesByID := map[bson.ObjectId]types.FunctionalService{}
+	for _, service := range services {
+		servicesByID[service.ID] = service
+	}
+	return &deploymentAnalytics{
+		docktor:        docktorAPI,
+		store:          store,
+		services:       services,
+		servicesByID:   servicesByID,
+		updateProgress: updateProgress,
+		workers:        workers,
+	}
+}
 
-	idDocktorGroup, err := docktorAPI.GetGroupIDFromURL(project.DocktorGroupURL)
+// deploymentResults is the result of the deployment analytics
+type deploymentResults struct {
+	updated         int
+	projectsInError []string
+	skipped         int                    // Number of projects not analyzed because the run was stopped
+	stopReason      error                  // Why the run was stopped, either the cancellation of the context or the unavailability of Docktor
+	projects        []types.JobRunProject  // Outcome of each project, in the same order as the analyzed projects
+	diffs           []types.DeploymentDiff // Changes proposed for the projects, in dry run
+}
+
+func (r deploymentResults) String() string {
+	message := fmt.Sprintf("%v projects updated, %v not updated because an error occurred", r.updated, len(r.projectsInError))
+	if r.skipped > 0 {
+		message += fmt.Sprintf(", %v skipped because the analytics were stopped (%v)", r.skipped, r.stopReason)
+	}
+	return message + fmt.Sprintf(". List of projects in error [%v]", strings.Join(r.projectsInError, ","))
+}
+
+func (a *deploymentAnalytics) getDocktorGroupData(ctx context.Context, project types.Project) (docktor.GroupDocktor, error) {
+	idDocktorGroup, err := docktor.GetGroupIDFromURL(project.DocktorGroupURL)
 	if err != nil {
 		log.WithField("docktorGroupURL", project.DocktorGroupURL).WithError(err).Error("Error when parsing Docktor group ID")
 		return docktor.GroupDocktor{}, err
 	}
 
-	docktorGroup, err := docktorAPI.GetGroup(idDocktorGroup)
+	docktorGroup, err := a.docktor.GetGroup(ctx, idDocktorGroup)
 	if err != nil {
 		log.WithError(err).Error("Error when getting containers services")
 		return docktor.GroupDocktor{}, err
@@ -51,36 +127,18 @@ func isDeclarative(docktorGroupData docktor.GroupDocktor) bool {
 	return false
 }
 
-func getAllFunctionalServicesDeployByProject(docktorGroupData docktor.GroupDocktor) ([]types.FunctionalService, error) {
-	// Connect to mongo
-	database, err := mongo.Get()
-	if err != nil {
-		log.WithError(err).Error("Unable to connect to the database")
-		return nil, err
-	}
-	defer database.Session.Close()
-
+// deployedFunctionalServices returns the functional services provided by the containers of the Docktor group
+func (a *deploymentAnalytics) deployedFunctionalServices(docktorGroupData docktor.GroupDocktor) []types.FunctionalService {
 	// Formatting to an array of services
 	servicesDeployed := []string{}
 	for _, container := range docktorGroupData.Containers {
 		servicesDeployed = append(servicesDeployed, strings.ToLower(container.ServiceTitle))
 	}
-
-	// Find all deployed functional services
-	functionalServicesDeployed, err := database.FunctionalServices.FindFunctionalServicesDeployByServices(servicesDeployed)
-	if err != nil {
-		log.WithError(err).Error("Error when getting functional services")
-		return nil, err
-	}
-
-	return functionalServicesDeployed, nil
+	return types.DeployedFunctionalServices(a.services, servicesDeployed)
 }
 
 // constructFullMatrix updates the matrix of a project to make it exhaustive
-func constructFullMatrix(project *types.Project, functionalServices []types.FunctionalService) {
-
-	// Check if we have to update the progress value during the task
-	updateProgress := viper.GetBool("tasks.recurrence.updateProgress")
+func constructFullMatrix(project *types.Project, functionalServices []types.FunctionalService, updateProgress bool) {
 
 	for _, functionalService := range functionalServices {
 		found := false
@@ -111,102 +169,341 @@ func constructFullMatrix(project *types.Project, functionalServices []types.Func
 	}
 }
 
+// applyDeploymentStatus updates the matrix of a project with the functional services deployed on Docktor
+func (a *deploymentAnalytics) applyDeploymentStatus(project *types.Project, functionalServices []types.FunctionalService) {
+
+	// check if declarative and default not deployed, unless we are in isolated network
+	for key, MatrixLine := range project.Matrix {
+		// get the functional service info
+		functionalService, ok := a.servicesByID[MatrixLine.Service]
+		if !ok {
+			continue
+		}
+		// check if declarative
+		if !functionalService.DeclarativeDeployment {
+			project.Matrix[key].Deployed = types.Deployed[-1]
+		}
+	}
+
+	constructFullMatrix(project, functionalServices, a.updateProgress)
+
+	// Put all the no deployed services to a progress of 0
+	if a.updateProgress {
+		for key, matrixLine := range project.Matrix {
+			if matrixLine.Deployed == types.Deployed[-1] {
+				project.Matrix[key].Progress = 0
+			}
+		}
+	}
+}
+
+// saveDeploymentStatus updates the project with the deployment status and saves it, telling whether its matrix changed.
+// A project whose matrix doesn't change is not saved, so that its version is only incremented by real modifications.
+// When the project has been modified by someone else in the meantime, the deployment status is applied again
+// on the latest version of the project, so that the modification is not overwritten.
+func (a *deploymentAnalytics) saveDeploymentStatus(project types.Project, functionalServices []types.FunctionalService) (bool, error) {
+	for attempt := 1; ; attempt++ {
+		// Keep a copy of the project as it was before the analytics, to record the changes in history
+		previousProject := project
+		previousProject.Matrix = append(types.Matrix{}, project.Matrix...)
+
+		a.applyDeploymentStatus(&project, functionalServices)
+		if len(types.DiffMatrix(previousProject.Matrix, project.Matrix)) == 0 {
+			return false, nil
+		}
+
+		_, err := a.store.SaveProject(project)
+		if err == types.ErrProjectVersionConflict && attempt < maxSaveAttempts {
+			log.WithField("project", project.ID).Info("Project was modified during the analytics, applying deployment status on its latest version")
+			project, err = a.store.FindProject(project.ID)
+			if err != nil {
+				return false, err
+			}
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+
+		err = a.store.RecordHistory(previousProject, project)
+		if err != nil {
+			log.WithError(err).WithField("project", project.ID).Warn("Error when recording the project history")
+		}
+		return true, nil
+	}
+}
+
+// previewDeploymentStatus returns the changes the deployment status would make to the matrix of the project
+func (a *deploymentAnalytics) previewDeploymentStatus(project types.Project, functionalServices []types.FunctionalService) []types.DeploymentChange {
+	updatedProject := project
+	updatedProject.Matrix = append(types.Matrix{}, project.Matrix...)
+	a.applyDeploymentStatus(&updatedProject, functionalServices)
+
+	changes := types.DiffMatrix(project.Matrix, updatedProject.Matrix)
+	for i := range changes {
+		changes[i].ServiceName = a.servicesByID[changes[i].Service].Name
+	}
+	return changes
+}
+
+// analyze computes and saves the deployment status of a single project
+// In dry run, the project is not saved and the changes are returned instead
+func (a *deploymentAnalytics) analyze(ctx context.Context, project types.Project) (types.JobRunOutcome, []types.DeploymentChange, error) {
+	docktorGroupData, err := a.getDocktorGroupData(ctx, project)
+	if err != nil {
+		return types.OutcomeInError, nil, err
+	}
+
+	// In the case of an isolated network or on the cloud, all services are declarative, so we don't check anything in deploy and progress status.
+	if isDeclarative(docktorGroupData) {
+		return types.OutcomeUnchanged, nil, nil
+	}
+
+	if a.dryRun {
+		changes := a.previewDeploymentStatus(project, a.deployedFunctionalServices(docktorGroupData))
+		if len(changes) == 0 {
+			return types.OutcomeUnchanged, nil, nil
+		}
+		return types.OutcomeUpdated, changes, nil
+	}
+
+	changed, err := a.saveDeploymentStatus(project, a.deployedFunctionalServices(docktorGroupData))
+	if err != nil {
+		log.WithError(err).WithField("project", project.ID).Warn("Error when updating the project")
+		return types.OutcomeInError, nil, err
+	}
+	if !changed {
+		return types.OutcomeUnchanged, nil, nil
+	}
+	return types.OutcomeUpdated, nil, nil
+}
+
+// run analyzes the projects with the workers, until all projects are analyzed or the run is stopped
+// The run is stopped when the context is cancelled, or when Docktor is unavailable as the remaining projects would fail the same way
+func (a *deploymentAnalytics) run(ctx context.Context, projects []types.Project) deploymentResults {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	outcomes := make([]types.JobRunProject, len(projects))
+	changes := make([][]types.DeploymentChange, len(projects))
+	for i, project := range projects {
+		outcomes[i] = types.JobRunProject{Project: project.ID, Name: project.Name, Outcome: types.OutcomeSkipped}
+	}
+	indexes := make(chan int)
+	var stopReason error
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for w := 0; w < a.workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				if ctx.Err() != nil {
+					// The run was stopped while the project was waiting, it stays skipped
+					continue
+				}
+				outcome, projectChanges, err := a.analyze(ctx, projects[i])
+				if err != nil && (err == docktor.ErrCircuitOpen || ctx.Err() != nil) {
+					// The project was not analyzed, so it is skipped rather than in error
+					outcome = types.OutcomeSkipped
+					mu.Lock()
+					if stopReason == nil {
+						stopReason = err
+						if ctx.Err() != nil {
+							stopReason = ctx.Err()
+						}
+					}
+					mu.Unlock()
+					cancel()
+				}
+				outcomes[i].Outcome = outcome
+				changes[i] = projectChanges
+				if err != nil {
+					outcomes[i].Error = err.Error()
+				}
+			}
+		}()
+	}
+
+feed:
+	for i := range projects {
+		select {
+		case <-ctx.Done():
+			break feed
+		case indexes <- i:
+		}
+	}
+	close(indexes)
+	wg.Wait()
+
+	results := deploymentResults{projectsInError: []string{}, stopReason: stopReason, projects: outcomes, diffs: []types.DeploymentDiff{}}
+	if results.stopReason == nil {
+		results.stopReason = ctx.Err()
+	}
+	for i, outcome := range outcomes {
+		switch outcome.Outcome {
+		case types.OutcomeUpdated:
+			results.updated++
+		case types.OutcomeInError:
+			results.projectsInError = append(results.projectsInError, fmt.Sprintf("%v (docktor:%v)", projects[i].Name, projects[i].DocktorGroupName))
+		case types.OutcomeSkipped:
+			results.skipped++
+		}
+		if len(changes[i]) > 0 {
+			results.diffs = append(results.diffs, types.DeploymentDiff{Project: projects[i].ID, Name: projects[i].Name, Changes: changes[i]})
+		}
+	}
+	return results
+}
+
+// loadDeploymentAnalytics prepares the analytics of all the projects which have a Docktor group URL
+func loadDeploymentAnalytics(database *mongo.DadMongo) (*deploymentAnalytics, []types.Project, error) {
+	// Get all the projects which have docktor group url
+	projects, err := database.Projects.FindWithDocktorGroupURL()
+	if err != nil {
+		log.WithError(err).Error("Unable to find projets with docktor group url. Analytics are stopped.")
+		return nil, nil, err
+	}
+
+	functionalServices, err := database.FunctionalServices.FindAll()
+	if err != nil {
+		log.WithError(err).Error("Unable to find functional services. Analytics are stopped.")
+		return nil, nil, err
+	}
+
+	// The client of the process is used for all projects, so that the Docktor session and circuit breaker are shared
+	docktorAPI, err := docktor.Get()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"address":  viper.GetString("docktor.addr"),
+			"username": viper.GetString("docktor.user"),
+		}).WithError(err).Error("Unable to connect to Docktor. Analytics are stopped.")
+		return nil, nil, err
+	}
+
+	log.Infof("Found %v projects with a Docktor URL, target as potentially updatable with deployment status.", len(projects))
+	analytics := newDeploymentAnalytics(
+		docktorAPI,
+		mongoDeploymentStore{database: database, author: types.DeploymentJobAuthor},
+		functionalServices,
+		viper.GetBool("tasks.recurrence.updateProgress"),
+		viper.GetInt("tasks.recurrence.workers"),
+	)
+	return analytics, projects, nil
+}
+
 // ExecuteDeploymentStatusAnalytics calculates whether a functional service are deployed or not for all projects.
 // Each fonctional services has some container which provide this service, if a proper container is deployed for a project, this service should be at 20% of progression at least.
 // Else, it should be at 0% or N/A if the administrator of the project has defined this fonctionnal service as N/A.
-func ExecuteDeploymentStatusAnalytics() (string, error) {
+// Cancelling the context stops the analytics: projects already analyzed stay updated.
+// The outcome of each project is returned along with the summary, and the run fails with the reason why it was stopped.
+func ExecuteDeploymentStatusAnalytics(ctx context.Context) (string, []types.JobRunProject, error) {
 
 	log.Info("Starting to compute deployment status analytics...")
-	// Check if we have to update the progress value during the task
-	updateProgress := viper.GetBool("tasks.recurrence.updateProgress")
 	// Connect to mongo
 	database, err := mongo.Get()
 	if err != nil {
 		log.WithError(err).Error("Unable to connect to the database. Analytics are stopped.")
-		return "", err
+		return "", nil, err
 	}
 	defer database.Session.Close()
 
-	// Get all the projects which have docktor group url
-	projects, err := database.Projects.FindWithDocktorGroupURL()
+	analytics, projects, err := loadDeploymentAnalytics(database)
 	if err != nil {
-		log.WithError(err).Error("Unable to find projets with docktor group url. Analytics are stopped.")
-		return "", err
+		return "", nil, err
 	}
 
-	log.Infof("Found %v projects with a Docktor URL, target as potentially updatable with deployment status.", len(projects))
-	updatedProjects := 0
-	projectsInError := []string{}
-	for _, project := range projects {
+	results := analytics.run(ctx, projects)
+	if results.skipped > 0 {
+		log.WithError(results.stopReason).WithField("skippedProjects", results.skipped).Error("Computing deployment status analytics was stopped")
+		return results.String(), results.projects, results.stopReason
+	}
+	log.Info("Computing deployment status analytics is over")
+	return results.String(), results.projects, nil
+}
 
-		docktorGroupData, err := getDocktorGroupData(project)
-		if err != nil {
-			log.WithError(err).Error("Error while retrieving Docktor data")
-			time.Sleep(1 * time.Second) // Let Docktor catch his breath when an error occurred.
-			continue
-		}
+// PreviewDeploymentStatusAnalytics computes the changes the deployment status analytics would make to the projects, without saving them.
+// Only the projects which would be modified are returned. The analytics fail with the reason why they were stopped, if any.
+func PreviewDeploymentStatusAnalytics(ctx context.Context) ([]types.DeploymentDiff, error) {
 
-		// In the case of an isolated network or on the cloud, all services are declarative, so we don't check anything in deploy and progress status.
-		if isDeclarative(docktorGroupData) {
-			continue
-		}
+	log.Info("Starting to preview deployment status analytics...")
+	database, err := mongo.Get()
+	if err != nil {
+		log.WithError(err).Error("Unable to connect to the database. Analytics are stopped.")
+		return nil, err
+	}
+	defer database.Session.Close()
 
-		// check if declarative and default not deployed, unless we are in isolated network
-		for key, MatrixLine := range project.Matrix {
-			// get the functional service info
-			functionalService, err := database.FunctionalServices.FindByID(MatrixLine.Service.Hex())
-			if err != nil {
-				continue
-			}
-			// check if declarative
-			if !functionalService.DeclarativeDeployment {
-				project.Matrix[key].Deployed = types.Deployed[-1]
-			}
+	analytics, projects, err := loadDeploymentAnalytics(database)
+	if err != nil {
+		return nil, err
+	}
+	analytics.dryRun = true
+
+	results := analytics.run(ctx, projects)
+	if results.skipped > 0 {
+		log.WithError(results.stopReason).WithField("skippedProjects", results.skipped).Error("Previewing deployment status analytics was stopped")
+		return nil, results.stopReason
+	}
+	log.WithField("modifiedProjects", len(results.diffs)).Info("Previewing deployment status analytics is over")
+	return results.diffs, nil
+}
+
+// applyDeploymentChanges saves the changes of each project, on its latest version
+// A project is not updated when its matrix was modified since the changes were proposed, without preventing other projects from being updated
+func applyDeploymentChanges(store deploymentStore, diffs []types.DeploymentDiff) types.BulkUpdateMatrixResults {
+	errs := []types.ProjectInError{}
+	for i, diff := range diffs {
+		if err := applyProjectChanges(store, diff); err != nil {
+			log.WithError(err).WithField("project", diff.Project).Warn("Error when applying the deployment changes of the project")
+			errs = append(errs, types.ProjectInError{Project: diff.Project, Message: err.Error(), Index: i})
 		}
+	}
+	return types.BulkUpdateMatrixResults{
+		All:     len(diffs),
+		Updated: len(diffs) - len(errs),
+		InError: len(errs),
+		Errors:  errs,
+	}
+}
 
-		// Get all functional services deployed
-		functionalServices, err := getAllFunctionalServicesDeployByProject(docktorGroupData)
+func applyProjectChanges(store deploymentStore, diff types.DeploymentDiff) error {
+	for attempt := 1; ; attempt++ {
+		project, err := store.FindProject(diff.Project)
 		if err != nil {
-			projectsInError = append(projectsInError, fmt.Sprintf("%v (docktor:%v)", project.Name, project.DocktorGroupName))
-			log.WithError(err).WithField("project", project.ID).Warn("Error while retrieving functional services")
-			continue
+			return fmt.Errorf("Project not found %v", diff.Project.Hex())
+		}
+		updatedProject, err := project.ApplyDeploymentChanges(diff.Changes)
+		if err != nil {
+			return err
 		}
 
-		// Waiting a little for Docktor to accept new incoming request
-		time.Sleep(50 * time.Millisecond)
-
-		constructFullMatrix(&project, functionalServices)
-
-		// Put all the no deployed services to a progress of 0
-		if updateProgress {
-			for key, matrixLine := range project.Matrix {
-				if matrixLine.Deployed == types.Deployed[-1] {
-					project.Matrix[key].Progress = 0
-				}
-			}
+		_, err = store.SaveProject(updatedProject)
+		if err == types.ErrProjectVersionConflict && attempt < maxSaveAttempts {
+			continue
+		}
+		if err != nil {
+			return err
 		}
 
-		// Save
-		_, err = database.Projects.Save(project)
+		err = store.RecordHistory(project, updatedProject)
 		if err != nil {
-			projectsInError = append(projectsInError, fmt.Sprintf("%v (docktor:%v)", project.Name, project.DocktorGroupName))
-			log.WithError(err).WithField("project", project.ID).Warn("Error when updating the project")
-			continue
+			log.WithError(err).WithField("project", project.ID).Warn("Error when recording the project history")
 		}
-		updatedProjects++
+		return nil
 	}
-	log.Info("Computing deployment status analytics is over")
-	return fmt.Sprintf("%v projects updated, %v not updated because an error occurred. List of projects in error [%v]",
-		updatedProjects, len(projectsInError), strings.Join(projectsInError, ",")), nil
 }
 
-// jobDeploy execute deployment statistics
-func jobDeploy(scheduler cron.Schedule) {
-	message, err := ExecuteDeploymentStatusAnalytics()
+// ApplyDeploymentChanges saves the changes approved among the ones proposed by PreviewDeploymentStatusAnalytics.
+// The changes are recorded in the history of the projects as made by the user who approved them.
+func ApplyDeploymentChanges(diffs []types.DeploymentDiff, username string) (types.BulkUpdateMatrixResults, error) {
+	database, err := mongo.Get()
 	if err != nil {
-		log.WithError(err).Error("Could not execute deployment status analatics")
-	} else {
-		log.Info(message)
+		log.WithError(err).Error("Unable to connect to the database. Deployment changes are not applied.")
+		return types.BulkUpdateMatrixResults{}, err
 	}
-	log.Infof("Deployment indicators will computed next at %s", scheduler.Next(time.Now()))
+	defer database.Session.Close()
+
+	return applyDeploymentChanges(mongoDeploymentStore{database: database, author: username}, diffs), nil
 }
@@ -0,0 +1,250 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/soprasteria/dad/server/docktor"
+	"github.com/soprasteria/dad/server/types"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// fakeDocktor serves groups by their ID, and tracks the number of concurrent requests
+type fakeDocktor struct {
+	mu            sync.Mutex
+	groups        map[string]docktor.GroupDocktor
+	err           error // Error of every request, when not nil
+	delay         time.Duration
+	running       int
+	maxConcurrent int
+}
+
+func (d *fakeDocktor) GetGroup(ctx context.Context, groupID string) (docktor.GroupDocktor, error) {
+	d.mu.Lock()
+	d.running++
+	if d.running > d.maxConcurrent {
+		d.maxConcurrent = d.running
+	}
+	d.mu.Unlock()
+
+	time.Sleep(d.delay)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.running--
+	if d.err != nil {
+		return docktor.GroupDocktor{}, d.err
+	}
+	group, ok := d.groups[groupID]
+	if !ok {
+		return docktor.GroupDocktor{}, errors.New("Group not found")
+	}
+	return group, nil
+}
+
+func newGroup(id string, serviceTitles ...string) docktor.GroupDocktor {
+	group := docktor.GroupDocktor{ID: id}
+	for _, title := range serviceTitles {
+		group.Containers = append(group.Containers, struct {
+			ServiceTitle string `json:"serviceTitle"`
+		}{ServiceTitle: title})
+	}
+	return group
+}
+
+// fakeStore keeps the saved projects in memory, and can reject the first save of a project as modified concurrently
+type fakeStore struct {
+	mu        sync.Mutex
+	saved     map[bson.ObjectId]types.Project
+	conflicts map[bson.ObjectId]bool
+	latest    map[bson.ObjectId]types.Project // Version of the project saved by someone else
+	history   int
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{
+		saved:     map[bson.ObjectId]types.Project{},
+		conflicts: map[bson.ObjectId]bool{},
+		latest:    map[bson.ObjectId]types.Project{},
+	}
+}
+
+func (s *fakeStore) FindProject(id bson.ObjectId) (types.Project, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.latest[id], nil
+}
+
+func (s *fakeStore) SaveProject(project types.Project) (types.Project, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conflicts[project.ID] {
+		delete(s.conflicts, project.ID)
+		return types.Project{}, types.ErrProjectVersionConflict
+	}
+	s.saved[project.ID] = project
+	return project, nil
+}
+
+func (s *fakeStore) RecordHistory(oldProject, newProject types.Project) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.history++
+	return nil
+}
+
+func TestDeploymentAnalytics(t *testing.T) {
+
+	Convey("Given projects linked to Docktor groups", t, func() {
+		jenkins := types.FunctionalService{ID: bson.NewObjectId(), Name: "Jenkins", Services: []string{"jenkins"}}
+		sonar := types.FunctionalService{ID: bson.NewObjectId(), Name: "Sonar", Services: []string{"sonarqube"}}
+		declarative := types.FunctionalService{ID: bson.NewObjectId(), Name: "Wiki", DeclarativeDeployment: true}
+		services := []types.FunctionalService{jenkins, sonar, declarative}
+
+		deployed := types.Project{
+			ID:         bson.NewObjectId(),
+			Name:       "Deployed",
+			DocktorURL: types.DocktorURL{DocktorGroupURL: "http://docktor/groups/deployed"},
+			Matrix: types.Matrix{
+				{Service: sonar.ID, Deployed: types.Deployed[0], Progress: 2},
+				{Service: declarative.ID, Deployed: types.Deployed[0]},
+			},
+		}
+		cloud := types.Project{ID: bson.NewObjectId(), Name: "Cloud", DocktorURL: types.DocktorURL{DocktorGroupURL: "http://docktor/groups/cloud"}}
+		unknown := types.Project{ID: bson.NewObjectId(), Name: "Unknown", DocktorURL: types.DocktorURL{DocktorGroupURL: "http://docktor/groups/unknown", DocktorGroupName: "unknown"}}
+		projects := []types.Project{deployed, cloud, unknown}
+
+		fakeDocktor := &fakeDocktor{groups: map[string]docktor.GroupDocktor{
+			"deployed": newGroup("deployed", "JENKINS"),
+			"cloud":    newGroup("cloud", "CLOUD", "jenkins"),
+		}}
+		store := newFakeStore()
+
+		Convey("When computing the deployment status", func() {
+			results := newDeploymentAnalytics(fakeDocktor, store, services, true, 2).run(context.Background(), projects)
+
+			Convey("Then deployed functional services are added to the matrix and others are not deployed anymore", func() {
+				So(results.String(), ShouldEqual, "1 projects updated, 1 not updated because an error occurred. List of projects in error [Unknown (docktor:unknown)]")
+				So(store.saved, ShouldHaveLength, 1)
+				So(store.saved[deployed.ID].Matrix, ShouldResemble, types.Matrix{
+					{Service: sonar.ID, Deployed: types.Deployed[-1], Progress: 0},
+					{Service: declarative.ID, Deployed: types.Deployed[0]},
+					{Service: jenkins.ID, Deployed: types.Deployed[0], Progress: 1},
+				})
+				So(store.history, ShouldEqual, 1)
+			})
+
+			Convey("Then the outcome of each project is reported", func() {
+				So(results.projects, ShouldHaveLength, 3)
+				So(results.projects[0], ShouldResemble, types.JobRunProject{Project: deployed.ID, Name: "Deployed", Outcome: types.OutcomeUpdated})
+				So(results.projects[1].Outcome, ShouldEqual, types.OutcomeUnchanged)
+				So(results.projects[2].Outcome, ShouldEqual, types.OutcomeInError)
+				So(results.projects[2].Error, ShouldNotBeEmpty)
+			})
+
+			Convey("Then projects already up to date are neither saved nor recorded in history", func() {
+				upToDate := []types.Project{store.saved[deployed.ID]}
+				store := newFakeStore()
+				results := newDeploymentAnalytics(fakeDocktor, store, services, true, 2).run(context.Background(), upToDate)
+				So(results.String(), ShouldStartWith, "0 projects updated")
+				So(results.projects[0].Outcome, ShouldEqual, types.OutcomeUnchanged)
+				So(store.saved, ShouldBeEmpty)
+				So(store.history, ShouldEqual, 0)
+			})
+		})
+
+		Convey("When previewing the deployment status", func() {
+			analytics := newDeploymentAnalytics(fakeDocktor, store, services, true, 2)
+			analytics.dryRun = true
+			results := analytics.run(context.Background(), projects)
+
+			Convey("Then the changes are returned without saving the projects", func() {
+				So(store.saved, ShouldBeEmpty)
+				So(results.diffs, ShouldHaveLength, 1)
+				So(results.diffs[0].Project, ShouldEqual, deployed.ID)
+				So(results.diffs[0].Changes, ShouldHaveLength, 2)
+				So(results.diffs[0].Changes[1].ServiceName, ShouldEqual, "Jenkins")
+			})
+
+			Convey("Then only the approved changes are applied", func() {
+				store.latest[deployed.ID] = deployed
+				approved := results.diffs[0]
+				approved.Changes = approved.Changes[1:]
+				applied := applyDeploymentChanges(store, []types.DeploymentDiff{approved})
+				So(applied.Updated, ShouldEqual, 1)
+				So(store.saved[deployed.ID].Matrix, ShouldResemble, types.Matrix{
+					{Service: sonar.ID, Deployed: types.Deployed[0], Progress: 2},
+					{Service: declarative.ID, Deployed: types.Deployed[0]},
+					{Service: jenkins.ID, Deployed: types.Deployed[0], Progress: 1},
+				})
+				So(store.history, ShouldEqual, 1)
+			})
+
+			Convey("Then changes of projects modified in the meantime are not applied", func() {
+				modified := deployed
+				modified.Matrix = types.Matrix{{Service: sonar.ID, Deployed: types.Deployed[-1]}}
+				store.latest[deployed.ID] = modified
+				applied := applyDeploymentChanges(store, results.diffs)
+				So(applied.InError, ShouldEqual, 1)
+				So(store.saved, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When a project is modified during the analytics", func() {
+			store.conflicts[deployed.ID] = true
+			modified := deployed
+			modified.Matrix = types.Matrix{{Service: jenkins.ID, Progress: 3}}
+			store.latest[deployed.ID] = modified
+			results := newDeploymentAnalytics(fakeDocktor, store, services, true, 2).run(context.Background(), projects)
+
+			Convey("Then the deployment status is applied on its latest version", func() {
+				So(results.updated, ShouldEqual, 1)
+				So(store.saved[deployed.ID].Matrix, ShouldResemble, types.Matrix{{Service: jenkins.ID, Deployed: types.Deployed[0], Progress: 3}})
+			})
+		})
+
+		Convey("When many projects are analyzed", func() {
+			fakeDocktor.delay = 5 * time.Millisecond
+			many := []types.Project{}
+			for i := 0; i < 12; i++ {
+				many = append(many, types.Project{ID: bson.NewObjectId(), DocktorURL: types.DocktorURL{DocktorGroupURL: "http://docktor/groups/deployed"}})
+			}
+			results := newDeploymentAnalytics(fakeDocktor, store, services, false, 3).run(context.Background(), many)
+
+			Convey("Then they are analyzed concurrently by the configured number of workers", func() {
+				So(results.updated, ShouldEqual, 12)
+				So(fakeDocktor.maxConcurrent, ShouldBeGreaterThan, 1)
+				So(fakeDocktor.maxConcurrent, ShouldBeLessThanOrEqualTo, 3)
+			})
+		})
+
+		Convey("When Docktor is unavailable", func() {
+			fakeDocktor.err = docktor.ErrCircuitOpen
+			results := newDeploymentAnalytics(fakeDocktor, store, services, false, 2).run(context.Background(), projects)
+
+			Convey("Then the analytics are stopped and projects are skipped", func() {
+				So(results.updated, ShouldEqual, 0)
+				So(results.projectsInError, ShouldBeEmpty)
+				So(results.skipped, ShouldEqual, 3)
+				So(results.stopReason, ShouldEqual, docktor.ErrCircuitOpen)
+				So(results.projects[0].Outcome, ShouldEqual, types.OutcomeSkipped)
+			})
+		})
+
+		Convey("When the analytics are cancelled", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			results := newDeploymentAnalytics(fakeDocktor, store, services, false, 2).run(ctx, projects)
+
+			Convey("Then no project is updated", func() {
+				So(store.saved, ShouldBeEmpty)
+				So(results.skipped, ShouldEqual, 3)
+				So(results.stopReason, ShouldEqual, context.Canceled)
+			})
+		})
+	})
+}
@@ -0,0 +1,141 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/soprasteria/dad/server/docktor"
+	"github.com/soprasteria/dad/server/mongo"
+	"github.com/soprasteria/dad/server/types"
+	"github.com/spf13/viper"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// groupNameStore saves the name of the Docktor group of a project, and records its change in the project history
+type groupNameStore interface {
+	UpdateDocktorGroupName(id bson.ObjectId, name string) error
+	RecordHistory(oldProject, newProject types.Project) error
+}
+
+// mongoGroupNameStore is the group name store backed by the database
+type mongoGroupNameStore struct {
+	database *mongo.DadMongo
+}
+
+func (s mongoGroupNameStore) UpdateDocktorGroupName(id bson.ObjectId, name string) error {
+	return s.database.Projects.UpdateDocktorGroupName(id, name)
+}
+
+func (s mongoGroupNameStore) RecordHistory(oldProject, newProject types.Project) error {
+	_, err := s.database.ProjectHistory.Record(types.UpdateAction, types.DocktorGroupsAuthor, oldProject, newProject)
+	return err
+}
+
+// refreshDocktorGroups updates the name of the Docktor group of the projects, when the group was renamed in Docktor
+// The refresh is stopped when the context is cancelled or when Docktor is unavailable, the remaining projects are skipped
+func refreshDocktorGroups(ctx context.Context, docktorAPI groupGetter, store groupNameStore, projects []types.Project) (string, []types.JobRunProject, error) {
+	outcomes := []types.JobRunProject{}
+	renamed, inError, skipped := 0, 0, 0
+	var stopReason error
+	for _, project := range projects {
+		outcome := types.JobRunProject{Project: project.ID, Name: project.Name, Outcome: types.OutcomeSkipped}
+		if stopReason == nil {
+			var err error
+			outcome.Outcome, err = refreshDocktorGroup(ctx, docktorAPI, store, project)
+			if outcome.Outcome == types.OutcomeSkipped {
+				stopReason = err
+			} else if err != nil {
+				outcome.Error = err.Error()
+			}
+		}
+		switch outcome.Outcome {
+		case types.OutcomeUpdated:
+			renamed++
+		case types.OutcomeInError:
+			inError++
+		case types.OutcomeSkipped:
+			skipped++
+		}
+		outcomes = append(outcomes, outcome)
+	}
+
+	summary := fmt.Sprintf("%v Docktor group names updated, %v not updated because an error occurred", renamed, inError)
+	if stopReason != nil {
+		summary += fmt.Sprintf(", %v skipped because the refresh was stopped (%v)", skipped, stopReason)
+	}
+	return summary, outcomes, stopReason
+}
+
+// refreshDocktorGroup updates the name of the Docktor group of a project
+// The project is skipped when the refresh has to be stopped, the error being the reason why
+func refreshDocktorGroup(ctx context.Context, docktorAPI groupGetter, store groupNameStore, project types.Project) (types.JobRunOutcome, error) {
+	if ctx.Err() != nil {
+		return types.OutcomeSkipped, ctx.Err()
+	}
+
+	groupID, err := docktor.GetGroupIDFromURL(project.DocktorGroupURL)
+	if err != nil {
+		return types.OutcomeInError, err
+	}
+	group, err := docktorAPI.GetGroup(ctx, groupID)
+	if ctx.Err() != nil {
+		return types.OutcomeSkipped, ctx.Err()
+	} else if err == docktor.ErrCircuitOpen {
+		return types.OutcomeSkipped, err
+	} else if err != nil {
+		return types.OutcomeInError, err
+	}
+
+	if group.Title == "" || group.Title == project.DocktorGroupName {
+		return types.OutcomeUnchanged, nil
+	}
+	err = store.UpdateDocktorGroupName(project.ID, group.Title)
+	if err != nil {
+		log.WithError(err).WithField("project", project.ID).Warn("Error when updating the Docktor group name of the project")
+		return types.OutcomeInError, err
+	}
+	renamed := project
+	renamed.DocktorGroupName = group.Title
+	if err = store.RecordHistory(project, renamed); err != nil {
+		log.WithError(err).WithField("project", project.ID).Warn("Error when recording the project history")
+	}
+	return types.OutcomeUpdated, nil
+}
+
+// ExecuteDocktorGroupsRefresh updates the name of the Docktor group of every project linked to Docktor, as groups can be renamed in Docktor.
+// Usage indicators are matched with projects by the name of their Docktor group, so it has to be up to date.
+func ExecuteDocktorGroupsRefresh(ctx context.Context) (string, []types.JobRunProject, error) {
+
+	log.Info("Starting to refresh Docktor group names...")
+	// Connect to mongo
+	database, err := mongo.Get()
+	if err != nil {
+		log.WithError(err).Error("Unable to connect to the database. Refresh is stopped.")
+		return "", nil, err
+	}
+	defer database.Session.Close()
+
+	projects, err := database.Projects.FindWithDocktorGroupURL()
+	if err != nil {
+		log.WithError(err).Error("Unable to find projets with docktor group url. Refresh is stopped.")
+		return "", nil, err
+	}
+
+	docktorAPI, err := docktor.Get()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"address":  viper.GetString("docktor.addr"),
+			"username": viper.GetString("docktor.user"),
+		}).WithError(err).Error("Unable to connect to Docktor. Refresh is stopped.")
+		return "", nil, err
+	}
+
+	summary, outcomes, err := refreshDocktorGroups(ctx, docktorAPI, mongoGroupNameStore{database: database}, projects)
+	if err != nil {
+		log.WithError(err).Error("Refreshing Docktor group names was stopped")
+	} else {
+		log.Info("Refreshing Docktor group names is over")
+	}
+	return summary, outcomes, err
+}
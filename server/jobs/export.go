@@ -0,0 +1,213 @@
+package jobs
+
+import (
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/matcornic/hermes"
+	"github.com/soprasteria/dad/server/email"
+	"github.com/soprasteria/dad/server/export"
+	"github.com/soprasteria/dad/server/mongo"
+	"github.com/soprasteria/dad/server/types"
+	"github.com/spf13/viper"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// maxConcurrentExports is the number of exports generated at the same time, other ones are waiting in the queue
+const maxConcurrentExports = 2
+
+// exportSlots limits the number of exports generated at the same time
+var exportSlots = make(chan struct{}, maxConcurrentExports)
+
+// ExecuteExportJob generates the file of a queued export, and stores it in database until it expires after "exports.retention" hours
+// It's meant to be called in a goroutine, as it opens its own connection to the database
+func ExecuteExportJob(id bson.ObjectId) {
+	exportSlots <- struct{}{}
+	defer func() { <-exportSlots }()
+
+	logFields := log.Fields{"exportJob": id}
+	log.WithFields(logFields).Info("Starting to generate export...")
+
+	// Open new Mongo session because function is called in a goroutine
+	database, err := mongo.Get()
+	if err != nil {
+		log.WithFields(logFields).WithError(err).Error("Unable to connect to the database. Export is stopped.")
+		return
+	}
+	defer database.Session.Close()
+
+	job, err := database.ExportJobs.Claim(id)
+	if err == mgo.ErrNotFound {
+		log.WithFields(logFields).Info("Export job is not queued anymore. Export is stopped.")
+		return
+	} else if err != nil {
+		log.WithFields(logFields).WithError(err).Error("Unable to update the export job. Export is stopped.")
+		return
+	}
+
+	stopHeartbeat := make(chan struct{})
+	go heartbeat(func() error { return database.ExportJobs.Beat(id) }, log.WithFields(logFields), stopHeartbeat)
+	job, err = generateExport(database, job)
+	close(stopHeartbeat)
+	if err != nil {
+		log.WithFields(logFields).WithError(err).Error("Unable to generate the export")
+	}
+	job = finishExport(job, err, time.Now(), time.Duration(viper.GetInt("exports.retention"))*time.Hour)
+	if _, err = database.ExportJobs.Save(job); err != nil {
+		log.WithFields(logFields).WithError(err).Error("Unable to update the export job")
+		return
+	}
+	log.WithFields(logFields).WithField("status", job.Status).Info("Generating export is over")
+
+	if job.Notify {
+		notifyExport(database, job)
+	}
+}
+
+// finishExport sets the result of the generation of an export
+// A generated file can be downloaded until the retention is over, and a failed export is kept as long to tell its error
+func finishExport(job types.ExportJob, err error, now time.Time, retention time.Duration) types.ExportJob {
+	job.Finished = &now
+	expiresAt := now.Add(retention)
+	job.ExpiresAt = &expiresAt
+	if err != nil {
+		job.Status = types.ExportFailed
+		job.Error = err.Error()
+		return job
+	}
+	job.Status = types.ExportDone
+	return job
+}
+
+// ResumeExportJobs generates the exports which were queued or being generated when DAD stopped
+// Exports are only queued in memory, so they would never be generated otherwise. Exports being generated by other
+// instances of DAD are not requeued, as long as their heartbeat goes on
+func ResumeExportJobs() {
+	database, err := mongo.Get()
+	if err != nil {
+		log.WithError(err).Error("Unable to connect to the database. Exports are not resumed.")
+		return
+	}
+	defer database.Session.Close()
+
+	if err = database.ExportJobs.Requeue(); err != nil {
+		log.WithError(err).Error("Unable to queue the interrupted exports. Exports are not resumed.")
+		return
+	}
+	jobs, err := database.ExportJobs.FindUnfinished()
+	if err != nil {
+		log.WithError(err).Error("Unable to find the queued exports. Exports are not resumed.")
+		return
+	}
+	for _, job := range jobs {
+		go ExecuteExportJob(job.ID)
+	}
+	if len(jobs) > 0 {
+		log.WithField("exports", len(jobs)).Info("Queued exports are resumed")
+	}
+}
+
+// generateExport generates the file of the export, with the rights of the user who requested it
+func generateExport(database *mongo.DadMongo, job types.ExportJob) (types.ExportJob, error) {
+	user, err := database.Users.FindByUsername(job.Username)
+	if err != nil {
+		return job, fmt.Errorf("Unable to find the user %s: %v", job.Username, err)
+	}
+	writer, err := export.GetWriter(job.Format)
+	if err != nil {
+		return job, err
+	}
+
+	exporter := export.Export{Database: database, Package: job.Package}
+	data, err := exporter.ExportForUser(user, job.Filter, job.Language, writer)
+	if err != nil {
+		return job, err
+	}
+
+	job.FileName = fmt.Sprintf("DAD-Export-%s%s", job.Created.Format("2006-01-02-150405"), writer.Extension())
+	job.ContentType = writer.ContentType()
+	return job, database.ExportJobs.WriteFile(job, data)
+}
+
+// notifyExport sends an email to the user who requested the export, to tell it's finished
+func notifyExport(database *mongo.DadMongo, job types.ExportJob) {
+	user, err := database.Users.FindByUsername(job.Username)
+	if err != nil || user.Email == "" {
+		log.WithError(err).WithField("username", job.Username).Warn("Unable to find the email of the user who requested the export")
+		return
+	}
+
+	subject, body := exportEmail(job, user, viper.GetString("server.url"))
+	err = email.Send(email.SendOptions{
+		To:      []mail.Address{{Name: user.DisplayName, Address: user.Email}},
+		Subject: subject,
+		Body:    hermes.Email{Body: body},
+	})
+	if err != nil {
+		log.WithError(err).WithField("username", job.Username).Error("Error while sending the export email")
+	}
+}
+
+// exportEmail returns the subject and the body of the email telling the user the export is finished
+// The link opens the page of DAD downloading the file, as the API requires the user to be authenticated
+func exportEmail(job types.ExportJob, user types.User, serverURL string) (string, hermes.Body) {
+	if job.Status == types.ExportFailed {
+		return "D.A.D - Export failed", hermes.Body{
+			Name:   user.DisplayName,
+			Title:  "Your export failed",
+			Intros: []string{"The export you requested could not be generated: " + job.Error},
+		}
+	}
+
+	body := hermes.Body{
+		Name:   user.DisplayName,
+		Title:  "Your export is ready",
+		Intros: []string{fmt.Sprintf("The %s file you requested can be downloaded from DAD", job.Format)},
+		Dictionary: []hermes.Entry{
+			{Key: "Download", Value: strings.TrimSuffix(serverURL, "/") + "/exports/" + job.ID.Hex()},
+		},
+	}
+	if job.ExpiresAt != nil {
+		body.Intros[0] += " until " + job.ExpiresAt.Format("02/01/2006 15:04")
+	}
+	body.Intros[0] += "."
+	return "D.A.D - Export ready", body
+}
+
+// ExecuteExportsCleanup deletes the exports whose files expired
+func ExecuteExportsCleanup() (string, error) {
+
+	log.Info("Starting to delete expired exports...")
+	// Connect to mongo
+	database, err := mongo.Get()
+	if err != nil {
+		log.WithError(err).Error("Unable to connect to the database. Cleanup is stopped.")
+		return "", err
+	}
+	defer database.Session.Close()
+
+	jobs, err := database.ExportJobs.FindExpiredBefore(time.Now())
+	if err != nil {
+		log.WithError(err).Error("Unable to find expired exports. Cleanup is stopped.")
+		return "", err
+	}
+
+	deletedExports := 0
+	exportsInError := []string{}
+	for _, job := range jobs {
+		if err = database.ExportJobs.Delete(job.ID); err != nil {
+			exportsInError = append(exportsInError, job.ID.Hex())
+			log.WithError(err).WithField("exportJob", job.ID).Warn("Error when deleting the export")
+			continue
+		}
+		deletedExports++
+	}
+
+	log.Info("Deleting expired exports is over")
+	return fmt.Sprintf("%v expired exports deleted, %v not deleted because an error occurred. List of exports in error [%v]",
+		deletedExports, len(exportsInError), strings.Join(exportsInError, ",")), nil
+}
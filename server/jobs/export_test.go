@@ -0,0 +1,60 @@
+package jobs
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/soprasteria/dad/server/types"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestFinishExport(t *testing.T) {
+
+	Convey("Given an export being generated", t, func() {
+		job := types.ExportJob{ID: bson.NewObjectId(), Status: types.ExportRunning, Format: "xlsx"}
+		now := time.Date(2017, 6, 1, 10, 0, 0, 0, time.UTC)
+
+		Convey("When it is generated", func() {
+			job = finishExport(job, nil, now, 24*time.Hour)
+
+			Convey("Then it is done and expires after the retention", func() {
+				So(job.Status, ShouldEqual, types.ExportDone)
+				So(*job.Finished, ShouldResemble, now)
+				So(*job.ExpiresAt, ShouldResemble, now.Add(24*time.Hour))
+				So(job.Error, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When its generation fails", func() {
+			job = finishExport(job, errors.New("no such user"), now, 24*time.Hour)
+
+			Convey("Then it is failed, with the error, and expires after the retention", func() {
+				So(job.Status, ShouldEqual, types.ExportFailed)
+				So(job.Error, ShouldEqual, "no such user")
+				So(*job.ExpiresAt, ShouldResemble, now.Add(24*time.Hour))
+			})
+
+			Convey("Then its email tells the export failed, without link", func() {
+				subject, body := exportEmail(job, types.User{DisplayName: "John"}, "http://dad")
+				So(subject, ShouldEqual, "D.A.D - Export failed")
+				So(body.Intros, ShouldResemble, []string{"The export you requested could not be generated: no such user"})
+				So(body.Dictionary, ShouldBeEmpty)
+			})
+		})
+	})
+
+	Convey("Given a generated export", t, func() {
+		now := time.Date(2017, 6, 1, 10, 0, 0, 0, time.UTC)
+		job := finishExport(types.ExportJob{ID: bson.NewObjectId(), Format: "csv"}, nil, now, 2*time.Hour)
+
+		Convey("Then its email links to the download page of DAD", func() {
+			subject, body := exportEmail(job, types.User{DisplayName: "John"}, "http://dad/")
+			So(subject, ShouldEqual, "D.A.D - Export ready")
+			So(body.Intros, ShouldResemble, []string{"The csv file you requested can be downloaded from DAD until 01/06/2017 12:00."})
+			So(body.Dictionary, ShouldHaveLength, 1)
+			So(body.Dictionary[0].Value, ShouldEqual, "http://dad/exports/"+job.ID.Hex())
+		})
+	})
+}
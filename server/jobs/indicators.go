@@ -0,0 +1,39 @@
+package jobs
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/soprasteria/dad/server/mongo"
+	"github.com/spf13/viper"
+)
+
+// ExecuteStaleIndicatorsCleanup deletes the usage indicators not updated for more than "tasks.indicators-cleanup.retention" days.
+// Such indicators belong to service instances which don't report their usage anymore, e.g. because they were removed.
+func ExecuteStaleIndicatorsCleanup() (string, error) {
+
+	retention := viper.GetInt("tasks.indicators-cleanup.retention")
+	if retention <= 0 {
+		return "Cleanup of stale usage indicators is disabled", nil
+	}
+
+	log.Info("Starting to delete stale usage indicators...")
+	// Connect to mongo
+	database, err := mongo.Get()
+	if err != nil {
+		log.WithError(err).Error("Unable to connect to the database. Cleanup is stopped.")
+		return "", err
+	}
+	defer database.Session.Close()
+
+	limit := time.Now().AddDate(0, 0, -retention)
+	deleted, err := database.UsageIndicators.DeleteUpdatedBefore(limit)
+	if err != nil {
+		log.WithError(err).Error("Unable to delete stale usage indicators. Cleanup is stopped.")
+		return "", err
+	}
+
+	log.Info("Deleting stale usage indicators is over")
+	return fmt.Sprintf("%v usage indicators not updated since %v deleted", deleted, limit.Format(time.RFC3339)), nil
+}
@@ -1,39 +1,222 @@
 package jobs
 
 import (
+	"errors"
+	"sync"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/robfig/cron"
+	"github.com/soprasteria/dad/server/mongo"
+	"github.com/soprasteria/dad/server/types"
 	"github.com/spf13/viper"
+	mgo "gopkg.in/mgo.v2"
 )
 
+// ErrJobDisabled is returned when a scheduled run is skipped because the job was disabled by an administrator
+var ErrJobDisabled = errors.New("Job is disabled")
+
+// Job is a background job, executed at regular time and on demand
+type Job struct {
+	Name              string   `json:"name"` // Name identifying the job and its runs
+	Description       string   `json:"description"`
+	DefaultSchedule   string   `json:"defaultSchedule"`   // Recurrence of the job when not configured (see https://godoc.org/github.com/robfig/cron)
+	ScheduleKey       string   `json:"scheduleKey"`       // Configuration key of the recurrence of the job
+	ConfigKeys        []string `json:"configKeys"`        // Other configuration keys read by the job
+	DisabledByDefault bool     `json:"disabledByDefault"` // The job is only scheduled once enabled by an administrator
+	handler           task
+	preview           preview // Computes the changes of the job without saving them, nil when the job has no dry run
+}
+
+// registry lists all the background jobs
+var registry = []Job{
+	{
+		Name:            DeploymentJob,
+		Description:     "Deployment indicators",
+		DefaultSchedule: "0 0 23 * * *",
+		ScheduleKey:     "tasks.recurrence",
+		ConfigKeys:      []string{"tasks.recurrence.updateProgress", "tasks.recurrence.workers", "docktor.addr", "docktor.user"},
+		handler:         ExecuteDeploymentStatusAnalytics,
+		preview:         PreviewDeploymentStatusAnalytics,
+	},
+	{
+		Name:            SnapshotJob,
+		Description:     "Projects snapshot",
+		DefaultSchedule: "0 0 22 * * 0",
+		ScheduleKey:     "tasks.snapshot.recurrence",
+		handler:         withoutProjects(ExecuteProjectsSnapshot),
+	},
+	{
+		Name:            PurgeJob,
+		Description:     "Archived projects purge",
+		DefaultSchedule: "0 0 3 * * *",
+		ScheduleKey:     "tasks.purge.recurrence",
+		ConfigKeys:      []string{"tasks.purge.retention"},
+		handler:         withoutProjects(ExecuteArchivedProjectsPurge),
+	},
+	{
+		Name:            ExportsCleanupJob,
+		Description:     "Expired exports cleanup",
+		DefaultSchedule: "0 0 * * * *",
+		ScheduleKey:     "tasks.exports.recurrence",
+		ConfigKeys:      []string{"exports.retention"},
+		handler:         withoutProjects(ExecuteExportsCleanup),
+	},
+	{
+		Name:            LDAPSyncJob,
+		Description:     "LDAP synchronization",
+		DefaultSchedule: "0 0 2 * * *",
+		ScheduleKey:     "tasks.ldap-sync.recurrence",
+		ConfigKeys:      []string{"ldap.address", "ldap.baseDN"},
+		handler:         withoutProjects(ExecuteLDAPSync),
+	},
+	{
+		Name:            DocktorGroupsJob,
+		Description:     "Docktor group names refresh",
+		DefaultSchedule: "0 0 1 * * *",
+		ScheduleKey:     "tasks.docktor-groups.recurrence",
+		ConfigKeys:      []string{"docktor.addr", "docktor.user"},
+		handler:         ExecuteDocktorGroupsRefresh,
+	},
+	{
+		Name:            IndicatorsCleanupJob,
+		Description:     "Stale usage indicators cleanup",
+		DefaultSchedule: "0 0 4 * * *",
+		ScheduleKey:     "tasks.indicators-cleanup.recurrence",
+		ConfigKeys:      []string{"tasks.indicators-cleanup.retention"},
+		handler:         withoutProjects(ExecuteStaleIndicatorsCleanup),
+	},
+	{
+		Name:              RemindersJob,
+		Description:       "Overdue matrix lines reminders",
+		DefaultSchedule:   "0 0 8 * * 1",
+		ScheduleKey:       "tasks.reminders.recurrence",
+		ConfigKeys:        []string{"smtp.server", "server.url"},
+		DisabledByDefault: true,
+		handler:           withoutProjects(ExecuteOverdueReminders),
+	},
+}
+
+// Jobs returns all the background jobs
+func Jobs() []Job {
+	return append([]Job{}, registry...)
+}
+
+// Lookup returns the background job with the given name
+func Lookup(name string) (Job, bool) {
+	for _, job := range registry {
+		if job.Name == name {
+			return job, true
+		}
+	}
+	return Job{}, false
+}
+
+// Schedule returns the recurrence of the job, as configured
+func (job Job) Schedule() string {
+	if recurrence := viper.GetString(job.ScheduleKey); recurrence != "" {
+		return recurrence
+	}
+	return job.DefaultSchedule
+}
+
+// IsEnabled tells whether the job is scheduled, as set at runtime by an administrator
+func (job Job) IsEnabled(database *mongo.DadMongo) (bool, error) {
+	setting, err := database.JobSettings.FindByJob(job.Name)
+	if err == mgo.ErrNotFound {
+		return !job.DisabledByDefault, nil
+	} else if err != nil {
+		return false, err
+	}
+	return setting.Enabled, nil
+}
+
+// schedules are the parsed recurrences of the scheduled jobs, by name
+var schedules = struct {
+	sync.RWMutex
+	byJob map[string]cron.Schedule
+}{byJob: map[string]cron.Schedule{}}
+
+// NextRun returns the date of the next scheduled run of the job, or nil when it is not scheduled
+func (job Job) NextRun() *time.Time {
+	schedules.RLock()
+	defer schedules.RUnlock()
+	schedule, ok := schedules.byJob[job.Name]
+	if !ok {
+		return nil
+	}
+	next := schedule.Next(time.Now())
+	return &next
+}
+
+// JobStatus is a background job, with its schedule, its state and its last run
+type JobStatus struct {
+	Job
+	Schedule string        `json:"schedule"`
+	Enabled  bool          `json:"enabled"`
+	NextRun  *time.Time    `json:"nextRun,omitempty"`
+	LastRun  *types.JobRun `json:"lastRun,omitempty"`
+}
+
+// Statuses returns the status of all the background jobs
+func Statuses(database *mongo.DadMongo) ([]JobStatus, error) {
+	statuses := []JobStatus{}
+	for _, job := range registry {
+		enabled, err := job.IsEnabled(database)
+		if err != nil {
+			return nil, err
+		}
+		status := JobStatus{Job: job, Schedule: job.Schedule(), Enabled: enabled, NextRun: job.NextRun()}
+
+		runs, err := database.JobRuns.FindRecent(job.Name, 1)
+		if err != nil {
+			return nil, err
+		}
+		if len(runs) > 0 {
+			status.LastRun = &runs[0]
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
 // RunBackgroundJobs schedules background tasks as cron jobs
 func RunBackgroundJobs() {
 
-	recurrenceCronString := viper.GetString("tasks.recurrence")
+	scheduler := cron.New()
+	for _, job := range registry {
+		scheduleJob(scheduler, job)
+	}
+	scheduler.Start()
+}
 
-	job := cron.New()
+// scheduleJob adds a job to the cron jobs, following its recurrence
+// Disabled jobs are scheduled too, as they can be enabled at runtime: their runs are skipped while they are disabled
+func scheduleJob(scheduler *cron.Cron, job Job) {
 
-	scheduler, err := cron.Parse(recurrenceCronString)
+	recurrence := job.Schedule()
+	schedule, err := cron.Parse(recurrence)
 	if err != nil {
-		log.WithError(err).WithField("Deployment indicators recurrence", recurrenceCronString).Error("Unable to parse indicators recurrence")
+		log.WithError(err).WithField(job.Description+" recurrence", recurrence).Error("Unable to parse recurrence")
 		return
 	}
 
 	log.WithFields(log.Fields{
-		"cron":          recurrenceCronString,
-		"nextExecution": scheduler.Next(time.Now()),
+		"job":           job.Description,
+		"cron":          recurrence,
+		"nextExecution": schedule.Next(time.Now()),
 	}).Info("Cron configuration")
 
-	err = job.AddFunc(recurrenceCronString, func() {
-		jobDeploy(scheduler)
+	err = scheduler.AddFunc(recurrence, func() {
+		runScheduled(job.Name)
+		log.Infof("%s will be executed next at %s", job.Description, schedule.Next(time.Now()))
 	})
-
 	if err != nil {
-		log.Warnf("Error when init background job: %s", err)
+		log.Warnf("Error when init background job %s: %s", job.Description, err)
 		return
 	}
 
-	job.Start()
+	schedules.Lock()
+	schedules.byJob[job.Name] = schedule
+	schedules.Unlock()
 }
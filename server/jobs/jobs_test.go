@@ -0,0 +1,107 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/robfig/cron"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/soprasteria/dad/server/docktor"
+	"github.com/soprasteria/dad/server/types"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestRegistry(t *testing.T) {
+
+	Convey("Given the registered jobs", t, func() {
+		Convey("Then they have a unique name, a valid default schedule and a handler", func() {
+			names := map[string]bool{}
+			for _, job := range Jobs() {
+				So(names[job.Name], ShouldBeFalse)
+				names[job.Name] = true
+				_, err := cron.Parse(job.DefaultSchedule)
+				So(err, ShouldBeNil)
+				So(job.ScheduleKey, ShouldNotBeEmpty)
+				So(job.handler, ShouldNotBeNil)
+			}
+		})
+
+		Convey("Then they can be looked up by name", func() {
+			job, ok := Lookup(DocktorGroupsJob)
+			So(ok, ShouldBeTrue)
+			So(job.Name, ShouldEqual, DocktorGroupsJob)
+			_, ok = Lookup("unknown")
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("Then only the deployment job has a dry run", func() {
+			job, _ := Lookup(DeploymentJob)
+			So(job.preview, ShouldNotBeNil)
+			_, err := StartDryRun(PurgeJob, types.ManualTrigger, "admin")
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "Job purge has no dry run")
+		})
+	})
+}
+
+// fakeGroupNames keeps the updated Docktor group names, and the history of the renamed projects, in memory
+type fakeGroupNames struct {
+	names     map[bson.ObjectId]string
+	histories []types.FieldChange
+}
+
+func (f *fakeGroupNames) UpdateDocktorGroupName(id bson.ObjectId, name string) error {
+	if name == "" {
+		return errors.New("Name is empty")
+	}
+	f.names[id] = name
+	return nil
+}
+
+func (f *fakeGroupNames) RecordHistory(oldProject, newProject types.Project) error {
+	f.histories = append(f.histories, types.DiffProjects(oldProject, newProject)...)
+	return nil
+}
+
+func TestRefreshDocktorGroups(t *testing.T) {
+
+	Convey("Given projects linked to Docktor groups", t, func() {
+		renamed := types.Project{ID: bson.NewObjectId(), Name: "Renamed", DocktorURL: types.DocktorURL{DocktorGroupURL: "http://docktor/groups/renamed", DocktorGroupName: "old"}}
+		unchanged := types.Project{ID: bson.NewObjectId(), Name: "Unchanged", DocktorURL: types.DocktorURL{DocktorGroupURL: "http://docktor/groups/unchanged", DocktorGroupName: "same"}}
+		unknown := types.Project{ID: bson.NewObjectId(), Name: "Unknown", DocktorURL: types.DocktorURL{DocktorGroupURL: "http://docktor/groups/unknown"}}
+		projects := []types.Project{renamed, unchanged, unknown}
+
+		fakeDocktor := &fakeDocktor{groups: map[string]docktor.GroupDocktor{
+			"renamed":   {ID: "renamed", Title: "new"},
+			"unchanged": {ID: "unchanged", Title: "same"},
+		}}
+		store := &fakeGroupNames{names: map[bson.ObjectId]string{}}
+
+		Convey("When refreshing their group names", func() {
+			summary, outcomes, err := refreshDocktorGroups(context.Background(), fakeDocktor, store, projects)
+			Convey("Then renamed groups are updated", func() {
+				So(err, ShouldBeNil)
+				So(summary, ShouldEqual, "1 Docktor group names updated, 1 not updated because an error occurred")
+				So(store.names, ShouldResemble, map[bson.ObjectId]string{renamed.ID: "new"})
+				So(store.histories, ShouldResemble, []types.FieldChange{{Field: "docktorURL.docktorGroupName", Old: "old", New: "new"}})
+				So(outcomes[0].Outcome, ShouldEqual, types.OutcomeUpdated)
+				So(outcomes[1].Outcome, ShouldEqual, types.OutcomeUnchanged)
+				So(outcomes[2].Outcome, ShouldEqual, types.OutcomeInError)
+			})
+		})
+
+		Convey("When Docktor is unavailable", func() {
+			fakeDocktor.err = docktor.ErrCircuitOpen
+			_, outcomes, err := refreshDocktorGroups(context.Background(), fakeDocktor, store, projects)
+			Convey("Then the refresh is stopped and projects are skipped", func() {
+				So(err, ShouldEqual, docktor.ErrCircuitOpen)
+				So(store.names, ShouldBeEmpty)
+				So(store.histories, ShouldBeEmpty)
+				for _, outcome := range outcomes {
+					So(outcome.Outcome, ShouldEqual, types.OutcomeSkipped)
+				}
+			})
+		})
+	})
+}
@@ -0,0 +1,129 @@
+package jobs
+
+import (
+	"fmt"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/soprasteria/dad/server/auth"
+	"github.com/soprasteria/dad/server/mongo"
+	"github.com/soprasteria/dad/server/types"
+	"github.com/spf13/viper"
+)
+
+// ldapSyncOutcome is what the synchronization does to a user
+type ldapSyncOutcome int
+
+const (
+	ldapSyncSkipped  ldapSyncOutcome = iota // User deactivated by an administrator, left as is
+	ldapSyncUpdated                         // Identity, role and entities updated from the directory
+	ldapSyncDisabled                        // User disabled because they left the directory
+	ldapSyncEnabled                         // User disabled when he left the directory, enabled again as he is back
+)
+
+// synchronizeUser applies the directory to a LDAP user, found in the directory when ldapUser is not nil
+// Users disabled by the synchronization are enabled again when they are back in the directory,
+// while users deactivated by an administrator are not synchronized at all
+func synchronizeUser(user types.User, ldapUser *auth.LDAPUserInfo, mappings auth.GroupMappings) (types.User, ldapSyncOutcome) {
+	if user.Disabled && user.DisabledReason != types.DisabledLeftLDAP {
+		return user, ldapSyncSkipped
+	}
+	if ldapUser == nil {
+		if user.Disabled {
+			return user, ldapSyncSkipped
+		}
+		user.Disabled = true
+		user.DisabledReason = types.DisabledLeftLDAP
+		return user, ldapSyncDisabled
+	}
+
+	auth.UpdateUserInfo(&user, auth.NewLDAPUserInfo(ldapUser, mappings))
+	if user.Disabled {
+		user.Disabled = false
+		user.DisabledReason = ""
+		return user, ldapSyncEnabled
+	}
+	return user, ldapSyncUpdated
+}
+
+// ExecuteLDAPSync updates the identity, role and entities of LDAP users from the directory.
+// Users who left the directory are disabled and logged out, and enabled again when they are back.
+func ExecuteLDAPSync() (string, error) {
+
+	if !viper.GetBool("ldap.enable") {
+		return "LDAP synchronization is disabled, as LDAP is not enabled", nil
+	}
+	conf := auth.NewLDAPConf()
+	if conf.LdapServer == "" {
+		return "LDAP synchronization is disabled, as no LDAP is configured", nil
+	}
+
+	log.Info("Starting to synchronize users with LDAP...")
+	// Connect to mongo
+	database, err := mongo.Get()
+	if err != nil {
+		log.WithError(err).Error("Unable to connect to the database. LDAP synchronization is stopped.")
+		return "", err
+	}
+	defer database.Session.Close()
+
+	users, err := database.Users.FindByProvider(types.LDAPProvider)
+	if err != nil {
+		log.WithError(err).Error("Unable to find LDAP users. LDAP synchronization is stopped.")
+		return "", err
+	}
+	usernames := []string{}
+	for _, user := range users {
+		if !user.Disabled || user.DisabledReason == types.DisabledLeftLDAP {
+			usernames = append(usernames, user.Username)
+		}
+	}
+
+	// The directory has to be fully read before disabling anyone, so that a LDAP failure does not disable all users
+	ldap := auth.NewLDAP(conf)
+	defer ldap.Close()
+	ldapUsers, err := ldap.SearchAll(usernames)
+	if err != nil {
+		log.WithError(err).Error("Unable to search users in LDAP. LDAP synchronization is stopped.")
+		return "", err
+	}
+
+	login := auth.Authentication{
+		Users:         &database.Users,
+		RefreshTokens: &database.RefreshTokens,
+		RevokedTokens: &database.RevokedTokens,
+	}
+	updatedUsers := 0
+	disabledUsers := []string{}
+	enabledUsers := []string{}
+	usersInError := []string{}
+	for _, user := range users {
+		user, outcome := synchronizeUser(user, ldapUsers[user.Username], conf.Mappings)
+		if outcome == ldapSyncSkipped {
+			continue
+		}
+		if _, err = database.Users.Save(user); err != nil {
+			usersInError = append(usersInError, user.Username)
+			log.WithError(err).WithField("username", user.Username).Warn("Error when saving the synchronized user")
+			continue
+		}
+
+		switch outcome {
+		case ldapSyncUpdated:
+			updatedUsers++
+		case ldapSyncEnabled:
+			log.WithField("username", user.Username).Info("User is back in the LDAP directory, it is enabled again")
+			enabledUsers = append(enabledUsers, user.Username)
+		case ldapSyncDisabled:
+			log.WithField("username", user.Username).Info("User left the LDAP directory, it is disabled")
+			disabledUsers = append(disabledUsers, user.Username)
+			if err = login.RevokeUser(user.Username); err != nil {
+				log.WithError(err).WithField("username", user.Username).Warn("Error when logging out the disabled user")
+			}
+		}
+	}
+
+	log.Info("Synchronizing users with LDAP is over")
+	return fmt.Sprintf("%v users updated, %v users disabled because they left the directory [%v], %v users enabled again because they are back in the directory [%v], %v not synchronized because an error occurred. List of users in error [%v]",
+		updatedUsers, len(disabledUsers), strings.Join(disabledUsers, ","), len(enabledUsers), strings.Join(enabledUsers, ","), len(usersInError), strings.Join(usersInError, ",")), nil
+}
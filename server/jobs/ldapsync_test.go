@@ -0,0 +1,59 @@
+package jobs
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/soprasteria/dad/server/auth"
+	"github.com/soprasteria/dad/server/types"
+)
+
+func TestSynchronizeUser(t *testing.T) {
+
+	Convey("Given LDAP users of DAD", t, func() {
+		inDirectory := &auth.LDAPUserInfo{Username: "jdoe", FirstName: "John", LastName: "Doe", Email: "john.doe@dad.io"}
+		active := types.User{Username: "jdoe", Provider: types.LDAPProvider}
+
+		Convey("Then an active user in the directory is updated", func() {
+			user, outcome := synchronizeUser(active, inDirectory, nil)
+			So(outcome, ShouldEqual, ldapSyncUpdated)
+			So(user.DisplayName, ShouldEqual, "John Doe")
+			So(user.Disabled, ShouldBeFalse)
+		})
+
+		Convey("Then an active user who left the directory is disabled, with the reason", func() {
+			user, outcome := synchronizeUser(active, nil, nil)
+			So(outcome, ShouldEqual, ldapSyncDisabled)
+			So(user.Disabled, ShouldBeTrue)
+			So(user.DisabledReason, ShouldEqual, types.DisabledLeftLDAP)
+		})
+
+		Convey("Then a user who left the directory is enabled again when back", func() {
+			left := active
+			left.Disabled, left.DisabledReason = true, types.DisabledLeftLDAP
+			user, outcome := synchronizeUser(left, inDirectory, nil)
+			So(outcome, ShouldEqual, ldapSyncEnabled)
+			So(user.Disabled, ShouldBeFalse)
+			So(user.DisabledReason, ShouldBeEmpty)
+
+			Convey("And left as is while still out of the directory", func() {
+				_, outcome := synchronizeUser(left, nil, nil)
+				So(outcome, ShouldEqual, ldapSyncSkipped)
+			})
+		})
+
+		Convey("Then a user deactivated by an administrator is never enabled again", func() {
+			deactivated := active
+			deactivated.Disabled, deactivated.DisabledReason = true, types.DisabledByAdmin
+			user, outcome := synchronizeUser(deactivated, inDirectory, nil)
+			So(outcome, ShouldEqual, ldapSyncSkipped)
+			So(user, ShouldResemble, deactivated)
+
+			Convey("Even without reason, when deactivated before reasons were recorded", func() {
+				deactivated.DisabledReason = ""
+				_, outcome := synchronizeUser(deactivated, inDirectory, nil)
+				So(outcome, ShouldEqual, ldapSyncSkipped)
+			})
+		})
+	})
+}
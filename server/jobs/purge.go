@@ -0,0 +1,93 @@
+package jobs
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/soprasteria/dad/server/mongo"
+	"github.com/soprasteria/dad/server/types"
+	"github.com/spf13/viper"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// ExecuteArchivedProjectsPurge permanently deletes the projects archived for more than "tasks.purge.retention" days.
+// Once purged, a project can't be restored anymore, but its history is kept.
+func ExecuteArchivedProjectsPurge() (string, error) {
+
+	retention := viper.GetInt("tasks.purge.retention")
+	if retention <= 0 {
+		return "Purge of archived projects is disabled", nil
+	}
+
+	log.Info("Starting to purge archived projects...")
+	// Connect to mongo
+	database, err := mongo.Get()
+	if err != nil {
+		log.WithError(err).Error("Unable to connect to the database. Purge is stopped.")
+		return "", err
+	}
+	defer database.Session.Close()
+
+	return purgeArchivedProjects(mongoPurgeStore{database: database}, purgeLimit(time.Now(), retention))
+}
+
+// purgeStore finds and deletes the archived projects purged by the job
+type purgeStore interface {
+	FindArchivedBefore(date time.Time) ([]types.Project, error)
+	DeleteProject(id bson.ObjectId) error
+	RecordPurge(project types.Project) error
+}
+
+// mongoPurgeStore is the purge store backed by the database
+type mongoPurgeStore struct {
+	database *mongo.DadMongo
+}
+
+func (s mongoPurgeStore) FindArchivedBefore(date time.Time) ([]types.Project, error) {
+	return s.database.Projects.FindArchivedBefore(date)
+}
+
+func (s mongoPurgeStore) DeleteProject(id bson.ObjectId) error {
+	_, err := s.database.Projects.Delete(id)
+	return err
+}
+
+func (s mongoPurgeStore) RecordPurge(project types.Project) error {
+	_, err := s.database.ProjectHistory.Record(types.PurgeAction, types.PurgeJobAuthor, project, types.Project{})
+	return err
+}
+
+// purgeArchivedProjects deletes the projects archived before the limit, and records their purge in their history
+// A project failing to be deleted doesn't stop the purge of the other ones
+func purgeArchivedProjects(store purgeStore, limit time.Time) (string, error) {
+	projects, err := store.FindArchivedBefore(limit)
+	if err != nil {
+		log.WithError(err).Error("Unable to find archived projects. Purge is stopped.")
+		return "", err
+	}
+
+	purgedProjects := 0
+	projectsInError := []string{}
+	for _, project := range projects {
+		if err = store.DeleteProject(project.ID); err != nil {
+			projectsInError = append(projectsInError, project.Name)
+			log.WithError(err).WithField("project", project.ID).Warn("Error when purging the project")
+			continue
+		}
+		if err = store.RecordPurge(project); err != nil {
+			log.WithError(err).WithField("project", project.ID).Warn("Error when recording the project history")
+		}
+		purgedProjects++
+	}
+
+	log.Info("Purging archived projects is over")
+	return fmt.Sprintf("%v projects archived before %v purged, %v not purged because an error occurred. List of projects in error [%v]",
+		purgedProjects, limit.Format(time.RFC3339), len(projectsInError), strings.Join(projectsInError, ",")), nil
+}
+
+// purgeLimit returns the date before which archived projects are purged, retention days ago
+func purgeLimit(now time.Time, retention int) time.Time {
+	return now.AddDate(0, 0, -retention)
+}
@@ -0,0 +1,93 @@
+package jobs
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/soprasteria/dad/server/types"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// fakePurgeStore keeps the projects in memory, and records the deleted projects and the history of their purge
+type fakePurgeStore struct {
+	projects  []types.Project
+	failing   map[bson.ObjectId]bool // Projects failing to be deleted
+	deleted   []bson.ObjectId
+	histories []string
+	queried   time.Time
+}
+
+func (f *fakePurgeStore) FindArchivedBefore(date time.Time) ([]types.Project, error) {
+	f.queried = date
+	projects := []types.Project{}
+	for _, project := range f.projects {
+		if project.Archived && project.ArchivedAt.Before(date) {
+			projects = append(projects, project)
+		}
+	}
+	return projects, nil
+}
+
+func (f *fakePurgeStore) DeleteProject(id bson.ObjectId) error {
+	if f.failing[id] {
+		return errors.New("Delete failed")
+	}
+	f.deleted = append(f.deleted, id)
+	return nil
+}
+
+func (f *fakePurgeStore) RecordPurge(project types.Project) error {
+	f.histories = append(f.histories, project.Name)
+	return nil
+}
+
+func TestArchivedProjectsPurge(t *testing.T) {
+
+	Convey("Given a retention of archived projects", t, func() {
+		now := time.Date(2017, 3, 1, 3, 0, 0, 0, time.UTC)
+
+		Convey("Then projects archived before the retention in days are purged", func() {
+			So(purgeLimit(now, 30), ShouldResemble, time.Date(2017, 1, 30, 3, 0, 0, 0, time.UTC))
+		})
+
+		Convey("Then the retention is counted in calendar days, whatever the length of months", func() {
+			So(purgeLimit(now, 1), ShouldResemble, time.Date(2017, 2, 28, 3, 0, 0, 0, time.UTC))
+		})
+	})
+
+	Convey("Given active projects, and projects archived before and after the limit", t, func() {
+		limit := time.Date(2017, 1, 30, 3, 0, 0, 0, time.UTC)
+		longAgo, recently := limit.AddDate(0, 0, -1), limit.AddDate(0, 0, 1)
+		active := types.Project{ID: bson.NewObjectId(), Name: "active"}
+		old := types.Project{ID: bson.NewObjectId(), Name: "old", Archived: true, ArchivedAt: &longAgo}
+		older := types.Project{ID: bson.NewObjectId(), Name: "older", Archived: true, ArchivedAt: &longAgo}
+		recent := types.Project{ID: bson.NewObjectId(), Name: "recent", Archived: true, ArchivedAt: &recently}
+		store := &fakePurgeStore{projects: []types.Project{active, old, older, recent}, failing: map[bson.ObjectId]bool{}}
+
+		Convey("When purging them", func() {
+			summary, err := purgeArchivedProjects(store, limit)
+
+			Convey("Then only the projects archived before the limit are deleted, with their purge recorded in their history", func() {
+				So(err, ShouldBeNil)
+				So(store.queried, ShouldResemble, limit)
+				So(store.deleted, ShouldResemble, []bson.ObjectId{old.ID, older.ID})
+				So(store.histories, ShouldResemble, []string{"old", "older"})
+				So(summary, ShouldStartWith, "2 projects archived before 2017-01-30T03:00:00Z purged, 0 not purged")
+			})
+		})
+
+		Convey("When a project fails to be deleted", func() {
+			store.failing[old.ID] = true
+			summary, err := purgeArchivedProjects(store, limit)
+
+			Convey("Then the other projects are purged, and the project in error is reported without history", func() {
+				So(err, ShouldBeNil)
+				So(store.deleted, ShouldResemble, []bson.ObjectId{older.ID})
+				So(store.histories, ShouldResemble, []string{"older"})
+				So(summary, ShouldEqual, "1 projects archived before 2017-01-30T03:00:00Z purged, 1 not purged because an error occurred. List of projects in error [old]")
+			})
+		})
+	})
+}
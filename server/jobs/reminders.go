@@ -0,0 +1,106 @@
+package jobs
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/matcornic/hermes"
+	"github.com/soprasteria/dad/server/email"
+	"github.com/soprasteria/dad/server/mongo"
+	"github.com/soprasteria/dad/server/types"
+	"github.com/spf13/viper"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// ExecuteOverdueReminders sends an email to the project manager of every project having overdue matrix lines,
+// i.e. lines whose due date is over while their goal is not reached.
+func ExecuteOverdueReminders() (string, error) {
+
+	log.Info("Starting to send reminders of overdue matrix lines...")
+	// Connect to mongo
+	database, err := mongo.Get()
+	if err != nil {
+		log.WithError(err).Error("Unable to connect to the database. Reminders are stopped.")
+		return "", err
+	}
+	defer database.Session.Close()
+
+	projects, err := database.Projects.FindAll()
+	if err != nil {
+		log.WithError(err).Error("Unable to find projects. Reminders are stopped.")
+		return "", err
+	}
+
+	functionalServices, err := database.FunctionalServices.FindAll()
+	if err != nil {
+		log.WithError(err).Error("Unable to find functional services. Reminders are stopped.")
+		return "", err
+	}
+	servicesByID := map[bson.ObjectId]types.FunctionalService{}
+	for _, service := range functionalServices {
+		servicesByID[service.ID] = service
+	}
+
+	now := time.Now()
+	remindedProjects := 0
+	projectsInError := []string{}
+	for _, project := range projects {
+		overdue := project.Matrix.Overdue(now)
+		if len(overdue) == 0 {
+			continue
+		}
+		err = sendReminder(database, project, overdue, servicesByID)
+		if err != nil {
+			projectsInError = append(projectsInError, project.Name)
+			log.WithError(err).WithField("project", project.ID).Warn("Error when sending the reminder of the project")
+			continue
+		}
+		remindedProjects++
+	}
+
+	log.Info("Sending reminders of overdue matrix lines is over")
+	return fmt.Sprintf("%v project managers reminded of overdue matrix lines, %v not reminded because an error occurred. List of projects in error [%v]",
+		remindedProjects, len(projectsInError), strings.Join(projectsInError, ",")), nil
+}
+
+// sendReminder sends the overdue matrix lines of a project to its project manager
+func sendReminder(database *mongo.DadMongo, project types.Project, overdue types.Matrix, servicesByID map[bson.ObjectId]types.FunctionalService) error {
+	if project.ProjectManager == "" {
+		return errors.New("Project has no project manager")
+	}
+	projectManager, err := database.Users.FindByID(project.ProjectManager)
+	if err != nil {
+		return fmt.Errorf("Unable to find the project manager: %v", err)
+	}
+	if projectManager.Email == "" {
+		return fmt.Errorf("Project manager %v has no email", projectManager.Username)
+	}
+
+	lines := []hermes.Entry{}
+	for _, line := range overdue {
+		lines = append(lines, hermes.Entry{
+			Key:   servicesByID[line.Service].Name,
+			Value: fmt.Sprintf("due on %s, progress %s for a goal of %s", line.DueDate.Format("02/01/2006"), types.Progress[line.Progress], types.Progress[line.TargetGoal()]),
+		})
+	}
+
+	return email.Send(email.SendOptions{
+		To:      []mail.Address{{Name: projectManager.DisplayName, Address: projectManager.Email}},
+		Subject: "D.A.D - Overdue deployment plan of " + project.Name,
+		Body: hermes.Email{Body: hermes.Body{
+			Name:  projectManager.DisplayName,
+			Title: "Project " + project.Name + " is late on its deployment plan",
+			Intros: []string{
+				"The following functional services have not reached their goal by their due date.",
+			},
+			Dictionary: lines,
+			Outros: []string{
+				"The deployment plan can be updated from " + strings.TrimSuffix(viper.GetString("server.url"), "/") + "/projects/" + project.ID.Hex(),
+			},
+		}},
+	})
+}
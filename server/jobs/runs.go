@@ -0,0 +1,193 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/soprasteria/dad/server/mongo"
+	"github.com/soprasteria/dad/server/types"
+)
+
+// Names of the jobs, identifying their runs
+const (
+	DeploymentJob        = "deployment-indicators"
+	SnapshotJob          = "snapshots"
+	PurgeJob             = "purge"
+	ExportsCleanupJob    = "exports-cleanup"
+	LDAPSyncJob          = "ldap-sync"
+	DocktorGroupsJob     = "docktor-groups"
+	IndicatorsCleanupJob = "indicators-cleanup"
+	RemindersJob         = "reminders"
+)
+
+// task executes a job, and returns its summary and the outcome of each project it processed
+type task func(ctx context.Context) (string, []types.JobRunProject, error)
+
+// preview computes the changes a job would make to the projects, without saving them
+type preview func(ctx context.Context) ([]types.DeploymentDiff, error)
+
+// withoutProjects adapts a job which doesn't report the outcome of each project
+func withoutProjects(execute func() (string, error)) task {
+	return func(ctx context.Context) (string, []types.JobRunProject, error) {
+		summary, err := execute()
+		return summary, nil, err
+	}
+}
+
+// Run executes a job and records its run in database
+// It fails with types.ErrJobRunning when the job is already running, on this instance of DAD or another one.
+// Scheduled runs fail with ErrJobDisabled while the job is disabled, manual runs are always executed.
+// The run returned is the finished run, along with the error of the job when it failed.
+func Run(ctx context.Context, job string, trigger types.JobTrigger, username string) (types.JobRun, error) {
+	return runJob(ctx, types.NewJobRun(job, trigger, username))
+}
+
+// RunDryRun computes the changes of a job without saving them, and records it as a run of the job
+// As a run of the job, it can't be executed while the job is running. The proposed changes are stored on the run.
+func RunDryRun(ctx context.Context, job string, trigger types.JobTrigger, username string) (types.JobRun, error) {
+	return runJob(ctx, newDryRun(job, trigger, username))
+}
+
+// Start executes a job in background, and returns its run as soon as it is started
+// The job is not stopped with the caller, e.g. when the request starting it is cancelled: its result is read from the run in database.
+// It fails like Run when the job can't be started.
+func Start(job string, trigger types.JobTrigger, username string) (types.JobRun, error) {
+	return startJob(types.NewJobRun(job, trigger, username))
+}
+
+// StartDryRun computes in background the changes of a job without saving them, like RunDryRun
+func StartDryRun(job string, trigger types.JobTrigger, username string) (types.JobRun, error) {
+	return startJob(newDryRun(job, trigger, username))
+}
+
+func newDryRun(job string, trigger types.JobTrigger, username string) types.JobRun {
+	run := types.NewJobRun(job, trigger, username)
+	run.DryRun = true
+	return run
+}
+
+func runJob(ctx context.Context, run types.JobRun) (types.JobRun, error) {
+	registered, database, run, err := begin(run)
+	if err != nil {
+		return types.JobRun{}, err
+	}
+	defer database.Session.Close()
+	return execute(ctx, database, registered, run)
+}
+
+func startJob(run types.JobRun) (types.JobRun, error) {
+	registered, database, run, err := begin(run)
+	if err != nil {
+		return types.JobRun{}, err
+	}
+	go func() {
+		defer database.Session.Close()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		finished, err := execute(ctx, database, registered, run)
+		if err != nil {
+			log.WithError(err).WithField("run", finished.ID.Hex()).Error("Job run failed")
+		}
+	}()
+	return run, nil
+}
+
+// begin records the start of a run, unless the job is already running or is disabled
+// The returned database session is kept open for the run, and has to be closed by the caller
+func begin(run types.JobRun) (Job, *mongo.DadMongo, types.JobRun, error) {
+	job, trigger, username := run.Job, run.Trigger, run.Username
+	registered, ok := Lookup(job)
+	if !ok {
+		return Job{}, nil, types.JobRun{}, fmt.Errorf("Job %v does not exist", job)
+	}
+	if run.DryRun && registered.preview == nil {
+		return Job{}, nil, types.JobRun{}, fmt.Errorf("Job %v has no dry run", job)
+	}
+
+	database, err := mongo.Get()
+	if err != nil {
+		log.WithError(err).WithField("job", job).Error("Unable to connect to the database. Job is not executed.")
+		return Job{}, nil, types.JobRun{}, err
+	}
+
+	if trigger == types.CronTrigger {
+		enabled, err := registered.IsEnabled(database)
+		if err != nil {
+			database.Session.Close()
+			return Job{}, nil, types.JobRun{}, err
+		}
+		if !enabled {
+			database.Session.Close()
+			return Job{}, nil, types.JobRun{}, ErrJobDisabled
+		}
+	}
+
+	run, err = database.JobRuns.Start(run)
+	if err != nil {
+		database.Session.Close()
+		return Job{}, nil, types.JobRun{}, err
+	}
+	log.WithFields(log.Fields{
+		"job":      job,
+		"run":      run.ID.Hex(),
+		"trigger":  trigger,
+		"username": username,
+	}).Info("Job run started")
+	return registered, database, run, nil
+}
+
+// execute runs the handler of a started job, and records the end of its run
+func execute(ctx context.Context, database *mongo.DadMongo, registered Job, run types.JobRun) (types.JobRun, error) {
+	stopHeartbeat := make(chan struct{})
+	go heartbeat(func() error { return database.JobRuns.Beat(run.ID) }, log.WithField("run", run.ID.Hex()), stopHeartbeat)
+
+	var summary string
+	var projects []types.JobRunProject
+	var err error
+	if run.DryRun {
+		run.Diffs, err = registered.preview(ctx)
+		summary = fmt.Sprintf("%v projects would be modified", len(run.Diffs))
+	} else {
+		summary, projects, err = registered.handler(ctx)
+	}
+	close(stopHeartbeat)
+
+	run = run.Finish(summary, projects, err, ctx.Err() != nil)
+	if finishErr := database.JobRuns.Finish(run); finishErr != nil {
+		log.WithError(finishErr).WithField("run", run.ID.Hex()).Error("Unable to record the end of the job run")
+	}
+	return run, err
+}
+
+// heartbeat signals a job run or an export is alive until it is stopped, so that other instances don't consider it abandoned
+func heartbeat(beat func() error, logger *log.Entry, stop <-chan struct{}) {
+	ticker := time.NewTicker(types.JobRunHeartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := beat(); err != nil {
+				logger.WithError(err).Warn("Unable to update the heartbeat")
+			}
+		}
+	}
+}
+
+// runScheduled executes a job triggered by its recurrence, and logs its result
+func runScheduled(job string) {
+	run, err := Run(context.Background(), job, types.CronTrigger, "")
+	switch {
+	case err == types.ErrJobRunning:
+		log.WithField("job", job).Info("Job is already running, the scheduled run is skipped")
+	case err == ErrJobDisabled:
+		log.WithField("job", job).Info("Job is disabled, the scheduled run is skipped")
+	case err != nil:
+		log.WithError(err).WithField("job", job).Error("Could not execute job")
+	default:
+		log.WithField("job", job).Info(run.Summary)
+	}
+}
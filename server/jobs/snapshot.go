@@ -0,0 +1,52 @@
+package jobs
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/soprasteria/dad/server/mongo"
+	"github.com/soprasteria/dad/server/types"
+)
+
+// ExecuteProjectsSnapshot freezes the matrix and usage indicators of every project, so that their maturity can be followed over time.
+// All the snapshots of a single execution share the same date.
+func ExecuteProjectsSnapshot() (string, error) {
+
+	log.Info("Starting to snapshot projects maturity...")
+	// Connect to mongo
+	database, err := mongo.Get()
+	if err != nil {
+		log.WithError(err).Error("Unable to connect to the database. Snapshot is stopped.")
+		return "", err
+	}
+	defer database.Session.Close()
+
+	projects, err := database.Projects.FindAll()
+	if err != nil {
+		log.WithError(err).Error("Unable to find projects. Snapshot is stopped.")
+		return "", err
+	}
+
+	date := time.Now()
+	snapshots := []types.ProjectSnapshot{}
+	for _, project := range projects {
+		usageIndicators := []types.UsageIndicator{}
+		if project.DocktorGroupName != "" {
+			usageIndicators, err = database.UsageIndicators.FindAllFromGroup(project.DocktorGroupName)
+			if err != nil {
+				log.WithError(err).WithField("project", project.ID).Warn("Error while retrieving usage indicators, project is snapshotted without them")
+			}
+		}
+		snapshots = append(snapshots, types.NewProjectSnapshot(project, usageIndicators, date))
+	}
+
+	err = database.ProjectSnapshots.BulkInsert(snapshots)
+	if err != nil {
+		log.WithError(err).Error("Unable to save projects snapshots")
+		return "", err
+	}
+
+	log.Info("Snapshotting projects maturity is over")
+	return fmt.Sprintf("%v projects snapshotted at %v", len(snapshots), date.Format(time.RFC3339)), nil
+}
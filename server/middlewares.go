@@ -3,6 +3,8 @@ package server
 import (
 	"fmt"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/soprasteria/dad/server/auth"
@@ -43,50 +45,136 @@ func sessionMongo(next echo.HandlerFunc) echo.HandlerFunc {
 	}
 }
 
-func openLDAP(next echo.HandlerFunc) echo.HandlerFunc {
-	return func(c echo.Context) error {
-		address := viper.GetString("ldap.address")
-		baseDN := viper.GetString("ldap.baseDN")
-		bindDN := viper.GetString("ldap.bindDN")
-		bindPassword := viper.GetString("ldap.bindPassword")
-		searchFilter := viper.GetString("ldap.searchFilter")
-		usernameAttribute := viper.GetString("ldap.attr.username")
-		firstnameAttribute := viper.GetString("ldap.attr.firstname")
-		lastnameAttribute := viper.GetString("ldap.attr.lastname")
-		realNameAttribute := viper.GetString("ldap.attr.realname")
-		emailAttribute := viper.GetString("ldap.attr.email")
-
-		if address == "" {
-			panic("No LDAP configured. This application requires to have a LDAP configured")
+// newLDAP creates the LDAP entrypoint from configuration
+func newLDAP() *auth.LDAP {
+	conf := auth.NewLDAPConf()
+	if conf.LdapServer == "" {
+		panic("No LDAP configured. This application requires to have a LDAP configured")
+	}
+	log.Info("Connected to LDAP : ", conf.LdapServer)
+	return auth.NewLDAP(conf)
+}
+
+// withLDAP enriches the echo context with the LDAP entrypoint
+// The same entrypoint is shared by all requests, so that LDAP connections are reused
+func withLDAP(ldap *auth.LDAP) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Set("ldap", ldap)
+			return next(c)
+		}
+	}
+}
+
+// newPolicy reads the permissions of the roles from configuration
+// Permissions can only be given in the configuration file, as a [permissions] table listing the permissions of each role
+func newPolicy() types.Policy {
+	config := map[string][]string{}
+	if err := viper.UnmarshalKey("permissions", &config); err != nil {
+		panic(fmt.Sprintf("Invalid permissions configuration: %v", err))
+	}
+	policy, err := types.DefaultPolicy().WithConfig(config)
+	if err != nil {
+		panic(fmt.Sprintf("Invalid permissions configuration: %v", err))
+	}
+	return policy
+}
+
+// newOIDC creates the OpenID Connect client from configuration
+func newOIDC() *auth.OIDC {
+	log.Info("OpenID Connect authentication with : ", viper.GetString("oidc.issuer"))
+	return auth.NewOIDC(&auth.OIDCConf{
+		Issuer:        viper.GetString("oidc.issuer"),
+		ClientID:      viper.GetString("oidc.client-id"),
+		ClientSecret:  viper.GetString("oidc.client-secret"),
+		RedirectURL:   viper.GetString("oidc.redirect-url"),
+		Scopes:        viper.GetStringSlice("oidc.scopes"),
+		UsernameClaim: viper.GetString("oidc.username-claim"),
+		StateSecret:   viper.GetString("oidc.state-secret"),
+	})
+}
+
+// withOIDC enriches the echo context with the OpenID Connect client
+// The same client is shared by all requests, so that the configuration of the identity provider is only discovered once
+func withOIDC(oidc *auth.OIDC) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Set("oidc", oidc)
+			return next(c)
 		}
+	}
+}
+
+// apiTokenScopes lists the routes reachable with an API token, with the scope the token requires
+// Other routes can only be reached with an access token
+var apiTokenScopes = map[string]string{
+	"POST /api/usage-indicators/import": types.ScopeIndicatorsWrite,
+	"GET /api/export":                   types.ScopeExportRead,
+	"GET /api/export/jobs":              types.ScopeExportRead,
+	"POST /api/export/jobs":             types.ScopeExportRead,
+	"GET /api/export/jobs/:id":          types.ScopeExportRead,
+	"GET /api/export/jobs/:id/download": types.ScopeExportRead,
+	"GET /api/projects":                 types.ScopeProjectsRead,
+	"GET /api/projects/:id":             types.ScopeProjectsRead,
+	"GET /api/projects/:id/indicators":  types.ScopeProjectsRead,
+	"GET /api/projects/:id/history":     types.ScopeProjectsRead,
+	"GET /api/projects/:id/trend":       types.ScopeProjectsRead,
+	"GET /api/projects/:id/members":     types.ScopeProjectsRead,
+	"GET /api/trends":                   types.ScopeProjectsRead,
+}
 
-		// Enrich the echo context with LDAP configuration
-		log.Info("Connected to LDAP : ", address)
-
-		ldap := auth.NewLDAP(&auth.LDAPConf{
-			LdapServer:   address,
-			BaseDN:       baseDN,
-			BindDN:       bindDN,
-			BindPassword: bindPassword,
-			SearchFilter: searchFilter,
-			Attr: auth.Attributes{
-				Username:  usernameAttribute,
-				Firstname: firstnameAttribute,
-				Lastname:  lastnameAttribute,
-				Realname:  realNameAttribute,
-				Email:     emailAttribute,
-			},
-		})
-
-		c.Set("ldap", ldap)
+// bearerAPIToken returns the API token given in the Authorization header, if any
+func bearerAPIToken(c echo.Context) (string, bool) {
+	token := strings.TrimPrefix(c.Request().Header.Get(echo.HeaderAuthorization), "Bearer ")
+	return token, strings.HasPrefix(token, auth.APITokenPrefix)
+}
 
+// isAPITokenRequest tells the JWT middleware to skip requests authenticated with an API token
+func isAPITokenRequest(c echo.Context) bool {
+	_, ok := bearerAPIToken(c)
+	return ok
+}
+
+// getAPITokenUser enriches the echo context with the owner of the API token, when the token has the scope required by the route
+func getAPITokenUser(next echo.HandlerFunc, c echo.Context) error {
+	// The token was already checked by a parent group
+	if c.Get("apitoken") != nil {
 		return next(c)
+	}
+	token, _ := bearerAPIToken(c)
+	database := c.Get("database").(*mongo.DadMongo)
 
+	apiToken, err := database.APITokens.FindByHash(auth.HashAPIToken(token))
+	if err != nil || apiToken.IsExpired() {
+		return c.JSON(http.StatusUnauthorized, types.NewErr("Invalid or expired API token"))
 	}
+	scope, ok := apiTokenScopes[c.Request().Method+" "+c.Path()]
+	if !ok || !apiToken.HasScope(scope) {
+		return c.JSON(http.StatusForbidden, types.NewErr(fmt.Sprintf("API token %q is not allowed to reach this API", apiToken.Name)))
+	}
+
+	user, err := database.Users.FindByUsername(apiToken.Username)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, types.NewErr(fmt.Sprintf("The owner %q of the API token has been removed", apiToken.Username)))
+	}
+	if user.Disabled {
+		return c.JSON(http.StatusUnauthorized, types.NewErr(fmt.Sprintf("The owner %q of the API token is disabled", apiToken.Username)))
+	}
+	if err = database.APITokens.Touch(apiToken.ID); err != nil {
+		log.WithError(err).WithField("name", apiToken.Name).Warn("Can't record the last use of the API token")
+	}
+
+	c.Set("apitoken", apiToken)
+	c.Set("authuser", user)
+	return next(c)
 }
 
 func getAuthenticatedUser(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
+		if isAPITokenRequest(c) {
+			return getAPITokenUser(next, c)
+		}
+
 		// Get api from context
 		userToken := c.Get("user-token").(*jwt.Token)
 		database := c.Get("database").(*mongo.DadMongo)
@@ -94,12 +182,27 @@ func getAuthenticatedUser(next echo.HandlerFunc) echo.HandlerFunc {
 		// Parse the token
 		claims := userToken.Claims.(*auth.MyCustomClaims)
 
+		// Tokens without ID can't be revoked, they are not accepted
+		if claims.Id == "" {
+			return c.JSON(http.StatusUnauthorized, types.NewErr(auth.ErrInvalidAccessToken.Error()))
+		}
+		revoked, err := database.RevokedTokens.IsRevoked(claims.Id, claims.Username, time.Unix(claims.IssuedAt, 0))
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, types.NewErr(fmt.Sprintf("Can't check the revocation of the token: %v", err)))
+		}
+		if revoked {
+			return c.JSON(http.StatusUnauthorized, types.NewErr("Your session has been revoked. Please login again"))
+		}
+
 		// Get the user from database
 		user, err := database.Users.FindByUsername(claims.Username)
 		if err != nil {
 			// Will logout the user automatically, as server considers the token to be invalid
 			return c.JSON(http.StatusUnauthorized, types.NewErr(fmt.Sprintf("Your account %q has been removed. Please create a new one.", claims.Username)))
 		}
+		if user.Disabled {
+			return c.JSON(http.StatusUnauthorized, types.NewErr(auth.ErrUserDisabled.Error()))
+		}
 
 		c.Set("authuser", user)
 
@@ -108,30 +211,21 @@ func getAuthenticatedUser(next echo.HandlerFunc) echo.HandlerFunc {
 	}
 }
 
-// hasRole is a middleware checking if the currently authenticated users has sufficient privileges to reach a route
-func hasRole(role types.Role) func(next echo.HandlerFunc) echo.HandlerFunc {
+// hasPermission is a middleware checking if the currently authenticated users has the permission to reach a route
+func hasPermission(permission types.Permission) func(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
 			// Get user from context
 			user := c.Get("authuser").(types.User)
 
-			// Check if the user has at least the required role
+			// Check if the role of the user gives the permission
 			log.WithFields(log.Fields{
-				"username":     user.Username,
-				"userRole":     user.Role,
-				"requiredRole": role,
+				"username":           user.Username,
+				"userRole":           user.Role,
+				"requiredPermission": permission,
 			}).Info("Checking if user has correct privileges")
 
-			switch role {
-			case types.AdminRole:
-				if user.Role == types.AdminRole {
-					return next(c)
-				}
-			case types.RIRole:
-				if user.Role == types.AdminRole || user.Role == types.RIRole {
-					return next(c)
-				}
-			case types.PMRole, types.DeputyRole:
+			if user.Can(permission) {
 				return next(c)
 			}
 
@@ -172,17 +266,20 @@ func getProject(id string) func(next echo.HandlerFunc) echo.HandlerFunc {
 			}).Info("User trying to retrieve a project")
 
 			project, err := database.Projects.FindByID(id)
-			if err != nil || project.ID.Hex() == "" {
+			if err != nil || project.ID.Hex() == "" || project.Archived {
 				return c.JSON(http.StatusNotFound, types.NewErr(fmt.Sprintf("Project not found %v", id)))
 			}
 
-			userProjects, err := database.Projects.FindForUser(authUser)
-			if err != nil {
-				return c.JSON(http.StatusInternalServerError, types.NewErr(fmt.Sprintf("Error while retrieving the projects of the user %s", authUser.Username)))
-			}
+			// Members see the project, other users depend on their permissions
+			if project.MemberRole(authUser.ID) == "" {
+				userProjects, err := database.Projects.FindForUser(authUser)
+				if err != nil {
+					return c.JSON(http.StatusInternalServerError, types.NewErr(fmt.Sprintf("Error while retrieving the projects of the user %s", authUser.Username)))
+				}
 
-			if !userProjects.ContainsBsonID(project.ID) {
-				return c.JSON(http.StatusForbidden, types.NewErr(fmt.Sprintf("User %s cannot see the project %s", authUser.Username, project.ID)))
+				if !userProjects.ContainsBsonID(project.ID) {
+					return c.JSON(http.StatusForbidden, types.NewErr(fmt.Sprintf("User %s cannot see the project %s", authUser.Username, project.ID)))
+				}
 			}
 			c.Set("project", project)
 			return next(c)
@@ -190,6 +287,22 @@ func getProject(id string) func(next echo.HandlerFunc) echo.HandlerFunc {
 	}
 }
 
+// getExportJob is a middleware used to get an export job based on its Id. Only the user who requested the export can access it
+func getExportJob(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		database := c.Get("database").(*mongo.DadMongo)
+		authUser := c.Get("authuser").(types.User)
+		id := c.Param("id")
+
+		job, err := database.ExportJobs.FindByID(bson.ObjectIdHex(id))
+		if err != nil || job.Username != authUser.Username {
+			return c.JSON(http.StatusNotFound, types.NewErr(fmt.Sprintf("Export not found %v", id)))
+		}
+		c.Set("exportJob", job)
+		return next(c)
+	}
+}
+
 // RetrieveUser is a middleware setting the user in context
 func RetrieveUser(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
@@ -21,6 +21,14 @@ type DadMongo struct {
 	Technologies       types.TechnologyRepo        // Repo for accessing technologies methods
 	UsageIndicators    types.UsageIndicatorRepo    // Repo for accessing usage indicators methods
 	Languages          types.LanguageRepo          // Repo for accessing languages methods
+	ProjectHistory     types.ProjectHistoryRepo    // Repo for accessing the history of projects
+	ProjectSnapshots   types.ProjectSnapshotRepo   // Repo for accessing the snapshots of projects maturity
+	ExportJobs         types.ExportJobRepo         // Repo for accessing asynchronous exports and their files
+	RefreshTokens      types.RefreshTokenRepo      // Repo for accessing refresh tokens
+	RevokedTokens      types.RevokedTokenRepo      // Repo for accessing the revocation list of access tokens
+	APITokens          types.APITokenRepo          // Repo for accessing API tokens of machine clients
+	JobRuns            types.JobRunRepo            // Repo for accessing the runs of background jobs
+	JobSettings        types.JobSettingRepo        // Repo for accessing the settings of background jobs
 	Session            *mgo.Session                // Cloned session
 	collections        []types.IsCollection        // Cache for listing all collections. Useful when doing operations on all collections at once (e.g. index creation at startup)
 }
@@ -92,6 +100,14 @@ func Get() (*DadMongo, error) {
 	projects := types.NewProjectRepo(database)
 	technologies := types.NewTechnologyRepo(database)
 	languages := types.NewLanguageRepo(database)
+	projectHistory := types.NewProjectHistoryRepo(database)
+	projectSnapshots := types.NewProjectSnapshotRepo(database)
+	exportJobs := types.NewExportJobRepo(database)
+	refreshTokens := types.NewRefreshTokenRepo(database)
+	revokedTokens := types.NewRevokedTokenRepo(database)
+	apiTokens := types.NewAPITokenRepo(database)
+	jobRuns := types.NewJobRunRepo(database)
+	jobSettings := types.NewJobSettingRepo(database)
 
 	collections = append(collections, &users)
 	collections = append(collections, &entities)
@@ -100,6 +116,14 @@ func Get() (*DadMongo, error) {
 	collections = append(collections, &projects)
 	collections = append(collections, &technologies)
 	collections = append(collections, &languages)
+	collections = append(collections, &projectHistory)
+	collections = append(collections, &projectSnapshots)
+	collections = append(collections, &exportJobs)
+	collections = append(collections, &refreshTokens)
+	collections = append(collections, &revokedTokens)
+	collections = append(collections, &apiTokens)
+	collections = append(collections, &jobRuns)
+	collections = append(collections, &jobSettings)
 
 	return &DadMongo{
 		Users:              users,
@@ -109,6 +133,14 @@ func Get() (*DadMongo, error) {
 		Projects:           projects,
 		Technologies:       technologies,
 		Languages:          languages,
+		ProjectHistory:     projectHistory,
+		ProjectSnapshots:   projectSnapshots,
+		ExportJobs:         exportJobs,
+		RefreshTokens:      refreshTokens,
+		RevokedTokens:      revokedTokens,
+		APITokens:          apiTokens,
+		JobRuns:            jobRuns,
+		JobSettings:        jobSettings,
 		Session:            s,
 		collections:        collections,
 	}, nil
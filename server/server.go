@@ -19,6 +19,8 @@ type JSON map[string]interface{}
 
 // New instance of the server
 func New(version string) {
+	types.SetPolicy(newPolicy())
+
 	engine := echo.New()
 	authC := controllers.Auth{}
 	usersC := controllers.Users{}
@@ -30,6 +32,8 @@ func New(version string) {
 	exportC := controllers.Export{}
 	adminC := controllers.Admin{}
 	languagesC := controllers.Languages{}
+	trendsC := controllers.Trends{}
+	apiTokensC := controllers.APITokens{}
 
 	engine.Use(middleware.Logger())
 	engine.Use(middleware.Recover())
@@ -40,11 +44,20 @@ func New(version string) {
 	authAPI := engine.Group("/auth")
 	{
 		if viper.GetBool("ldap.enable") {
-			authAPI.Use(openLDAP)
+			authAPI.Use(withLDAP(newLDAP()))
 		}
 		authAPI.Use(noCache)
 		authAPI.Use(sessionMongo) // Enrich echo context with connection to Mongo
+		if viper.GetString("oidc.issuer") != "" {
+			authAPI.Use(withOIDC(newOIDC()))
+		}
 		authAPI.POST("/login", authC.Login)
+		authAPI.POST("/refresh", authC.Refresh)
+		authAPI.POST("/logout", authC.Logout)
+		authAPI.POST("/reset-password/request", authC.RequestPasswordReset)
+		authAPI.POST("/reset-password", authC.ResetPassword)
+		authAPI.GET("/oidc/login", authC.OIDCLogin)
+		authAPI.POST("/oidc/token", authC.OIDCToken)
 		authAPI.GET("/*", index)
 	}
 
@@ -56,46 +69,63 @@ func New(version string) {
 			Claims:     &auth.MyCustomClaims{},
 			SigningKey: []byte(viper.GetString("auth.jwt-secret")),
 			ContextKey: "user-token",
+			Skipper:    isAPITokenRequest, // API tokens are checked when getting the authenticated user
 		}
 		api.Use(middleware.JWTWithConfig(config)) // Enrich echo context with JWT
 		api.Use(getAuthenticatedUser)             // Enrich echo context with authenticated user (fetched from JWT token)
 		api.GET("/profile", usersC.Profile)
+		api.GET("/profile/permissions", usersC.Permissions)
 
 		usersAPI := api.Group("/users")
 		{
 			usersAPI.GET("", usersC.GetAll)
+			usersAPI.POST("/local", usersC.CreateLocal, hasPermission(types.UsersManagePermission))
+			usersAPI.GET("/dangling-references", usersC.GetDanglingReferences, hasPermission(types.UsersManagePermission))
 			userAPI := usersAPI.Group("/:id")
 			{
 				userAPI.Use(isValidID("id"))
 				userAPI.GET("", usersC.Get, RetrieveUser)
-				userAPI.DELETE("", usersC.Delete, hasRole(types.AdminRole))
-				userAPI.PUT("", usersC.Update, hasRole(types.RIRole))
+				userAPI.DELETE("", usersC.Deactivate, hasPermission(types.UsersManagePermission), RetrieveUser)
+				userAPI.POST("/activate", usersC.Activate, hasPermission(types.UsersManagePermission), RetrieveUser)
+				userAPI.POST("/reassign", usersC.Reassign, hasPermission(types.UsersManagePermission), RetrieveUser)
+				userAPI.POST("/logout", usersC.Logout, hasPermission(types.UsersManagePermission), RetrieveUser)
+				userAPI.PUT("", usersC.Update, hasPermission(types.UsersEditPermission))
 			}
 		}
 
+		apiTokensAPI := api.Group("/tokens")
+		{
+			apiTokensAPI.GET("", apiTokensC.GetAll)
+			apiTokensAPI.POST("", apiTokensC.Create)
+			apiTokensAPI.DELETE("/:id", apiTokensC.Delete, isValidID("id"))
+		}
+
 		entitiesAPI := api.Group("/entities")
 		{
 			entitiesAPI.GET("", entitiesC.GetAll)
-			entitiesAPI.POST("/new", entitiesC.Save, hasRole(types.AdminRole))
+			entitiesAPI.POST("/new", entitiesC.Save, hasPermission(types.EntitiesEditPermission))
+			entitiesAPI.GET("/integrity", entitiesC.GetIntegrity, hasPermission(types.EntitiesEditPermission))
+			entitiesAPI.POST("/integrity/repair", entitiesC.RepairIntegrity, hasPermission(types.EntitiesEditPermission))
 			entityAPI := entitiesAPI.Group("/:id")
 			{
 				entityAPI.Use(isValidID("id"))
 				entityAPI.GET("", entitiesC.Get)
-				entityAPI.DELETE("", entitiesC.Delete, hasRole(types.AdminRole))
-				entityAPI.PUT("", entitiesC.Save, hasRole(types.AdminRole))
+				entityAPI.DELETE("", entitiesC.Delete, hasPermission(types.EntitiesEditPermission))
+				entityAPI.PUT("", entitiesC.Save, hasPermission(types.EntitiesEditPermission))
+				entityAPI.POST("/merge", entitiesC.Merge, hasPermission(types.EntitiesEditPermission))
 			}
 		}
 
 		functionalServicesAPI := api.Group("/services")
 		{
 			functionalServicesAPI.GET("", functionalServicesC.GetAll)
-			functionalServicesAPI.POST("/new", functionalServicesC.Save, hasRole(types.AdminRole))
+			functionalServicesAPI.POST("/new", functionalServicesC.Save, hasPermission(types.ServicesEditPermission))
 			functionalServiceAPI := functionalServicesAPI.Group("/:id")
 			{
 				functionalServiceAPI.Use(isValidID("id"))
 				functionalServiceAPI.GET("", functionalServicesC.Get)
-				functionalServiceAPI.DELETE("", functionalServicesC.Delete, hasRole(types.AdminRole))
-				functionalServiceAPI.PUT("", functionalServicesC.Save, hasRole(types.AdminRole))
+				functionalServiceAPI.DELETE("", functionalServicesC.Delete, hasPermission(types.ServicesEditPermission))
+				functionalServiceAPI.PUT("", functionalServicesC.Save, hasPermission(types.ServicesEditPermission))
 			}
 		}
 
@@ -103,49 +133,82 @@ func New(version string) {
 		{
 			projectsAPI.Use(getAuthenticatedUser) // The rights are handled in the controller
 			projectsAPI.GET("", projectsC.GetAll)
-			projectsAPI.POST("/new", projectsC.Save, hasRole(types.RIRole))
+			projectsAPI.POST("/new", projectsC.Save, hasPermission(types.ProjectCreatePermission))
+			projectsAPI.POST("/import", projectsC.Import, hasPermission(types.ProjectImportPermission))
+			projectsAPI.GET("/archived", projectsC.GetArchived, hasPermission(types.ProjectArchivesPermission))
+			projectsAPI.PATCH("/matrix/:serviceId", projectsC.BulkUpdateMatrixLine, isValidID("serviceId"))
 			projectAPI := projectsAPI.Group("/:id")
 			{
 				projectAPI.Use(isValidID("id"))
 				projectAPI.GET("", projectsC.Get, getProject("id"))
-				projectAPI.DELETE("", projectsC.Delete, hasRole(types.RIRole))
+				projectAPI.DELETE("", projectsC.Delete, hasPermission(types.ProjectDeletePermission))
 				projectAPI.PUT("", projectsC.Save)
-				projectAPI.PATCH("", projectsC.UpdateDocktorInfo, hasRole(types.AdminRole))
+				projectAPI.PATCH("", projectsC.UpdateDocktorInfo, hasPermission(types.ProjectDocktorEditPermission))
+				projectAPI.POST("/restore", projectsC.Restore, hasPermission(types.ProjectArchivesPermission))
+				projectAPI.PATCH("/matrix/:serviceId", projectsC.UpdateMatrixLine, isValidID("serviceId"))
+				projectAPI.GET("/members", projectsC.GetMembers, getProject("id"))
+				projectAPI.PUT("/members/:userId", projectsC.SetMember, isValidID("userId"), getProject("id"))
+				projectAPI.DELETE("/members/:userId", projectsC.RemoveMember, isValidID("userId"), getProject("id"))
 				projectAPI.GET("/indicators", projectsC.GetIndicators, getProject("id")) // api used to get project's usage indicators
+				projectAPI.GET("/history", projectsC.GetHistory, getProject("id"))       // api used to get project's history of changes
+				projectAPI.GET("/trend", projectsC.GetTrend, getProject("id"))           // api used to get project's maturity over time
 			}
 		}
 
+		trendsAPI := api.Group("/trends")
+		{
+			trendsAPI.GET("", trendsC.GetAll)
+		}
+
 		technologiesAPI := api.Group("/technologies")
 		{
 			technologiesAPI.GET("", technologiesC.GetAll)
-			technologiesAPI.POST("/new", technologiesC.Save, hasRole(types.AdminRole))
+			technologiesAPI.POST("/new", technologiesC.Save, hasPermission(types.TechnologiesEditPermission))
 		}
 
 		usageIndicatorsAPI := api.Group("/usage-indicators")
 		{
 			// Indicators are created with bulk operations. Operations on single usages indicators is not possible.
 			// Therefore, only GetAll operation is available
-			usageIndicatorsAPI.GET("", usageIndicatorsC.GetAll, hasRole(types.AdminRole))
-			usageIndicatorsAPI.POST("/import", usageIndicatorsC.BulkImport, hasRole(types.AdminRole))
+			usageIndicatorsAPI.GET("", usageIndicatorsC.GetAll, hasPermission(types.UsageIndicatorsPermission))
+			usageIndicatorsAPI.POST("/import", usageIndicatorsC.BulkImport, hasPermission(types.UsageIndicatorsPermission))
 		}
 
 		languageAPI := api.Group("/languages")
 		{
 			languageAPI.GET("", languagesC.GetAll)
-			languageAPI.POST("/new", languagesC.Save, hasRole(types.AdminRole))
+			languageAPI.POST("/new", languagesC.Save, hasPermission(types.LanguagesEditPermission))
 		}
 
 		exportAPI := api.Group("/export")
 		{
 			exportAPI.Use(getAuthenticatedUser)
 			exportAPI.GET("", exportC.ExportAll)
+			exportAPI.GET("/jobs", exportC.GetJobs)
+			exportAPI.POST("/jobs", exportC.CreateJob)
+			exportJobAPI := exportAPI.Group("/jobs/:id")
+			{
+				exportJobAPI.Use(isValidID("id"), getExportJob)
+				exportJobAPI.GET("", exportC.GetJob)
+				exportJobAPI.GET("/download", exportC.DownloadJob)
+			}
 		}
 
 		adminAPI := api.Group("/admin")
 		{
-			adminAPI.Use(hasRole(types.AdminRole))
+			adminAPI.Use(hasPermission(types.JobsPermission))
 			jobsAPI := adminAPI.Group("/jobs")
+			jobsAPI.GET("", adminC.GetJobs)
+			jobsAPI.PUT("/:name/settings", adminC.UpdateJob)
+			jobsAPI.POST("/:name/run", adminC.RunJob)
 			jobsAPI.POST("/deployment-indicators", adminC.ExecuteDeploymentJobAnalytics)
+			jobsAPI.POST("/deployment-indicators/apply", adminC.ApplyDeploymentChanges)
+			jobsAPI.POST("/snapshots", adminC.ExecuteProjectsSnapshot)
+			jobsAPI.POST("/purge", adminC.ExecuteArchivedProjectsPurge)
+			jobsAPI.POST("/exports-cleanup", adminC.ExecuteExportsCleanup)
+			jobsAPI.POST("/ldap-sync", adminC.ExecuteLDAPSync)
+			jobsAPI.GET("/runs", adminC.GetJobRuns)
+			jobsAPI.GET("/runs/:id", adminC.GetJobRun, isValidID("id"))
 		}
 	}
 
@@ -164,6 +227,7 @@ func New(version string) {
 
 	// Launch back-end tasks.
 	go jobs.RunBackgroundJobs()
+	go jobs.ResumeExportJobs()
 
 	if err := engine.Start(":8080"); err != nil {
 		engine.Logger.Fatal(err.Error())
@@ -0,0 +1,177 @@
+package types
+
+import (
+	"time"
+
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	// ScopeIndicatorsWrite allows to import usage indicators
+	ScopeIndicatorsWrite = "indicators:write"
+	// ScopeExportRead allows to export projects
+	ScopeExportRead = "export:read"
+	// ScopeProjectsRead allows to read projects, their indicators, history and trends
+	ScopeProjectsRead = "projects:read"
+)
+
+// APITokenScopes lists all known scopes of API tokens
+var APITokenScopes = []string{ScopeIndicatorsWrite, ScopeExportRead, ScopeProjectsRead}
+
+// IsValidScope checks if a scope of API token is known
+func IsValidScope(scope string) bool {
+	for _, s := range APITokenScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// APIToken authenticates machine clients on behalf of its owner, restricted to its scopes
+// Only the hash of the token is stored, the token itself is only given at creation
+type APIToken struct {
+	ID        bson.ObjectId `bson:"_id,omitempty" json:"id,omitempty"`
+	Name      string        `bson:"name" json:"name"`
+	Username  string        `bson:"username" json:"username"` // Owner of the token, whose rights are used
+	Prefix    string        `bson:"prefix" json:"prefix"`     // Beginning of the token, to recognize it
+	Hash      string        `bson:"hash" json:"-"`
+	Scopes    []string      `bson:"scopes" json:"scopes"`
+	Created   time.Time     `bson:"created" json:"created"`
+	LastUsed  *time.Time    `bson:"lastUsed,omitempty" json:"lastUsed,omitempty"`
+	ExpiresAt *time.Time    `bson:"expiresAt,omitempty" json:"expiresAt,omitempty"`
+}
+
+// GetID gets the ID of the API token
+func (t APIToken) GetID() bson.ObjectId {
+	return t.ID
+}
+
+// HasScope checks the API token was granted the scope
+func (t APIToken) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// IsExpired checks the API token can't be used anymore
+func (t APIToken) IsExpired() bool {
+	return t.ExpiresAt != nil && t.ExpiresAt.Before(time.Now())
+}
+
+// APITokenRepo wraps all requests to database for accessing API tokens
+type APITokenRepo struct {
+	database *mgo.Database
+}
+
+// NewAPITokenRepo creates a new API token repo from database
+// This APITokenRepo is wrapping all requests with database
+func NewAPITokenRepo(database *mgo.Database) APITokenRepo {
+	return APITokenRepo{database: database}
+}
+
+func (r *APITokenRepo) col() *mgo.Collection {
+	return r.database.C("apiTokens")
+}
+
+func (r *APITokenRepo) isInitialized() bool {
+	return r.database != nil
+}
+
+// CreateIndexes creates Index
+func (r *APITokenRepo) CreateIndexes() error {
+	if !r.isInitialized() {
+		return ErrDatabaseNotInitialized
+	}
+	err := r.col().EnsureIndex(mgo.Index{
+		Key:    []string{"hash"},
+		Unique: true,
+	})
+	if err != nil {
+		return err
+	}
+	return r.col().EnsureIndex(mgo.Index{
+		Key: []string{"username"},
+	})
+}
+
+// FindByID get the API token by its id
+func (r *APITokenRepo) FindByID(id bson.ObjectId) (APIToken, error) {
+	if !r.isInitialized() {
+		return APIToken{}, ErrDatabaseNotInitialized
+	}
+	result := APIToken{}
+	err := r.col().FindId(id).One(&result)
+	return result, err
+}
+
+// FindByHash get the API token by its hash
+func (r *APITokenRepo) FindByHash(hash string) (APIToken, error) {
+	if !r.isInitialized() {
+		return APIToken{}, ErrDatabaseNotInitialized
+	}
+	result := APIToken{}
+	err := r.col().Find(bson.M{"hash": hash}).One(&result)
+	return result, err
+}
+
+// FindByUsername get the API tokens of a user, most recent first
+func (r *APITokenRepo) FindByUsername(username string) ([]APIToken, error) {
+	if !r.isInitialized() {
+		return []APIToken{}, ErrDatabaseNotInitialized
+	}
+	tokens := []APIToken{}
+	err := r.col().Find(bson.M{"username": username}).Sort("-created").All(&tokens)
+	return tokens, err
+}
+
+// FindAll get all API tokens, most recent first
+func (r *APITokenRepo) FindAll() ([]APIToken, error) {
+	if !r.isInitialized() {
+		return []APIToken{}, ErrDatabaseNotInitialized
+	}
+	tokens := []APIToken{}
+	err := r.col().Find(bson.M{}).Sort("-created").All(&tokens)
+	return tokens, err
+}
+
+// Save updates or create the API token in database
+func (r *APITokenRepo) Save(token APIToken) (APIToken, error) {
+	if !r.isInitialized() {
+		return APIToken{}, ErrDatabaseNotInitialized
+	}
+
+	if token.ID.Hex() == "" {
+		token.ID = bson.NewObjectId()
+	}
+
+	_, err := r.col().UpsertId(token.ID, bson.M{"$set": token})
+	return token, err
+}
+
+// Touch records the last use of the API token
+func (r *APITokenRepo) Touch(id bson.ObjectId) error {
+	if !r.isInitialized() {
+		return ErrDatabaseNotInitialized
+	}
+	return r.col().UpdateId(id, bson.M{"$set": bson.M{"lastUsed": time.Now()}})
+}
+
+// DeleteByUsername removes all API tokens of a user
+// This is used for cascade deletions
+func (r *APITokenRepo) DeleteByUsername(username string) error {
+	if !r.isInitialized() {
+		return ErrDatabaseNotInitialized
+	}
+	_, err := r.col().RemoveAll(bson.M{"username": username})
+	return err
+}
+
+// Delete the API token
+func (r *APITokenRepo) Delete(id bson.ObjectId) (bson.ObjectId, error) {
+	return BasicDelete(r, id)
+}
@@ -9,4 +9,6 @@ var (
 	ErrInvalidUserID = errors.New("Invalid User ID")
 	// ErrInvalidEntityID occurs when the entity id is not a valid objectID Hex
 	ErrInvalidEntityID = errors.New("Invalid Entity ID")
+	// ErrProjectVersionConflict occurs when a project can't be saved because it has been modified by someone else in the meantime
+	ErrProjectVersionConflict = errors.New("Project has been modified by someone else in the meantime")
 )
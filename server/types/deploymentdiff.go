@@ -0,0 +1,106 @@
+package types
+
+import (
+	"fmt"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// DeploymentChange is a change of the deployment status or progress of a matrix line, proposed by the deployment analytics
+// Only the modified fields are set in From and To
+type DeploymentChange struct {
+	Service     bson.ObjectId   `json:"service"`
+	ServiceName string          `json:"serviceName,omitempty"`
+	New         bool            `json:"new"` // The matrix line doesn't exist yet
+	From        MatrixLinePatch `json:"from"`
+	To          MatrixLinePatch `json:"to"`
+}
+
+// DeploymentDiff is the changes proposed by the deployment analytics for a project
+type DeploymentDiff struct {
+	Project bson.ObjectId      `json:"project"`
+	Name    string             `json:"name"`
+	Changes []DeploymentChange `json:"changes"`
+}
+
+// DiffMatrix returns the changes of deployment status and progress from a matrix to its updated version
+func DiffMatrix(before, after Matrix) []DeploymentChange {
+	changes := []DeploymentChange{}
+	for _, line := range after {
+		previous, ok := before.line(line.Service)
+		if !ok {
+			deployed, progress := line.Deployed, line.Progress
+			changes = append(changes, DeploymentChange{
+				Service: line.Service,
+				New:     true,
+				To:      MatrixLinePatch{Deployed: &deployed, Progress: &progress},
+			})
+			continue
+		}
+
+		change := DeploymentChange{Service: line.Service}
+		if previous.Deployed != line.Deployed {
+			from, to := previous.Deployed, line.Deployed
+			change.From.Deployed, change.To.Deployed = &from, &to
+		}
+		if previous.Progress != line.Progress {
+			from, to := previous.Progress, line.Progress
+			change.From.Progress, change.To.Progress = &from, &to
+		}
+		if change.To.Deployed != nil || change.To.Progress != nil {
+			changes = append(changes, change)
+		}
+	}
+	return changes
+}
+
+// line returns the matrix line of a functional service
+func (m Matrix) line(service bson.ObjectId) (MatrixLine, bool) {
+	for _, line := range m {
+		if line.Service == service {
+			return line, true
+		}
+	}
+	return MatrixLine{}, false
+}
+
+// ApplyDeploymentChanges returns a copy of the project whose matrix is updated with the changes
+// It fails when the matrix was modified since the changes were proposed, so that they are not applied on other values
+func (p Project) ApplyDeploymentChanges(changes []DeploymentChange) (Project, error) {
+	matrix := append(Matrix{}, p.Matrix...)
+	for _, change := range changes {
+		if change.To.Goal != nil || change.To.Priority != nil || change.To.DueDate != nil || change.To.ClearDueDate || change.To.Comment != nil {
+			return Project{}, fmt.Errorf("Only the deployment status and the progress of service %v can be changed", change.Service.Hex())
+		}
+		if err := change.To.Validate(); err != nil {
+			return Project{}, fmt.Errorf("Change of service %v is not valid: %v", change.Service.Hex(), err)
+		}
+
+		index := -1
+		for i, line := range matrix {
+			if line.Service == change.Service {
+				index = i
+				break
+			}
+		}
+
+		if change.New {
+			if index >= 0 {
+				return Project{}, fmt.Errorf("Matrix line of service %v was created in the meantime", change.Service.Hex())
+			}
+			matrix = append(matrix, change.To.Apply(MatrixLine{Service: change.Service}))
+			continue
+		}
+
+		if index < 0 {
+			return Project{}, fmt.Errorf("Matrix line of service %v does not exist", change.Service.Hex())
+		}
+		line := matrix[index]
+		if (change.From.Deployed != nil && *change.From.Deployed != line.Deployed) || (change.From.Progress != nil && *change.From.Progress != line.Progress) {
+			return Project{}, fmt.Errorf("Matrix line of service %v was modified in the meantime", change.Service.Hex())
+		}
+		matrix[index] = change.To.Apply(line)
+	}
+	p.Matrix = matrix
+	return p, nil
+}
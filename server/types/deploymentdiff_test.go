@@ -0,0 +1,51 @@
+package types
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestDeploymentChanges(t *testing.T) {
+
+	Convey("Given the matrix of a project updated by the deployment analytics", t, func() {
+		jenkins, sonar, wiki := bson.NewObjectId(), bson.NewObjectId(), bson.NewObjectId()
+		project := Project{Matrix: Matrix{
+			{Service: sonar, Deployed: Deployed[0], Progress: 2, Goal: 3},
+			{Service: wiki, Deployed: Deployed[0], Progress: 1},
+		}}
+		updated := Matrix{
+			{Service: sonar, Deployed: Deployed[-1], Progress: 0, Goal: 3},
+			{Service: wiki, Deployed: Deployed[0], Progress: 1},
+			{Service: jenkins, Deployed: Deployed[0], Progress: 1},
+		}
+
+		changes := DiffMatrix(project.Matrix, updated)
+
+		Convey("Then the changes of deployment status and progress are listed", func() {
+			yes, no, two, zero, one := Deployed[0], Deployed[-1], 2, 0, 1
+			So(changes, ShouldResemble, []DeploymentChange{
+				{Service: sonar, From: MatrixLinePatch{Deployed: &yes, Progress: &two}, To: MatrixLinePatch{Deployed: &no, Progress: &zero}},
+				{Service: jenkins, New: true, To: MatrixLinePatch{Deployed: &yes, Progress: &one}},
+			})
+		})
+
+		Convey("When the changes are applied", func() {
+			applied, err := project.ApplyDeploymentChanges(changes)
+			Convey("Then the matrix is the updated one", func() {
+				So(err, ShouldBeNil)
+				So(applied.Matrix, ShouldResemble, updated)
+				So(project.Matrix[0].Deployed, ShouldEqual, Deployed[0])
+			})
+		})
+
+		Convey("When the matrix was modified since the changes were computed", func() {
+			project.Matrix[0].Progress = 3
+			_, err := project.ApplyDeploymentChanges(changes[:1])
+			Convey("Then the changes are refused", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
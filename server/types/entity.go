@@ -15,13 +15,22 @@ const (
 	BusinessUnitType EntityType = "businessUnit"
 	// ServiceCenterType is the type of entity for services centers
 	ServiceCenterType EntityType = "serviceCenter"
+	// TeamType is the type of entity for teams, usually inside a service center
+	TeamType EntityType = "team"
 )
 
+// IsValid checks if an entity type is valid
+func (t EntityType) IsValid() bool {
+	return t == BusinessUnitType || t == ServiceCenterType || t == TeamType
+}
+
 // Entity represents an Sopra Steria entity
+// Entities are organized as a tree, like business units containing service centers containing teams
 type Entity struct {
-	ID   bson.ObjectId `bson:"_id,omitempty" json:"id,omitempty"`
-	Name string        `bson:"name" json:"name"`
-	Type EntityType    `bson:"type" json:"type"`
+	ID     bson.ObjectId `bson:"_id,omitempty" json:"id,omitempty"`
+	Name   string        `bson:"name" json:"name"`
+	Type   EntityType    `bson:"type" json:"type"`
+	Parent bson.ObjectId `bson:"parent,omitempty" json:"parent,omitempty"` // Entity containing this one, empty for root entities
 }
 
 // GetID gets the ID of the entity
@@ -98,11 +107,13 @@ func (r *EntityRepo) FindAllByIDBson(ids []bson.ObjectId) ([]Entity, error) {
 	return entities, nil
 }
 
-// Exists checks if an entity (name) already exists
-func (r *EntityRepo) Exists(name string) (bool, error) {
-	nb, err := r.col().Find(bson.M{
-		"name": name,
-	}).Count()
+// Exists checks if an entity (name) already exists, other than the given entity
+func (r *EntityRepo) Exists(name string, id bson.ObjectId) (bool, error) {
+	query := bson.M{"name": name}
+	if id.Hex() != "" {
+		query["_id"] = bson.M{"$ne": id}
+	}
+	nb, err := r.col().Find(query).Count()
 
 	if err != nil {
 		return true, err
@@ -120,7 +131,12 @@ func (r *EntityRepo) Save(entity Entity) (Entity, error) {
 		entity.ID = bson.NewObjectId()
 	}
 
-	_, err := r.col().UpsertId(entity.ID, bson.M{"$set": entity})
+	update := bson.M{"$set": entity}
+	if entity.Parent == "" {
+		// The parent is omitted when empty, so it has to be removed explicitly when the entity becomes a root entity
+		update["$unset"] = bson.M{"parent": ""}
+	}
+	_, err := r.col().UpsertId(entity.ID, update)
 	return entity, err
 }
 
@@ -128,3 +144,33 @@ func (r *EntityRepo) Save(entity Entity) (Entity, error) {
 func (r *EntityRepo) Delete(id bson.ObjectId) (bson.ObjectId, error) {
 	return BasicDelete(r, id)
 }
+
+// DeleteAll deletes the entities
+func (r *EntityRepo) DeleteAll(ids []bson.ObjectId) error {
+	if !r.isInitialized() {
+		return ErrDatabaseNotInitialized
+	}
+	_, err := r.col().RemoveAll(bson.M{"_id": bson.M{"$in": ids}})
+	return err
+}
+
+// Reparent moves the children of an entity to another entity
+func (r *EntityRepo) Reparent(from, to bson.ObjectId) (int, error) {
+	if !r.isInitialized() {
+		return 0, ErrDatabaseNotInitialized
+	}
+	info, err := r.col().UpdateAll(bson.M{"parent": from}, bson.M{"$set": bson.M{"parent": to}})
+	if err != nil {
+		return 0, err
+	}
+	return info.Updated, nil
+}
+
+// FindTree get all entities from the database, as a tree
+func (r *EntityRepo) FindTree() (EntityTree, error) {
+	entities, err := r.FindAll()
+	if err != nil {
+		return EntityTree{}, err
+	}
+	return NewEntityTree(entities), nil
+}
@@ -0,0 +1,150 @@
+package types
+
+import (
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	// InvalidEntityReference is the reason of a reference which is not an entity ID
+	InvalidEntityReference = "invalid"
+	// MisformattedEntityReference is the reason of a reference which is an entity ID, but not formatted like the IDs stored by the application (lower case hexadecimal)
+	MisformattedEntityReference = "format"
+	// MissingEntityReference is the reason of a reference to an entity which does not exist
+	MissingEntityReference = "missing"
+	// MismatchedEntityReference is the reason of a reference to an entity whose type is not the expected one
+	MismatchedEntityReference = "type"
+)
+
+// EntityReference is a problematic reference to an entity, from a project, a user or another entity
+type EntityReference struct {
+	Collection string        `json:"collection"` // Either projects, users or entities
+	Document   bson.ObjectId `json:"document"`   // ID of the document referencing the entity
+	Name       string        `json:"name"`       // Name of the document referencing the entity
+	Field      string        `json:"field"`
+	Entity     string        `json:"entity"` // Referenced entity ID, as stored
+	Reason     string        `json:"reason"`
+}
+
+// EntityIntegrityResults is the result of the check of the references to entities
+type EntityIntegrityResults struct {
+	References []EntityReference `json:"references"`
+	Repaired   int               `json:"repaired"` // Number of documents repaired
+	Errors     []string          `json:"errors"`   // Errors occurred while repairing documents
+}
+
+// MergeEntitiesResults is the result of the merge of an entity into another one
+type MergeEntitiesResults struct {
+	Projects int `json:"projects"` // Number of projects referencing the merged entity
+	Users    int `json:"users"`    // Number of users referencing the merged entity
+	Children int `json:"children"` // Number of child entities moved to the other entity
+}
+
+// ReplaceEntity returns a copy of the project where an entity is replaced by another one as business unit and service center
+// The other entity is never twice service center. It returns false when the entity is neither business unit nor service center
+func (p Project) ReplaceEntity(from, to string) (Project, bool) {
+	replaced := false
+	if p.BusinessUnit == from {
+		p.BusinessUnit = to
+		replaced = true
+	}
+
+	serviceCenters := []string{}
+	for _, sC := range p.ServiceCenter {
+		if sC == from {
+			sC = to
+			replaced = true
+		}
+		if !containsString(serviceCenters, sC) {
+			serviceCenters = append(serviceCenters, sC)
+		}
+	}
+	if replaced {
+		p.ServiceCenter = serviceCenters
+	}
+	return p, replaced
+}
+
+// checkReference returns the reason why the reference to an entity of one of the given types is problematic, or an empty string
+// Projects store entity IDs as hexadecimal strings, so they can be invalid or misformatted, while users and entities store them as IDs
+func (t EntityTree) checkReference(id string, entityTypes ...EntityType) string {
+	if !bson.IsObjectIdHex(id) {
+		return InvalidEntityReference
+	}
+	entity, ok := t.GetHex(id)
+	if !ok {
+		return MissingEntityReference
+	}
+	if id != entity.ID.Hex() {
+		return MisformattedEntityReference
+	}
+	if len(entityTypes) == 0 {
+		return ""
+	}
+	for _, entityType := range entityTypes {
+		if entity.Type == entityType {
+			return ""
+		}
+	}
+	return MismatchedEntityReference
+}
+
+// CheckProject returns the problematic references of the project to entities, and a copy of the project where they are repaired
+// Misformatted references are rewritten, other problematic references are removed
+func (t EntityTree) CheckProject(p Project) (Project, []EntityReference) {
+	references := []EntityReference{}
+	check := func(field, id string, entityTypes ...EntityType) (string, bool) {
+		reason := t.checkReference(id, entityTypes...)
+		if reason == "" {
+			return id, true
+		}
+		references = append(references, EntityReference{Collection: "projects", Document: p.ID, Name: p.Name, Field: field, Entity: id, Reason: reason})
+		if reason == MisformattedEntityReference {
+			return bson.ObjectIdHex(id).Hex(), true
+		}
+		return "", false
+	}
+
+	if p.BusinessUnit != "" {
+		p.BusinessUnit, _ = check("businessUnit", p.BusinessUnit, BusinessUnitType)
+	}
+	serviceCenters := []string{}
+	for _, sC := range p.ServiceCenter {
+		if id, ok := check("serviceCenter", sC, ServiceCenterType, TeamType); ok && !containsString(serviceCenters, id) {
+			serviceCenters = append(serviceCenters, id)
+		}
+	}
+	if len(references) > 0 {
+		p.ServiceCenter = serviceCenters
+	}
+	return p, references
+}
+
+// CheckUser returns the references of the user to missing entities, and a copy of the user where they are removed
+func (t EntityTree) CheckUser(u User) (User, []EntityReference) {
+	references := []EntityReference{}
+	entities := []bson.ObjectId{}
+	for _, id := range u.Entities {
+		if reason := t.checkReference(id.Hex()); reason != "" {
+			references = append(references, EntityReference{Collection: "users", Document: u.ID, Name: u.Username, Field: "entities", Entity: id.Hex(), Reason: reason})
+			continue
+		}
+		entities = append(entities, id)
+	}
+	if len(references) > 0 {
+		u.Entities = entities
+	}
+	return u, references
+}
+
+// CheckEntity returns the reference of the entity to a missing parent, and a copy of the entity which becomes a root entity
+func (t EntityTree) CheckEntity(e Entity) (Entity, []EntityReference) {
+	references := []EntityReference{}
+	if e.Parent == "" {
+		return e, references
+	}
+	if reason := t.checkReference(e.Parent.Hex()); reason != "" {
+		references = append(references, EntityReference{Collection: "entities", Document: e.ID, Name: e.Name, Field: "parent", Entity: e.Parent.Hex(), Reason: reason})
+		e.Parent = ""
+	}
+	return e, references
+}
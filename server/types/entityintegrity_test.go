@@ -0,0 +1,81 @@
+package types
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestReplaceEntity(t *testing.T) {
+
+	Convey("Given a project with two service centers", t, func() {
+		bu, merged, kept := bson.NewObjectId().Hex(), bson.NewObjectId().Hex(), bson.NewObjectId().Hex()
+		project := Project{BusinessUnit: bu, ServiceCenter: []string{merged, kept}}
+
+		Convey("When merging one service center into the other", func() {
+			project, replaced := project.ReplaceEntity(merged, kept)
+			Convey("Then the service center is not duplicated", func() {
+				So(replaced, ShouldBeTrue)
+				So(project.BusinessUnit, ShouldEqual, bu)
+				So(project.ServiceCenter, ShouldResemble, []string{kept})
+			})
+		})
+
+		Convey("When merging an entity the project doesn't reference", func() {
+			project, replaced := project.ReplaceEntity(bson.NewObjectId().Hex(), kept)
+			Convey("Then the project is unchanged", func() {
+				So(replaced, ShouldBeFalse)
+				So(project.ServiceCenter, ShouldResemble, []string{merged, kept})
+			})
+		})
+	})
+}
+
+func TestCheckEntityReferences(t *testing.T) {
+
+	Convey("Given a tree of entities", t, func() {
+		bu := Entity{ID: bson.NewObjectId(), Name: "Banking", Type: BusinessUnitType}
+		sc := Entity{ID: bson.NewObjectId(), Name: "Paris", Type: ServiceCenterType, Parent: bu.ID}
+		orphan := Entity{ID: bson.NewObjectId(), Name: "Team A", Type: TeamType, Parent: bson.NewObjectId()}
+		tree := NewEntityTree([]Entity{bu, sc, orphan})
+
+		Convey("When checking a project with problematic references", func() {
+			missing := bson.NewObjectId().Hex()
+			project := Project{
+				ID:            bson.NewObjectId(),
+				Name:          "DAD",
+				BusinessUnit:  sc.ID.Hex(),
+				ServiceCenter: []string{strings.ToUpper(sc.ID.Hex()), missing, "Paris", orphan.ID.Hex()},
+			}
+			repaired, references := tree.CheckProject(project)
+			Convey("Then they are reported", func() {
+				So(references, ShouldResemble, []EntityReference{
+					{Collection: "projects", Document: project.ID, Name: "DAD", Field: "businessUnit", Entity: sc.ID.Hex(), Reason: MismatchedEntityReference},
+					{Collection: "projects", Document: project.ID, Name: "DAD", Field: "serviceCenter", Entity: strings.ToUpper(sc.ID.Hex()), Reason: MisformattedEntityReference},
+					{Collection: "projects", Document: project.ID, Name: "DAD", Field: "serviceCenter", Entity: missing, Reason: MissingEntityReference},
+					{Collection: "projects", Document: project.ID, Name: "DAD", Field: "serviceCenter", Entity: "Paris", Reason: InvalidEntityReference},
+				})
+			})
+			Convey("Then misformatted references are rewritten and the other ones removed", func() {
+				So(repaired.BusinessUnit, ShouldEqual, "")
+				So(repaired.ServiceCenter, ShouldResemble, []string{sc.ID.Hex(), orphan.ID.Hex()})
+			})
+		})
+
+		Convey("When checking users and entities referencing missing entities", func() {
+			user := User{ID: bson.NewObjectId(), Username: "jdoe", Entities: []bson.ObjectId{bu.ID, orphan.Parent}}
+			repairedUser, userReferences := tree.CheckUser(user)
+			repairedEntity, entityReferences := tree.CheckEntity(orphan)
+			Convey("Then the missing entities are removed", func() {
+				So(userReferences, ShouldHaveLength, 1)
+				So(repairedUser.Entities, ShouldResemble, []bson.ObjectId{bu.ID})
+				So(entityReferences, ShouldHaveLength, 1)
+				So(repairedEntity.Parent, ShouldEqual, bson.ObjectId(""))
+				_, references := tree.CheckEntity(sc)
+				So(references, ShouldBeEmpty)
+			})
+		})
+	})
+}
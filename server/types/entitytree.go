@@ -0,0 +1,120 @@
+package types
+
+import (
+	"strings"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// EntityPathSeparator separates the names of the entities in the path of an entity
+const EntityPathSeparator = " / "
+
+// EntityTree indexes entities by their ID, to navigate from parents to children and back
+type EntityTree struct {
+	entities map[bson.ObjectId]Entity
+	children map[bson.ObjectId][]bson.ObjectId
+}
+
+// NewEntityTree builds the tree of the entities
+func NewEntityTree(entities []Entity) EntityTree {
+	tree := EntityTree{
+		entities: map[bson.ObjectId]Entity{},
+		children: map[bson.ObjectId][]bson.ObjectId{},
+	}
+	for _, entity := range entities {
+		tree.entities[entity.ID] = entity
+		if entity.Parent != "" {
+			tree.children[entity.Parent] = append(tree.children[entity.Parent], entity.ID)
+		}
+	}
+	return tree
+}
+
+// Get returns the entity with the given ID
+func (t EntityTree) Get(id bson.ObjectId) (Entity, bool) {
+	entity, ok := t.entities[id]
+	return entity, ok
+}
+
+// GetHex returns the entity with the given ID, as stored in projects
+func (t EntityTree) GetHex(id string) (Entity, bool) {
+	if !bson.IsObjectIdHex(id) {
+		return Entity{}, false
+	}
+	return t.Get(bson.ObjectIdHex(id))
+}
+
+// Ancestors returns the ancestors of the entity, from the root to its parent
+func (t EntityTree) Ancestors(id bson.ObjectId) []Entity {
+	ancestors := []Entity{}
+	seen := map[bson.ObjectId]bool{id: true}
+	entity, ok := t.entities[id]
+	for ok && entity.Parent != "" && !seen[entity.Parent] {
+		seen[entity.Parent] = true
+		entity, ok = t.entities[entity.Parent]
+		if ok {
+			ancestors = append([]Entity{entity}, ancestors...)
+		}
+	}
+	return ancestors
+}
+
+// Ancestor returns the closest ancestor of the entity with the given type
+func (t EntityTree) Ancestor(id bson.ObjectId, entityType EntityType) (Entity, bool) {
+	ancestors := t.Ancestors(id)
+	for i := len(ancestors) - 1; i >= 0; i-- {
+		if ancestors[i].Type == entityType {
+			return ancestors[i], true
+		}
+	}
+	return Entity{}, false
+}
+
+// Subtree returns the IDs of the entities and of all their descendants
+func (t EntityTree) Subtree(ids ...bson.ObjectId) []bson.ObjectId {
+	subtree := []bson.ObjectId{}
+	seen := map[bson.ObjectId]bool{}
+	for len(ids) > 0 {
+		id := ids[0]
+		ids = ids[1:]
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		subtree = append(subtree, id)
+		ids = append(ids, t.children[id]...)
+	}
+	return subtree
+}
+
+// SubtreeHex returns the IDs of the entities and of all their descendants, as stored in projects
+func (t EntityTree) SubtreeHex(ids ...bson.ObjectId) []string {
+	subtree := []string{}
+	for _, id := range t.Subtree(ids...) {
+		subtree = append(subtree, id.Hex())
+	}
+	return subtree
+}
+
+// IsInSubtree checks that an entity is the root entity or one of its descendants
+func (t EntityTree) IsInSubtree(id, root bson.ObjectId) bool {
+	for _, i := range t.Subtree(root) {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Path returns the names of the ancestors of the entity and of the entity itself, from the root
+func (t EntityTree) Path(id bson.ObjectId) string {
+	entity, ok := t.entities[id]
+	if !ok {
+		return ""
+	}
+	names := []string{}
+	for _, ancestor := range t.Ancestors(id) {
+		names = append(names, ancestor.Name)
+	}
+	return strings.Join(append(names, entity.Name), EntityPathSeparator)
+}
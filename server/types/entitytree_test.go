@@ -0,0 +1,43 @@
+package types
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestEntityTree(t *testing.T) {
+
+	Convey("Given a business unit containing a service center containing a team", t, func() {
+		bu := Entity{ID: bson.NewObjectId(), Name: "Banking", Type: BusinessUnitType}
+		sc := Entity{ID: bson.NewObjectId(), Name: "Paris", Type: ServiceCenterType, Parent: bu.ID}
+		team := Entity{ID: bson.NewObjectId(), Name: "Team A", Type: TeamType, Parent: sc.ID}
+		other := Entity{ID: bson.NewObjectId(), Name: "Insurance", Type: BusinessUnitType}
+		tree := NewEntityTree([]Entity{team, sc, bu, other})
+
+		Convey("Then the subtree of the business unit contains its descendants only", func() {
+			So(tree.Subtree(bu.ID), ShouldResemble, []bson.ObjectId{bu.ID, sc.ID, team.ID})
+			So(tree.IsInSubtree(team.ID, bu.ID), ShouldBeTrue)
+			So(tree.IsInSubtree(bu.ID, team.ID), ShouldBeFalse)
+			So(tree.IsInSubtree(other.ID, bu.ID), ShouldBeFalse)
+		})
+
+		Convey("Then the team knows its ancestors and its full path", func() {
+			So(tree.Ancestors(team.ID), ShouldResemble, []Entity{bu, sc})
+			So(tree.Path(team.ID), ShouldEqual, "Banking / Paris / Team A")
+			ancestor, ok := tree.Ancestor(team.ID, BusinessUnitType)
+			So(ok, ShouldBeTrue)
+			So(ancestor, ShouldResemble, bu)
+		})
+
+		Convey("When the entities have a cycle", func() {
+			bu.Parent = team.ID
+			tree = NewEntityTree([]Entity{team, sc, bu})
+			Convey("Then the tree is still navigable", func() {
+				So(tree.Ancestors(team.ID), ShouldResemble, []Entity{bu, sc})
+				So(tree.Subtree(sc.ID), ShouldResemble, []bson.ObjectId{sc.ID, team.ID, bu.ID})
+			})
+		})
+	})
+}
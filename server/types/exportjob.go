@@ -0,0 +1,228 @@
+package types
+
+import (
+	"io"
+	"os"
+	"time"
+
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// ExportJobStatus is the status of an asynchronous export
+type ExportJobStatus string
+
+const (
+	// ExportQueued is the status of an export waiting to be generated
+	ExportQueued ExportJobStatus = "queued"
+	// ExportRunning is the status of an export being generated
+	ExportRunning ExportJobStatus = "running"
+	// ExportDone is the status of an export whose file can be downloaded
+	ExportDone ExportJobStatus = "done"
+	// ExportFailed is the status of an export whose generation failed
+	ExportFailed ExportJobStatus = "failed"
+)
+
+// ExportJob represents an export requested by a user, generated in background
+type ExportJob struct {
+	ID          bson.ObjectId   `bson:"_id,omitempty" json:"id,omitempty"`
+	Username    string          `bson:"username" json:"username"`
+	Status      ExportJobStatus `bson:"status" json:"status"`
+	Format      string          `bson:"format" json:"format"`
+	Language    string          `bson:"language" json:"language"`
+	Package     string          `bson:"package" json:"package"`
+	Filter      ProjectFilter   `bson:"filter" json:"-"`
+	Notify      bool            `bson:"notify" json:"notify"` // When true, the user receives an email when the file is ready
+	FileName    string          `bson:"fileName" json:"fileName"`
+	ContentType string          `bson:"contentType" json:"contentType"`
+	Error       string          `bson:"error,omitempty" json:"error,omitempty"`
+	Created     time.Time       `bson:"created" json:"created"`
+	Finished    *time.Time      `bson:"finished,omitempty" json:"finished,omitempty"`
+	ExpiresAt   *time.Time      `bson:"expiresAt,omitempty" json:"expiresAt,omitempty"`
+	Host        string          `bson:"host,omitempty" json:"-"`      // Instance of DAD generating the export
+	Heartbeat   *time.Time      `bson:"heartbeat,omitempty" json:"-"` // Last signal of the instance generating the export
+}
+
+// ExportJobRepo wraps all requests to database for accessing export jobs and their generated files
+type ExportJobRepo struct {
+	database *mgo.Database
+}
+
+// NewExportJobRepo creates a new export job repo from database
+// This ExportJobRepo is wrapping all requests with database
+func NewExportJobRepo(database *mgo.Database) ExportJobRepo {
+	return ExportJobRepo{database: database}
+}
+
+func (r *ExportJobRepo) col() *mgo.Collection {
+	return r.database.C("exportJobs")
+}
+
+// files is the GridFS storing the generated files, whose ID is the ID of the job
+func (r *ExportJobRepo) files() *mgo.GridFS {
+	return r.database.GridFS("exports")
+}
+
+func (r *ExportJobRepo) isInitialized() bool {
+	return r.database != nil
+}
+
+// CreateIndexes creates Index
+func (r *ExportJobRepo) CreateIndexes() error {
+	if !r.isInitialized() {
+		return ErrDatabaseNotInitialized
+	}
+	err := r.col().EnsureIndex(mgo.Index{
+		Key: []string{"username", "-created"},
+	})
+	if err != nil {
+		return err
+	}
+	return r.col().EnsureIndex(mgo.Index{
+		Key: []string{"expiresAt"},
+	})
+}
+
+// FindByID get the export job by its id
+func (r *ExportJobRepo) FindByID(id bson.ObjectId) (ExportJob, error) {
+	if !r.isInitialized() {
+		return ExportJob{}, ErrDatabaseNotInitialized
+	}
+	result := ExportJob{}
+	err := r.col().FindId(id).One(&result)
+	return result, err
+}
+
+// FindByUsername get the export jobs requested by a user, most recent first
+func (r *ExportJobRepo) FindByUsername(username string) ([]ExportJob, error) {
+	if !r.isInitialized() {
+		return []ExportJob{}, ErrDatabaseNotInitialized
+	}
+	jobs := []ExportJob{}
+	err := r.col().Find(bson.M{"username": username}).Sort("-created").All(&jobs)
+	return jobs, err
+}
+
+// FindExpiredBefore get the export jobs whose file expired before the given date
+func (r *ExportJobRepo) FindExpiredBefore(date time.Time) ([]ExportJob, error) {
+	if !r.isInitialized() {
+		return []ExportJob{}, ErrDatabaseNotInitialized
+	}
+	jobs := []ExportJob{}
+	err := r.col().Find(bson.M{"expiresAt": bson.M{"$lt": date}}).All(&jobs)
+	return jobs, err
+}
+
+// FindUnfinished get the export jobs which are queued or being generated
+func (r *ExportJobRepo) FindUnfinished() ([]ExportJob, error) {
+	if !r.isInitialized() {
+		return []ExportJob{}, ErrDatabaseNotInitialized
+	}
+	jobs := []ExportJob{}
+	err := r.col().Find(bson.M{"status": bson.M{"$in": []ExportJobStatus{ExportQueued, ExportRunning}}}).Sort("created").All(&jobs)
+	return jobs, err
+}
+
+// Claim sets a queued export job as running on this instance, and returns it
+// It fails with mgo.ErrNotFound when the job is not queued anymore, e.g. when it is already generated by another goroutine
+func (r *ExportJobRepo) Claim(id bson.ObjectId) (ExportJob, error) {
+	if !r.isInitialized() {
+		return ExportJob{}, ErrDatabaseNotInitialized
+	}
+	host, _ := os.Hostname()
+	job := ExportJob{}
+	_, err := r.col().Find(bson.M{"_id": id, "status": ExportQueued}).Apply(mgo.Change{
+		Update:    bson.M{"$set": bson.M{"status": ExportRunning, "host": host, "heartbeat": time.Now()}},
+		ReturnNew: true,
+	}, &job)
+	return job, err
+}
+
+// Beat updates the heartbeat of an export job being generated
+func (r *ExportJobRepo) Beat(id bson.ObjectId) error {
+	if !r.isInitialized() {
+		return ErrDatabaseNotInitialized
+	}
+	return r.col().Update(
+		bson.M{"_id": id, "status": ExportRunning},
+		bson.M{"$set": bson.M{"heartbeat": time.Now()}},
+	)
+}
+
+// Requeue sets the export jobs abandoned by their instance back in the queue
+// As job runs, an export whose heartbeat stopped is abandoned, e.g. when its instance crashed. Exports generated by
+// other running instances are left alone
+func (r *ExportJobRepo) Requeue() error {
+	if !r.isInitialized() {
+		return ErrDatabaseNotInitialized
+	}
+	_, err := r.col().UpdateAll(
+		bson.M{"status": ExportRunning, "$or": []bson.M{
+			{"heartbeat": bson.M{"$lt": time.Now().Add(-jobRunStaleAfter)}},
+			{"heartbeat": bson.M{"$exists": false}},
+		}},
+		bson.M{
+			"$set":   bson.M{"status": ExportQueued},
+			"$unset": bson.M{"host": "", "heartbeat": ""},
+		},
+	)
+	return err
+}
+
+// Save updates or create the export job in database
+func (r *ExportJobRepo) Save(job ExportJob) (ExportJob, error) {
+	if !r.isInitialized() {
+		return ExportJob{}, ErrDatabaseNotInitialized
+	}
+
+	if job.ID.Hex() == "" {
+		job.ID = bson.NewObjectId()
+	}
+
+	_, err := r.col().UpsertId(job.ID, bson.M{"$set": job})
+	return job, err
+}
+
+// WriteFile stores the generated file of an export job
+// The file partially written by a previous generation of the job, interrupted by a restart, is replaced
+func (r *ExportJobRepo) WriteFile(job ExportJob, content io.Reader) error {
+	if !r.isInitialized() {
+		return ErrDatabaseNotInitialized
+	}
+	err := r.files().RemoveId(job.ID)
+	if err != nil && err != mgo.ErrNotFound {
+		return err
+	}
+	file, err := r.files().Create(job.FileName)
+	if err != nil {
+		return err
+	}
+	file.SetId(job.ID)
+	file.SetContentType(job.ContentType)
+	if _, err = io.Copy(file, content); err != nil {
+		//nolint:errcheck
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// OpenFile opens the generated file of an export job. The file has to be closed by the caller
+func (r *ExportJobRepo) OpenFile(id bson.ObjectId) (*mgo.GridFile, error) {
+	if !r.isInitialized() {
+		return nil, ErrDatabaseNotInitialized
+	}
+	return r.files().OpenId(id)
+}
+
+// Delete removes the export job and its generated file
+func (r *ExportJobRepo) Delete(id bson.ObjectId) error {
+	if !r.isInitialized() {
+		return ErrDatabaseNotInitialized
+	}
+	err := r.files().RemoveId(id)
+	if err != nil && err != mgo.ErrNotFound {
+		return err
+	}
+	return r.col().RemoveId(id)
+}
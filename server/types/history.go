@@ -0,0 +1,218 @@
+package types
+
+import (
+	"reflect"
+	"strings"
+	"time"
+
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// HistoryAction identifies the kind of operation recorded in a project history entry
+type HistoryAction string
+
+const (
+	// CreateAction is recorded when a project is created
+	CreateAction HistoryAction = "create"
+	// UpdateAction is recorded when a project is updated by a user
+	UpdateAction HistoryAction = "update"
+	// DeleteAction is recorded when a project is deleted
+	DeleteAction HistoryAction = "delete"
+	// JobAction is recorded when a project matrix is updated by a background job
+	JobAction HistoryAction = "job"
+	// RestoreAction is recorded when an archived project is restored
+	RestoreAction HistoryAction = "restore"
+	// PurgeAction is recorded when an archived project is permanently deleted
+	PurgeAction HistoryAction = "purge"
+)
+
+const (
+	// DeploymentJobAuthor is the author recorded for changes made by the deployment job
+	DeploymentJobAuthor = "deployment job"
+	// PurgeJobAuthor is the author recorded for projects permanently deleted by the purge job
+	PurgeJobAuthor = "purge job"
+	// DocktorGroupsAuthor is the author recorded for Docktor group names refreshed from Docktor, by the job or after a save
+	DocktorGroupsAuthor = "docktor groups refresh"
+)
+
+// FieldChange represents the modification of a single field of a project
+type FieldChange struct {
+	Field string      `bson:"field" json:"field"`
+	Old   interface{} `bson:"old" json:"old"`
+	New   interface{} `bson:"new" json:"new"`
+}
+
+// ProjectHistoryEntry represents a change made on a project, by whom and when
+type ProjectHistoryEntry struct {
+	ID          bson.ObjectId `bson:"_id,omitempty" json:"id,omitempty"`
+	ProjectID   bson.ObjectId `bson:"projectID" json:"projectID"`
+	ProjectName string        `bson:"projectName" json:"projectName"`
+	Action      HistoryAction `bson:"action" json:"action"`
+	Author      string        `bson:"author" json:"author"`
+	Date        time.Time     `bson:"date" json:"date"`
+	Changes     []FieldChange `bson:"changes" json:"changes"`
+}
+
+// ignoredHistoryFields are the fields of a project which are not tracked in the history
+var ignoredHistoryFields = map[string]bool{
+	"_id":     true,
+	"created": true,
+	"updated": true,
+	"version": true,
+	"matrix":  true, // Matrix is diffed line by line
+}
+
+// DiffProjects computes the field-level differences between two versions of a project
+// Matrix lines are compared by functional service, and reported as "matrix.<serviceID>.<field>"
+func DiffProjects(oldProject, newProject Project) []FieldChange {
+	changes := diffStruct("", reflect.ValueOf(oldProject), reflect.ValueOf(newProject))
+	return append(changes, diffMatrix(oldProject.Matrix, newProject.Matrix)...)
+}
+
+// diffStruct compares two values of the same struct type, field by field, using bson names as field names
+// Embedded structs are flattened with their bson name as prefix (e.g. "technicalData.mode")
+func diffStruct(prefix string, oldValue, newValue reflect.Value) []FieldChange {
+	changes := []FieldChange{}
+	for i := 0; i < oldValue.NumField(); i++ {
+		field := oldValue.Type().Field(i)
+		name := strings.Split(field.Tag.Get("bson"), ",")[0]
+		if name == "" || name == "-" || ignoredHistoryFields[prefix+name] {
+			continue
+		}
+
+		oldField := oldValue.Field(i)
+		newField := newValue.Field(i)
+		if field.Type.Kind() == reflect.Struct && field.Type != reflect.TypeOf(time.Time{}) {
+			changes = append(changes, diffStruct(prefix+name+".", oldField, newField)...)
+			continue
+		}
+
+		if !sameValue(oldField.Interface(), newField.Interface()) {
+			changes = append(changes, FieldChange{
+				Field: prefix + name,
+				Old:   oldField.Interface(),
+				New:   newField.Interface(),
+			})
+		}
+	}
+	return changes
+}
+
+// diffMatrix compares two matrix, line by line, using the functional service as a key
+func diffMatrix(oldMatrix, newMatrix Matrix) []FieldChange {
+	changes := []FieldChange{}
+
+	oldLines := map[bson.ObjectId]MatrixLine{}
+	for _, line := range oldMatrix {
+		oldLines[line.Service] = line
+	}
+
+	seen := map[bson.ObjectId]bool{}
+	for _, newLine := range newMatrix {
+		seen[newLine.Service] = true
+		oldLine, ok := oldLines[newLine.Service]
+		if !ok {
+			changes = append(changes, FieldChange{Field: "matrix." + newLine.Service.Hex(), New: newLine})
+			continue
+		}
+		changes = append(changes, diffStruct("matrix."+newLine.Service.Hex()+".", reflect.ValueOf(oldLine), reflect.ValueOf(newLine))...)
+	}
+
+	for _, oldLine := range oldMatrix {
+		if !seen[oldLine.Service] {
+			changes = append(changes, FieldChange{Field: "matrix." + oldLine.Service.Hex(), Old: oldLine})
+		}
+	}
+	return changes
+}
+
+// sameValue checks that two field values are equal, considering nil and empty slices as equal
+func sameValue(a, b interface{}) bool {
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	if va.Kind() == reflect.Slice && vb.Kind() == reflect.Slice && va.Len() == 0 && vb.Len() == 0 {
+		return true
+	}
+	if va.Kind() == reflect.Ptr && vb.Kind() == reflect.Ptr && !va.IsNil() && !vb.IsNil() {
+		return reflect.DeepEqual(va.Elem().Interface(), vb.Elem().Interface())
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// ProjectHistoryRepo wraps all requests to database for accessing the history of projects
+type ProjectHistoryRepo struct {
+	database *mgo.Database
+}
+
+// NewProjectHistoryRepo creates a new project history repo from database
+// This ProjectHistoryRepo is wrapping all requests with database
+func NewProjectHistoryRepo(database *mgo.Database) ProjectHistoryRepo {
+	return ProjectHistoryRepo{database: database}
+}
+
+func (r *ProjectHistoryRepo) col() *mgo.Collection {
+	return r.database.C("projectHistory")
+}
+
+func (r *ProjectHistoryRepo) isInitialized() bool {
+	return r.database != nil
+}
+
+// CreateIndexes creates Index
+func (r *ProjectHistoryRepo) CreateIndexes() error {
+	if !r.isInitialized() {
+		return ErrDatabaseNotInitialized
+	}
+	return r.col().EnsureIndex(mgo.Index{
+		Key: []string{"projectID", "-date"},
+	})
+}
+
+// FindByProject gets the history of a project, most recent changes first
+func (r *ProjectHistoryRepo) FindByProject(id bson.ObjectId) ([]ProjectHistoryEntry, error) {
+	if !r.isInitialized() {
+		return []ProjectHistoryEntry{}, ErrDatabaseNotInitialized
+	}
+	entries := []ProjectHistoryEntry{}
+	err := r.col().Find(bson.M{"projectID": id}).Sort("-date").All(&entries)
+	return entries, err
+}
+
+// Save inserts a new history entry in database
+func (r *ProjectHistoryRepo) Save(entry ProjectHistoryEntry) (ProjectHistoryEntry, error) {
+	if !r.isInitialized() {
+		return ProjectHistoryEntry{}, ErrDatabaseNotInitialized
+	}
+
+	if entry.ID.Hex() == "" {
+		entry.ID = bson.NewObjectId()
+	}
+	if entry.Date.IsZero() {
+		entry.Date = time.Now()
+	}
+
+	err := r.col().Insert(entry)
+	return entry, err
+}
+
+// Record computes the changes between two versions of a project and saves them in history
+// Nothing is recorded for an update without any change
+func (r *ProjectHistoryRepo) Record(action HistoryAction, author string, oldProject, newProject Project) (ProjectHistoryEntry, error) {
+	changes := DiffProjects(oldProject, newProject)
+	if action != DeleteAction && action != PurgeAction && len(changes) == 0 {
+		return ProjectHistoryEntry{}, nil
+	}
+
+	project := newProject
+	if action == PurgeAction {
+		project = oldProject
+	}
+
+	return r.Save(ProjectHistoryEntry{
+		ProjectID:   project.ID,
+		ProjectName: project.Name,
+		Action:      action,
+		Author:      author,
+		Changes:     changes,
+	})
+}
@@ -0,0 +1,61 @@
+package types
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestDiffProjects(t *testing.T) {
+
+	jenkins := bson.NewObjectId()
+	sonar := bson.NewObjectId()
+
+	Convey("Given two identical projects", t, func() {
+		project := Project{Name: "DAD", Matrix: Matrix{{Service: jenkins, Progress: 2}}}
+		Convey("When calling the DiffProjects function", func() {
+			changes := DiffProjects(project, project)
+			Convey("Then no change is found", func() {
+				So(changes, ShouldBeEmpty)
+			})
+		})
+	})
+
+	Convey("Given a project with nil and empty slices", t, func() {
+		oldProject := Project{Name: "DAD"}
+		newProject := Project{Name: "DAD", Domain: []string{}, Deputies: []string{}}
+		Convey("When calling the DiffProjects function", func() {
+			changes := DiffProjects(oldProject, newProject)
+			Convey("Then no change is found", func() {
+				So(changes, ShouldBeEmpty)
+			})
+		})
+	})
+
+	Convey("Given a project with modified details", t, func() {
+		oldProject := Project{Name: "DAD", TechnicalData: TechnicalData{Mode: "SaaS"}}
+		newProject := Project{Name: "D.A.D", TechnicalData: TechnicalData{Mode: "DMZ"}}
+		Convey("When calling the DiffProjects function", func() {
+			changes := DiffProjects(oldProject, newProject)
+			Convey("Then the changes are reported with their bson names", func() {
+				So(changes, ShouldHaveLength, 2)
+				So(changes, ShouldContain, FieldChange{Field: "name", Old: "DAD", New: "D.A.D"})
+				So(changes, ShouldContain, FieldChange{Field: "technicalData.mode", Old: "SaaS", New: "DMZ"})
+			})
+		})
+	})
+
+	Convey("Given a project with a modified matrix", t, func() {
+		oldProject := Project{Matrix: Matrix{{Service: jenkins, Progress: 2}, {Service: sonar, Progress: 1}}}
+		newProject := Project{Matrix: Matrix{{Service: jenkins, Progress: 4}}}
+		Convey("When calling the DiffProjects function", func() {
+			changes := DiffProjects(oldProject, newProject)
+			Convey("Then the matrix changes are reported by functional service", func() {
+				So(changes, ShouldHaveLength, 2)
+				So(changes, ShouldContain, FieldChange{Field: "matrix." + jenkins.Hex() + ".progress", Old: 2, New: 4})
+				So(changes, ShouldContain, FieldChange{Field: "matrix." + sonar.Hex(), Old: oldProject.Matrix[1]})
+			})
+		})
+	})
+}
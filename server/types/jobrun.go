@@ -0,0 +1,241 @@
+package types
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// ErrJobRunning is returned when starting a job whose previous run is still in progress, on this instance or another one
+var ErrJobRunning = errors.New("Job is already running")
+
+// JobRunHeartbeat is the interval at which a running job signals it is still alive
+const JobRunHeartbeat = 30 * time.Second
+
+// jobRunStaleAfter is the delay after which a run without heartbeat is considered abandoned, e.g. when its instance crashed
+const jobRunStaleAfter = 10 * JobRunHeartbeat
+
+// JobTrigger is what started a job run
+type JobTrigger string
+
+const (
+	// CronTrigger is the trigger of the runs scheduled by the recurrence of the job
+	CronTrigger JobTrigger = "cron"
+	// ManualTrigger is the trigger of the runs requested by an administrator
+	ManualTrigger JobTrigger = "manual"
+)
+
+// JobRunStatus is the status of a job run
+type JobRunStatus string
+
+const (
+	// JobRunning is the status of a run in progress
+	JobRunning JobRunStatus = "running"
+	// JobDone is the status of a run which completed
+	JobDone JobRunStatus = "done"
+	// JobFailed is the status of a run which stopped because of an error
+	JobFailed JobRunStatus = "failed"
+	// JobCancelled is the status of a run stopped before its end, e.g. when the request triggering it was cancelled
+	JobCancelled JobRunStatus = "cancelled"
+)
+
+// JobRunOutcome is the outcome of a job run for a single project
+type JobRunOutcome string
+
+const (
+	// OutcomeUpdated is the outcome of a project updated by the job
+	OutcomeUpdated JobRunOutcome = "updated"
+	// OutcomeUnchanged is the outcome of a project the job didn't need to update
+	OutcomeUnchanged JobRunOutcome = "unchanged"
+	// OutcomeInError is the outcome of a project the job failed to process
+	OutcomeInError JobRunOutcome = "error"
+	// OutcomeSkipped is the outcome of a project not processed because the run was stopped
+	OutcomeSkipped JobRunOutcome = "skipped"
+)
+
+// JobRunProject is the outcome of a job run for a project
+type JobRunProject struct {
+	Project bson.ObjectId `bson:"project" json:"project"`
+	Name    string        `bson:"name" json:"name"`
+	Outcome JobRunOutcome `bson:"outcome" json:"outcome"`
+	Error   string        `bson:"error,omitempty" json:"error,omitempty"`
+}
+
+// JobRun is an execution of a background job, either scheduled or requested by an administrator
+type JobRun struct {
+	ID        bson.ObjectId    `bson:"_id,omitempty" json:"id,omitempty"`
+	Job       string           `bson:"job" json:"job"`
+	Status    JobRunStatus     `bson:"status" json:"status"`
+	Trigger   JobTrigger       `bson:"trigger" json:"trigger"`
+	Username  string           `bson:"username,omitempty" json:"username,omitempty"` // User who requested a manual run
+	Host      string           `bson:"host" json:"host"`                             // Instance of DAD executing the run
+	Started   time.Time        `bson:"started" json:"started"`
+	Finished  *time.Time       `bson:"finished,omitempty" json:"finished,omitempty"`
+	Heartbeat time.Time        `bson:"heartbeat" json:"-"`
+	Summary   string           `bson:"summary,omitempty" json:"summary,omitempty"`
+	Error     string           `bson:"error,omitempty" json:"error,omitempty"`
+	Projects  []JobRunProject  `bson:"projects,omitempty" json:"projects,omitempty"`
+	DryRun    bool             `bson:"dryRun,omitempty" json:"dryRun,omitempty"` // The run computed the changes of the job without saving them
+	Diffs     []DeploymentDiff `bson:"diffs,omitempty" json:"diffs,omitempty"`   // Changes proposed by a dry run
+	// Lock is the name of the job while the run is in progress. Its unique index prevents concurrent runs of a job
+	Lock string `bson:"lock,omitempty" json:"-"`
+}
+
+// NewJobRun creates a run of a job, not started yet
+func NewJobRun(job string, trigger JobTrigger, username string) JobRun {
+	host, _ := os.Hostname()
+	return JobRun{Job: job, Trigger: trigger, Username: username, Host: host}
+}
+
+// Finish sets the result of the run
+// A run returning an error is failed, or cancelled when its context was cancelled
+func (run JobRun) Finish(summary string, projects []JobRunProject, err error, cancelled bool) JobRun {
+	now := time.Now()
+	run.Finished = &now
+	run.Summary = summary
+	run.Projects = projects
+	run.Status = JobDone
+	if err != nil {
+		run.Error = err.Error()
+		run.Status = JobFailed
+		if cancelled {
+			run.Status = JobCancelled
+		}
+	}
+	return run
+}
+
+// JobRunRepo wraps all requests to database for accessing job runs
+type JobRunRepo struct {
+	database *mgo.Database
+}
+
+// NewJobRunRepo creates a new job run repo from database
+// This JobRunRepo is wrapping all requests with database
+func NewJobRunRepo(database *mgo.Database) JobRunRepo {
+	return JobRunRepo{database: database}
+}
+
+func (r *JobRunRepo) col() *mgo.Collection {
+	return r.database.C("jobRuns")
+}
+
+func (r *JobRunRepo) isInitialized() bool {
+	return r.database != nil
+}
+
+// CreateIndexes creates Index
+func (r *JobRunRepo) CreateIndexes() error {
+	if !r.isInitialized() {
+		return ErrDatabaseNotInitialized
+	}
+	err := r.col().EnsureIndex(mgo.Index{
+		Key:    []string{"lock"},
+		Unique: true,
+		Sparse: true,
+	})
+	if err != nil {
+		return err
+	}
+	return r.col().EnsureIndex(mgo.Index{
+		Key: []string{"job", "-started"},
+	})
+}
+
+// Start records the run as running, unless another run of the same job is in progress
+// As the guard relies on a unique index, it is shared by all the instances of DAD using the database.
+// A run whose heartbeat stopped is considered abandoned, it is marked as failed and doesn't prevent new runs.
+func (r *JobRunRepo) Start(run JobRun) (JobRun, error) {
+	if !r.isInitialized() {
+		return JobRun{}, ErrDatabaseNotInitialized
+	}
+
+	now := time.Now()
+	run.ID = bson.NewObjectId()
+	run.Status = JobRunning
+	run.Started = now
+	run.Heartbeat = now
+	run.Lock = run.Job
+
+	err := r.col().Insert(run)
+	if !mgo.IsDup(err) {
+		return run, err
+	}
+
+	info, err := r.col().UpdateAll(
+		bson.M{"lock": run.Job, "heartbeat": bson.M{"$lt": now.Add(-jobRunStaleAfter)}},
+		bson.M{
+			"$set":   bson.M{"status": JobFailed, "error": "Run was abandoned by its instance", "finished": now},
+			"$unset": bson.M{"lock": ""},
+		},
+	)
+	if err != nil {
+		return JobRun{}, err
+	}
+	if info.Updated == 0 {
+		return JobRun{}, ErrJobRunning
+	}
+
+	err = r.col().Insert(run)
+	if mgo.IsDup(err) {
+		return JobRun{}, ErrJobRunning
+	}
+	return run, err
+}
+
+// Beat updates the heartbeat of a running run
+func (r *JobRunRepo) Beat(id bson.ObjectId) error {
+	if !r.isInitialized() {
+		return ErrDatabaseNotInitialized
+	}
+	return r.col().Update(
+		bson.M{"_id": id, "status": JobRunning},
+		bson.M{"$set": bson.M{"heartbeat": time.Now()}},
+	)
+}
+
+// Finish saves the result of the run, and releases the job for the next runs
+func (r *JobRunRepo) Finish(run JobRun) error {
+	if !r.isInitialized() {
+		return ErrDatabaseNotInitialized
+	}
+	return r.col().UpdateId(run.ID, bson.M{
+		"$set": bson.M{
+			"status":   run.Status,
+			"finished": run.Finished,
+			"summary":  run.Summary,
+			"error":    run.Error,
+			"projects": run.Projects,
+			"diffs":    run.Diffs,
+		},
+		"$unset": bson.M{"lock": ""},
+	})
+}
+
+// FindByID get the job run by its id
+func (r *JobRunRepo) FindByID(id bson.ObjectId) (JobRun, error) {
+	if !r.isInitialized() {
+		return JobRun{}, ErrDatabaseNotInitialized
+	}
+	result := JobRun{}
+	err := r.col().FindId(id).One(&result)
+	return result, err
+}
+
+// FindRecent get the most recent runs, of all jobs or of the given job, most recent first
+// The outcome of each project and the changes proposed by dry runs are not loaded, as they are only needed for the details of a run
+func (r *JobRunRepo) FindRecent(job string, limit int) ([]JobRun, error) {
+	if !r.isInitialized() {
+		return []JobRun{}, ErrDatabaseNotInitialized
+	}
+	query := bson.M{}
+	if job != "" {
+		query["job"] = job
+	}
+	runs := []JobRun{}
+	err := r.col().Find(query).Select(bson.M{"projects": 0, "diffs": 0}).Sort("-started").Limit(limit).All(&runs)
+	return runs, err
+}
@@ -0,0 +1,42 @@
+package types
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFinishJobRun(t *testing.T) {
+
+	Convey("Given a running job", t, func() {
+		run := NewJobRun("purge", ManualTrigger, "jdoe")
+		run.Status = JobRunning
+
+		Convey("When it completes", func() {
+			run := run.Finish("2 projects purged", nil, nil, false)
+			Convey("Then it is done", func() {
+				So(run.Status, ShouldEqual, JobDone)
+				So(run.Summary, ShouldEqual, "2 projects purged")
+				So(run.Error, ShouldBeEmpty)
+				So(run.Finished, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When it fails", func() {
+			run := run.Finish("", nil, errors.New("Docktor is down"), false)
+			Convey("Then it is failed with the error", func() {
+				So(run.Status, ShouldEqual, JobFailed)
+				So(run.Error, ShouldEqual, "Docktor is down")
+			})
+		})
+
+		Convey("When it is stopped by the cancellation of its context", func() {
+			run := run.Finish("", nil, context.Canceled, true)
+			Convey("Then it is cancelled", func() {
+				So(run.Status, ShouldEqual, JobCancelled)
+			})
+		})
+	})
+}
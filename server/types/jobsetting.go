@@ -0,0 +1,66 @@
+package types
+
+import (
+	"time"
+
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// JobSetting is the setting of a background job changed at runtime by an administrator
+// It is shared by all the instances of DAD using the database
+type JobSetting struct {
+	Job      string    `bson:"_id" json:"job"`
+	Enabled  bool      `bson:"enabled" json:"enabled"`
+	Username string    `bson:"username" json:"username"` // Administrator who changed the setting
+	Updated  time.Time `bson:"updated" json:"updated"`
+}
+
+// JobSettingRepo wraps all requests to database for accessing the settings of jobs
+type JobSettingRepo struct {
+	database *mgo.Database
+}
+
+// NewJobSettingRepo creates a new job setting repo from database
+// This JobSettingRepo is wrapping all requests with database
+func NewJobSettingRepo(database *mgo.Database) JobSettingRepo {
+	return JobSettingRepo{database: database}
+}
+
+func (r *JobSettingRepo) col() *mgo.Collection {
+	return r.database.C("jobSettings")
+}
+
+func (r *JobSettingRepo) isInitialized() bool {
+	return r.database != nil
+}
+
+// FindByJob get the setting of a job. It fails with mgo.ErrNotFound when the setting was never changed
+func (r *JobSettingRepo) FindByJob(job string) (JobSetting, error) {
+	if !r.isInitialized() {
+		return JobSetting{}, ErrDatabaseNotInitialized
+	}
+	result := JobSetting{}
+	err := r.col().FindId(job).One(&result)
+	return result, err
+}
+
+// FindAll get the settings of all jobs whose setting was changed
+func (r *JobSettingRepo) FindAll() ([]JobSetting, error) {
+	if !r.isInitialized() {
+		return []JobSetting{}, ErrDatabaseNotInitialized
+	}
+	settings := []JobSetting{}
+	err := r.col().Find(bson.M{}).All(&settings)
+	return settings, err
+}
+
+// Save updates or create the setting of a job
+func (r *JobSettingRepo) Save(setting JobSetting) (JobSetting, error) {
+	if !r.isInitialized() {
+		return JobSetting{}, ErrDatabaseNotInitialized
+	}
+	setting.Updated = time.Now()
+	_, err := r.col().UpsertId(setting.Job, bson.M{"$set": setting})
+	return setting, err
+}
@@ -0,0 +1,126 @@
+package types
+
+import (
+	"fmt"
+	"time"
+
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// ProjectRole identifies the rights of a member on a project
+type ProjectRole string
+
+const (
+	// ViewerRole is a member who can see the project
+	ViewerRole ProjectRole = "viewer"
+	// ContributorRole is a member who can see and modify the project, like its matrix lines
+	ContributorRole ProjectRole = "contributor"
+	// OwnerRole is a member who can see and modify the project, and manage its members
+	OwnerRole ProjectRole = "owner"
+)
+
+// ProjectRoles is the list of every project roles
+var ProjectRoles = []ProjectRole{ViewerRole, ContributorRole, OwnerRole}
+
+// IsValid checks if a project role is valid
+func (r ProjectRole) IsValid() bool {
+	return r == ViewerRole || r == ContributorRole || r == OwnerRole
+}
+
+// includes checks that the role gives at least the rights of the other role
+func (r ProjectRole) includes(other ProjectRole) bool {
+	rank := map[ProjectRole]int{ViewerRole: 1, ContributorRole: 2, OwnerRole: 3}
+	return rank[r] >= rank[other]
+}
+
+// ProjectMember is a user given a role on a project
+type ProjectMember struct {
+	User string      `bson:"user" json:"user"` // ID of the user
+	Role ProjectRole `bson:"role" json:"role"`
+}
+
+// MemberRole returns the role of the user on the project, empty when the user is not a member
+// The project manager and the deputies are owners of the project
+func (p Project) MemberRole(id bson.ObjectId) ProjectRole {
+	if p.ProjectManager == id.Hex() {
+		return OwnerRole
+	}
+	for _, deputy := range p.Deputies {
+		if deputy == id.Hex() {
+			return OwnerRole
+		}
+	}
+	for _, member := range p.Members {
+		if member.User == id.Hex() {
+			return member.Role
+		}
+	}
+	return ""
+}
+
+// byMember returns the conditions matching the projects where the user is project manager, deputy, or member with one of the roles
+func byMember(id bson.ObjectId, roles []ProjectRole) []bson.M {
+	conditions := []bson.M{
+		{"projectManager": id.Hex()},
+		{"deputies": id.Hex()},
+	}
+	if len(roles) > 0 {
+		conditions = append(conditions, bson.M{"members": bson.M{"$elemMatch": bson.M{"user": id.Hex(), "role": bson.M{"$in": roles}}}})
+	}
+	return conditions
+}
+
+// FindByMember get all projects where the user is project manager, deputy, or member with one of the roles
+// Without roles, only the projects where the user is project manager or deputy are returned
+func (r *ProjectRepo) FindByMember(id bson.ObjectId, roles ...ProjectRole) ([]Project, error) {
+	if !r.isInitialized() {
+		return []Project{}, ErrDatabaseNotInitialized
+	}
+	projects := []Project{}
+	err := r.col().Find(bson.M{"$or": byMember(id, roles), "archived": notArchived["archived"]}).All(&projects)
+	if err != nil {
+		return []Project{}, fmt.Errorf("Can't retrieve projects for member %s", id.Hex())
+	}
+	return projects, nil
+}
+
+// SetMember atomically gives a role on the project to the user, replacing his previous role
+func (r *ProjectRepo) SetMember(id bson.ObjectId, member ProjectMember) error {
+	if !r.isInitialized() {
+		return ErrDatabaseNotInitialized
+	}
+	err := r.col().Update(
+		bson.M{"_id": id, "members.user": member.User},
+		bson.M{
+			"$set": bson.M{"members.$.role": member.Role, "updated": time.Now()},
+			"$inc": bson.M{"version": 1},
+		},
+	)
+	if err != mgo.ErrNotFound {
+		return err
+	}
+	return r.col().Update(
+		bson.M{"_id": id, "members.user": bson.M{"$ne": member.User}},
+		bson.M{
+			"$push": bson.M{"members": member},
+			"$set":  bson.M{"updated": time.Now()},
+			"$inc":  bson.M{"version": 1},
+		},
+	)
+}
+
+// RemoveMember atomically removes the user from the members of the project
+func (r *ProjectRepo) RemoveMember(id bson.ObjectId, user string) error {
+	if !r.isInitialized() {
+		return ErrDatabaseNotInitialized
+	}
+	return r.col().UpdateId(
+		id,
+		bson.M{
+			"$pull": bson.M{"members": bson.M{"user": user}},
+			"$set":  bson.M{"updated": time.Now()},
+			"$inc":  bson.M{"version": 1},
+		},
+	)
+}
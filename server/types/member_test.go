@@ -0,0 +1,35 @@
+package types
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestMemberRole(t *testing.T) {
+
+	Convey("Given a project with a manager, a deputy and members", t, func() {
+		manager, deputy, architect, qa, other := bson.NewObjectId(), bson.NewObjectId(), bson.NewObjectId(), bson.NewObjectId(), bson.NewObjectId()
+		project := Project{
+			ProjectManager: manager.Hex(),
+			Deputies:       []string{deputy.Hex()},
+			Members: []ProjectMember{
+				{User: architect.Hex(), Role: ContributorRole},
+				{User: qa.Hex(), Role: ViewerRole},
+			},
+		}
+
+		Convey("Then the manager and the deputies are owners", func() {
+			So(project.MemberRole(manager), ShouldEqual, OwnerRole)
+			So(project.MemberRole(deputy), ShouldEqual, OwnerRole)
+		})
+		Convey("Then members have their role", func() {
+			So(project.MemberRole(architect), ShouldEqual, ContributorRole)
+			So(project.MemberRole(qa), ShouldEqual, ViewerRole)
+		})
+		Convey("Then other users have no role", func() {
+			So(project.MemberRole(other), ShouldBeEmpty)
+		})
+	})
+}
@@ -0,0 +1,162 @@
+package types
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Permission is a named right on the application, given to roles by the policy
+type Permission string
+
+const (
+	// ProjectsAllPermission allows to see and modify every project
+	ProjectsAllPermission Permission = "projects.all"
+	// ProjectsEntitiesPermission allows to see and modify the projects of the user's entities
+	ProjectsEntitiesPermission Permission = "projects.entities"
+	// ProjectCreatePermission allows to create projects
+	ProjectCreatePermission Permission = "project.create"
+	// ProjectImportPermission allows to import projects from a file
+	ProjectImportPermission Permission = "project.import"
+	// ProjectDeletePermission allows to delete projects
+	ProjectDeletePermission Permission = "project.delete"
+	// ProjectArchivesPermission allows to see and restore deleted projects
+	ProjectArchivesPermission Permission = "project.archives"
+	// ProjectDetailsEditPermission allows to edit the name, domain, manager, entities and Docktor group of projects
+	ProjectDetailsEditPermission Permission = "project.details.edit"
+	// ProjectModeEditPermission allows to edit the deployment mode of projects
+	ProjectModeEditPermission Permission = "project.mode.edit"
+	// ProjectEntitiesAnyPermission allows to assign any entity to projects, not only the user's entities
+	ProjectEntitiesAnyPermission Permission = "project.entities.any"
+	// ProjectMembersPermission allows to manage the members of the projects the user can modify. Owners of a project can always manage its members
+	ProjectMembersPermission Permission = "project.members.edit"
+	// ProjectDocktorEditPermission allows to edit the Docktor information of projects
+	ProjectDocktorEditPermission Permission = "project.docktor.edit"
+	// MatrixEditPermission allows to edit the progress, due date and comment of matrix lines
+	MatrixEditPermission Permission = "matrix.edit"
+	// MatrixGoalsEditPermission allows to edit the goal and priority of matrix lines
+	MatrixGoalsEditPermission Permission = "matrix.goals.edit"
+	// MatrixDeployedEditPermission allows to override the deployed status of matrix lines, computed by the deployment job
+	MatrixDeployedEditPermission Permission = "matrix.deployed.edit"
+	// ExportAllPermission allows to export every project
+	ExportAllPermission Permission = "export.all"
+	// TrendsAllPermission allows to see the trend of every project, including deleted ones
+	TrendsAllPermission Permission = "trends.all"
+	// UsersEditPermission allows to edit users
+	UsersEditPermission Permission = "users.edit"
+	// UsersManagePermission allows to create, delete and log out users, and to edit their role and entities
+	UsersManagePermission Permission = "users.manage"
+	// EntitiesEditPermission allows to create, edit and delete entities
+	EntitiesEditPermission Permission = "entities.edit"
+	// ServicesEditPermission allows to create, edit and delete functional services
+	ServicesEditPermission Permission = "services.edit"
+	// TechnologiesEditPermission allows to create technologies
+	TechnologiesEditPermission Permission = "technologies.edit"
+	// LanguagesEditPermission allows to create languages
+	LanguagesEditPermission Permission = "languages.edit"
+	// UsageIndicatorsPermission allows to see and import usage indicators
+	UsageIndicatorsPermission Permission = "indicators.manage"
+	// APITokensAllPermission allows to see and revoke the API tokens of every user
+	APITokensAllPermission Permission = "tokens.all"
+	// JobsPermission allows to execute the background jobs on demand
+	JobsPermission Permission = "jobs.execute"
+)
+
+// AllPermissions is the list of every permissions
+var AllPermissions = []Permission{
+	ProjectsAllPermission, ProjectsEntitiesPermission,
+	ProjectCreatePermission, ProjectImportPermission, ProjectDeletePermission, ProjectArchivesPermission,
+	ProjectDetailsEditPermission, ProjectModeEditPermission, ProjectEntitiesAnyPermission, ProjectMembersPermission, ProjectDocktorEditPermission,
+	MatrixEditPermission, MatrixGoalsEditPermission, MatrixDeployedEditPermission,
+	ExportAllPermission, TrendsAllPermission,
+	UsersEditPermission, UsersManagePermission,
+	EntitiesEditPermission, ServicesEditPermission, TechnologiesEditPermission, LanguagesEditPermission,
+	UsageIndicatorsPermission, APITokensAllPermission, JobsPermission,
+}
+
+// IsValid checks if a permission is known
+func (p Permission) IsValid() bool {
+	for _, permission := range AllPermissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// Policy gives permissions to each role
+type Policy map[Role][]Permission
+
+// DefaultPolicy returns the permissions of each role when they are not configured
+func DefaultPolicy() Policy {
+	return Policy{
+		AdminRole: AllPermissions,
+		RIRole: {
+			ProjectsEntitiesPermission,
+			ProjectCreatePermission, ProjectImportPermission, ProjectDeletePermission, ProjectDetailsEditPermission, ProjectMembersPermission,
+			MatrixEditPermission, MatrixGoalsEditPermission,
+			UsersEditPermission,
+		},
+		PMRole:     {ProjectModeEditPermission, MatrixEditPermission},
+		DeputyRole: {ProjectModeEditPermission, MatrixEditPermission},
+	}
+}
+
+// WithConfig returns the policy where the permissions of the configured roles are replaced
+// The configuration maps role names to permission names. Roles which are not configured keep their permissions
+func (p Policy) WithConfig(config map[string][]string) (Policy, error) {
+	policy := Policy{}
+	for role, permissions := range p {
+		policy[role] = permissions
+	}
+	for name, names := range config {
+		role := Role(name)
+		if !role.IsValid() {
+			return nil, fmt.Errorf("Role %q of the permissions is not valid", name)
+		}
+		permissions := []Permission{}
+		for _, n := range names {
+			permission := Permission(n)
+			if !permission.IsValid() {
+				return nil, fmt.Errorf("Permission %q of the role %s is not valid, it should be one of %v", n, role, AllPermissions)
+			}
+			permissions = append(permissions, permission)
+		}
+		policy[role] = permissions
+	}
+	return policy, nil
+}
+
+// Permissions returns the sorted permissions of the role
+func (p Policy) Permissions(role Role) []Permission {
+	permissions := append([]Permission{}, p[role]...)
+	sort.Slice(permissions, func(i, j int) bool { return permissions[i] < permissions[j] })
+	return permissions
+}
+
+// Allows checks that the role has the permission
+func (p Policy) Allows(role Role, permission Permission) bool {
+	for _, p := range p[role] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// policy is the policy of the application, set at startup
+var policy = DefaultPolicy()
+
+// SetPolicy sets the policy checking the permissions of all users
+func SetPolicy(p Policy) {
+	policy = p
+}
+
+// Can checks that the user has the permission, through his role
+func (u User) Can(permission Permission) bool {
+	return policy.Allows(u.Role, permission)
+}
+
+// Permissions returns the permissions of the user, through his role
+func (u User) Permissions() []Permission {
+	return policy.Permissions(u.Role)
+}
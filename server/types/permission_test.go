@@ -0,0 +1,64 @@
+package types
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPolicy(t *testing.T) {
+
+	Convey("Given the default policy", t, func() {
+		policy := DefaultPolicy()
+		Convey("Then admins have every permission", func() {
+			for _, permission := range AllPermissions {
+				So(policy.Allows(AdminRole, permission), ShouldBeTrue)
+			}
+		})
+		Convey("Then RIs can edit project details but not the deployment mode", func() {
+			So(policy.Allows(RIRole, ProjectDetailsEditPermission), ShouldBeTrue)
+			So(policy.Allows(RIRole, ProjectModeEditPermission), ShouldBeFalse)
+		})
+		Convey("Then project managers can edit the deployment mode but not project details", func() {
+			So(policy.Allows(PMRole, ProjectModeEditPermission), ShouldBeTrue)
+			So(policy.Allows(PMRole, ProjectDetailsEditPermission), ShouldBeFalse)
+		})
+		Convey("Then unknown roles have no permission", func() {
+			So(policy.Allows(Role("guest"), MatrixEditPermission), ShouldBeFalse)
+			So(policy.Permissions(Role("guest")), ShouldBeEmpty)
+		})
+	})
+
+	Convey("Given a configuration of the permissions of RIs", t, func() {
+		config := map[string][]string{"ri": {"project.mode.edit", "export.all"}}
+		Convey("When applying it to the default policy", func() {
+			policy, err := DefaultPolicy().WithConfig(config)
+			Convey("Then the permissions of RIs are replaced", func() {
+				So(err, ShouldBeNil)
+				So(policy.Permissions(RIRole), ShouldResemble, []Permission{ExportAllPermission, ProjectModeEditPermission})
+				So(policy.Allows(RIRole, ProjectDetailsEditPermission), ShouldBeFalse)
+			})
+			Convey("Then the permissions of other roles are kept", func() {
+				So(policy.Permissions(PMRole), ShouldResemble, DefaultPolicy().Permissions(PMRole))
+			})
+			Convey("Then the default policy is not modified", func() {
+				So(DefaultPolicy().Allows(RIRole, ProjectDetailsEditPermission), ShouldBeTrue)
+			})
+		})
+	})
+
+	Convey("Given invalid configurations", t, func() {
+		Convey("When a permission is unknown", func() {
+			_, err := DefaultPolicy().WithConfig(map[string][]string{"ri": {"project.everything"}})
+			Convey("Then an error is returned", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+		Convey("When a role is unknown", func() {
+			_, err := DefaultPolicy().WithConfig(map[string][]string{"guest": {"matrix.edit"}})
+			Convey("Then an error is returned", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
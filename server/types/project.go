@@ -46,9 +46,163 @@ type MatrixLine struct {
 	Comment  string        `bson:"comment" json:"comment"`
 }
 
+// Patch returns the patch changing the matrix line into the other one, with only the fields which differ
+func (l MatrixLine) Patch(to MatrixLine) MatrixLinePatch {
+	patch := MatrixLinePatch{}
+	if l.Deployed != to.Deployed {
+		patch.Deployed = &to.Deployed
+	}
+	if l.Progress != to.Progress {
+		patch.Progress = &to.Progress
+	}
+	if l.Goal != to.Goal {
+		patch.Goal = &to.Goal
+	}
+	if l.Priority != to.Priority {
+		patch.Priority = &to.Priority
+	}
+	if to.DueDate == nil && l.DueDate != nil {
+		patch.ClearDueDate = true
+	} else if to.DueDate != nil && (l.DueDate == nil || !l.DueDate.Equal(*to.DueDate)) {
+		patch.DueDate = to.DueDate
+	}
+	if l.Comment != to.Comment {
+		patch.Comment = &to.Comment
+	}
+	return patch
+}
+
 // Matrix represent a slice of matrix lines
 type Matrix []MatrixLine
 
+// FullProgress is the progress code of a fully deployed functional service
+const FullProgress = 5
+
+// TargetGoal returns the goal of the matrix line. Without goal, the functional service is expected to be fully deployed
+func (l MatrixLine) TargetGoal() int {
+	if l.Goal < 0 {
+		return FullProgress
+	}
+	return l.Goal
+}
+
+// IsOverdue checks whether the due date of the matrix line is before the given date while its goal is not reached
+// Lines whose progress is N/A are never overdue
+func (l MatrixLine) IsOverdue(date time.Time) bool {
+	return l.DueDate != nil && l.DueDate.Before(date) && l.Progress >= 0 && l.Progress < l.TargetGoal()
+}
+
+// Overdue returns the matrix lines which are overdue at the given date
+func (m Matrix) Overdue(date time.Time) Matrix {
+	lines := Matrix{}
+	for _, line := range m {
+		if line.IsOverdue(date) {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// MatrixLinePatch contains the fields of a matrix line to update. Nil fields are left unchanged
+type MatrixLinePatch struct {
+	Deployed     *string    `json:"deployed,omitempty"`
+	Progress     *int       `json:"progress,omitempty"`
+	Goal         *int       `json:"goal,omitempty"`
+	Priority     *string    `json:"priority,omitempty"`
+	DueDate      *time.Time `json:"dueDate,omitempty"`
+	ClearDueDate bool       `json:"clearDueDate,omitempty"` // Removes the due date, as a nil DueDate leaves it unchanged
+	Comment      *string    `json:"comment,omitempty"`
+}
+
+// Validate checks that the values of the patch are known codes
+func (patch MatrixLinePatch) Validate() error {
+	if patch.Deployed != nil && !ContainsValue(Deployed, *patch.Deployed) {
+		return fmt.Errorf("Deployed status %q is not valid", *patch.Deployed)
+	}
+	if _, ok := Progress[intValue(patch.Progress)]; !ok {
+		return fmt.Errorf("Progress %v is not valid", *patch.Progress)
+	}
+	if _, ok := Progress[intValue(patch.Goal)]; !ok {
+		return fmt.Errorf("Goal %v is not valid", *patch.Goal)
+	}
+	if patch.Priority != nil && !ContainsValue(Priority, *patch.Priority) {
+		return fmt.Errorf("Priority %q is not valid", *patch.Priority)
+	}
+	if patch.DueDate != nil && patch.ClearDueDate {
+		return errors.New("Due date can't be both set and cleared")
+	}
+	return nil
+}
+
+// Fields returns the modified fields of the patch, indexed by their bson name
+func (patch MatrixLinePatch) Fields() bson.M {
+	fields := bson.M{}
+	if patch.Deployed != nil {
+		fields["deployed"] = *patch.Deployed
+	}
+	if patch.Progress != nil {
+		fields["progress"] = *patch.Progress
+	}
+	if patch.Goal != nil {
+		fields["goal"] = *patch.Goal
+	}
+	if patch.Priority != nil {
+		fields["priority"] = *patch.Priority
+	}
+	if patch.DueDate != nil {
+		fields["dueDate"] = *patch.DueDate
+	} else if patch.ClearDueDate {
+		fields["dueDate"] = nil
+	}
+	if patch.Comment != nil {
+		fields["comment"] = *patch.Comment
+	}
+	return fields
+}
+
+// Apply returns a copy of the matrix line, updated with the fields of the patch
+func (patch MatrixLinePatch) Apply(line MatrixLine) MatrixLine {
+	if patch.Deployed != nil {
+		line.Deployed = *patch.Deployed
+	}
+	if patch.Progress != nil {
+		line.Progress = *patch.Progress
+	}
+	if patch.Goal != nil {
+		line.Goal = *patch.Goal
+	}
+	if patch.Priority != nil {
+		line.Priority = *patch.Priority
+	}
+	if patch.DueDate != nil {
+		line.DueDate = patch.DueDate
+	} else if patch.ClearDueDate {
+		line.DueDate = nil
+	}
+	if patch.Comment != nil {
+		line.Comment = *patch.Comment
+	}
+	return line
+}
+
+// intValue returns the value of an optional code, or a valid code when not set
+func intValue(code *int) int {
+	if code == nil {
+		return 0
+	}
+	return *code
+}
+
+// ContainsValue checks that a code map contains the given string representation
+func ContainsValue(codes map[int]string, value string) bool {
+	for _, v := range codes {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
 // TechnicalData contains the technical data of a project
 type TechnicalData struct {
 	Technologies                   []string `bson:"technologies" json:"technologies"`
@@ -76,6 +230,7 @@ type Project struct {
 	Client         string                         `bson:"client" json:"client"`
 	ProjectManager string                         `bson:"projectManager" json:"projectManager"`
 	Deputies       []string                       `bson:"deputies" json:"deputies"`
+	Members        []ProjectMember                `bson:"members" json:"members"` // Users given a role on the project, managed apart from the project
 	BusinessUnit   string                         `bson:"businessUnit" json:"businessUnit"`
 	ServiceCenter  []string                       `bson:"serviceCenter" json:"serviceCenter"`
 	DocktorURL     `bson:"docktorURL" json:""`    // json is an empty string because we want to flatten the object to avoid client-side null-checks
@@ -83,8 +238,15 @@ type Project struct {
 	Matrix         Matrix                         `bson:"matrix" json:"matrix"`
 	Created        time.Time                      `bson:"created" json:"created"`
 	Updated        time.Time                      `bson:"updated" json:"updated"`
+	Archived       bool                           `bson:"archived,omitempty" json:"archived,omitempty"`
+	ArchivedBy     string                         `bson:"archivedBy,omitempty" json:"archivedBy,omitempty"`
+	ArchivedAt     *time.Time                     `bson:"archivedAt,omitempty" json:"archivedAt,omitempty"`
+	Version        int                            `bson:"version" json:"version"` // Incremented at each save, used to detect concurrent modifications
 }
 
+// notArchived is the filter matching projects which are not archived
+var notArchived = bson.M{"archived": bson.M{"$ne": true}}
+
 // Projects represents a slice of Project
 type Projects []Project
 
@@ -152,17 +314,17 @@ func (r *ProjectRepo) FindByName(name string) (Project, error) {
 	}
 	result := Project{}
 	regex := "^" + regexp.QuoteMeta(name) + "$"
-	err := r.col().Find(bson.M{"name": bson.RegEx{Pattern: regex, Options: "i"}}).One(&result)
+	err := r.col().Find(bson.M{"name": bson.RegEx{Pattern: regex, Options: "i"}, "archived": notArchived["archived"]}).One(&result)
 	return result, err
 }
 
-// FindAll get all projects from the database
+// FindAll get all projects from the database, except archived ones
 func (r *ProjectRepo) FindAll() ([]Project, error) {
 	if !r.isInitialized() {
 		return []Project{}, ErrDatabaseNotInitialized
 	}
 	projects := []Project{}
-	err := r.col().Find(bson.M{}).All(&projects)
+	err := r.col().Find(notArchived).All(&projects)
 	if err != nil {
 		return []Project{}, errors.New("Can't retrieve all projects")
 	}
@@ -182,89 +344,242 @@ func removeDuplicates(projects []Project) []Project {
 	return result
 }
 
-// FindForUser returns the projects associated to a user, handling their rights
+// FindForUser returns the projects associated to a user, handling their permissions
+// Users see every project, or the projects of their entities, in addition to the projects they are member of
 func (r *ProjectRepo) FindForUser(user User) (Projects, error) {
 	var projects []Project
 	var err error
 
-	switch user.Role {
-	case AdminRole:
+	switch {
+	case !user.HasValidRole():
+		return nil, fmt.Errorf("Invalid role %s for user %s", user.Role, user.Username)
+	case user.Can(ProjectsAllPermission):
 		projects, err = r.FindAll()
-	case RIRole:
+	case user.Can(ProjectsEntitiesPermission):
 		projects, err = r.FindByEntities(user.Entities)
 		if err != nil {
 			return nil, err
 		}
 
-		var projectsByPMOrDeputy []Project
-		projectsByPMOrDeputy, err = r.FindByProjectManagerOrDeputy(user.ID)
+		var projectsByMember []Project
+		projectsByMember, err = r.FindByMember(user.ID, ProjectRoles...)
 		if err != nil {
 			return nil, err
 		}
 
-		projects = removeDuplicates(append(projects, projectsByPMOrDeputy...))
-	case PMRole, DeputyRole:
-		projects, err = r.FindByProjectManagerOrDeputy(user.ID)
+		projects = removeDuplicates(append(projects, projectsByMember...))
 	default:
-		return nil, fmt.Errorf("Invalid role %s for user %s", user.Role, user.Username)
+		projects, err = r.FindByMember(user.ID, ProjectRoles...)
 	}
 
 	return projects, err
 }
 
+// ProjectFilter contains the criteria used to filter projects. Empty criteria are ignored
+type ProjectFilter struct {
+	Entities    []string        // Business units or service centers of the projects, their descendants included
+	Services    []bson.ObjectId // Functional services the projects have a matrix line for
+	Client      string          // Client of the projects (case insensitive)
+	Domain      string          // Consolidation criteria of the projects
+	Mode        string          // Deployment mode of the projects
+	Technology  string          // Technology used by the projects
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+	UpdatedFrom time.Time
+	UpdatedTo   time.Time
+}
+
+// Query converts the filter to a Mongo query
+// The entities are expanded to their subtree, so that filtering on an entity includes the projects of its descendants
+func (f ProjectFilter) Query(tree EntityTree) bson.M {
+	query := []bson.M{notArchived}
+	if len(f.Entities) > 0 {
+		ids := []bson.ObjectId{}
+		for _, entity := range f.Entities {
+			if bson.IsObjectIdHex(entity) {
+				ids = append(ids, bson.ObjectIdHex(entity))
+			}
+		}
+		entities := tree.SubtreeHex(ids...)
+		query = append(query, bson.M{"$or": []bson.M{
+			{"businessUnit": bson.M{"$in": entities}},
+			{"serviceCenter": bson.M{"$in": entities}},
+		}})
+	}
+	if f.Services != nil {
+		query = append(query, bson.M{"matrix.service": bson.M{"$in": f.Services}})
+	}
+	if f.Client != "" {
+		query = append(query, bson.M{"client": bson.RegEx{Pattern: "^" + regexp.QuoteMeta(f.Client) + "$", Options: "i"}})
+	}
+	if f.Domain != "" {
+		query = append(query, bson.M{"domain": f.Domain})
+	}
+	if f.Mode != "" {
+		query = append(query, bson.M{"technicalData.mode": f.Mode})
+	}
+	if f.Technology != "" {
+		query = append(query, bson.M{"technicalData.technologies": f.Technology})
+	}
+	if dates := dateRange(f.CreatedFrom, f.CreatedTo); len(dates) > 0 {
+		query = append(query, bson.M{"created": dates})
+	}
+	if dates := dateRange(f.UpdatedFrom, f.UpdatedTo); len(dates) > 0 {
+		query = append(query, bson.M{"updated": dates})
+	}
+	return bson.M{"$and": query}
+}
+
+func dateRange(from, to time.Time) bson.M {
+	dates := bson.M{}
+	if !from.IsZero() {
+		dates["$gte"] = from
+	}
+	if !to.IsZero() {
+		dates["$lte"] = to
+	}
+	return dates
+}
+
+// FindWithFilter returns all the projects matching the filter
+func (r *ProjectRepo) FindWithFilter(filter ProjectFilter) (Projects, error) {
+	if !r.isInitialized() {
+		return Projects{}, ErrDatabaseNotInitialized
+	}
+
+	tree, err := r.entityTree()
+	if err != nil {
+		return nil, err
+	}
+
+	projects := Projects{}
+	err = r.col().Find(filter.Query(tree)).All(&projects)
+	if err != nil {
+		return nil, fmt.Errorf("Can't retrieve projects: %v", err)
+	}
+	return projects, nil
+}
+
+// FindForUserWithFilter returns the projects associated to a user and matching the filter, in a single query
+// The permissions of the user are the same as in FindForUser
+func (r *ProjectRepo) FindForUserWithFilter(user User, filter ProjectFilter) (Projects, error) {
+	if !r.isInitialized() {
+		return Projects{}, ErrDatabaseNotInitialized
+	}
+
+	tree, err := r.entityTree()
+	if err != nil {
+		return nil, err
+	}
+
+	query := filter.Query(tree)
+	byMembers := byMember(user.ID, ProjectRoles)
+	switch {
+	case !user.HasValidRole():
+		return nil, fmt.Errorf("Invalid role %s for user %s", user.Role, user.Username)
+	case user.Can(ProjectsAllPermission):
+	case user.Can(ProjectsEntitiesPermission):
+		idsString := tree.SubtreeHex(user.Entities...)
+		query["$or"] = append(byMembers,
+			bson.M{"businessUnit": bson.M{"$in": idsString}},
+			bson.M{"serviceCenter": bson.M{"$in": idsString}},
+		)
+	default:
+		query["$or"] = byMembers
+	}
+
+	projects := Projects{}
+	err = r.col().Find(query).All(&projects)
+	if err != nil {
+		return nil, fmt.Errorf("Can't retrieve projects of user %s: %v", user.Username, err)
+	}
+	return projects, nil
+}
+
 // FindWithDocktorGroupURL returns the projects with a no empty docktor group url
 func (r *ProjectRepo) FindWithDocktorGroupURL() ([]Project, error) {
 	projects := []Project{}
 	err := r.col().Find(bson.M{
 		"$and": []bson.M{
 			{"docktorURL.docktorGroupURL": bson.M{"$exists": true, "$ne": ""}},
+			notArchived,
 		},
 	}).All(&projects)
 	return projects, err
 }
 
+// UpdateDocktorGroupName updates the name of the Docktor group of the project
+func (r *ProjectRepo) UpdateDocktorGroupName(id bson.ObjectId, name string) error {
+	if !r.isInitialized() {
+		return ErrDatabaseNotInitialized
+	}
+	return r.col().UpdateId(id, bson.M{
+		"$set": bson.M{"docktorURL.docktorGroupName": name, "updated": time.Now()},
+		"$inc": bson.M{"version": 1},
+	})
+}
+
 // FindModifiableForUser returns the projects associated to a user, but only projects which are modifiable by him
+// Viewers can't modify the projects they are member of
 func (r *ProjectRepo) FindModifiableForUser(user User) (Projects, error) {
-	var projects []Project
-	var err error
-
-	switch user.Role {
-	case AdminRole:
-		projects, err = r.FindAll()
-	case RIRole:
-		projects, err = r.FindByEntities(user.Entities)
+	switch {
+	case !user.HasValidRole():
+		return nil, fmt.Errorf("Invalid role %s for user %s", user.Role, user.Username)
+	case user.Can(ProjectsAllPermission):
+		return r.FindAll()
+	case user.Can(ProjectsEntitiesPermission):
+		projects, err := r.FindByEntities(user.Entities)
 		if err != nil {
 			return nil, err
 		}
-	case PMRole, DeputyRole:
-		projects, err = r.FindByProjectManagerOrDeputy(user.ID)
+		projectsByMember, err := r.FindByMember(user.ID, ContributorRole, OwnerRole)
 		if err != nil {
 			return nil, err
 		}
+		return removeDuplicates(append(projects, projectsByMember...)), nil
 	default:
-		return nil, fmt.Errorf("Invalid role %s for user %s", user.Role, user.Username)
+		return r.FindByMember(user.ID, ContributorRole, OwnerRole)
 	}
+}
 
-	return projects, err
+// entitiesSubtree returns the IDs of the entities and of all their descendants, as stored in projects
+func (r *ProjectRepo) entitiesSubtree(ids []bson.ObjectId) ([]string, error) {
+	tree, err := r.entityTree()
+	if err != nil {
+		return nil, err
+	}
+	return tree.SubtreeHex(ids...), nil
+}
+
+// entityTree returns the tree of all the entities
+func (r *ProjectRepo) entityTree() (EntityTree, error) {
+	entityRepo := NewEntityRepo(r.database)
+	tree, err := entityRepo.FindTree()
+	if err != nil {
+		return EntityTree{}, fmt.Errorf("Can't retrieve the tree of entities: %v", err)
+	}
+	return tree, nil
 }
 
 // FindByEntities get all projects with a matching businessUnit or serviceCenter
+// Projects of the descendants of the entities match too, so that rights on an entity cascade down the tree
 func (r *ProjectRepo) FindByEntities(ids []bson.ObjectId) ([]Project, error) {
 	if !r.isInitialized() {
 		return []Project{}, ErrDatabaseNotInitialized
 	}
 
-	idsString := []string{}
-	for _, id := range ids {
-		idsString = append(idsString, id.Hex())
+	idsString, err := r.entitiesSubtree(ids)
+	if err != nil {
+		return []Project{}, err
 	}
 
 	projects := []Project{}
-	err := r.col().Find(bson.M{
+	err = r.col().Find(bson.M{
 		"$or": []bson.M{
 			{"businessUnit": bson.M{"$in": idsString}},
 			{"serviceCenter": bson.M{"$in": idsString}},
 		},
+		"archived": notArchived["archived"],
 	}).All(&projects)
 	if err != nil {
 		return []Project{}, fmt.Errorf("Can't retrieve projects for entities %v", ids)
@@ -272,23 +587,28 @@ func (r *ProjectRepo) FindByEntities(ids []bson.ObjectId) ([]Project, error) {
 	return projects, nil
 }
 
-// FindByProjectManagerOrDeputy get all projects with a specific project manager or deputy
-func (r *ProjectRepo) FindByProjectManagerOrDeputy(id bson.ObjectId) ([]Project, error) {
+// FindByEntity get all projects, including archived ones, with the entity as businessUnit or serviceCenter
+// Unlike FindByEntities, the descendants of the entity are ignored
+func (r *ProjectRepo) FindByEntity(id string) ([]Project, error) {
 	if !r.isInitialized() {
 		return []Project{}, ErrDatabaseNotInitialized
 	}
-	projectsbyPM := []Project{}
-	err1 := r.col().Find(bson.M{"projectManager": id.Hex()}).All(&projectsbyPM)
-	projectsbyDeputy := []Project{}
-	err2 := r.col().Find(bson.M{"deputies": id.Hex()}).All(&projectsbyDeputy)
-	if err1 != nil && err2 != nil {
-		return []Project{}, fmt.Errorf("Can't retrieve projects for project manager and deputy %s", id)
+
+	projects := []Project{}
+	err := r.col().Find(bson.M{
+		"$or": []bson.M{
+			{"businessUnit": id},
+			{"serviceCenter": id},
+		},
+	}).All(&projects)
+	if err != nil {
+		return []Project{}, fmt.Errorf("Can't retrieve projects for entity %v", id)
 	}
-	projects := removeDuplicates(append(projectsbyPM, projectsbyDeputy...))
 	return projects, nil
 }
 
-// Save updates or create the functional service in database
+// Save updates or create the project in database
+// An existing project is only updated when its version matches the one in database, and returns ErrProjectVersionConflict otherwise
 func (r *ProjectRepo) Save(project Project) (Project, error) {
 	if !r.isInitialized() {
 		return Project{}, ErrDatabaseNotInitialized
@@ -296,39 +616,191 @@ func (r *ProjectRepo) Save(project Project) (Project, error) {
 
 	if project.ID.Hex() == "" {
 		project.ID = bson.NewObjectId()
+		project.Version = 1
+		err := r.col().Insert(project)
+		return project, err
 	}
 
-	_, err := r.col().UpsertId(project.ID, bson.M{"$set": project})
+	selector := versionSelector(project)
+	project.Version++
+
+	err := r.col().Update(selector, bson.M{"$set": project})
+	if err == mgo.ErrNotFound {
+		nb, errCount := r.col().FindId(project.ID).Count()
+		if errCount != nil {
+			return project, errCount
+		}
+		if nb > 0 {
+			return project, ErrProjectVersionConflict
+		}
+	}
 	return project, err
 }
 
-// RemoveEntity removes an entity (businessUnit or serviceCenter) from a project
+// versionSelector selects the project in database only when it is still at the version of the given project
+func versionSelector(project Project) bson.M {
+	selector := bson.M{"_id": project.ID, "version": project.Version}
+	if project.Version == 0 {
+		// Projects saved before versioning don't have any version field
+		selector["version"] = bson.M{"$in": []interface{}{0, nil}}
+	}
+	return selector
+}
+
+// UpdateMatrixLine atomically updates the matrix line of a functional service of the project
+// The line is created with default values when the project does not have it yet
+func (r *ProjectRepo) UpdateMatrixLine(id, service bson.ObjectId, patch MatrixLinePatch) error {
+	if !r.isInitialized() {
+		return ErrDatabaseNotInitialized
+	}
+
+	set := bson.M{"updated": time.Now()}
+	for field, value := range patch.Fields() {
+		set["matrix.$."+field] = value
+	}
+	err := r.col().Update(
+		bson.M{"_id": id, "matrix.service": service},
+		bson.M{"$set": set, "$inc": bson.M{"version": 1}},
+	)
+	if err != mgo.ErrNotFound {
+		return err
+	}
+
+	line := patch.Apply(MatrixLine{Service: service, Progress: -1, Goal: -1})
+	return r.col().Update(
+		bson.M{"_id": id, "matrix.service": bson.M{"$ne": service}},
+		bson.M{
+			"$push": bson.M{"matrix": line},
+			"$set":  bson.M{"updated": time.Now()},
+			"$inc":  bson.M{"version": 1},
+		},
+	)
+}
+
+// RemoveEntities removes entities (businessUnit or serviceCenter) from the projects
 // This is used for cascade deletions
-func (r *ProjectRepo) RemoveEntity(id string) error {
+func (r *ProjectRepo) RemoveEntities(ids []string) error {
+	if !r.isInitialized() {
+		return ErrDatabaseNotInitialized
+	}
+
+	_, err := r.col().UpdateAll(
+		bson.M{"businessUnit": bson.M{"$in": ids}},
+		bson.M{"$set": bson.M{"businessUnit": ""}, "$inc": bson.M{"version": 1}},
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.col().UpdateAll(
+		bson.M{"serviceCenter": bson.M{"$in": ids}},
+		bson.M{"$pull": bson.M{"serviceCenter": bson.M{"$in": ids}}, "$inc": bson.M{"version": 1}},
+	)
+	return err
+}
+
+// ReplaceEntity replaces an entity by another one as business unit and service center of the projects, like Project.ReplaceEntity
+// This is used when merging entities. Each update is atomic for all the projects, so the merge can be run again after a failure
+func (r *ProjectRepo) ReplaceEntity(from, to string) error {
 	if !r.isInitialized() {
 		return ErrDatabaseNotInitialized
 	}
 
+	now := time.Now()
 	_, err := r.col().UpdateAll(
-		bson.M{"businessUnit": id},
-		bson.M{"$set": bson.M{"businessUnit": ""}},
+		bson.M{"businessUnit": from},
+		bson.M{"$set": bson.M{"businessUnit": to, "updated": now}, "$inc": bson.M{"version": 1}},
 	)
 	if err != nil {
 		return err
 	}
 
+	// The other entity is never twice service center: it is removed from projects where it already is one
+	_, err = r.col().UpdateAll(
+		bson.M{"serviceCenter": bson.M{"$all": []string{from, to}}},
+		bson.M{"$pull": bson.M{"serviceCenter": from}, "$set": bson.M{"updated": now}, "$inc": bson.M{"version": 1}},
+	)
+	if err != nil {
+		return err
+	}
 	_, err = r.col().UpdateAll(
-		bson.M{"serviceCenter": id},
-		bson.M{"$set": bson.M{"serviceCenter": bson.M{}}},
+		bson.M{"serviceCenter": from},
+		bson.M{"$set": bson.M{"serviceCenter.$": to, "updated": now}, "$inc": bson.M{"version": 1}},
 	)
 	return err
 }
 
-// Delete the project
+// Delete the project permanently
 func (r *ProjectRepo) Delete(id bson.ObjectId) (bson.ObjectId, error) {
 	return BasicDelete(r, id)
 }
 
+// Archive marks the project as deleted by the given user, without removing it from database
+// Archived projects are hidden from every search, except FindByID and FindArchived
+func (r *ProjectRepo) Archive(id bson.ObjectId, username string) error {
+	if !r.isInitialized() {
+		return ErrDatabaseNotInitialized
+	}
+	return r.col().UpdateId(id, archiveUpdate(username, time.Now()))
+}
+
+// Restore makes an archived project visible again
+func (r *ProjectRepo) Restore(id bson.ObjectId) error {
+	if !r.isInitialized() {
+		return ErrDatabaseNotInitialized
+	}
+	return r.col().UpdateId(id, restoreUpdate(time.Now()))
+}
+
+// archiveUpdate is the update archiving a project, which is then excluded by notArchived
+func archiveUpdate(username string, now time.Time) bson.M {
+	return bson.M{
+		"$set": bson.M{
+			"archived":   true,
+			"archivedBy": username,
+			"archivedAt": now,
+		},
+		"$inc": bson.M{"version": 1},
+	}
+}
+
+// restoreUpdate is the update restoring an archived project, which is then matched by notArchived again
+func restoreUpdate(now time.Time) bson.M {
+	return bson.M{
+		"$unset": bson.M{"archived": "", "archivedBy": "", "archivedAt": ""},
+		"$set":   bson.M{"updated": now},
+		"$inc":   bson.M{"version": 1},
+	}
+}
+
+// archivedBefore is the filter matching the projects archived strictly before the date
+func archivedBefore(date time.Time) bson.M {
+	return bson.M{"archived": true, "archivedAt": bson.M{"$lt": date}}
+}
+
+// FindArchived get all archived projects from the database
+func (r *ProjectRepo) FindArchived() ([]Project, error) {
+	if !r.isInitialized() {
+		return []Project{}, ErrDatabaseNotInitialized
+	}
+	projects := []Project{}
+	err := r.col().Find(bson.M{"archived": true}).Sort("-archivedAt").All(&projects)
+	if err != nil {
+		return []Project{}, errors.New("Can't retrieve archived projects")
+	}
+	return projects, nil
+}
+
+// FindArchivedBefore get all projects archived before the given date
+func (r *ProjectRepo) FindArchivedBefore(date time.Time) ([]Project, error) {
+	if !r.isInitialized() {
+		return []Project{}, ErrDatabaseNotInitialized
+	}
+	projects := []Project{}
+	err := r.col().Find(archivedBefore(date)).All(&projects)
+	return projects, err
+}
+
 // UpdateDocktorGroupURL updates Docktor Group URL to project in database
 func (r *ProjectRepo) UpdateDocktorGroupURL(id bson.ObjectId, docktorGroupURL, docktorGroupName string) error {
 	if !r.isInitialized() {
@@ -336,10 +808,48 @@ func (r *ProjectRepo) UpdateDocktorGroupURL(id bson.ObjectId, docktorGroupURL, d
 	}
 	return r.col().UpdateId(
 		id,
-		bson.M{"$set": bson.M{
-			"docktorURL.docktorGroupURL":  docktorGroupURL,
-			"docktorURL.docktorGroupName": docktorGroupName,
-			"updated":                     time.Now(),
-		}},
+		bson.M{
+			"$set": bson.M{
+				"docktorURL.docktorGroupURL":  docktorGroupURL,
+				"docktorURL.docktorGroupName": docktorGroupName,
+				"updated":                     time.Now(),
+			},
+			"$inc": bson.M{"version": 1},
+		},
 	)
 }
+
+// BulkUpdateMatrixResults is the result of the update of a matrix line across several projects
+type BulkUpdateMatrixResults struct {
+	All     int              `json:"all"`     // Number of projects to update
+	Updated int              `json:"updated"` // Number of projects actually updated
+	InError int              `json:"inError"` // Number of projects not updated because an error happened
+	Errors  []ProjectInError `json:"errors"`  // Occurred errors and its details
+}
+
+// ProjectInError represents a project that could not be updated because an error occurred
+type ProjectInError struct {
+	Project bson.ObjectId `json:"project"`
+	Message string        `json:"message"`
+	Index   int           `json:"index"` // Index of project in error, in original slice
+}
+
+// BulkImportProjectsResults is the result of the import of projects from a file
+type BulkImportProjectsResults struct {
+	DryRun   bool               `json:"dryRun"`   // When true, projects have been validated but not saved
+	All      int                `json:"all"`      // Number of projects in the file
+	Created  int                `json:"created"`  // Number of projects created
+	Updated  int                `json:"updated"`  // Number of existing projects updated
+	InError  int                `json:"inError"`  // Number of projects not imported because an error happened
+	Warnings []string           `json:"warnings"` // Non blocking issues, like ignored columns
+	Rows     []ProjectImportRow `json:"rows"`     // Result of the import of each row of the file
+}
+
+// ProjectImportRow is the result of the import of a row of a file
+type ProjectImportRow struct {
+	Line    int           `json:"line"`
+	Name    string        `json:"name"`
+	ID      bson.ObjectId `json:"id,omitempty"`
+	Status  string        `json:"status"` // One of "created", "updated" or "error"
+	Message string        `json:"message,omitempty"`
+}
@@ -0,0 +1,287 @@
+package types
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestProjectFilterQuery(t *testing.T) {
+
+	Convey("Given an empty filter", t, func() {
+		filter := ProjectFilter{}
+		Convey("When converting it to a query", func() {
+			query := filter.Query(EntityTree{})
+			Convey("Then only archived projects are excluded", func() {
+				So(query, ShouldResemble, bson.M{"$and": []bson.M{notArchived}})
+			})
+		})
+	})
+
+	Convey("Given a filter on mode and creation date", t, func() {
+		from := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+		filter := ProjectFilter{Mode: "SaaS", CreatedFrom: from}
+		Convey("When converting it to a query", func() {
+			query := filter.Query(EntityTree{})
+			Convey("Then all criteria are combined", func() {
+				So(query["$and"], ShouldResemble, []bson.M{
+					notArchived,
+					{"technicalData.mode": "SaaS"},
+					{"created": bson.M{"$gte": from}},
+				})
+			})
+		})
+	})
+
+	Convey("Given a filter on an entity with descendants", t, func() {
+		businessUnit := Entity{ID: bson.NewObjectId(), Type: BusinessUnitType}
+		serviceCenter := Entity{ID: bson.NewObjectId(), Type: ServiceCenterType, Parent: businessUnit.ID}
+		other := Entity{ID: bson.NewObjectId(), Type: BusinessUnitType}
+		tree := NewEntityTree([]Entity{businessUnit, serviceCenter, other})
+		filter := ProjectFilter{Entities: []string{businessUnit.ID.Hex()}}
+		Convey("When converting it to a query", func() {
+			query := filter.Query(tree)
+			Convey("Then the projects of the entity and of its descendants match", func() {
+				entities := []string{businessUnit.ID.Hex(), serviceCenter.ID.Hex()}
+				So(query["$and"], ShouldResemble, []bson.M{
+					notArchived,
+					{"$or": []bson.M{
+						{"businessUnit": bson.M{"$in": entities}},
+						{"serviceCenter": bson.M{"$in": entities}},
+					}},
+				})
+			})
+		})
+	})
+}
+
+// document encodes the project as it is stored in database
+func document(project Project) bson.M {
+	data, err := bson.Marshal(project)
+	So(err, ShouldBeNil)
+	doc := bson.M{}
+	So(bson.Unmarshal(data, &doc), ShouldBeNil)
+	return doc
+}
+
+// matches evaluates a query on a document, with the operators used by the archive filters
+func matches(doc bson.M, query bson.M) bool {
+	for field, condition := range query {
+		value, found := doc[field]
+		operators, ok := condition.(bson.M)
+		if !ok {
+			if !found || value != condition {
+				return false
+			}
+			continue
+		}
+		for operator, operand := range operators {
+			switch operator {
+			case "$ne":
+				if found && value == operand {
+					return false
+				}
+			case "$lt":
+				date, ok := value.(time.Time)
+				if !ok || !date.Before(operand.(time.Time)) {
+					return false
+				}
+			default:
+				panic("Unsupported operator " + operator)
+			}
+		}
+	}
+	return true
+}
+
+// update applies an update to a document, with the operators used by the archive updates
+func update(doc bson.M, change bson.M) {
+	for operator, fields := range change {
+		for field, value := range fields.(bson.M) {
+			switch operator {
+			case "$set":
+				doc[field] = value
+			case "$unset":
+				delete(doc, field)
+			case "$inc":
+				current, _ := doc[field].(int)
+				doc[field] = current + value.(int)
+			default:
+				panic("Unsupported operator " + operator)
+			}
+		}
+	}
+}
+
+func TestArchive(t *testing.T) {
+
+	Convey("Given active, archived and restored projects", t, func() {
+		now := time.Date(2017, 6, 1, 0, 0, 0, 0, time.UTC)
+		limit := now.AddDate(0, 0, -30)
+
+		active := document(Project{ID: bson.NewObjectId(), Name: "active", Version: 3})
+		archivedLongAgo := document(Project{ID: bson.NewObjectId(), Name: "archived long ago"})
+		update(archivedLongAgo, archiveUpdate("jdoe", limit.AddDate(0, 0, -1)))
+		archivedAtLimit := document(Project{ID: bson.NewObjectId(), Name: "archived at the limit"})
+		update(archivedAtLimit, archiveUpdate("jdoe", limit))
+		archivedRecently := document(Project{ID: bson.NewObjectId(), Name: "archived recently"})
+		update(archivedRecently, archiveUpdate("jdoe", now))
+		restored := document(Project{ID: bson.NewObjectId(), Name: "restored"})
+		update(restored, archiveUpdate("jdoe", limit.AddDate(0, 0, -1)))
+		update(restored, restoreUpdate(now))
+		projects := []bson.M{active, archivedLongAgo, archivedAtLimit, archivedRecently, restored}
+
+		selected := func(query bson.M) []string {
+			names := []string{}
+			for _, project := range projects {
+				if matches(project, query) {
+					names = append(names, project["name"].(string))
+				}
+			}
+			return names
+		}
+
+		Convey("Then archiving a project records who archived it, and when", func() {
+			So(archivedRecently["archivedBy"], ShouldEqual, "jdoe")
+			So(archivedRecently["archivedAt"], ShouldResemble, now)
+			So(active["version"], ShouldEqual, 3)
+			So(restored["version"], ShouldEqual, 2)
+		})
+
+		Convey("Then only the projects never archived or restored are visible", func() {
+			So(selected(notArchived), ShouldResemble, []string{"active", "restored"})
+		})
+
+		Convey("Then only the projects archived strictly before the retention limit are purged", func() {
+			So(selected(archivedBefore(limit)), ShouldResemble, []string{"archived long ago"})
+		})
+
+		Convey("Then a restored project has no archive field left", func() {
+			So(restored, ShouldNotContainKey, "archived")
+			So(restored, ShouldNotContainKey, "archivedBy")
+			So(restored, ShouldNotContainKey, "archivedAt")
+			So(restored["updated"], ShouldResemble, now)
+		})
+	})
+}
+
+func TestMatrixLinePatch(t *testing.T) {
+
+	Convey("Given patches of a matrix line", t, func() {
+		yes, unknown, p1, comment := Deployed[0], "maybe", Priority[1], "Migrated"
+		three, six, notApplicable := 3, 6, -1
+		due := time.Date(2017, 6, 1, 0, 0, 0, 0, time.UTC)
+		previousDue := due.AddDate(0, -1, 0)
+		line := MatrixLine{Service: bson.NewObjectId(), Deployed: Deployed[-1], Progress: 1, Goal: 4, Priority: Priority[0], DueDate: &previousDue, Comment: "Planned"}
+
+		cases := []struct {
+			name   string
+			patch  MatrixLinePatch
+			err    bool
+			fields bson.M
+			line   MatrixLine
+		}{
+			{
+				name:   "An empty patch is valid and leaves the line unchanged",
+				fields: bson.M{},
+				line:   line,
+			},
+			{
+				name:   "Set fields are updated, nil fields are left unchanged",
+				patch:  MatrixLinePatch{Progress: &three, Priority: &p1},
+				fields: bson.M{"progress": 3, "priority": "P1"},
+				line:   MatrixLine{Service: line.Service, Deployed: Deployed[-1], Progress: 3, Goal: 4, Priority: "P1", DueDate: &previousDue, Comment: "Planned"},
+			},
+			{
+				name:   "Every field can be updated",
+				patch:  MatrixLinePatch{Deployed: &yes, Progress: &notApplicable, Goal: &three, Priority: &p1, DueDate: &due, Comment: &comment},
+				fields: bson.M{"deployed": "yes", "progress": -1, "goal": 3, "priority": "P1", "dueDate": due, "comment": "Migrated"},
+				line:   MatrixLine{Service: line.Service, Deployed: "yes", Progress: -1, Goal: 3, Priority: "P1", DueDate: &due, Comment: "Migrated"},
+			},
+			{
+				name:   "The due date is cleared",
+				patch:  MatrixLinePatch{ClearDueDate: true},
+				fields: bson.M{"dueDate": nil},
+				line:   MatrixLine{Service: line.Service, Deployed: Deployed[-1], Progress: 1, Goal: 4, Priority: Priority[0], Comment: "Planned"},
+			},
+			{
+				name:  "The due date can't be both set and cleared",
+				patch: MatrixLinePatch{DueDate: &due, ClearDueDate: true},
+				err:   true,
+			},
+			{
+				name:  "An unknown deployed status is not valid",
+				patch: MatrixLinePatch{Deployed: &unknown},
+				err:   true,
+			},
+			{
+				name:  "An unknown progress is not valid",
+				patch: MatrixLinePatch{Progress: &six},
+				err:   true,
+			},
+			{
+				name:  "An unknown goal is not valid",
+				patch: MatrixLinePatch{Goal: &six},
+				err:   true,
+			},
+			{
+				name:  "An unknown priority is not valid",
+				patch: MatrixLinePatch{Priority: &unknown},
+				err:   true,
+			},
+		}
+
+		for _, c := range cases {
+			Convey(c.name, func() {
+				err := c.patch.Validate()
+				if c.err {
+					So(err, ShouldNotBeNil)
+					return
+				}
+				So(err, ShouldBeNil)
+				So(c.patch.Fields(), ShouldResemble, c.fields)
+				So(c.patch.Apply(line), ShouldResemble, c.line)
+			})
+		}
+	})
+}
+
+func TestOverdueMatrixLines(t *testing.T) {
+
+	Convey("Given a matrix with due dates", t, func() {
+		now := time.Date(2017, 6, 1, 0, 0, 0, 0, time.UTC)
+		past, future := now.AddDate(0, -1, 0), now.AddDate(0, 1, 0)
+		late := MatrixLine{Service: bson.NewObjectId(), Progress: 1, Goal: 3, DueDate: &past}
+		withoutGoal := MatrixLine{Service: bson.NewObjectId(), Progress: 4, Goal: -1, DueDate: &past}
+		matrix := Matrix{
+			late,
+			withoutGoal,
+			{Service: bson.NewObjectId(), Progress: 3, Goal: 3, DueDate: &past},
+			{Service: bson.NewObjectId(), Progress: 1, Goal: 3, DueDate: &future},
+			{Service: bson.NewObjectId(), Progress: -1, Goal: 3, DueDate: &past},
+			{Service: bson.NewObjectId(), Progress: 0, Goal: 3},
+		}
+		Convey("Then the lines whose goal is not reached by their due date are overdue", func() {
+			So(matrix.Overdue(now), ShouldResemble, Matrix{late, withoutGoal})
+		})
+	})
+}
+
+func TestVersionSelector(t *testing.T) {
+
+	Convey("Given a versioned project", t, func() {
+		project := Project{ID: bson.NewObjectId(), Version: 3}
+		Convey("Then it is only saved over the same version", func() {
+			So(versionSelector(project), ShouldResemble, bson.M{"_id": project.ID, "version": 3})
+		})
+	})
+
+	Convey("Given a project saved before versioning", t, func() {
+		project := Project{ID: bson.NewObjectId()}
+		Convey("Then it is saved over a project without version", func() {
+			So(versionSelector(project), ShouldResemble, bson.M{"_id": project.ID, "version": bson.M{"$in": []interface{}{0, nil}}})
+		})
+	})
+}
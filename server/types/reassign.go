@@ -0,0 +1,137 @@
+package types
+
+import (
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	// MissingUser is the reason of a reference to a user who does not exist
+	MissingUser = "missing"
+	// InactiveUser is the reason of a reference to a user who is disabled
+	InactiveUser = "inactive"
+)
+
+// ReplaceUser returns a copy of the project where a user is replaced by another one as project manager, deputy and member
+// The other user is never both project manager and deputy, nor twice deputy. The other user is a member only when neither project manager nor deputy,
+// with the highest of the two roles. It returns false when the user is neither project manager, deputy nor member
+func (p Project) ReplaceUser(from, to string) (Project, bool) {
+	replaced := false
+	if p.ProjectManager == from {
+		p.ProjectManager = to
+		replaced = true
+	}
+
+	deputies := []string{}
+	for _, deputy := range p.Deputies {
+		if deputy == from {
+			deputy = to
+			replaced = true
+		}
+		if deputy != p.ProjectManager && !containsString(deputies, deputy) {
+			deputies = append(deputies, deputy)
+		}
+	}
+
+	for _, member := range p.Members {
+		if member.User == from {
+			replaced = true
+		}
+	}
+	if !replaced {
+		return p, false
+	}
+	p.Deputies = deputies
+
+	members := []ProjectMember{}
+	for _, member := range p.Members {
+		if member.User == from {
+			member.User = to
+		}
+		if member.User == p.ProjectManager || containsString(p.Deputies, member.User) {
+			continue
+		}
+		merged := false
+		for i := range members {
+			if members[i].User == member.User {
+				if member.Role.includes(members[i].Role) {
+					members[i].Role = member.Role
+				}
+				merged = true
+			}
+		}
+		if !merged {
+			members = append(members, member)
+		}
+	}
+	if p.Members != nil {
+		p.Members = members
+	}
+	return p, true
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// BulkReassignResults is the result of the reassignment of the projects of a user to another one
+type BulkReassignResults struct {
+	All     int              `json:"all"`     // Number of projects to reassign
+	Updated int              `json:"updated"` // Number of projects actually reassigned
+	InError int              `json:"inError"` // Number of projects not reassigned because an error happened
+	Errors  []ProjectInError `json:"errors"`  // Occurred errors and its details
+}
+
+// DanglingReference is a reference of a project to a user who is missing or inactive
+type DanglingReference struct {
+	Project     bson.ObjectId `json:"project"`
+	ProjectName string        `json:"projectName"`
+	Field       string        `json:"field"`  // Either projectManager, deputies or members
+	User        string        `json:"user"`   // ID of the referenced user
+	Reason      string        `json:"reason"` // Either missing or inactive
+}
+
+// FindDanglingReferences returns the references of the projects, as project manager, deputy or member, to users who are missing or inactive
+func FindDanglingReferences(projects []Project, users []User) []DanglingReference {
+	usersByID := map[string]User{}
+	for _, user := range users {
+		usersByID[user.ID.Hex()] = user
+	}
+
+	references := []DanglingReference{}
+	check := func(project Project, field, id string) {
+		user, found := usersByID[id]
+		reason := ""
+		if !found {
+			reason = MissingUser
+		} else if user.Disabled {
+			reason = InactiveUser
+		} else {
+			return
+		}
+		references = append(references, DanglingReference{
+			Project:     project.ID,
+			ProjectName: project.Name,
+			Field:       field,
+			User:        id,
+			Reason:      reason,
+		})
+	}
+
+	for _, project := range projects {
+		if project.ProjectManager != "" {
+			check(project, "projectManager", project.ProjectManager)
+		}
+		for _, deputy := range project.Deputies {
+			check(project, "deputies", deputy)
+		}
+		for _, member := range project.Members {
+			check(project, "members", member.User)
+		}
+	}
+	return references
+}
@@ -0,0 +1,95 @@
+package types
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestReplaceUser(t *testing.T) {
+
+	Convey("Given a project managed by a user who is also deputy of another project", t, func() {
+		leaving, newcomer, deputy := bson.NewObjectId().Hex(), bson.NewObjectId().Hex(), bson.NewObjectId().Hex()
+
+		Convey("When replacing the project manager", func() {
+			project, replaced := Project{ProjectManager: leaving, Deputies: []string{deputy, newcomer}}.ReplaceUser(leaving, newcomer)
+			Convey("Then the new project manager is not deputy anymore", func() {
+				So(replaced, ShouldBeTrue)
+				So(project.ProjectManager, ShouldEqual, newcomer)
+				So(project.Deputies, ShouldResemble, []string{deputy})
+			})
+		})
+
+		Convey("When replacing a deputy by another deputy", func() {
+			project, replaced := Project{ProjectManager: deputy, Deputies: []string{newcomer, leaving}}.ReplaceUser(leaving, newcomer)
+			Convey("Then the deputy is not duplicated", func() {
+				So(replaced, ShouldBeTrue)
+				So(project.ProjectManager, ShouldEqual, deputy)
+				So(project.Deputies, ShouldResemble, []string{newcomer})
+			})
+		})
+
+		Convey("When replacing a member", func() {
+			project, replaced := Project{ProjectManager: deputy, Members: []ProjectMember{{User: leaving, Role: ContributorRole}}}.ReplaceUser(leaving, newcomer)
+			Convey("Then the new user is member with the same role", func() {
+				So(replaced, ShouldBeTrue)
+				So(project.Members, ShouldResemble, []ProjectMember{{User: newcomer, Role: ContributorRole}})
+			})
+		})
+
+		Convey("When replacing a member by another member", func() {
+			members := []ProjectMember{{User: leaving, Role: OwnerRole}, {User: newcomer, Role: ViewerRole}}
+			project, replaced := Project{ProjectManager: deputy, Members: members}.ReplaceUser(leaving, newcomer)
+			Convey("Then the member is not duplicated, and keeps the highest role", func() {
+				So(replaced, ShouldBeTrue)
+				So(project.Members, ShouldResemble, []ProjectMember{{User: newcomer, Role: OwnerRole}})
+			})
+		})
+
+		Convey("When replacing the project manager by a member", func() {
+			members := []ProjectMember{{User: newcomer, Role: ViewerRole}}
+			project, replaced := Project{ProjectManager: leaving, Members: members}.ReplaceUser(leaving, newcomer)
+			Convey("Then the new project manager is not member anymore", func() {
+				So(replaced, ShouldBeTrue)
+				So(project.ProjectManager, ShouldEqual, newcomer)
+				So(project.Members, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When the user has no duty on the project", func() {
+			project, replaced := Project{ProjectManager: deputy, Deputies: []string{newcomer}}.ReplaceUser(leaving, newcomer)
+			Convey("Then the project is unchanged", func() {
+				So(replaced, ShouldBeFalse)
+				So(project.Deputies, ShouldResemble, []string{newcomer})
+			})
+		})
+	})
+}
+
+func TestFindDanglingReferences(t *testing.T) {
+
+	Convey("Given projects referencing active, inactive and missing users", t, func() {
+		active := User{ID: bson.NewObjectId()}
+		inactive := User{ID: bson.NewObjectId(), Disabled: true}
+		missing := bson.NewObjectId().Hex()
+		project := Project{
+			ID:             bson.NewObjectId(),
+			Name:           "DAD",
+			ProjectManager: inactive.ID.Hex(),
+			Deputies:       []string{active.ID.Hex(), missing},
+			Members:        []ProjectMember{{User: active.ID.Hex(), Role: ViewerRole}, {User: inactive.ID.Hex(), Role: ContributorRole}},
+		}
+
+		Convey("When looking for dangling references", func() {
+			references := FindDanglingReferences([]Project{project, {ProjectManager: active.ID.Hex()}}, []User{active, inactive})
+			Convey("Then only references to inactive and missing users are returned", func() {
+				So(references, ShouldResemble, []DanglingReference{
+					{Project: project.ID, ProjectName: "DAD", Field: "projectManager", User: inactive.ID.Hex(), Reason: InactiveUser},
+					{Project: project.ID, ProjectName: "DAD", Field: "deputies", User: missing, Reason: MissingUser},
+					{Project: project.ID, ProjectName: "DAD", Field: "members", User: inactive.ID.Hex(), Reason: InactiveUser},
+				})
+			})
+		})
+	})
+}
@@ -0,0 +1,118 @@
+package types
+
+import (
+	"time"
+
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// RefreshToken is a long-lived token used to get new access tokens
+// Only the hash of the token is stored. A refresh token can only be used once, and is replaced by a new one of the same family
+type RefreshToken struct {
+	ID        bson.ObjectId `bson:"_id,omitempty" json:"id,omitempty"`
+	Hash      string        `bson:"hash" json:"-"`
+	Username  string        `bson:"username" json:"username"`
+	Family    bson.ObjectId `bson:"family" json:"family"` // Tokens rotated from the same login share their family
+	Used      bool          `bson:"used" json:"used"`
+	Created   time.Time     `bson:"created" json:"created"`
+	ExpiresAt time.Time     `bson:"expiresAt" json:"expiresAt"`
+}
+
+// RefreshTokenRepo wraps all requests to database for accessing refresh tokens
+type RefreshTokenRepo struct {
+	database *mgo.Database
+}
+
+// NewRefreshTokenRepo creates a new refresh token repo from database
+// This RefreshTokenRepo is wrapping all requests with database
+func NewRefreshTokenRepo(database *mgo.Database) RefreshTokenRepo {
+	return RefreshTokenRepo{database: database}
+}
+
+func (r *RefreshTokenRepo) col() *mgo.Collection {
+	return r.database.C("refreshTokens")
+}
+
+func (r *RefreshTokenRepo) isInitialized() bool {
+	return r.database != nil
+}
+
+// CreateIndexes creates Index
+// Expired tokens are automatically removed by MongoDB
+func (r *RefreshTokenRepo) CreateIndexes() error {
+	if !r.isInitialized() {
+		return ErrDatabaseNotInitialized
+	}
+	err := r.col().EnsureIndex(mgo.Index{
+		Key:    []string{"hash"},
+		Unique: true,
+	})
+	if err != nil {
+		return err
+	}
+	err = r.col().EnsureIndex(mgo.Index{
+		Key: []string{"username"},
+	})
+	if err != nil {
+		return err
+	}
+	return r.col().EnsureIndex(mgo.Index{
+		Key:         []string{"expiresAt"},
+		ExpireAfter: time.Second,
+	})
+}
+
+// FindByHash get the refresh token by its hash
+func (r *RefreshTokenRepo) FindByHash(hash string) (RefreshToken, error) {
+	if !r.isInitialized() {
+		return RefreshToken{}, ErrDatabaseNotInitialized
+	}
+	result := RefreshToken{}
+	err := r.col().Find(bson.M{"hash": hash}).One(&result)
+	return result, err
+}
+
+// Save updates or create the refresh token in database
+func (r *RefreshTokenRepo) Save(token RefreshToken) (RefreshToken, error) {
+	if !r.isInitialized() {
+		return RefreshToken{}, ErrDatabaseNotInitialized
+	}
+
+	if token.ID.Hex() == "" {
+		token.ID = bson.NewObjectId()
+	}
+
+	_, err := r.col().UpsertId(token.ID, bson.M{"$set": token})
+	return token, err
+}
+
+// Use marks the refresh token as used
+// It returns mgo.ErrNotFound when the token was already used, so that concurrent uses are detected
+func (r *RefreshTokenRepo) Use(id bson.ObjectId) error {
+	if !r.isInitialized() {
+		return ErrDatabaseNotInitialized
+	}
+	return r.col().Update(
+		bson.M{"_id": id, "used": false},
+		bson.M{"$set": bson.M{"used": true}},
+	)
+}
+
+// DeleteFamily removes all refresh tokens of a family
+func (r *RefreshTokenRepo) DeleteFamily(family bson.ObjectId) error {
+	if !r.isInitialized() {
+		return ErrDatabaseNotInitialized
+	}
+	_, err := r.col().RemoveAll(bson.M{"family": family})
+	return err
+}
+
+// DeleteByUsername removes all refresh tokens of a user
+func (r *RefreshTokenRepo) DeleteByUsername(username string) error {
+	if !r.isInitialized() {
+		return ErrDatabaseNotInitialized
+	}
+	_, err := r.col().RemoveAll(bson.M{"username": username})
+	return err
+}
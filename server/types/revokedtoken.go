@@ -0,0 +1,105 @@
+package types
+
+import (
+	"time"
+
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// RevokedToken revokes either a single access token, or all access tokens of a user issued before a date
+// It is kept until the revoked access tokens expire
+type RevokedToken struct {
+	ID            bson.ObjectId `bson:"_id,omitempty" json:"id,omitempty"`
+	TokenID       string        `bson:"tokenId,omitempty" json:"tokenId,omitempty"`
+	Username      string        `bson:"username,omitempty" json:"username,omitempty"`
+	RevokedBefore *time.Time    `bson:"revokedBefore,omitempty" json:"revokedBefore,omitempty"`
+	ExpiresAt     time.Time     `bson:"expiresAt" json:"expiresAt"`
+}
+
+// RevokedTokenRepo wraps all requests to database for accessing the revocation list of access tokens
+type RevokedTokenRepo struct {
+	database *mgo.Database
+}
+
+// NewRevokedTokenRepo creates a new revoked token repo from database
+// This RevokedTokenRepo is wrapping all requests with database
+func NewRevokedTokenRepo(database *mgo.Database) RevokedTokenRepo {
+	return RevokedTokenRepo{database: database}
+}
+
+func (r *RevokedTokenRepo) col() *mgo.Collection {
+	return r.database.C("revokedTokens")
+}
+
+func (r *RevokedTokenRepo) isInitialized() bool {
+	return r.database != nil
+}
+
+// CreateIndexes creates Index
+// Revocations are automatically removed by MongoDB once the revoked tokens expired
+func (r *RevokedTokenRepo) CreateIndexes() error {
+	if !r.isInitialized() {
+		return ErrDatabaseNotInitialized
+	}
+	err := r.col().EnsureIndex(mgo.Index{
+		Key:    []string{"tokenId"},
+		Sparse: true,
+	})
+	if err != nil {
+		return err
+	}
+	err = r.col().EnsureIndex(mgo.Index{
+		Key:    []string{"username", "revokedBefore"},
+		Sparse: true,
+	})
+	if err != nil {
+		return err
+	}
+	return r.col().EnsureIndex(mgo.Index{
+		Key:         []string{"expiresAt"},
+		ExpireAfter: time.Second,
+	})
+}
+
+// RevokeToken revokes a single access token, until its expiration
+func (r *RevokedTokenRepo) RevokeToken(tokenID string, expiresAt time.Time) error {
+	if !r.isInitialized() {
+		return ErrDatabaseNotInitialized
+	}
+	return r.col().Insert(RevokedToken{
+		ID:        bson.NewObjectId(),
+		TokenID:   tokenID,
+		ExpiresAt: expiresAt,
+	})
+}
+
+// RevokeUser revokes all access tokens of the user issued before the current second
+// As tokens are issued with a precision of a second, tokens issued right after the revocation, e.g. when the user
+// logs in again, stay valid. The revocation is kept until the given date, when all these tokens have expired
+func (r *RevokedTokenRepo) RevokeUser(username string, expiresAt time.Time) error {
+	if !r.isInitialized() {
+		return ErrDatabaseNotInitialized
+	}
+	revokedBefore := time.Now().Truncate(time.Second)
+	return r.col().Insert(RevokedToken{
+		ID:            bson.NewObjectId(),
+		Username:      username,
+		RevokedBefore: &revokedBefore,
+		ExpiresAt:     expiresAt,
+	})
+}
+
+// IsRevoked checks whether the access token, identified by its ID, user and issue date, has been revoked
+func (r *RevokedTokenRepo) IsRevoked(tokenID, username string, issuedAt time.Time) (bool, error) {
+	if !r.isInitialized() {
+		return false, ErrDatabaseNotInitialized
+	}
+	count, err := r.col().Find(bson.M{
+		"$or": []bson.M{
+			{"tokenId": tokenID},
+			{"username": username, "revokedBefore": bson.M{"$gt": issuedAt}},
+		},
+	}).Count()
+	return count > 0, err
+}
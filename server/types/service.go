@@ -2,6 +2,7 @@ package types
 
 import (
 	"errors"
+	"fmt"
 	"strings"
 
 	"gopkg.in/mgo.v2"
@@ -79,23 +80,39 @@ func (r *FunctionalServiceRepo) FindAll() ([]FunctionalService, error) {
 	return functionalServices, nil
 }
 
+// FindByPackage get all functional services of a package
+func (r *FunctionalServiceRepo) FindByPackage(pkg string) ([]FunctionalService, error) {
+	if !r.isInitialized() {
+		return []FunctionalService{}, ErrDatabaseNotInitialized
+	}
+	functionalServices := []FunctionalService{}
+	err := r.col().Find(bson.M{"package": pkg}).Sort("position").All(&functionalServices)
+	if err != nil {
+		return []FunctionalService{}, fmt.Errorf("Can't retrieve functional services of package %s", pkg)
+	}
+	return functionalServices, nil
+}
+
 // FindFunctionalServicesDeployByServices find all functional services associated to
 func (r *FunctionalServiceRepo) FindFunctionalServicesDeployByServices(services []string) ([]FunctionalService, error) {
 
-	functionalServices := []FunctionalService{}
-
 	allFunctionalServices, err := r.FindAll()
 	if err != nil {
 		return nil, errors.New("Unable to get all functional services from database")
 	}
 
-	for _, s := range allFunctionalServices {
+	return DeployedFunctionalServices(allFunctionalServices, services), nil
+}
+
+// DeployedFunctionalServices returns the functional services associated to at least one of the given services
+func DeployedFunctionalServices(functionalServices []FunctionalService, services []string) []FunctionalService {
+	deployed := []FunctionalService{}
+	for _, s := range functionalServices {
 		if s.isAssociatedWithAtLeastGivenService(services) {
-			functionalServices = append(functionalServices, s)
+			deployed = append(deployed, s)
 		}
 	}
-
-	return functionalServices, err
+	return deployed
 }
 
 // Exists checks if a functional service (name and package) already exists
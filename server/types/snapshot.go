@@ -0,0 +1,207 @@
+package types
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// ProjectSnapshot is the frozen state of the maturity of a project at a given date
+type ProjectSnapshot struct {
+	ID              bson.ObjectId    `bson:"_id,omitempty" json:"id,omitempty"`
+	ProjectID       bson.ObjectId    `bson:"projectID" json:"projectID"`
+	ProjectName     string           `bson:"projectName" json:"projectName"`
+	BusinessUnit    string           `bson:"businessUnit" json:"businessUnit"`
+	ServiceCenter   []string         `bson:"serviceCenter" json:"serviceCenter"`
+	Matrix          Matrix           `bson:"matrix" json:"matrix"`
+	UsageIndicators []UsageIndicator `bson:"usageIndicators" json:"usageIndicators"`
+	Date            time.Time        `bson:"date" json:"date"`
+}
+
+// NewProjectSnapshot freezes the current matrix and usage indicators of a project
+func NewProjectSnapshot(project Project, usageIndicators []UsageIndicator, date time.Time) ProjectSnapshot {
+	return ProjectSnapshot{
+		ProjectID:       project.ID,
+		ProjectName:     project.Name,
+		BusinessUnit:    project.BusinessUnit,
+		ServiceCenter:   project.ServiceCenter,
+		Matrix:          project.Matrix,
+		UsageIndicators: usageIndicators,
+		Date:            date,
+	}
+}
+
+// TrendPoint is the aggregated maturity of one or several projects at a given date
+type TrendPoint struct {
+	Date     time.Time `json:"date"`
+	Projects int       `json:"projects"` // Number of projects taken into account
+	Lines    int       `json:"lines"`    // Number of applicable matrix lines taken into account
+	Progress float64   `json:"progress"` // Average progress code of the applicable matrix lines
+	Goal     float64   `json:"goal"`     // Average goal code of the applicable matrix lines having a goal
+	Deployed int       `json:"deployed"` // Number of matrix lines deployed
+}
+
+// ComputeTrend aggregates snapshots by date, for all functional services or only the given one
+// Matrix lines whose progress is N/A are not taken into account, and lines whose goal is N/A are not part of the average goal
+func ComputeTrend(snapshots []ProjectSnapshot, service bson.ObjectId) []TrendPoint {
+	points := map[time.Time]*TrendPoint{}
+	progressSum := map[time.Time]int{}
+	goalSum := map[time.Time]int{}
+	goalLines := map[time.Time]int{}
+
+	for _, snapshot := range snapshots {
+		date := snapshot.Date.UTC()
+		point, ok := points[date]
+		if !ok {
+			point = &TrendPoint{Date: date}
+			points[date] = point
+		}
+		point.Projects++
+
+		for _, line := range snapshot.Matrix {
+			if service.Hex() != "" && line.Service != service {
+				continue
+			}
+			if line.Deployed == Deployed[0] {
+				point.Deployed++
+			}
+			if line.Progress < 0 {
+				continue
+			}
+			point.Lines++
+			progressSum[date] += line.Progress
+			if line.Goal >= 0 {
+				goalSum[date] += line.Goal
+				goalLines[date]++
+			}
+		}
+	}
+
+	trend := []TrendPoint{}
+	for date, point := range points {
+		if point.Lines > 0 {
+			point.Progress = float64(progressSum[date]) / float64(point.Lines)
+		}
+		if goalLines[date] > 0 {
+			point.Goal = float64(goalSum[date]) / float64(goalLines[date])
+		}
+		trend = append(trend, *point)
+	}
+	sort.Slice(trend, func(i, j int) bool {
+		return trend[i].Date.Before(trend[j].Date)
+	})
+	return trend
+}
+
+// SnapshotQuery contains the criteria used to find snapshots
+type SnapshotQuery struct {
+	Projects []bson.ObjectId // When not nil, only snapshots of these projects are returned
+	Entity   string          // Entity of the snapshot projects, their business unit or service center being the entity or one of its descendants
+	From     time.Time
+	To       time.Time
+}
+
+// ProjectSnapshotRepo wraps all requests to database for accessing snapshots of projects
+type ProjectSnapshotRepo struct {
+	database *mgo.Database
+}
+
+// NewProjectSnapshotRepo creates a new project snapshot repo from database
+// This ProjectSnapshotRepo is wrapping all requests with database
+func NewProjectSnapshotRepo(database *mgo.Database) ProjectSnapshotRepo {
+	return ProjectSnapshotRepo{database: database}
+}
+
+func (r *ProjectSnapshotRepo) col() *mgo.Collection {
+	return r.database.C("projectSnapshots")
+}
+
+func (r *ProjectSnapshotRepo) isInitialized() bool {
+	return r.database != nil
+}
+
+// CreateIndexes creates Index
+func (r *ProjectSnapshotRepo) CreateIndexes() error {
+	if !r.isInitialized() {
+		return ErrDatabaseNotInitialized
+	}
+	err := r.col().EnsureIndex(mgo.Index{
+		Key: []string{"projectID", "date"},
+	})
+	if err != nil {
+		return err
+	}
+	return r.col().EnsureIndex(mgo.Index{
+		Key: []string{"date"},
+	})
+}
+
+// Query converts the query to a Mongo query, the entity being expanded to its descendants with the tree of entities
+func (q SnapshotQuery) Query(tree EntityTree) bson.M {
+	filter := bson.M{}
+	if q.Projects != nil {
+		filter["projectID"] = bson.M{"$in": q.Projects}
+	}
+	if q.Entity != "" && bson.IsObjectIdHex(q.Entity) {
+		entities := tree.SubtreeHex(bson.ObjectIdHex(q.Entity))
+		filter["$or"] = []bson.M{
+			{"businessUnit": bson.M{"$in": entities}},
+			{"serviceCenter": bson.M{"$in": entities}},
+		}
+	}
+	date := bson.M{}
+	if !q.From.IsZero() {
+		date["$gte"] = q.From
+	}
+	if !q.To.IsZero() {
+		date["$lte"] = q.To
+	}
+	if len(date) > 0 {
+		filter["date"] = date
+	}
+	return filter
+}
+
+// Find gets the snapshots matching the query, sorted by date
+func (r *ProjectSnapshotRepo) Find(query SnapshotQuery) ([]ProjectSnapshot, error) {
+	if !r.isInitialized() {
+		return []ProjectSnapshot{}, ErrDatabaseNotInitialized
+	}
+
+	tree := EntityTree{}
+	if query.Entity != "" {
+		entityRepo := NewEntityRepo(r.database)
+		var err error
+		if tree, err = entityRepo.FindTree(); err != nil {
+			return []ProjectSnapshot{}, fmt.Errorf("Can't retrieve the tree of entities: %v", err)
+		}
+	}
+
+	snapshots := []ProjectSnapshot{}
+	err := r.col().Find(query.Query(tree)).Sort("date").All(&snapshots)
+	return snapshots, err
+}
+
+// BulkInsert inserts all snapshots at once
+func (r *ProjectSnapshotRepo) BulkInsert(snapshots []ProjectSnapshot) error {
+	if !r.isInitialized() {
+		return ErrDatabaseNotInitialized
+	}
+	if len(snapshots) == 0 {
+		return nil
+	}
+
+	b := r.col().Bulk()
+	b.Unordered()
+	for _, snapshot := range snapshots {
+		if snapshot.ID.Hex() == "" {
+			snapshot.ID = bson.NewObjectId()
+		}
+		b.Insert(snapshot)
+	}
+	_, err := b.Run()
+	return err
+}
@@ -0,0 +1,130 @@
+package types
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestComputeTrend(t *testing.T) {
+
+	Convey("Given snapshots of projects", t, func() {
+		jenkins, sonar := bson.NewObjectId(), bson.NewObjectId()
+		monday := time.Date(2017, 5, 1, 22, 0, 0, 0, time.UTC)
+		nextMonday := monday.AddDate(0, 0, 7)
+		snapshot := func(date time.Time, matrix ...MatrixLine) ProjectSnapshot {
+			return ProjectSnapshot{ProjectID: bson.NewObjectId(), Date: date, Matrix: matrix}
+		}
+
+		cases := []struct {
+			name      string
+			snapshots []ProjectSnapshot
+			service   bson.ObjectId
+			expected  []TrendPoint
+		}{
+			{
+				name:     "No snapshot gives an empty trend",
+				expected: []TrendPoint{},
+			},
+			{
+				name: "Progress and goal are averaged over the lines of the projects",
+				snapshots: []ProjectSnapshot{
+					snapshot(monday, MatrixLine{Service: jenkins, Progress: 1, Goal: 3, Deployed: Deployed[0]}),
+					snapshot(monday, MatrixLine{Service: jenkins, Progress: 2, Goal: 4, Deployed: Deployed[-1]}),
+				},
+				expected: []TrendPoint{{Date: monday, Projects: 2, Lines: 2, Progress: 1.5, Goal: 3.5, Deployed: 1}},
+			},
+			{
+				name: "Lines whose goal is N/A are not part of the average goal",
+				snapshots: []ProjectSnapshot{
+					snapshot(monday,
+						MatrixLine{Service: jenkins, Progress: 1, Goal: 4},
+						MatrixLine{Service: sonar, Progress: 3, Goal: -1},
+					),
+				},
+				expected: []TrendPoint{{Date: monday, Projects: 1, Lines: 2, Progress: 2, Goal: 4}},
+			},
+			{
+				name: "Lines whose goals are all N/A have no average goal",
+				snapshots: []ProjectSnapshot{
+					snapshot(monday, MatrixLine{Service: jenkins, Progress: 2, Goal: -1}),
+				},
+				expected: []TrendPoint{{Date: monday, Projects: 1, Lines: 1, Progress: 2, Goal: 0}},
+			},
+			{
+				name: "Lines whose progress is N/A are not taken into account",
+				snapshots: []ProjectSnapshot{
+					snapshot(monday,
+						MatrixLine{Service: jenkins, Progress: -1, Goal: 4},
+						MatrixLine{Service: sonar, Progress: 2, Goal: 2},
+					),
+				},
+				expected: []TrendPoint{{Date: monday, Projects: 1, Lines: 1, Progress: 2, Goal: 2}},
+			},
+			{
+				name: "Only the lines of the given functional service are taken into account",
+				snapshots: []ProjectSnapshot{
+					snapshot(monday,
+						MatrixLine{Service: jenkins, Progress: 1, Goal: 4},
+						MatrixLine{Service: sonar, Progress: 3, Goal: 2},
+					),
+				},
+				service:  sonar,
+				expected: []TrendPoint{{Date: monday, Projects: 1, Lines: 1, Progress: 3, Goal: 2}},
+			},
+			{
+				name: "Snapshots are aggregated by date, in chronological order",
+				snapshots: []ProjectSnapshot{
+					snapshot(nextMonday, MatrixLine{Service: jenkins, Progress: 4, Goal: 4}),
+					snapshot(monday, MatrixLine{Service: jenkins, Progress: 2, Goal: 4}),
+				},
+				expected: []TrendPoint{
+					{Date: monday, Projects: 1, Lines: 1, Progress: 2, Goal: 4},
+					{Date: nextMonday, Projects: 1, Lines: 1, Progress: 4, Goal: 4},
+				},
+			},
+		}
+
+		for _, c := range cases {
+			Convey(c.name, func() {
+				So(ComputeTrend(c.snapshots, c.service), ShouldResemble, c.expected)
+			})
+		}
+	})
+}
+
+func TestSnapshotQuery(t *testing.T) {
+
+	Convey("Given a query of the snapshots of an entity", t, func() {
+		businessUnit := Entity{ID: bson.NewObjectId(), Type: BusinessUnitType}
+		serviceCenter := Entity{ID: bson.NewObjectId(), Type: ServiceCenterType, Parent: businessUnit.ID}
+		other := Entity{ID: bson.NewObjectId(), Type: BusinessUnitType}
+		tree := NewEntityTree([]Entity{businessUnit, serviceCenter, other})
+		project := bson.NewObjectId()
+		query := SnapshotQuery{Projects: []bson.ObjectId{project}, Entity: businessUnit.ID.Hex()}
+
+		Convey("When converting it to a query", func() {
+			filter := query.Query(tree)
+			Convey("Then the snapshots of the entity and of its descendants match", func() {
+				entities := []string{businessUnit.ID.Hex(), serviceCenter.ID.Hex()}
+				So(filter, ShouldResemble, bson.M{
+					"projectID": bson.M{"$in": []bson.ObjectId{project}},
+					"$or": []bson.M{
+						{"businessUnit": bson.M{"$in": entities}},
+						{"serviceCenter": bson.M{"$in": entities}},
+					},
+				})
+			})
+		})
+	})
+
+	Convey("Given a query of all the snapshots in a period", t, func() {
+		from := time.Date(2017, 5, 1, 0, 0, 0, 0, time.UTC)
+		query := SnapshotQuery{From: from}
+		Convey("Then the snapshots of all entities match", func() {
+			So(query.Query(EntityTree{}), ShouldResemble, bson.M{"date": bson.M{"$gte": from}})
+		})
+	})
+}
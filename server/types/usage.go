@@ -147,3 +147,15 @@ func (r *UsageIndicatorRepo) BulkImport(usageIndicators []UsageIndicator) (BulkI
 		Errors:   errs,
 	}, nil
 }
+
+// DeleteUpdatedBefore deletes the usage indicators not updated since the given date, and returns how many were deleted
+func (r *UsageIndicatorRepo) DeleteUpdatedBefore(date time.Time) (int, error) {
+	if !r.isInitialized() {
+		return 0, ErrDatabaseNotInitialized
+	}
+	info, err := r.col().RemoveAll(bson.M{"updated": bson.M{"$lt": date}})
+	if err != nil {
+		return 0, err
+	}
+	return info.Removed, nil
+}
@@ -3,6 +3,7 @@ package types
 import (
 	"errors"
 	"fmt"
+	"regexp"
 	"time"
 
 	"gopkg.in/mgo.v2"
@@ -33,18 +34,38 @@ func (r Role) IsValid() bool {
 	return r == AdminRole || r == RIRole || r == PMRole || r == DeputyRole
 }
 
+const (
+	// LDAPProvider is the provider of users authenticated by the LDAP server
+	LDAPProvider = "ldap"
+	// LocalProvider is the provider of users whose password is stored in DAD
+	LocalProvider = "local"
+	// OIDCProvider is the provider of users authenticated by an OpenID Connect identity provider
+	OIDCProvider = "oidc"
+)
+
+const (
+	// DisabledByAdmin is the reason of users deactivated by an administrator
+	DisabledByAdmin = "admin"
+	// DisabledLeftLDAP is the reason of users disabled by the LDAP synchronization, because they left the directory
+	DisabledLeftLDAP = "left-ldap"
+)
+
 // User model
 type User struct {
-	ID          bson.ObjectId   `bson:"_id,omitempty" json:"id,omitempty"`
-	FirstName   string          `bson:"firstName" json:"firstName"`
-	LastName    string          `bson:"lastName" json:"lastName"`
-	DisplayName string          `bson:"displayName" json:"displayName"`
-	Username    string          `bson:"username" json:"username"`
-	Email       string          `bson:"email" json:"email"`
-	Role        Role            `bson:"role" json:"role"`
-	Created     time.Time       `bson:"created" json:"created"`
-	Updated     time.Time       `bson:"updated" json:"updated"`
-	Entities    []bson.ObjectId `bson:"entities" json:"entities"`
+	ID             bson.ObjectId   `bson:"_id,omitempty" json:"id,omitempty"`
+	FirstName      string          `bson:"firstName" json:"firstName"`
+	LastName       string          `bson:"lastName" json:"lastName"`
+	DisplayName    string          `bson:"displayName" json:"displayName"`
+	Username       string          `bson:"username" json:"username"`
+	Email          string          `bson:"email" json:"email"`
+	Role           Role            `bson:"role" json:"role"`
+	Created        time.Time       `bson:"created" json:"created"`
+	Updated        time.Time       `bson:"updated" json:"updated"`
+	Entities       []bson.ObjectId `bson:"entities" json:"entities"`
+	Provider       string          `bson:"provider" json:"provider"`                                 // Authentication provider owning the user
+	Password       string          `bson:"password,omitempty" json:"-"`                              // Hashed password, only for local users
+	Disabled       bool            `bson:"disabled" json:"disabled"`                                 // Disabled users can't log in anymore
+	DisabledReason string          `bson:"disabledReason,omitempty" json:"disabledReason,omitempty"` // Why the user is disabled, DisabledByAdmin or DisabledLeftLDAP
 }
 
 // GetID gets the ID of the user
@@ -52,6 +73,15 @@ func (u User) GetID() bson.ObjectId {
 	return u.ID
 }
 
+// AuthProvider returns the authentication provider owning the user
+// Users created before the providers were introduced come from LDAP
+func (u User) AuthProvider() string {
+	if u.Provider == "" {
+		return LDAPProvider
+	}
+	return u.Provider
+}
+
 // IsAdmin checks that the user is an admin, meaning he can do anything on the application.
 func (u User) IsAdmin() bool {
 	return u.Role == AdminRole
@@ -117,7 +147,7 @@ func (s *UserRepo) FindByUsername(username string) (User, error) {
 	}
 	user := User{}
 	err := s.col().Find(bson.M{
-		"username": bson.RegEx{Pattern: username, Options: "i"},
+		"username": bson.RegEx{Pattern: "^" + regexp.QuoteMeta(username) + "$", Options: "i"},
 	}).One(&user)
 	if err != nil {
 		return User{}, fmt.Errorf("Can't retrieve user %s", username)
@@ -126,6 +156,24 @@ func (s *UserRepo) FindByUsername(username string) (User, error) {
 	return user, nil
 }
 
+// FindByProvider get the users owned by an authentication provider
+func (s *UserRepo) FindByProvider(provider string) ([]User, error) {
+	if !s.isInitialized() {
+		return []User{}, ErrDatabaseNotInitialized
+	}
+	query := bson.M{"provider": provider}
+	// Users created before the providers were introduced come from LDAP, their provider is empty or missing
+	if provider == LDAPProvider {
+		query = bson.M{"provider": bson.M{"$in": []interface{}{LDAPProvider, "", nil}}}
+	}
+	users := []User{}
+	err := s.col().Find(query).All(&users)
+	if err != nil {
+		return []User{}, fmt.Errorf("Can't retrieve users of provider %s", provider)
+	}
+	return users, nil
+}
+
 // FindAll get all users from Dad
 func (s *UserRepo) FindAll() ([]User, error) {
 	if !s.isInitialized() {
@@ -169,20 +217,42 @@ func (s *UserRepo) Save(user User) (User, error) {
 	return user, err
 }
 
-// RemoveEntity removes an entity from a user
+// RemoveEntities removes entities from the users
 // This is used for cascade deletions
-func (s *UserRepo) RemoveEntity(id bson.ObjectId) error {
+func (s *UserRepo) RemoveEntities(ids []bson.ObjectId) error {
 	if !s.isInitialized() {
 		return ErrDatabaseNotInitialized
 	}
 
 	_, err := s.col().UpdateAll(
-		bson.M{"entities": id},
-		bson.M{"$pull": bson.M{"entities": id}},
+		bson.M{"entities": bson.M{"$in": ids}},
+		bson.M{"$pull": bson.M{"entities": bson.M{"$in": ids}}},
 	)
 	return err
 }
 
+// ReplaceEntity replaces an entity by another one in the entities of the users
+// This is used when merging entities
+func (s *UserRepo) ReplaceEntity(from, to bson.ObjectId) (int, error) {
+	if !s.isInitialized() {
+		return 0, ErrDatabaseNotInitialized
+	}
+
+	// The same array can't be updated twice in the same request, so the other entity is added before the entity is removed
+	info, err := s.col().UpdateAll(
+		bson.M{"entities": from},
+		bson.M{"$addToSet": bson.M{"entities": to}},
+	)
+	if err != nil {
+		return 0, err
+	}
+	_, err = s.col().UpdateAll(
+		bson.M{"entities": from},
+		bson.M{"$pull": bson.M{"entities": from}},
+	)
+	return info.Matched, err
+}
+
 // Delete the user
 func (s *UserRepo) Delete(id bson.ObjectId) (bson.ObjectId, error) {
 	return BasicDelete(s, id)